@@ -2,8 +2,13 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -15,6 +20,8 @@ import (
 // ServeHTTP demonstrates a plugin that handles HTTP requests by greeting the world.
 func (p *Plugin) ServeHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Request) {
 	router := mux.NewRouter()
+	// EWS item IDs may contain "/" and "+"; match them percent-encoded
+	router.UseEncodedPath()
 
 	// API routes
 	api := router.PathPrefix("/api/v1").Subrouter()
@@ -23,11 +30,28 @@ func (p *Plugin) ServeHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Req
 	api.HandleFunc("/meeting/accept", p.handleMeetingResponse).Methods("POST")
 	api.HandleFunc("/meeting/decline", p.handleMeetingResponse).Methods("POST")
 	api.HandleFunc("/meeting/tentative", p.handleMeetingResponse).Methods("POST")
+	api.HandleFunc("/meeting/respond", p.handleMeetingResponseDialog).Methods("POST")
+	api.HandleFunc("/meeting/create", p.handleCreateMeetingDialog).Methods("POST")
+	api.HandleFunc("/meeting/reschedule", p.handleOrganizerAction).Methods("POST")
+	api.HandleFunc("/meeting/cancel", p.handleOrganizerAction).Methods("POST")
+	api.HandleFunc("/meeting/reschedule/submit", p.handleRescheduleDialog).Methods("POST")
+	api.HandleFunc("/meeting/cancel/submit", p.handleCancelDialog).Methods("POST")
+	api.HandleFunc("/meeting/book", p.handleBookSlot).Methods("POST")
 	api.HandleFunc("/test-connection", p.handleTestConnection).Methods("POST")
 	api.HandleFunc("/reminder/snooze", p.handleSnoozeReminder).Methods("POST")
 	api.HandleFunc("/calendar/open", p.handleOpenCalendar).Methods("POST")
 	api.HandleFunc("/reminders", p.handleGetReminders).Methods("GET")
 	api.HandleFunc("/reminders/update", p.handleUpdateReminders).Methods("POST")
+	api.HandleFunc("/event/details", p.handleEventDetailsAction).Methods("POST")
+	api.HandleFunc("/event/{id}", p.handleGetEvent).Methods("GET")
+	api.HandleFunc("/availability", p.handleGetAvailability).Methods("GET")
+	api.HandleFunc("/oof", p.handleGetOof).Methods("GET")
+	api.HandleFunc("/oof", p.handleUpdateOof).Methods("POST")
+	api.HandleFunc("/users/resolve", p.handleResolveUser).Methods("GET")
+	api.HandleFunc("/mail/watch", p.handleGetMailWatch).Methods("GET")
+	api.HandleFunc("/mail/watch", p.handleUpdateMailWatch).Methods("POST")
+	api.HandleFunc("/mail/read", p.handleMarkMailRead).Methods("POST")
+	api.HandleFunc("/mail/open", p.handleOpenMail).Methods("POST")
 
 	router.ServeHTTP(w, r)
 }
@@ -59,8 +83,8 @@ func (p *Plugin) handleCredentials(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	client := NewExchangeClient(config.ExchangeServerURL, &credentials)
-	err := client.TestConnection()
+	client := p.newExchangeClient(&credentials)
+	endpoint, err := p.discoverUserEndpoint(userID, client, &credentials)
 	if err != nil {
 		// Log the error for debugging
 		p.API.LogError("Failed to save credentials due to connection test failure",
@@ -85,6 +109,18 @@ func (p *Plugin) handleCredentials(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Store the working EWS endpoint so later calls don't need to probe for it
+	if err := p.storeUserEndpoint(userID, endpoint); err != nil {
+		p.API.LogError("Ошибка сохранения EWS endpoint", "user_id", userID, "error", err.Error())
+	}
+
+	// New credentials: notify again if they are rejected later
+	p.clearExchangeAuthFailure(userID)
+
+	// The credentials may belong to another mailbox
+	p.clearCalendarCache(userID)
+	p.subscriptionManager.stop(userID)
+
 	// Send success response
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
@@ -121,9 +157,9 @@ func (p *Plugin) handleGetCalendar(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	events, err := p.getCalendarEvents(credentials)
+	events, err := p.getCalendarEvents(userID, credentials)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to get calendar events: %s", err.Error()), http.StatusInternalServerError)
+		writeExchangeError(w, "Failed to get calendar events", err)
 		return
 	}
 
@@ -139,10 +175,7 @@ func (p *Plugin) handleMeetingResponse(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var request struct {
-		Context map[string]interface{} `json:"context"`
-	}
-
+	var request model.PostActionIntegrationRequest
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
@@ -157,11 +190,11 @@ func (p *Plugin) handleMeetingResponse(w http.ResponseWriter, r *http.Request) {
 	// Determine response type from URL path
 	var responseType string
 	if strings.Contains(r.URL.Path, "accept") {
-		responseType = "Accept"
+		responseType = MeetingResponseAccept
 	} else if strings.Contains(r.URL.Path, "decline") {
-		responseType = "Decline"
+		responseType = MeetingResponseDecline
 	} else if strings.Contains(r.URL.Path, "tentative") {
-		responseType = "Tentative"
+		responseType = MeetingResponseTentative
 	} else {
 		http.Error(w, "Invalid response type", http.StatusBadRequest)
 		return
@@ -174,46 +207,95 @@ func (p *Plugin) handleMeetingResponse(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Send meeting response (this would need to be implemented with EWS)
-	err = p.sendMeetingResponse(credentials, eventID, responseType)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to send meeting response: %s", err.Error()), http.StatusInternalServerError)
+	// Buttons ask for a comment and whether to notify the organizer first
+	if request.TriggerId != "" {
+		if err := p.openMeetingResponseDialog(request.TriggerId, request.PostId, eventID, responseType); err != nil {
+			p.API.LogError("Ошибка открытия диалога ответа на приглашение", "user_id", userID, "error", err.Error())
+			http.Error(w, "Failed to open response dialog", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(model.PostActionIntegrationResponse{})
 		return
 	}
 
-	// Send confirmation message
-	var emoji, action string
-	switch responseType {
-	case "Accept":
-		emoji = "✅"
-		action = "приняли"
-	case "Decline":
-		emoji = "❌"
-		action = "отклонили"
-	case "Tentative":
-		emoji = "❓"
-		action = "отметили как под вопросом"
+	// API callers pass the options in the context
+	comment, _ := request.Context["comment"].(string)
+	sendResponse := true
+	if value, ok := request.Context["send_response"].(bool); ok {
+		sendResponse = value
 	}
 
-	bot, botErr := p.API.GetBot("", true)
-	if botErr == nil {
-		channel, channelErr := p.API.GetDirectChannel(userID, bot.UserId)
-		if channelErr == nil {
-			post := &model.Post{
-				ChannelId: channel.Id,
-				UserId:    bot.UserId,
-				Message:   fmt.Sprintf("%s Вы %s приглашение на встречу.", emoji, action),
-			}
-			p.API.CreatePost(post)
+	if err := p.sendMeetingResponse(userID, credentials, eventID, responseType, comment, sendResponse); err != nil {
+		writeExchangeError(w, "Failed to send meeting response", err)
+		return
+	}
+
+	response := model.PostActionIntegrationResponse{}
+	if request.PostId != "" {
+		post, err := p.markMeetingResponded(userID, request.PostId, eventID, responseType, comment, sendResponse)
+		if err != nil {
+			p.API.LogError("Ошибка обновления приглашения", "user_id", userID, "post_id", request.PostId, "error", err.Error())
 		}
+		response.Update = post
 	}
 
-	// Return success response
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"status":  "success",
-		"message": fmt.Sprintf("Meeting %s", strings.ToLower(responseType)),
-	})
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleMeetingResponseDialog sends the meeting response chosen in the response dialog
+func (p *Plugin) handleMeetingResponseDialog(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request model.SubmitDialogRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if request.Cancelled {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var state meetingResponseState
+	if err := json.Unmarshal([]byte(request.State), &state); err != nil || state.EventID == "" {
+		http.Error(w, "Invalid dialog state", http.StatusBadRequest)
+		return
+	}
+
+	credentials, err := p.getUserExchangeCredentials(userID)
+	if err != nil {
+		http.Error(w, "Exchange credentials not configured", http.StatusBadRequest)
+		return
+	}
+
+	comment, _ := request.Submission["comment"].(string)
+	sendResponse := request.Submission["send_response"] != "false"
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := p.sendMeetingResponse(userID, credentials, state.EventID, state.ResponseType, comment, sendResponse); err != nil {
+		p.API.LogWarn("Ошибка отправки ответа на приглашение", "user_id", userID, "event_id", state.EventID, "error", err.Error())
+		json.NewEncoder(w).Encode(model.SubmitDialogResponse{
+			Error: describeExchangeError(err),
+		})
+		return
+	}
+
+	if state.PostID != "" {
+		if _, err := p.markMeetingResponded(userID, state.PostID, state.EventID, state.ResponseType, comment, sendResponse); err != nil {
+			p.API.LogError("Ошибка обновления приглашения", "user_id", userID, "post_id", state.PostID, "error", err.Error())
+		}
+	}
+
+	json.NewEncoder(w).Encode(model.SubmitDialogResponse{})
 }
 
 // handleTestConnection tests Exchange connection
@@ -242,8 +324,8 @@ func (p *Plugin) handleTestConnection(w http.ResponseWriter, r *http.Request) {
 		"username", credentials.Username,
 		"domain", credentials.Domain)
 
-	client := NewExchangeClient(config.ExchangeServerURL, &credentials)
-	err := client.TestConnection()
+	client := p.newExchangeClient(&credentials)
+	_, err := p.discoverUserEndpoint(userID, client, &credentials)
 	if err != nil {
 		// Log the detailed error
 		p.API.LogError("Exchange connection test failed", "error", err.Error(),
@@ -259,7 +341,7 @@ func (p *Plugin) handleTestConnection(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	p.API.LogInfo("Exchange connection test successful")
+	p.API.LogInfo("Exchange connection test successful", "ews_url", client.EWSURL())
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"success": true,
@@ -267,15 +349,14 @@ func (p *Plugin) handleTestConnection(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// sendMeetingResponse sends a meeting response via EWS
-func (p *Plugin) sendMeetingResponse(credentials *ExchangeCredentials, eventID, responseType string) error {
-	// TODO: Implement proper EWS CreateItem request for meeting responses
-	// This would use credentials to authenticate with Exchange server
-	_ = credentials // credentials will be used when implementing EWS meeting response
+// writeExchangeError writes an Exchange failure with a status code matching its kind
+func writeExchangeError(w http.ResponseWriter, message string, err error) {
+	var ewsErr *EWSError
+	if errors.As(err, &ewsErr) && errors.Is(err, ErrThrottled) && ewsErr.BackOff > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(ewsErr.BackOff.Seconds()))))
+	}
 
-	// For now, just log the action
-	p.API.LogInfo("Meeting response sent", "event_id", eventID, "response", responseType)
-	return nil
+	http.Error(w, fmt.Sprintf("%s: %s", message, describeExchangeError(err)), exchangeErrorStatus(err))
 }
 
 // ExecuteCommand executes slash commands
@@ -309,7 +390,29 @@ func (p *Plugin) handleExchangeCommand(args *model.CommandArgs) (*model.CommandR
 	case "status":
 		return p.handleStatusCommand(args.UserId), nil
 	case "calendar":
-		return p.handleCalendarCommand(args.UserId), nil
+		return p.handleCalendarCommand(args.UserId, parts[2:]), nil
+	case "create":
+		return p.handleCreateCommand(args, strings.Join(parts[2:], " ")), nil
+	case "reschedule":
+		return p.handleOrganizedMeetingsCommand(args.UserId, organizerActionReschedule), nil
+	case "cancel":
+		return p.handleOrganizedMeetingsCommand(args.UserId, organizerActionCancel), nil
+	case "freebusy":
+		return p.handleFreeBusyCommand(args.UserId, parts[2:]), nil
+	case "findtime":
+		return p.handleFindTimeCommand(args, parts[2:]), nil
+	case "rooms":
+		return p.handleRoomsCommand(args.UserId, parts[2:]), nil
+	case "oof":
+		return p.handleOofCommand(args.UserId, parts[2:]), nil
+	case "delegate":
+		return p.handleDelegateCommand(args.UserId, parts[2:]), nil
+	case "folders":
+		return p.handleFoldersCommand(args.UserId, parts[2:]), nil
+	case "mapping":
+		return p.handleMappingCommand(args.UserId, parts[2:]), nil
+	case "mail":
+		return p.handleMailCommand(args.UserId, parts[2:]), nil
 	case "reminders":
 		return p.handleRemindersCommand(args.UserId), nil
 	case "help":
@@ -366,8 +469,9 @@ func (p *Plugin) handleStatusCommand(userID string) *model.CommandResponse {
 	}
 }
 
-// handleCalendarCommand shows calendar events
-func (p *Plugin) handleCalendarCommand(userID string) *model.CommandResponse {
+// handleCalendarCommand shows today's calendar events.
+// With --mailbox it shows the calendar of a mailbox the user has delegate access to.
+func (p *Plugin) handleCalendarCommand(userID string, args []string) *model.CommandResponse {
 	credentials, err := p.getUserExchangeCredentials(userID)
 	if err != nil {
 		return &model.CommandResponse{
@@ -376,32 +480,56 @@ func (p *Plugin) handleCalendarCommand(userID string) *model.CommandResponse {
 		}
 	}
 
-	// Get today's events
-	today := time.Now()
-	startOfDay := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, today.Location())
-	endOfDay := startOfDay.Add(24 * time.Hour)
+	var mailboxArg string
+	for i, arg := range args {
+		if value, ok := strings.CutPrefix(arg, "--mailbox="); ok {
+			mailboxArg = value
+		} else if arg == "--mailbox" && i+1 < len(args) {
+			mailboxArg = args[i+1]
+		}
+	}
 
-	events, err := p.getCalendarEventsInRange(credentials, startOfDay, endOfDay)
+	// Get today's events in the user's time zone
+	loc := p.getUserLocation(userID)
+	startOfDay, endOfDay := dayBounds(time.Now(), loc)
+
+	var events []CalendarEvent
+	title := "📅 **Ваши встречи на сегодня:**\n\n"
+	empty := "📅 На сегодня встреч не запланировано."
+	if mailboxArg != "" {
+		mailbox, _, err := p.delegateMailbox(userID, mailboxArg)
+		if err != nil {
+			return &model.CommandResponse{
+				ResponseType: "ephemeral",
+				Text:         fmt.Sprintf("❌ %s", err.Error()),
+			}
+		}
+		events, err = p.getMailboxCalendarEvents(userID, credentials, mailbox.Email, startOfDay, endOfDay)
+		title = fmt.Sprintf("📅 **Встречи на сегодня — календарь %s:**\n\n", mailbox.Label())
+		empty = fmt.Sprintf("📅 В календаре %s на сегодня встреч не запланировано.", mailbox.Label())
+	} else {
+		events, err = p.getCalendarEventsInRange(userID, credentials, startOfDay, endOfDay)
+	}
 	if err != nil {
 		return &model.CommandResponse{
 			ResponseType: "ephemeral",
-			Text:         fmt.Sprintf("❌ Ошибка получения календаря: %s", err.Error()),
+			Text:         fmt.Sprintf("❌ Ошибка получения календаря: %s", describeExchangeError(err)),
 		}
 	}
 
 	if len(events) == 0 {
 		return &model.CommandResponse{
 			ResponseType: "ephemeral",
-			Text:         "📅 На сегодня встреч не запланировано.",
+			Text:         empty,
 		}
 	}
 
-	text := "📅 **Ваши встречи на сегодня:**\n\n"
+	text := title
 	for _, event := range events {
-		startTime := event.Start.Format("15:04")
-		endTime := event.End.Format("15:04")
+		startTime := event.Start.In(loc).Format("15:04")
+		endTime := event.End.In(loc).Format("15:04")
 
-		text += fmt.Sprintf("🕐 **%s - %s**: %s", startTime, endTime, event.Subject)
+		text += fmt.Sprintf("🕐 **%s - %s**: %s%s", startTime, endTime, event.Subject, recurrenceMark(event))
 		if event.Location != "" {
 			text += fmt.Sprintf(" (📍 %s)", event.Location)
 		}
@@ -414,166 +542,1795 @@ func (p *Plugin) handleCalendarCommand(userID string) *model.CommandResponse {
 	}
 }
 
-// getExchangeHelp returns help text for Exchange commands
-func (p *Plugin) getExchangeHelp() *model.CommandResponse {
-	text := "### 📧 Exchange Integration - Справка\n\n" +
-		"**Доступные команды:**\n\n" +
-		"- `/exchange setup` - Инструкции по настройке\n" +
-		"- `/exchange status` - Текущий статус подключения\n" +
-		"- `/exchange calendar` - Просмотр календаря на сегодня\n" +
-		"- `/exchange reminders` - Управление напоминаниями о встречах\n" +
-		"- `/exchange help` - Эта справка\n\n" +
-		"**Функции:**\n" +
-		"- 🔄 Автоматическая синхронизация статуса на основе календаря\n" +
-		"- 📅 Ежедневная утренняя сводка встреч (в 9:00)\n" +
-		"- 📧 Уведомления о новых приглашениях на встречи\n" +
-		"- ⏰ Напоминания за 15 минут до встречи\n" +
-		"- ✅ Возможность принимать/отклонять встречи прямо из Mattermost"
+// handleCreateCommand opens the dialog for a new meeting
+func (p *Plugin) handleCreateCommand(args *model.CommandArgs, subject string) *model.CommandResponse {
+	if _, err := p.getUserExchangeCredentials(args.UserId); err != nil {
+		return &model.CommandResponse{
+			ResponseType: "ephemeral",
+			Text:         "❌ Exchange не настроен. Используйте `/exchange setup` для настройки.",
+		}
+	}
 
-	return &model.CommandResponse{
-		ResponseType: "ephemeral",
-		Text:         text,
+	if err := p.openCreateMeetingDialog(args.UserId, args.TriggerId, subject); err != nil {
+		p.API.LogError("Ошибка открытия диалога создания встречи", "user_id", args.UserId, "error", err.Error())
+		return &model.CommandResponse{
+			ResponseType: "ephemeral",
+			Text:         "❌ Не удалось открыть форму создания встречи.",
+		}
 	}
+
+	return &model.CommandResponse{}
 }
 
-// handleSnoozeReminder handles snoozing a meeting reminder
-func (p *Plugin) handleSnoozeReminder(w http.ResponseWriter, r *http.Request) {
+// handleCreateMeetingDialog creates the meeting submitted in the create meeting dialog
+func (p *Plugin) handleCreateMeetingDialog(w http.ResponseWriter, r *http.Request) {
 	userID := r.Header.Get("Mattermost-User-Id")
 	if userID == "" {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	var request struct {
-		Context map[string]interface{} `json:"context"`
-	}
-
+	var request model.SubmitDialogRequest
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
 
-	eventID, ok := request.Context["event_id"].(string)
-	if !ok {
-		http.Error(w, "Missing event_id", http.StatusBadRequest)
+	if request.Cancelled {
+		w.WriteHeader(http.StatusOK)
 		return
 	}
 
-	snoozeMinsFloat, ok := request.Context["snooze_mins"].(float64)
-	if !ok {
-		http.Error(w, "Missing snooze_mins", http.StatusBadRequest)
+	credentials, err := p.getUserExchangeCredentials(userID)
+	if err != nil {
+		http.Error(w, "Exchange credentials not configured", http.StatusBadRequest)
 		return
 	}
-	snoozeMins := int(snoozeMinsFloat)
 
-	// Get current reminders
-	reminders, err := p.reminderManager.getUserReminders(userID)
+	w.Header().Set("Content-Type", "application/json")
+
+	loc := p.getUserLocation(userID)
+	fieldErrors := make(map[string]string)
+
+	subject, _ := request.Submission["subject"].(string)
+	location, _ := request.Submission["location"].(string)
+	body, _ := request.Submission["body"].(string)
+	attendees, _ := request.Submission["attendees"].(string)
+
+	meeting := NewMeeting{
+		Subject:  strings.TrimSpace(subject),
+		Location: strings.TrimSpace(location),
+		Body:     strings.TrimSpace(body),
+	}
+	if meeting.Subject == "" {
+		fieldErrors["subject"] = "Укажите тему встречи"
+	}
+
+	meeting.Start, meeting.End = parseMeetingTime(request.Submission, loc, fieldErrors)
+	if _, ok := fieldErrors["date"]; !ok && !meeting.Start.IsZero() && meeting.Start.Before(time.Now()) {
+		fieldErrors["time"] = "Время начала уже прошло"
+	}
+
+	emails, unknown := p.resolveAttendees(attendees)
+	if len(unknown) > 0 {
+		fieldErrors["attendees"] = fmt.Sprintf("Не найдены: %s", strings.Join(unknown, ", "))
+	}
+	meeting.RequiredAttendees = emails
+
+	if len(fieldErrors) > 0 {
+		json.NewEncoder(w).Encode(model.SubmitDialogResponse{Errors: fieldErrors})
+		return
+	}
+
+	// Book the first free room of the chosen building
+	if roomList, _ := request.Submission["room_list"].(string); roomList != "" {
+		rooms, err := p.findFreeRooms(userID, credentials, []Room{{Email: roomList}}, meeting.Start, meeting.End)
+		if err != nil {
+			p.logExchangeError(userID, "Ошибка поиска переговорных", err)
+			json.NewEncoder(w).Encode(model.SubmitDialogResponse{
+				Errors: map[string]string{"room_list": describeExchangeError(err)},
+			})
+			return
+		}
+		if len(rooms) == 0 {
+			json.NewEncoder(w).Encode(model.SubmitDialogResponse{
+				Errors: map[string]string{"room_list": "В этом здании нет свободных переговорных на выбранное время"},
+			})
+			return
+		}
+
+		meeting.Resources = []string{rooms[0].Email}
+		if meeting.Location == "" {
+			meeting.Location = rooms[0].Name
+		} else {
+			meeting.Location += ", " + rooms[0].Name
+		}
+	}
+
+	_, link, err := p.createMeeting(userID, credentials, meeting)
+	if errors.Is(err, ErrOutcomeUnknown) {
+		// Close the dialog: submitting it again could create the meeting twice
+		p.API.LogWarn("Результат создания встречи неизвестен", "user_id", userID, "error", err.Error())
+		if err := p.sendMeetingUnconfirmedMessage(userID, meeting); err != nil {
+			p.API.LogError("Ошибка отправки сообщения о встрече", "user_id", userID, "error", err.Error())
+		}
+		json.NewEncoder(w).Encode(model.SubmitDialogResponse{})
+		return
+	}
 	if err != nil {
-		http.Error(w, "Failed to get reminders", http.StatusInternalServerError)
+		p.API.LogWarn("Ошибка создания встречи", "user_id", userID, "error", err.Error())
+		json.NewEncoder(w).Encode(model.SubmitDialogResponse{
+			Error: describeExchangeError(err),
+		})
 		return
 	}
 
-	// Find the reminder and snooze it
-	for i, reminder := range reminders {
-		if reminder.EventID == eventID {
-			// Create new snooze reminder
-			snoozeTime := time.Now().Add(time.Duration(snoozeMins) * time.Minute)
-			reminders[i].ReminderTime = snoozeTime
-			reminders[i].Sent = false
+	if err := p.sendMeetingCreatedMessage(userID, meeting, link); err != nil {
+		p.API.LogError("Ошибка отправки подтверждения о встрече", "user_id", userID, "error", err.Error())
+	}
 
-			// Update reminders
-			storeErr := p.reminderManager.storeUserReminders(userID, reminders)
-			if storeErr != nil {
-				http.Error(w, "Failed to update reminder", http.StatusInternalServerError)
-				return
-			}
+	// Schedule the reminder for the new meeting right away
+	if err := p.reminderManager.UpdateRemindersForUser(userID); err != nil {
+		p.logExchangeError(userID, "Ошибка обновления напоминаний", err)
+	}
 
-			// Send confirmation message
-			bot, botErr := p.API.GetBot("", true)
-			if botErr == nil {
-				channel, channelErr := p.API.GetDirectChannel(userID, bot.UserId)
-				if channelErr == nil {
-					post := &model.Post{
-						ChannelId: channel.Id,
-						UserId:    bot.UserId,
-						Message:   fmt.Sprintf("⏱️ Напоминание о встрече \"%s\" отложено на %d минут", reminder.Subject, snoozeMins),
-					}
-					p.API.CreatePost(post)
-				}
-			}
+	json.NewEncoder(w).Encode(model.SubmitDialogResponse{})
+}
 
-			break
+// handleOrganizedMeetingsCommand lists the upcoming meetings the user organizes with a reschedule or cancel button
+func (p *Plugin) handleOrganizedMeetingsCommand(userID, action string) *model.CommandResponse {
+	credentials, err := p.getUserExchangeCredentials(userID)
+	if err != nil {
+		return &model.CommandResponse{
+			ResponseType: "ephemeral",
+			Text:         "❌ Exchange не настроен. Используйте `/exchange setup` для настройки.",
 		}
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"status":  "success",
-		"message": "Reminder snoozed",
-	})
+	meetings, err := p.getOrganizedMeetings(userID, credentials)
+	if err != nil {
+		return &model.CommandResponse{
+			ResponseType: "ephemeral",
+			Text:         fmt.Sprintf("❌ Ошибка получения календаря: %s", describeExchangeError(err)),
+		}
+	}
+
+	if len(meetings) == 0 {
+		return &model.CommandResponse{
+			ResponseType: "ephemeral",
+			Text:         "📅 В ближайшие 7 дней нет встреч, которые вы организуете.",
+		}
+	}
+
+	text := "🕑 **Какую встречу перенести?**"
+	if action == organizerActionCancel {
+		text = "🚫 **Какую встречу отменить?**"
+	}
+
+	return &model.CommandResponse{
+		ResponseType: "ephemeral",
+		Text:         text,
+		Attachments:  p.organizedMeetingsAttachments(userID, meetings, action),
+	}
 }
 
-// handleOpenCalendar handles opening calendar view
-func (p *Plugin) handleOpenCalendar(w http.ResponseWriter, r *http.Request) {
+// handleOrganizerAction opens the reschedule or cancel dialog for a meeting the user organizes
+func (p *Plugin) handleOrganizerAction(w http.ResponseWriter, r *http.Request) {
 	userID := r.Header.Get("Mattermost-User-Id")
 	if userID == "" {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	// For now, just send a message with today's calendar
+	var request model.PostActionIntegrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	eventID, ok := request.Context["event_id"].(string)
+	if !ok {
+		http.Error(w, "Missing event_id", http.StatusBadRequest)
+		return
+	}
+
 	credentials, err := p.getUserExchangeCredentials(userID)
 	if err != nil {
 		http.Error(w, "Exchange credentials not configured", http.StatusBadRequest)
 		return
 	}
 
-	// Get today's events
-	today := time.Now()
-	startOfDay := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, today.Location())
-	endOfDay := startOfDay.Add(24 * time.Hour)
+	w.Header().Set("Content-Type", "application/json")
 
-	events, err := p.getCalendarEventsInRange(credentials, startOfDay, endOfDay)
+	event, err := p.getEventDetails(userID, credentials, eventID)
 	if err != nil {
-		http.Error(w, "Failed to get calendar events", http.StatusInternalServerError)
+		p.API.LogWarn("Ошибка получения встречи", "user_id", userID, "event_id", eventID, "error", err.Error())
+		json.NewEncoder(w).Encode(model.PostActionIntegrationResponse{
+			EphemeralText: fmt.Sprintf("❌ Не удалось получить встречу: %s", describeExchangeError(err)),
+		})
 		return
 	}
 
-	var message string
-	if len(events) == 0 {
-		message = "📅 На сегодня встреч не запланировано."
+	if !event.IsOrganizer {
+		json.NewEncoder(w).Encode(model.PostActionIntegrationResponse{
+			EphemeralText: "❌ " + describeExchangeError(ErrNotOrganizer),
+		})
+		return
+	}
+
+	if strings.HasSuffix(r.URL.Path, "/cancel") {
+		err = p.openCancelDialog(request.TriggerId, event)
 	} else {
-		message = "📅 **Ваши встречи на сегодня:**\n\n"
-		for _, event := range events {
-			startTime := event.Start.Format("15:04")
-			endTime := event.End.Format("15:04")
+		err = p.openRescheduleDialog(userID, request.TriggerId, event)
+	}
+	if err != nil {
+		p.API.LogError("Ошибка открытия диалога организатора", "user_id", userID, "error", err.Error())
+		http.Error(w, "Failed to open dialog", http.StatusInternalServerError)
+		return
+	}
 
-			message += fmt.Sprintf("🕐 **%s - %s**: %s", startTime, endTime, event.Subject)
-			if event.Location != "" {
-				message += fmt.Sprintf(" (📍 %s)", event.Location)
-			}
-			message += "\n"
-		}
+	json.NewEncoder(w).Encode(model.PostActionIntegrationResponse{})
+}
+
+// handleRescheduleDialog moves the meeting to the time submitted in the reschedule dialog
+func (p *Plugin) handleRescheduleDialog(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
 	}
 
-	// Send calendar message
-	bot, botErr := p.API.GetBot("", true)
-	if botErr == nil {
-		channel, channelErr := p.API.GetDirectChannel(userID, bot.UserId)
-		if channelErr == nil {
-			post := &model.Post{
-				ChannelId: channel.Id,
-				UserId:    bot.UserId,
-				Message:   message,
-			}
-			p.API.CreatePost(post)
-		}
+	var request model.SubmitDialogRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if request.Cancelled {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var state organizerActionState
+	if err := json.Unmarshal([]byte(request.State), &state); err != nil || state.EventID == "" {
+		http.Error(w, "Invalid dialog state", http.StatusBadRequest)
+		return
+	}
+
+	credentials, err := p.getUserExchangeCredentials(userID)
+	if err != nil {
+		http.Error(w, "Exchange credentials not configured", http.StatusBadRequest)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"status":  "success",
-		"message": "Calendar displayed",
+
+	loc := p.getUserLocation(userID)
+	fieldErrors := make(map[string]string)
+
+	update := EventUpdate{}
+	update.Start, update.End = parseMeetingTime(request.Submission, loc, fieldErrors)
+	if _, ok := fieldErrors["date"]; !ok && !update.Start.IsZero() && update.Start.Before(time.Now()) {
+		fieldErrors["time"] = "Время начала уже прошло"
+	}
+
+	if len(fieldErrors) > 0 {
+		json.NewEncoder(w).Encode(model.SubmitDialogResponse{Errors: fieldErrors})
+		return
+	}
+
+	location, _ := request.Submission["location"].(string)
+	if location = strings.TrimSpace(location); location != state.Location {
+		update.Location = &location
+	}
+
+	err = p.rescheduleMeeting(userID, credentials, state.EventID, update)
+	if errors.Is(err, ErrOutcomeUnknown) {
+		// Close the dialog: submitting it again would send the update twice
+		p.API.LogWarn("Результат переноса встречи неизвестен", "user_id", userID, "event_id", state.EventID, "error", err.Error())
+		p.sendOrganizerUnconfirmed(userID, fmt.Sprintf("Exchange не подтвердил перенос встречи «%s».", state.Subject))
+		json.NewEncoder(w).Encode(model.SubmitDialogResponse{})
+		return
+	}
+	if err != nil {
+		p.API.LogWarn("Ошибка переноса встречи", "user_id", userID, "event_id", state.EventID, "error", err.Error())
+		json.NewEncoder(w).Encode(model.SubmitDialogResponse{
+			Error: describeExchangeError(err),
+		})
+		return
+	}
+
+	message := "🕑 **Встреча перенесена**\n\n"
+	message += fmt.Sprintf("**Тема:** %s\n", state.Subject)
+	message += fmt.Sprintf("**Новое время:** %s - %s\n", update.Start.In(loc).Format("02.01.2006 15:04"), update.End.In(loc).Format("15:04"))
+	if location != "" {
+		message += fmt.Sprintf("**Место:** %s\n", location)
+	}
+	message += "\nУчастники получили обновленное приглашение."
+
+	if err := p.sendOrganizerConfirmation(userID, message); err != nil {
+		p.API.LogError("Ошибка отправки подтверждения о переносе встречи", "user_id", userID, "error", err.Error())
+	}
+
+	if err := p.reminderManager.UpdateRemindersForUser(userID); err != nil {
+		p.logExchangeError(userID, "Ошибка обновления напоминаний", err)
+	}
+
+	json.NewEncoder(w).Encode(model.SubmitDialogResponse{})
+}
+
+// handleCancelDialog cancels the meeting confirmed in the cancel dialog
+func (p *Plugin) handleCancelDialog(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request model.SubmitDialogRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if request.Cancelled {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var state organizerActionState
+	if err := json.Unmarshal([]byte(request.State), &state); err != nil || state.EventID == "" {
+		http.Error(w, "Invalid dialog state", http.StatusBadRequest)
+		return
+	}
+
+	credentials, err := p.getUserExchangeCredentials(userID)
+	if err != nil {
+		http.Error(w, "Exchange credentials not configured", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	message, _ := request.Submission["message"].(string)
+	err = p.cancelMeeting(userID, credentials, state.EventID, strings.TrimSpace(message))
+	if errors.Is(err, ErrOutcomeUnknown) {
+		// Close the dialog: submitting it again would send the cancellation twice
+		p.API.LogWarn("Результат отмены встречи неизвестен", "user_id", userID, "event_id", state.EventID, "error", err.Error())
+		p.sendOrganizerUnconfirmed(userID, fmt.Sprintf("Exchange не подтвердил отмену встречи «%s».", state.Subject))
+		json.NewEncoder(w).Encode(model.SubmitDialogResponse{})
+		return
+	}
+	if err != nil {
+		p.API.LogWarn("Ошибка отмены встречи", "user_id", userID, "event_id", state.EventID, "error", err.Error())
+		json.NewEncoder(w).Encode(model.SubmitDialogResponse{
+			Error: describeExchangeError(err),
+		})
+		return
+	}
+
+	confirmation := fmt.Sprintf("🚫 **Встреча отменена**\n\n**Тема:** %s\n\nУчастники получили уведомление об отмене.", state.Subject)
+	if err := p.sendOrganizerConfirmation(userID, confirmation); err != nil {
+		p.API.LogError("Ошибка отправки подтверждения об отмене встречи", "user_id", userID, "error", err.Error())
+	}
+
+	// Drop the reminder of the cancelled meeting
+	if err := p.reminderManager.UpdateRemindersForUser(userID); err != nil {
+		p.logExchangeError(userID, "Ошибка обновления напоминаний", err)
+	}
+
+	json.NewEncoder(w).Encode(model.SubmitDialogResponse{})
+}
+
+// handleFreeBusyCommand shows the free/busy timeline of colleagues for a day
+func (p *Plugin) handleFreeBusyCommand(userID string, args []string) *model.CommandResponse {
+	credentials, err := p.getUserExchangeCredentials(userID)
+	if err != nil {
+		return &model.CommandResponse{
+			ResponseType: "ephemeral",
+			Text:         "❌ Exchange не настроен. Используйте `/exchange setup` для настройки.",
+		}
+	}
+
+	loc := p.getUserLocation(userID)
+	day := time.Now().In(loc)
+
+	var users []string
+	for _, arg := range args {
+		if parsed, ok := parseDayArgument(arg, time.Now(), loc); ok {
+			day = parsed
+			continue
+		}
+		users = append(users, arg)
+	}
+
+	if len(users) == 0 {
+		return &model.CommandResponse{
+			ResponseType: "ephemeral",
+			Text:         "Использование: `/exchange freebusy @user1 @user2 [дата]`\n\nДата: `сегодня`, `завтра`, `ДД.ММ` или `ДД.ММ.ГГГГ`.",
+		}
+	}
+
+	start, end := dayBounds(day, loc)
+	availability, unknown, err := p.getAvailability(userID, credentials, users, start, end)
+	if err != nil {
+		return &model.CommandResponse{
+			ResponseType: "ephemeral",
+			Text:         fmt.Sprintf("❌ Ошибка получения занятости: %s", describeExchangeError(err)),
+		}
+	}
+
+	text := fmt.Sprintf("📊 **Занятость на %s** (%s)\n\n", start.Format(dialogDateLayout), loc.String())
+	if len(availability) > 0 {
+		text += formatFreeBusyTimeline(availability, start, loc) + "\n"
+	}
+	for _, mailbox := range availability {
+		if mailbox.Err != nil {
+			text += fmt.Sprintf("\n⚠️ %s: %s", mailbox.User, mailbox.Error)
+		}
+	}
+	if len(unknown) > 0 {
+		text += fmt.Sprintf("\n⚠️ Не найдены: %s", strings.Join(unknown, ", "))
+	}
+
+	return &model.CommandResponse{
+		ResponseType: "ephemeral",
+		Text:         text,
+	}
+}
+
+// handleFindTimeCommand suggests common meeting slots for the channel members or the listed users
+func (p *Plugin) handleFindTimeCommand(args *model.CommandArgs, arguments []string) *model.CommandResponse {
+	credentials, err := p.getUserExchangeCredentials(args.UserId)
+	if err != nil {
+		return &model.CommandResponse{
+			ResponseType: "ephemeral",
+			Text:         "❌ Exchange не настроен. Используйте `/exchange setup` для настройки.",
+		}
+	}
+
+	minutes, days, users, err := parseFindTimeArguments(arguments)
+	if err != nil {
+		return &model.CommandResponse{
+			ResponseType: "ephemeral",
+			Text:         fmt.Sprintf("❌ %s\n\nИспользование: `/exchange findtime [длительность] [дней] [@user...]`, например `/exchange findtime 1h 3`.", err.Error()),
+		}
+	}
+
+	subject := "Встреча"
+	if len(users) == 0 {
+		if channel, appErr := p.API.GetChannel(args.ChannelId); appErr == nil && channel.DisplayName != "" && channel.Type != model.ChannelTypeDirect {
+			subject = fmt.Sprintf("Встреча: %s", channel.DisplayName)
+		}
+		users, err = p.getChannelAttendees(args.ChannelId)
+		if err != nil {
+			p.API.LogError("Ошибка получения участников канала", "channel_id", args.ChannelId, "error", err.Error())
+			return &model.CommandResponse{
+				ResponseType: "ephemeral",
+				Text:         "❌ Не удалось получить участников канала.",
+			}
+		}
+	}
+
+	// The organizer's own calendar counts too
+	requester, appErr := p.API.GetUser(args.UserId)
+	if appErr != nil {
+		return &model.CommandResponse{
+			ResponseType: "ephemeral",
+			Text:         "❌ Не удалось получить данные пользователя.",
+		}
+	}
+	organizer := "@" + requester.Username
+	if !slices.Contains(users, organizer) {
+		users = append([]string{organizer}, users...)
+	}
+
+	if len(users) > maxAvailabilityMailboxes {
+		return &model.CommandResponse{
+			ResponseType: "ephemeral",
+			Text:         fmt.Sprintf("❌ Слишком много участников: подбор времени поддерживает не более %d человек. Перечислите участников явно.", maxAvailabilityMailboxes),
+		}
+	}
+
+	loc := p.getUserLocation(args.UserId)
+	now := time.Now()
+	from := now.Truncate(findTimeSlotStep).Add(findTimeSlotStep)
+	_, until := dayBounds(now.AddDate(0, 0, days-1), loc)
+
+	availability, unknown, err := p.getAvailability(args.UserId, credentials, users, from, until)
+	if err != nil {
+		return &model.CommandResponse{
+			ResponseType: "ephemeral",
+			Text:         fmt.Sprintf("❌ Ошибка получения занятости: %s", describeExchangeError(err)),
+		}
+	}
+
+	var attendees, noData []string
+	for _, mailbox := range availability {
+		if mailbox.Err != nil {
+			noData = append(noData, mailbox.User)
+		}
+		if mailbox.User != organizer {
+			attendees = append(attendees, mailbox.Email)
+		}
+	}
+
+	slots := findMeetingSlots(availability, from, until, time.Duration(minutes)*time.Minute, loc)
+	if len(slots) == 0 {
+		return &model.CommandResponse{
+			ResponseType: "ephemeral",
+			Text:         fmt.Sprintf("📅 Не удалось найти время для встречи длительностью %s в ближайшие %d дн.", durationLabel(minutes), days),
+		}
+	}
+
+	text := fmt.Sprintf("🔎 **Подходящее время для встречи** (%s, участников: %d, рабочее время %02d:00-%02d:00, %s)", durationLabel(minutes), len(availability), workdayStartHour, workdayEndHour, loc.String())
+	if len(noData) > 0 {
+		text += fmt.Sprintf("\n⚠️ Нет данных о занятости: %s", strings.Join(noData, ", "))
+	}
+	if len(unknown) > 0 {
+		text += fmt.Sprintf("\n⚠️ Не найдены: %s", strings.Join(unknown, ", "))
+	}
+
+	return p.bookingListResponse(args.UserId, text, formatMeetingSlots(slots, subject, attendees, loc))
+}
+
+// handleRoomsCommand lists the rooms that are free at the given time
+func (p *Plugin) handleRoomsCommand(userID string, arguments []string) *model.CommandResponse {
+	credentials, err := p.getUserExchangeCredentials(userID)
+	if err != nil {
+		return &model.CommandResponse{
+			ResponseType: "ephemeral",
+			Text:         "❌ Exchange не настроен. Используйте `/exchange setup` для настройки.",
+		}
+	}
+
+	loc := p.getUserLocation(userID)
+	building, start, minutes, err := parseRoomsArguments(arguments, time.Now(), loc)
+	if err != nil {
+		return &model.CommandResponse{
+			ResponseType: "ephemeral",
+			Text:         fmt.Sprintf("❌ %s\n\nИспользование: `/exchange rooms [здание] [время] [длительность]`, например `/exchange rooms Центральный 15:00 1h`.", err.Error()),
+		}
+	}
+	end := start.Add(time.Duration(minutes) * time.Minute)
+
+	lists, err := p.getRoomLists(userID, credentials)
+	if err != nil {
+		return &model.CommandResponse{
+			ResponseType: "ephemeral",
+			Text:         fmt.Sprintf("❌ Ошибка получения списков переговорных: %s", describeExchangeError(err)),
+		}
+	}
+	if len(lists) == 0 {
+		return &model.CommandResponse{
+			ResponseType: "ephemeral",
+			Text:         "🏢 В Exchange не настроены списки переговорных. Обратитесь к администратору Exchange.",
+		}
+	}
+
+	matched := matchRoomLists(lists, building)
+	if len(matched) == 0 {
+		names := make([]string, 0, len(lists))
+		for _, list := range lists {
+			names = append(names, list.Name)
+		}
+		return &model.CommandResponse{
+			ResponseType: "ephemeral",
+			Text:         fmt.Sprintf("🏢 Здание «%s» не найдено. Доступные списки переговорных: %s", building, strings.Join(names, ", ")),
+		}
+	}
+
+	rooms, err := p.findFreeRooms(userID, credentials, matched, start, end)
+	if err != nil {
+		return &model.CommandResponse{
+			ResponseType: "ephemeral",
+			Text:         fmt.Sprintf("❌ Ошибка поиска переговорных: %s", describeExchangeError(err)),
+		}
+	}
+
+	period := fmt.Sprintf("%s - %s", start.Format("02.01.2006 15:04"), end.Format("15:04"))
+	if len(rooms) == 0 {
+		return &model.CommandResponse{
+			ResponseType: "ephemeral",
+			Text:         fmt.Sprintf("🏢 Нет свободных переговорных на %s.", period),
+		}
+	}
+
+	return p.bookingListResponse(userID, fmt.Sprintf("🏢 **Свободные переговорные на %s** (%s)", period, loc.String()), formatFreeRooms(rooms, start, end))
+}
+
+// delegateUsage describes the arguments of /exchange delegate
+const delegateUsage = "Использование:\n" +
+	"- `/exchange delegate` - подключенные почтовые ящики\n" +
+	"- `/exchange delegate add <email|@user> [reminders] [invitations]` - подключить ящик, к которому у вас есть делегированный доступ\n" +
+	"- `/exchange delegate remove <email|@user>` - отключить ящик\n" +
+	"- `/exchange delegate reminders <email|@user> on|off` - напоминания о встречах этого календаря\n" +
+	"- `/exchange delegate invitations <email|@user> on|off` - уведомления о приглашениях в этот ящик\n\n" +
+	"Календарь ящика: `/exchange calendar --mailbox <email|@user>`."
+
+// handleDelegateCommand connects and configures mailboxes the user has delegate access to
+func (p *Plugin) handleDelegateCommand(userID string, arguments []string) *model.CommandResponse {
+	credentials, err := p.getUserExchangeCredentials(userID)
+	if err != nil {
+		return &model.CommandResponse{
+			ResponseType: "ephemeral",
+			Text:         "❌ Exchange не настроен. Используйте `/exchange setup` для настройки.",
+		}
+	}
+
+	if len(arguments) == 0 || arguments[0] == "list" {
+		mailboxes, err := p.getDelegateMailboxes(userID)
+		if err != nil {
+			p.API.LogError("Ошибка получения делегированных ящиков", "user_id", userID, "error", err.Error())
+			return &model.CommandResponse{
+				ResponseType: "ephemeral",
+				Text:         "❌ Ошибка получения делегированных ящиков",
+			}
+		}
+
+		return &model.CommandResponse{
+			ResponseType: "ephemeral",
+			Text:         formatDelegateMailboxes(mailboxes) + "\n" + delegateUsage,
+		}
+	}
+
+	if len(arguments) < 2 {
+		return &model.CommandResponse{
+			ResponseType: "ephemeral",
+			Text:         delegateUsage,
+		}
+	}
+
+	mailbox, connected, err := p.delegateMailbox(userID, arguments[1])
+	if err != nil {
+		return &model.CommandResponse{
+			ResponseType: "ephemeral",
+			Text:         fmt.Sprintf("❌ %s", err.Error()),
+		}
+	}
+
+	var text string
+	switch action := arguments[0]; action {
+	case "add":
+		if connected {
+			text = fmt.Sprintf("👥 Ящик %s уже подключен.", mailbox.Label())
+			break
+		}
+		mailbox.Reminders = slices.Contains(arguments[2:], "reminders")
+		mailbox.Invitations = slices.Contains(arguments[2:], "invitations")
+		if err := p.addDelegateMailbox(userID, credentials, mailbox); err != nil {
+			text = fmt.Sprintf("❌ Не удалось подключить ящик %s: %s", mailbox.Email, describeExchangeError(err))
+			break
+		}
+		text = fmt.Sprintf("✅ Ящик %s подключен.\n\n", mailbox.Label()) + formatDelegateMailboxes([]DelegateMailbox{mailbox})
+	case "remove":
+		removed, err := p.removeDelegateMailbox(userID, mailbox.Email)
+		switch {
+		case err != nil:
+			p.API.LogError("Ошибка отключения делегированного ящика", "user_id", userID, "error", err.Error())
+			text = "❌ Ошибка отключения ящика"
+		case !removed:
+			text = fmt.Sprintf("👥 Ящик %s не подключен.", mailbox.Email)
+		default:
+			text = fmt.Sprintf("✅ Ящик %s отключен.", mailbox.Label())
+		}
+	case "reminders", "invitations":
+		if len(arguments) < 3 || (arguments[2] != "on" && arguments[2] != "off") {
+			text = delegateUsage
+			break
+		}
+		updated, err := p.setDelegateOption(userID, mailbox.Email, action, arguments[2] == "on")
+		switch {
+		case err != nil:
+			p.API.LogError("Ошибка сохранения настроек делегированного ящика", "user_id", userID, "error", err.Error())
+			text = "❌ Ошибка сохранения настроек"
+		case !updated:
+			text = fmt.Sprintf("👥 Ящик %s не подключен. Используйте `/exchange delegate add %s`.", mailbox.Email, mailbox.Email)
+		default:
+			text = "✅ Настройки сохранены."
+			if action == "reminders" {
+				if err := p.reminderManager.UpdateRemindersForUser(userID); err != nil {
+					p.logExchangeError(userID, "Ошибка обновления напоминаний", err)
+				}
+			}
+		}
+	default:
+		text = delegateUsage
+	}
+
+	return &model.CommandResponse{
+		ResponseType: "ephemeral",
+		Text:         text,
+	}
+}
+
+// foldersUsage describes the arguments of /exchange folders
+const foldersUsage = "Использование:\n" +
+	"- `/exchange folders` - календари почтового ящика\n" +
+	"- `/exchange folders add <номер|название>` - учитывать календарь в статусе, напоминаниях и сводках\n" +
+	"- `/exchange folders remove <номер|название>` - больше не учитывать календарь\n\n" +
+	"Основной календарь учитывается всегда."
+
+// handleFoldersCommand lists the secondary calendar folders and chooses the ones that are synced
+func (p *Plugin) handleFoldersCommand(userID string, arguments []string) *model.CommandResponse {
+	credentials, err := p.getUserExchangeCredentials(userID)
+	if err != nil {
+		return &model.CommandResponse{
+			ResponseType: "ephemeral",
+			Text:         "❌ Exchange не настроен. Используйте `/exchange setup` для настройки.",
+		}
+	}
+
+	folders, err := p.findCalendarFolders(userID, credentials)
+	if err != nil {
+		return &model.CommandResponse{
+			ResponseType: "ephemeral",
+			Text:         fmt.Sprintf("❌ Ошибка получения списка календарей: %s", describeExchangeError(err)),
+		}
+	}
+
+	chosen, err := p.getCalendarFolders(userID)
+	if err != nil {
+		p.API.LogError("Ошибка получения выбранных календарей", "user_id", userID, "error", err.Error())
+		return &model.CommandResponse{
+			ResponseType: "ephemeral",
+			Text:         "❌ Ошибка получения выбранных календарей",
+		}
+	}
+
+	if len(arguments) == 0 || arguments[0] == "list" {
+		return &model.CommandResponse{
+			ResponseType: "ephemeral",
+			Text:         formatCalendarFolders(folders, chosen) + "\n" + foldersUsage,
+		}
+	}
+
+	action := arguments[0]
+	name := strings.Join(arguments[1:], " ")
+	if (action != "add" && action != "remove") || name == "" {
+		return &model.CommandResponse{
+			ResponseType: "ephemeral",
+			Text:         foldersUsage,
+		}
+	}
+
+	var folder CalendarFolder
+	if i := matchCalendarFolder(folders, name); i >= 0 {
+		folder = folders[i]
+	} else if i := matchCalendarFolder(chosen, name); i >= 0 && action == "remove" {
+		// A chosen folder may have been deleted or renamed in Exchange
+		folder = chosen[i]
+	} else {
+		return &model.CommandResponse{
+			ResponseType: "ephemeral",
+			Text:         fmt.Sprintf("📁 Календарь «%s» не найден.\n\n", name) + formatCalendarFolders(folders, chosen),
+		}
+	}
+
+	updated := make([]CalendarFolder, 0, len(chosen)+1)
+	for _, selected := range chosen {
+		if selected.ID != folder.ID {
+			updated = append(updated, selected)
+		}
+	}
+	if action == "add" {
+		if len(updated) >= maxCalendarFolders {
+			return &model.CommandResponse{
+				ResponseType: "ephemeral",
+				Text:         fmt.Sprintf("❌ Можно выбрать не более %d дополнительных календарей.", maxCalendarFolders),
+			}
+		}
+		updated = append(updated, folder)
+	}
+
+	if err := p.storeCalendarFolders(userID, updated); err != nil {
+		p.API.LogError("Ошибка сохранения выбранных календарей", "user_id", userID, "error", err.Error())
+		return &model.CommandResponse{
+			ResponseType: "ephemeral",
+			Text:         "❌ Ошибка сохранения выбранных календарей",
+		}
+	}
+
+	// The status is updated by the next sync; reminders are rebuilt right away
+	if err := p.reminderManager.UpdateRemindersForUser(userID); err != nil {
+		p.logExchangeError(userID, "Ошибка обновления напоминаний", err)
+	}
+
+	return &model.CommandResponse{
+		ResponseType: "ephemeral",
+		Text:         "✅ Выбор календарей сохранен.\n\n" + formatCalendarFolders(folders, updated),
+	}
+}
+
+// mappingUsage describes the arguments of /exchange mapping
+const mappingUsage = "Использование:\n" +
+	"- `/exchange mapping resolve <email|@user>` - пользователь Mattermost почтового ящика или почтовый ящик пользователя\n" +
+	"- `/exchange mapping` - заданные администратором сопоставления\n" +
+	"- `/exchange mapping set @user <email>` - сопоставить пользователю другой почтовый ящик\n" +
+	"- `/exchange mapping remove @user` - удалить сопоставление\n\n" +
+	"Без сопоставления почтовый ящик пользователя определяется по email его учетной записи. " +
+	"Изменять сопоставления могут только системные администраторы."
+
+// handleMappingCommand shows how mailboxes map to Mattermost users and lets administrators override it
+func (p *Plugin) handleMappingCommand(userID string, arguments []string) *model.CommandResponse {
+	if len(arguments) >= 2 && arguments[0] == "resolve" {
+		mapping, err := p.resolveUserMapping(userID, arguments[1])
+		if err != nil {
+			return &model.CommandResponse{
+				ResponseType: "ephemeral",
+				Text:         fmt.Sprintf("❌ %s", err.Error()),
+			}
+		}
+
+		text := fmt.Sprintf("🔗 %s — пользователь Mattermost не найден.", mapping.Email)
+		switch {
+		case mapping.Username != "" && mapping.Email == "":
+			text = fmt.Sprintf("🔗 @%s — почтовый ящик скрыт настройками конфиденциальности.", mapping.Username)
+		case mapping.Username != "":
+			text = fmt.Sprintf("🔗 %s → @%s", mapping.Email, mapping.Username)
+		}
+		return &model.CommandResponse{
+			ResponseType: "ephemeral",
+			Text:         text,
+		}
+	}
+
+	if !p.API.HasPermissionTo(userID, model.PermissionManageSystem) {
+		return &model.CommandResponse{
+			ResponseType: "ephemeral",
+			Text:         "❌ Изменять сопоставления могут только системные администраторы.\n\n" + mappingUsage,
+		}
+	}
+
+	var err error
+	text := ""
+	switch {
+	case len(arguments) == 0 || arguments[0] == "list":
+		var overrides map[string]string
+		if overrides, err = p.getMailboxOverrides(); err == nil {
+			text = formatMailboxOverrides(overrides) + "\n" + mappingUsage
+		}
+	case arguments[0] == "set" && len(arguments) == 3:
+		username := strings.TrimPrefix(arguments[1], "@")
+		if _, appErr := p.API.GetUserByUsername(username); appErr != nil {
+			text = fmt.Sprintf("❌ Пользователь @%s не найден", username)
+			break
+		}
+		if !isSMTPAddress(arguments[2]) {
+			text = fmt.Sprintf("❌ Некорректный адрес почтового ящика: %s", arguments[2])
+			break
+		}
+		if err = p.setMailboxOverride(username, arguments[2]); err == nil {
+			text = fmt.Sprintf("✅ Почтовый ящик @%s: %s", username, strings.ToLower(arguments[2]))
+		}
+	case arguments[0] == "remove" && len(arguments) == 2:
+		username := strings.TrimPrefix(arguments[1], "@")
+		if err = p.setMailboxOverride(username, ""); err == nil {
+			text = fmt.Sprintf("✅ Сопоставление @%s удалено.", username)
+		}
+	default:
+		text = mappingUsage
+	}
+
+	if err != nil {
+		p.API.LogError("Ошибка изменения сопоставлений почтовых ящиков", "user_id", userID, "error", err.Error())
+		text = "❌ Ошибка сохранения сопоставлений"
+	}
+
+	return &model.CommandResponse{
+		ResponseType: "ephemeral",
+		Text:         text,
+	}
+}
+
+// errEmailsHidden is returned for lookups that would reveal email addresses Mattermost hides
+var errEmailsHidden = errors.New("адреса электронной почты скрыты настройками конфиденциальности")
+
+// resolveUserMapping maps an email address to its Mattermost user or an @username to the user's mailbox.
+// When Mattermost hides email addresses, only administrators see other users' mailboxes and
+// may look up the account of an address.
+func (p *Plugin) resolveUserMapping(userID, value string) (MailboxMapping, error) {
+	if strings.HasPrefix(value, "@") || !strings.Contains(value, "@") {
+		user, appErr := p.API.GetUserByUsername(strings.TrimPrefix(value, "@"))
+		if appErr != nil || user.IsBot {
+			return MailboxMapping{}, fmt.Errorf("пользователь %s не найден", value)
+		}
+
+		mapping := MailboxMapping{}
+		mapping.setUser(user, "")
+		if user.Id == userID || p.canSeeEmails(userID) {
+			mapping.Email, mapping.Source = p.mailboxForUser(user)
+		}
+		return mapping, nil
+	}
+
+	if !p.canSeeEmails(userID) {
+		return MailboxMapping{}, errEmailsHidden
+	}
+	return p.mapMailbox(userID, value, ""), nil
+}
+
+// handleResolveUser returns the mapping of a mailbox to a Mattermost user.
+// Query: email (a mailbox address, with an optional name for legacy DN addresses) or user (@username).
+func (p *Plugin) handleResolveUser(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	var mapping MailboxMapping
+	switch {
+	case query.Get("email") != "":
+		if !p.canSeeEmails(userID) {
+			http.Error(w, "Email addresses are hidden", http.StatusForbidden)
+			return
+		}
+		mapping = p.mapMailbox(userID, query.Get("email"), query.Get("name"))
+	case query.Get("user") != "":
+		var err error
+		if mapping, err = p.resolveUserMapping(userID, "@"+strings.TrimPrefix(query.Get("user"), "@")); err != nil {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+	default:
+		http.Error(w, "Missing email or user", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(mapping)
+}
+
+// oofUsage describes the arguments of /exchange oof
+const oofUsage = "Использование:\n" +
+	"- `/exchange oof` - текущие настройки автоответа\n" +
+	"- `/exchange oof on \"сообщение\"` - включить автоответ\n" +
+	"- `/exchange oof off` - выключить автоответ\n" +
+	"- `/exchange oof schedule <с> [ЧЧ:ММ] <по> [ЧЧ:ММ] \"сообщение\"` - автоответ на период, например `/exchange oof schedule 20.10 24.10 \"В отпуске\"`\n\n" +
+	"Без сообщения сохраняется текущий текст автоответа."
+
+// handleOofCommand shows or changes the user's automatic replies
+func (p *Plugin) handleOofCommand(userID string, arguments []string) *model.CommandResponse {
+	credentials, err := p.getUserExchangeCredentials(userID)
+	if err != nil {
+		return &model.CommandResponse{
+			ResponseType: "ephemeral",
+			Text:         "❌ Exchange не настроен. Используйте `/exchange setup` для настройки.",
+		}
+	}
+
+	loc := p.getUserLocation(userID)
+	change, err := parseOofArguments(arguments, time.Now(), loc)
+	if err != nil {
+		return &model.CommandResponse{
+			ResponseType: "ephemeral",
+			Text:         fmt.Sprintf("❌ %s\n\n%s", err.Error(), oofUsage),
+		}
+	}
+
+	if change == nil {
+		settings, err := p.getOofSettings(userID, credentials)
+		if err != nil {
+			return &model.CommandResponse{
+				ResponseType: "ephemeral",
+				Text:         fmt.Sprintf("❌ Ошибка получения настроек автоответа: %s", describeExchangeError(err)),
+			}
+		}
+
+		return &model.CommandResponse{
+			ResponseType: "ephemeral",
+			Text:         formatOofSettings(settings, time.Now(), loc) + "\n" + oofUsage,
+		}
+	}
+
+	settings, err := p.changeOofSettings(userID, credentials, *change)
+	if err != nil {
+		return &model.CommandResponse{
+			ResponseType: "ephemeral",
+			Text:         fmt.Sprintf("❌ Ошибка изменения автоответа: %s", describeExchangeError(err)),
+		}
+	}
+
+	return &model.CommandResponse{
+		ResponseType: "ephemeral",
+		Text:         "✅ Настройки автоответа сохранены в Exchange\n\n" + formatOofSettings(settings, time.Now(), loc),
+	}
+}
+
+// handleGetOof returns the user's automatic replies and whether they are sent now
+func (p *Plugin) handleGetOof(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	credentials, err := p.getUserExchangeCredentials(userID)
+	if err != nil {
+		http.Error(w, "Exchange credentials not configured", http.StatusBadRequest)
+		return
+	}
+
+	settings, err := p.getOofSettings(userID, credentials)
+	if err != nil {
+		writeExchangeError(w, "Failed to get automatic replies", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"settings": settings,
+		"active":   settings.ActiveAt(time.Now()),
+	})
+}
+
+// handleUpdateOof turns the user's automatic replies on or off or schedules them.
+// Body: {"state": "Enabled|Disabled|Scheduled", "start": RFC 3339, "end": RFC 3339, "message": "..."}.
+func (p *Plugin) handleUpdateOof(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	credentials, err := p.getUserExchangeCredentials(userID)
+	if err != nil {
+		http.Error(w, "Exchange credentials not configured", http.StatusBadRequest)
+		return
+	}
+
+	var change OofChange
+	if err := json.NewDecoder(r.Body).Decode(&change); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	switch change.State {
+	case oofStateEnabled, oofStateDisabled:
+	case oofStateScheduled:
+		if change.Start.IsZero() || !change.End.After(change.Start) {
+			http.Error(w, "Invalid start or end", http.StatusBadRequest)
+			return
+		}
+		if !change.End.After(time.Now()) {
+			http.Error(w, "End is in the past", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Invalid state", http.StatusBadRequest)
+		return
+	}
+
+	settings, err := p.changeOofSettings(userID, credentials, change)
+	if err != nil {
+		writeExchangeError(w, "Failed to update automatic replies", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"settings": settings,
+		"active":   settings.ActiveAt(time.Now()),
+	})
+}
+
+// mailUsage describes the arguments of /exchange mail
+const mailUsage = "Использование:\n" +
+	"- `/exchange mail` - текущие настройки\n" +
+	"- `/exchange mail on|off` - включить или выключить уведомления о новых непрочитанных письмах\n" +
+	"- `/exchange mail important on|off` - уведомлять о письмах с высокой важностью\n" +
+	"- `/exchange mail senders add <email|@домен|@user>` - уведомлять о письмах отправителя, например `@example.com`\n" +
+	"- `/exchange mail senders remove <email|@домен|@user>` - убрать отправителя\n\n" +
+	"Письма, полученные до включения уведомлений, не объявляются."
+
+// handleMailCommand shows or changes the user's new mail notifications
+func (p *Plugin) handleMailCommand(userID string, arguments []string) *model.CommandResponse {
+	if _, err := p.getUserExchangeCredentials(userID); err != nil {
+		return &model.CommandResponse{
+			ResponseType: "ephemeral",
+			Text:         "❌ Exchange не настроен. Используйте `/exchange setup` для настройки.",
+		}
+	}
+
+	if !p.getConfiguration().EnableMailNotifications {
+		return &model.CommandResponse{
+			ResponseType: "ephemeral",
+			Text:         "✉️ Уведомления о новых письмах отключены администратором.",
+		}
+	}
+
+	watch, err := p.getMailWatch(userID)
+	if err != nil {
+		p.API.LogError("Ошибка получения настроек уведомлений о почте", "user_id", userID, "error", err.Error())
+		return &model.CommandResponse{
+			ResponseType: "ephemeral",
+			Text:         "❌ Ошибка получения настроек уведомлений о почте",
+		}
+	}
+
+	if len(arguments) == 0 {
+		return &model.CommandResponse{
+			ResponseType: "ephemeral",
+			Text:         formatMailWatch(watch) + "\n" + mailUsage,
+		}
+	}
+
+	switch {
+	case arguments[0] == "on" || arguments[0] == "off":
+		watch.Enabled = arguments[0] == "on"
+	case arguments[0] == "important" && len(arguments) == 2 && (arguments[1] == "on" || arguments[1] == "off"):
+		watch.Important = arguments[1] == "on"
+	case arguments[0] == "senders" && len(arguments) == 3 && (arguments[1] == "add" || arguments[1] == "remove"):
+		sender, ok := p.normalizeWatchedSender(arguments[2])
+		if !ok {
+			return &model.CommandResponse{
+				ResponseType: "ephemeral",
+				Text:         fmt.Sprintf("❌ Некорректный отправитель: %s. Укажите email, домен (например, `@example.com`) или @user.", arguments[2]),
+			}
+		}
+
+		senders := make([]string, 0, len(watch.Senders)+1)
+		for _, existing := range watch.Senders {
+			if existing != sender {
+				senders = append(senders, existing)
+			}
+		}
+		if arguments[1] == "add" {
+			if len(senders) >= maxWatchedSenders {
+				return &model.CommandResponse{
+					ResponseType: "ephemeral",
+					Text:         fmt.Sprintf("❌ Можно указать не более %d отправителей.", maxWatchedSenders),
+				}
+			}
+			senders = append(senders, sender)
+		}
+		watch.Senders = senders
+	default:
+		return &model.CommandResponse{
+			ResponseType: "ephemeral",
+			Text:         mailUsage,
+		}
+	}
+
+	if err := p.storeMailWatch(userID, watch); err != nil {
+		p.API.LogError("Ошибка сохранения настроек уведомлений о почте", "user_id", userID, "error", err.Error())
+		return &model.CommandResponse{
+			ResponseType: "ephemeral",
+			Text:         "❌ Ошибка сохранения настроек уведомлений о почте",
+		}
+	}
+
+	return &model.CommandResponse{
+		ResponseType: "ephemeral",
+		Text:         "✅ Настройки сохранены.\n\n" + formatMailWatch(watch),
+	}
+}
+
+// handleGetMailWatch returns the user's new mail notification settings
+func (p *Plugin) handleGetMailWatch(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	watch, err := p.getMailWatch(userID)
+	if err != nil {
+		http.Error(w, "Failed to get mail notification settings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(watch)
+}
+
+// handleUpdateMailWatch changes the user's new mail notification settings.
+// Body: {"enabled": true, "important": true, "senders": ["boss@example.com", "@example.com"]}.
+func (p *Plugin) handleUpdateMailWatch(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if _, err := p.getUserExchangeCredentials(userID); err != nil {
+		http.Error(w, "Exchange credentials not configured", http.StatusBadRequest)
+		return
+	}
+
+	var request MailWatch
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(request.Senders) > maxWatchedSenders {
+		http.Error(w, fmt.Sprintf("At most %d senders are supported", maxWatchedSenders), http.StatusBadRequest)
+		return
+	}
+
+	watch, err := p.getMailWatch(userID)
+	if err != nil {
+		http.Error(w, "Failed to get mail notification settings", http.StatusInternalServerError)
+		return
+	}
+
+	watch.Enabled, watch.Important = request.Enabled, request.Important
+	watch.Senders = make([]string, 0, len(request.Senders))
+	for _, value := range request.Senders {
+		sender, ok := p.normalizeWatchedSender(value)
+		if !ok {
+			http.Error(w, fmt.Sprintf("Invalid sender: %s", value), http.StatusBadRequest)
+			return
+		}
+		if !slices.Contains(watch.Senders, sender) {
+			watch.Senders = append(watch.Senders, sender)
+		}
+	}
+
+	if err := p.storeMailWatch(userID, watch); err != nil {
+		p.API.LogError("Ошибка сохранения настроек уведомлений о почте", "user_id", userID, "error", err.Error())
+		http.Error(w, "Failed to store mail notification settings", http.StatusInternalServerError)
+		return
+	}
+
+	// Return the stored settings with the start of tracking
+	if stored, err := p.getMailWatch(userID); err == nil {
+		watch = stored
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(watch)
+}
+
+// handleMarkMailRead marks the message of a mail notification read in Exchange
+func (p *Plugin) handleMarkMailRead(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request model.PostActionIntegrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	itemID, ok := request.Context["item_id"].(string)
+	if !ok || itemID == "" {
+		http.Error(w, "Missing item_id", http.StatusBadRequest)
+		return
+	}
+
+	credentials, err := p.getUserExchangeCredentials(userID)
+	if err != nil {
+		http.Error(w, "Exchange credentials not configured", http.StatusBadRequest)
+		return
+	}
+
+	if err := p.markMailRead(userID, credentials, itemID); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(model.PostActionIntegrationResponse{
+			EphemeralText: "❌ Не удалось отметить письмо прочитанным: " + describeExchangeError(err),
+		})
+		return
+	}
+
+	response := model.PostActionIntegrationResponse{}
+	if request.PostId != "" {
+		post, err := p.markMailNotificationRead(userID, request.PostId, itemID)
+		if err != nil {
+			p.API.LogError("Ошибка обновления уведомления о письме", "user_id", userID, "post_id", request.PostId, "error", err.Error())
+		}
+		response.Update = post
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleOpenMail replies with the Outlook Web App link to the message of a mail notification
+func (p *Plugin) handleOpenMail(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request model.PostActionIntegrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	itemID, ok := request.Context["item_id"].(string)
+	if !ok || itemID == "" {
+		http.Error(w, "Missing item_id", http.StatusBadRequest)
+		return
+	}
+
+	credentials, err := p.getUserExchangeCredentials(userID)
+	if err != nil {
+		http.Error(w, "Exchange credentials not configured", http.StatusBadRequest)
+		return
+	}
+
+	text := "❌ Не удалось сформировать ссылку на письмо"
+	if link, err := p.mailOWAURL(userID, credentials, itemID); err != nil {
+		text += ": " + describeExchangeError(err)
+	} else if link != "" {
+		text = fmt.Sprintf("🌐 [Открыть письмо в Outlook Web App](%s)", link)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(model.PostActionIntegrationResponse{EphemeralText: text})
+}
+
+// handleBookSlot creates the meeting for a slot suggested by /exchange findtime or a room found by /exchange rooms.
+// A slot is booked once: the listing is updated without its button, also when Exchange did not confirm the booking.
+func (p *Plugin) handleBookSlot(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request model.PostActionIntegrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	subject, _ := request.Context["subject"].(string)
+	location, _ := request.Context["location"].(string)
+	listID, _ := request.Context["list_id"].(string)
+	slot, _ := request.Context["slot"].(string)
+	start, startErr := time.Parse(time.RFC3339, fmt.Sprint(request.Context["start"]))
+	end, endErr := time.Parse(time.RFC3339, fmt.Sprint(request.Context["end"]))
+	if startErr != nil || endErr != nil || !end.After(start) || listID == "" || slot == "" {
+		http.Error(w, "Invalid slot", http.StatusBadRequest)
+		return
+	}
+
+	meeting := NewMeeting{
+		Subject:           subject,
+		Location:          location,
+		Start:             start,
+		End:               end,
+		RequiredAttendees: contextStrings(request.Context["attendees"]),
+		Resources:         contextStrings(request.Context["resources"]),
+	}
+
+	credentials, err := p.getUserExchangeCredentials(userID)
+	if err != nil {
+		http.Error(w, "Exchange credentials not configured", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if start.Before(time.Now()) {
+		json.NewEncoder(w).Encode(model.PostActionIntegrationResponse{
+			EphemeralText: "❌ Это время уже прошло. Повторите поиск.",
+		})
+		return
+	}
+
+	// Only the first click books the slot
+	claimed, err := p.claimBookingSlot(userID, listID, slot)
+	if err != nil {
+		p.API.LogError("Ошибка сохранения отметки о бронировании", "user_id", userID, "error", err.Error())
+		json.NewEncoder(w).Encode(model.PostActionIntegrationResponse{
+			EphemeralText: "❌ Не удалось забронировать встречу. Попробуйте еще раз.",
+		})
+		return
+	}
+	if !claimed {
+		json.NewEncoder(w).Encode(model.PostActionIntegrationResponse{
+			EphemeralText: "ℹ️ Это время уже забронировано.",
+		})
+		return
+	}
+
+	// The room may have been booked since the search
+	if len(meeting.Resources) > 0 {
+		ok, err := p.roomsAreFree(userID, credentials, meeting.Resources, start, end)
+		if err != nil {
+			p.releaseBookingSlot(userID, listID, slot)
+			p.logExchangeError(userID, "Ошибка проверки занятости переговорной", err)
+			json.NewEncoder(w).Encode(model.PostActionIntegrationResponse{
+				EphemeralText: fmt.Sprintf("❌ Не удалось проверить, свободна ли переговорная: %s", describeExchangeError(err)),
+			})
+			return
+		}
+		if !ok {
+			p.releaseBookingSlot(userID, listID, slot)
+			json.NewEncoder(w).Encode(model.PostActionIntegrationResponse{
+				EphemeralText: "❌ Переговорная уже занята на это время. Повторите поиск.",
+			})
+			return
+		}
+	}
+
+	_, link, err := p.createMeeting(userID, credentials, meeting)
+	if errors.Is(err, ErrOutcomeUnknown) {
+		// Keep the slot claimed: booking it again could create the meeting twice
+		p.API.LogWarn("Результат бронирования встречи неизвестен", "user_id", userID, "error", err.Error())
+		if err := p.sendMeetingUnconfirmedMessage(userID, meeting); err != nil {
+			p.API.LogError("Ошибка отправки сообщения о встрече", "user_id", userID, "error", err.Error())
+		}
+		json.NewEncoder(w).Encode(model.PostActionIntegrationResponse{
+			Update: p.bookedSlotUpdate(userID, listID, slot, "⚠️ Exchange не подтвердил бронирование. Проверьте календарь в Outlook."),
+		})
+		return
+	}
+	if err != nil {
+		p.releaseBookingSlot(userID, listID, slot)
+		p.API.LogWarn("Ошибка бронирования встречи", "user_id", userID, "error", err.Error())
+		json.NewEncoder(w).Encode(model.PostActionIntegrationResponse{
+			EphemeralText: fmt.Sprintf("❌ Не удалось создать встречу: %s", describeExchangeError(err)),
+		})
+		return
+	}
+
+	if err := p.sendMeetingCreatedMessage(userID, meeting, link); err != nil {
+		p.API.LogError("Ошибка отправки подтверждения о встрече", "user_id", userID, "error", err.Error())
+	}
+
+	if err := p.reminderManager.UpdateRemindersForUser(userID); err != nil {
+		p.logExchangeError(userID, "Ошибка обновления напоминаний", err)
+	}
+
+	loc := p.getUserLocation(userID)
+	json.NewEncoder(w).Encode(model.PostActionIntegrationResponse{
+		Update:        p.bookedSlotUpdate(userID, listID, slot, "✅ Забронировано"),
+		EphemeralText: fmt.Sprintf("✅ Встреча «%s» забронирована на %s, приглашения отправлены.", subject, start.In(loc).Format("02.01.2006 15:04")),
+	})
+}
+
+// bookedSlotUpdate returns the listing without the button of the booked slot, or nil if it cannot be restored
+func (p *Plugin) bookedSlotUpdate(userID, listID, slot, note string) *model.Post {
+	post, err := p.bookedListPost(userID, listID, slot, note)
+	if err != nil {
+		p.API.LogWarn("Ошибка обновления списка для бронирования", "user_id", userID, "error", err.Error())
+		return nil
+	}
+	return post
+}
+
+// contextStrings returns the strings of a list passed in a post action context
+func contextStrings(value interface{}) []string {
+	list, _ := value.([]interface{})
+
+	values := make([]string, 0, len(list))
+	for _, item := range list {
+		if s, ok := item.(string); ok && s != "" {
+			values = append(values, s)
+		}
+	}
+	return values
+}
+
+// handleGetAvailability returns the busy blocks of colleagues.
+// Query: users (comma-separated @usernames or email addresses) and either date (ГГГГ-ММ-ДД)
+// or start and end (RFC 3339); the default is today in the user's time zone.
+func (p *Plugin) handleGetAvailability(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	credentials, err := p.getUserExchangeCredentials(userID)
+	if err != nil {
+		http.Error(w, "Exchange credentials not configured", http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	users := strings.FieldsFunc(query.Get("users"), func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+	if len(users) == 0 {
+		http.Error(w, "Missing users", http.StatusBadRequest)
+		return
+	}
+
+	loc := p.getUserLocation(userID)
+	start, end := dayBounds(time.Now(), loc)
+	if date := query.Get("date"); date != "" {
+		day, err := time.ParseInLocation("2006-01-02", date, loc)
+		if err != nil {
+			http.Error(w, "Invalid date", http.StatusBadRequest)
+			return
+		}
+		start, end = dayBounds(day, loc)
+	}
+	if query.Get("start") != "" || query.Get("end") != "" {
+		start, err = time.Parse(time.RFC3339, query.Get("start"))
+		if err != nil {
+			http.Error(w, "Invalid start", http.StatusBadRequest)
+			return
+		}
+		end, err = time.Parse(time.RFC3339, query.Get("end"))
+		if err != nil || !end.After(start) {
+			http.Error(w, "Invalid end", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if len(users) > maxAvailabilityMailboxes {
+		http.Error(w, fmt.Sprintf("At most %d users are supported", maxAvailabilityMailboxes), http.StatusBadRequest)
+		return
+	}
+
+	availability, unknown, err := p.getAvailability(userID, credentials, users, start, end)
+	if err != nil {
+		writeExchangeError(w, "Failed to get availability", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"start":     start,
+		"end":       end,
+		"mailboxes": availability,
+		"unknown":   unknown,
+	})
+}
+
+// getExchangeHelp returns help text for Exchange commands
+func (p *Plugin) getExchangeHelp() *model.CommandResponse {
+	text := "### 📧 Exchange Integration - Справка\n\n" +
+		"**Доступные команды:**\n\n" +
+		"- `/exchange setup` - Инструкции по настройке\n" +
+		"- `/exchange status` - Текущий статус подключения\n" +
+		"- `/exchange calendar [--mailbox email]` - Просмотр календаря на сегодня (свой или делегированный)\n" +
+		"- `/exchange create [тема]` - Создать встречу в Exchange\n" +
+		"- `/exchange reschedule` - Перенести встречу, которую вы организуете\n" +
+		"- `/exchange cancel` - Отменить встречу, которую вы организуете\n" +
+		"- `/exchange freebusy @user1 @user2 [дата]` - Занятость коллег\n" +
+		"- `/exchange findtime [длительность] [дней] [@user...]` - Подобрать время встречи для участников канала\n" +
+		"- `/exchange rooms [здание] [время] [длительность]` - Найти и забронировать свободную переговорную\n" +
+		"- `/exchange oof [on|off|schedule]` - Автоответ «Нет на месте»\n" +
+		"- `/exchange folders [add|remove]` - Дополнительные календари для статуса, напоминаний и сводок\n" +
+		"- `/exchange delegate [add|remove|reminders|invitations]` - Календари, к которым у вас есть делегированный доступ\n" +
+		"- `/exchange mapping [resolve|set|remove]` - Сопоставление пользователей Mattermost и почтовых ящиков\n" +
+		"- `/exchange mail [on|off|important|senders]` - Уведомления о важных письмах и письмах от выбранных отправителей\n" +
+		"- `/exchange reminders` - Управление напоминаниями о встречах\n" +
+		"- `/exchange help` - Эта справка\n\n" +
+		"**Функции:**\n" +
+		"- 🔄 Автоматическая синхронизация статуса на основе календаря\n" +
+		"- 📅 Ежедневная утренняя сводка встреч (в 9:00)\n" +
+		"- 📧 Уведомления о новых приглашениях на встречи\n" +
+		"- ✉️ Уведомления о важных письмах (по желанию)\n" +
+		"- ⏰ Напоминания за 15 минут до встречи\n" +
+		"- ✅ Возможность принимать/отклонять встречи прямо из Mattermost"
+
+	return &model.CommandResponse{
+		ResponseType: "ephemeral",
+		Text:         text,
+	}
+}
+
+// handleSnoozeReminder handles snoozing a meeting reminder
+func (p *Plugin) handleSnoozeReminder(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		Context map[string]interface{} `json:"context"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	eventID, ok := request.Context["event_id"].(string)
+	if !ok {
+		http.Error(w, "Missing event_id", http.StatusBadRequest)
+		return
+	}
+
+	// Reminders are stored per occurrence; older posts only carry the event ID
+	key := eventID
+	if occurrenceKey, ok := request.Context["occurrence_key"].(string); ok && occurrenceKey != "" {
+		key = occurrenceKey
+	}
+
+	snoozeMinsFloat, ok := request.Context["snooze_mins"].(float64)
+	if !ok {
+		http.Error(w, "Missing snooze_mins", http.StatusBadRequest)
+		return
+	}
+	snoozeMins := int(snoozeMinsFloat)
+
+	// Snooze the reminder without overwriting changes made concurrently by the checks
+	snoozeTime := time.Now().Add(time.Duration(snoozeMins) * time.Minute)
+	reminder, err := p.reminderManager.snoozeReminder(userID, key, snoozeTime)
+	if err != nil {
+		http.Error(w, "Failed to update reminder", http.StatusInternalServerError)
+		return
+	}
+
+	if reminder != nil {
+		// Send confirmation message
+		bot, botErr := p.API.GetBot("", true)
+		if botErr == nil {
+			channel, channelErr := p.API.GetDirectChannel(userID, bot.UserId)
+			if channelErr == nil {
+				post := &model.Post{
+					ChannelId: channel.Id,
+					UserId:    bot.UserId,
+					Message:   fmt.Sprintf("⏱️ Напоминание о встрече \"%s\" отложено на %d минут", reminder.Subject, snoozeMins),
+				}
+				p.API.CreatePost(post)
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "Reminder snoozed",
+	})
+}
+
+// handleOpenCalendar handles opening calendar view
+func (p *Plugin) handleOpenCalendar(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// For now, just send a message with today's calendar
+	credentials, err := p.getUserExchangeCredentials(userID)
+	if err != nil {
+		http.Error(w, "Exchange credentials not configured", http.StatusBadRequest)
+		return
+	}
+
+	// Get today's events in the user's time zone
+	loc := p.getUserLocation(userID)
+	startOfDay, endOfDay := dayBounds(time.Now(), loc)
+
+	events, err := p.getCalendarEventsInRange(userID, credentials, startOfDay, endOfDay)
+	if err != nil {
+		writeExchangeError(w, "Failed to get calendar events", err)
+		return
+	}
+
+	var message string
+	if len(events) == 0 {
+		message = "📅 На сегодня встреч не запланировано."
+	} else {
+		message = "📅 **Ваши встречи на сегодня:**\n\n"
+		for _, event := range events {
+			startTime := event.Start.In(loc).Format("15:04")
+			endTime := event.End.In(loc).Format("15:04")
+
+			message += fmt.Sprintf("🕐 **%s - %s**: %s%s", startTime, endTime, escapeMentions(event.Subject), recurrenceMark(event))
+			if event.Location != "" {
+				message += fmt.Sprintf(" (📍 %s)", escapeMentions(event.Location))
+			}
+			message += "\n"
+		}
+	}
+
+	// Send calendar message
+	bot, botErr := p.API.GetBot("", true)
+	if botErr == nil {
+		channel, channelErr := p.API.GetDirectChannel(userID, bot.UserId)
+		if channelErr == nil {
+			post := &model.Post{
+				ChannelId: channel.Id,
+				UserId:    bot.UserId,
+				Message:   message,
+			}
+			p.API.CreatePost(post)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "Calendar displayed",
+	})
+}
+
+// handleGetEvent returns the full details of a calendar event
+func (p *Plugin) handleGetEvent(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	eventID, err := url.PathUnescape(mux.Vars(r)["id"])
+	if err != nil || eventID == "" {
+		http.Error(w, "Invalid event id", http.StatusBadRequest)
+		return
+	}
+
+	credentials, err := p.getUserExchangeCredentials(userID)
+	if err != nil {
+		http.Error(w, "Exchange credentials not configured", http.StatusBadRequest)
+		return
+	}
+
+	details, err := p.getEventDetails(userID, credentials, eventID)
+	if err != nil {
+		writeExchangeError(w, "Failed to get event details", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(details)
+}
+
+// handleEventDetailsAction sends the details of a calendar event to the user (the "Details" button)
+func (p *Plugin) handleEventDetailsAction(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		Context map[string]interface{} `json:"context"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	eventID, ok := request.Context["event_id"].(string)
+	if !ok {
+		http.Error(w, "Missing event_id", http.StatusBadRequest)
+		return
+	}
+
+	credentials, err := p.getUserExchangeCredentials(userID)
+	if err != nil {
+		http.Error(w, "Exchange credentials not configured", http.StatusBadRequest)
+		return
+	}
+
+	var message string
+	details, err := p.getEventDetails(userID, credentials, eventID)
+	if err != nil {
+		p.API.LogWarn("Ошибка получения подробностей встречи", "user_id", userID, "event_id", eventID, "error", err.Error())
+		message = fmt.Sprintf("❌ Не удалось получить подробности встречи: %s", describeExchangeError(err))
+	} else {
+		message = p.formatEventDetails(userID, details)
+	}
+
+	// Send details message
+	bot, botErr := p.API.GetBot("", true)
+	if botErr == nil {
+		channel, channelErr := p.API.GetDirectChannel(userID, bot.UserId)
+		if channelErr == nil {
+			post := &model.Post{
+				ChannelId: channel.Id,
+				UserId:    bot.UserId,
+				Message:   message,
+			}
+			p.API.CreatePost(post)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "Event details displayed",
 	})
 }
 
@@ -613,7 +2370,7 @@ func (p *Plugin) handleUpdateReminders(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := p.reminderManager.UpdateRemindersForUser(userID); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to update reminders: %s", err.Error()), http.StatusInternalServerError)
+		writeExchangeError(w, "Failed to update reminders", err)
 		return
 	}
 
@@ -650,10 +2407,11 @@ func (p *Plugin) handleRemindersCommand(userID string) *model.CommandResponse {
 		}
 	}
 
+	loc := p.getUserLocation(userID)
 	text := "⏰ **Ваши предстоящие напоминания:**\n\n"
 	for _, reminder := range upcomingReminders {
-		meetingTime := reminder.StartTime.Format("02.01.2006 15:04")
-		reminderTime := reminder.ReminderTime.Format("02.01.2006 15:04")
+		meetingTime := reminder.StartTime.In(loc).Format("02.01.2006 15:04")
+		reminderTime := reminder.ReminderTime.In(loc).Format("02.01.2006 15:04")
 
 		text += fmt.Sprintf("📅 **%s**\n", reminder.Subject)
 		text += fmt.Sprintf("   🕐 Встреча: %s\n", meetingTime)
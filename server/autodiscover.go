@@ -0,0 +1,480 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// maxAutodiscoverRedirects limits address, URL and HTTP redirects for one lookup
+const maxAutodiscoverRedirects = 10
+
+// POX Autodiscover request (MS-OXDSCLI)
+type poxAutodiscoverRequest struct {
+	XMLName xml.Name              `xml:"http://schemas.microsoft.com/exchange/autodiscover/outlook/requestschema/2006 Autodiscover"`
+	Request poxAutodiscoverParams `xml:"Request"`
+}
+
+type poxAutodiscoverParams struct {
+	EMailAddress             string `xml:"EMailAddress"`
+	AcceptableResponseSchema string `xml:"AcceptableResponseSchema"`
+}
+
+// POX Autodiscover response
+type poxAutodiscoverResponse struct {
+	XMLName  xml.Name `xml:"Autodiscover"`
+	Response struct {
+		Error *struct {
+			ErrorCode string `xml:"ErrorCode"`
+			Message   string `xml:"Message"`
+		} `xml:"Error"`
+		Account struct {
+			Action       string `xml:"Action"`
+			RedirectAddr string `xml:"RedirectAddr"`
+			RedirectUrl  string `xml:"RedirectUrl"`
+			Protocol     []struct {
+				Type   string `xml:"Type"`
+				EwsUrl string `xml:"EwsUrl"`
+			} `xml:"Protocol"`
+		} `xml:"Account"`
+	} `xml:"Response"`
+}
+
+// SOAP Autodiscover GetUserSettings response (MS-OXWSADISC)
+type soapAutodiscoverResponse struct {
+	XMLName xml.Name `xml:"Envelope"`
+	Body    struct {
+		GetUserSettingsResponseMessage *struct {
+			Response struct {
+				ErrorCode     string `xml:"ErrorCode"`
+				ErrorMessage  string `xml:"ErrorMessage"`
+				UserResponses struct {
+					UserResponse []struct {
+						ErrorCode      string `xml:"ErrorCode"`
+						ErrorMessage   string `xml:"ErrorMessage"`
+						RedirectTarget string `xml:"RedirectTarget"`
+						UserSettings   struct {
+							UserSetting []struct {
+								Name  string `xml:"Name"`
+								Value string `xml:"Value"`
+							} `xml:"UserSetting"`
+						} `xml:"UserSettings"`
+					} `xml:"UserResponse"`
+				} `xml:"UserResponses"`
+			} `xml:"Response"`
+		} `xml:"GetUserSettingsResponseMessage"`
+		Fault *SOAPFault `xml:"Fault"`
+	} `xml:"Body"`
+}
+
+const soapAutodiscoverTemplate = `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:a="http://schemas.microsoft.com/exchange/2010/Autodiscover" xmlns:wsa="http://www.w3.org/2005/08/addressing" xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/">
+  <soap:Header>
+    <a:RequestedServerVersion>Exchange2010</a:RequestedServerVersion>
+    <wsa:Action>http://schemas.microsoft.com/exchange/2010/Autodiscover/Autodiscover/GetUserSettings</wsa:Action>
+    <wsa:To>%s</wsa:To>
+  </soap:Header>
+  <soap:Body>
+    <a:GetUserSettingsRequestMessage>
+      <a:Request>
+        <a:Users>
+          <a:User>
+            <a:Mailbox>%s</a:Mailbox>
+          </a:User>
+        </a:Users>
+        <a:RequestedSettings>
+          <a:Setting>InternalEwsUrl</a:Setting>
+          <a:Setting>ExternalEwsUrl</a:Setting>
+        </a:RequestedSettings>
+      </a:Request>
+    </a:GetUserSettingsRequestMessage>
+  </soap:Body>
+</soap:Envelope>`
+
+// autodiscoverResult is the outcome of a single Autodiscover request
+type autodiscoverResult struct {
+	ewsURL       string
+	redirectAddr string
+	redirectURL  string
+}
+
+// Autodiscover finds the EWS URL for the mailbox using SOAP and POX Autodiscover.
+// It tries the configured server, the email domain and autodiscover.<domain>,
+// then the HTTP redirect method and the SRV record, following redirects.
+// Credentials are only sent to trusted hosts (see trustedAutodiscoverHost); other
+// candidates are asked without them, and redirects to untrusted hosts are refused.
+func (c *ExchangeClient) Autodiscover(email string) (string, error) {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return "", fmt.Errorf("invalid email address: %q", email)
+	}
+	originalDomain := strings.ToLower(email[at+1:])
+
+	var attempts []string
+	for redirects := 0; redirects < maxAutodiscoverRedirects; redirects++ {
+		domain := email[at+1:]
+		result, err := c.autodiscoverDomain(email, domain, &attempts)
+		if err != nil {
+			return "", err
+		}
+
+		if result.redirectAddr != "" {
+			// The mailbox lives under another address; start over with it
+			at = strings.LastIndex(result.redirectAddr, "@")
+			if at < 0 {
+				return "", fmt.Errorf("invalid Autodiscover redirect address: %q", result.redirectAddr)
+			}
+			if domain := strings.ToLower(result.redirectAddr[at+1:]); domain != originalDomain && !c.trustedAutodiscoverHost(domain) {
+				return "", fmt.Errorf("Autodiscover перенаправил на адрес в другом домене %s, перенаправление отклонено", result.redirectAddr)
+			}
+			email = result.redirectAddr
+			continue
+		}
+
+		// The EWS URL receives the credentials of every request
+		if !c.trustedAutodiscoverURL(result.ewsURL) {
+			return "", fmt.Errorf("Autodiscover вернул EWS URL %s на недоверенном хосте или без HTTPS", result.ewsURL)
+		}
+
+		return result.ewsURL, nil
+	}
+
+	return "", errors.New("too many Autodiscover redirects")
+}
+
+// autodiscoverDomain tries all candidate Autodiscover endpoints for a domain
+func (c *ExchangeClient) autodiscoverDomain(email, domain string, attempts *[]string) (*autodiscoverResult, error) {
+	var hosts []string
+	if serverURL, err := url.Parse(c.serverURL); err == nil && serverURL.Host != "" {
+		hosts = append(hosts, serverURL.Host)
+	}
+	hosts = append(hosts, domain, "autodiscover."+domain)
+
+	seen := make(map[string]bool)
+	for _, host := range hosts {
+		if seen[host] {
+			continue
+		}
+		seen[host] = true
+
+		if result := c.autodiscoverHost("https://"+host, email, attempts); result != nil {
+			return result, nil
+		}
+	}
+
+	// HTTP redirect method: http://autodiscover.<domain> answers with a 302 to the real endpoint
+	if location := c.autodiscoverHTTPRedirect(domain); location != "" {
+		if result := c.autodiscoverURL(location, email, false, attempts); result != nil {
+			return result, nil
+		}
+	}
+
+	// DNS SRV record _autodiscover._tcp.<domain>
+	if _, records, err := net.LookupSRV("autodiscover", "tcp", domain); err == nil {
+		for _, record := range records {
+			host := strings.TrimSuffix(record.Target, ".")
+			if seen[host] {
+				continue
+			}
+			seen[host] = true
+
+			if result := c.autodiscoverHost("https://"+host, email, attempts); result != nil {
+				return result, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("Autodiscover не нашел EWS для %s:\n%s", email, strings.Join(*attempts, "\n"))
+}
+
+// autodiscoverHost tries SOAP and then POX Autodiscover on a host
+func (c *ExchangeClient) autodiscoverHost(baseURL, email string, attempts *[]string) *autodiscoverResult {
+	if result := c.autodiscoverURL(baseURL+"/autodiscover/autodiscover.svc", email, true, attempts); result != nil {
+		return result
+	}
+	return c.autodiscoverURL(baseURL+"/autodiscover/autodiscover.xml", email, false, attempts)
+}
+
+// autodiscoverURL sends one Autodiscover request, following HTTP and URL redirects.
+// It returns nil when the endpoint did not give a usable answer.
+func (c *ExchangeClient) autodiscoverURL(endpoint, email string, soap bool, attempts *[]string) *autodiscoverResult {
+	for redirects := 0; redirects < maxAutodiscoverRedirects; redirects++ {
+		body, location, err := c.postAutodiscover(endpoint, email, soap, c.trustedAutodiscoverURL(endpoint))
+		if err != nil {
+			if certMsg, ok := describeCertificateError(err); ok {
+				*attempts = append(*attempts, fmt.Sprintf("• %s: 🔒 %s", endpoint, certMsg))
+				return nil
+			}
+			*attempts = append(*attempts, fmt.Sprintf("• %s: %v", endpoint, err))
+			return nil
+		}
+		if location != "" {
+			if !c.trustedAutodiscoverURL(location) {
+				*attempts = append(*attempts, fmt.Sprintf("• %s: перенаправление на недоверенный хост %s отклонено", endpoint, location))
+				return nil
+			}
+			endpoint = location
+			continue
+		}
+
+		var result *autodiscoverResult
+		if soap {
+			result, err = parseSOAPAutodiscoverResponse(body)
+		} else {
+			result, err = parsePOXAutodiscoverResponse(body)
+		}
+		if err != nil {
+			*attempts = append(*attempts, fmt.Sprintf("• %s: %v", endpoint, err))
+			return nil
+		}
+
+		if result.redirectURL != "" {
+			if !c.secureAutodiscoverURL(result.redirectURL) {
+				*attempts = append(*attempts, fmt.Sprintf("• %s: небезопасный redirect %s", endpoint, result.redirectURL))
+				return nil
+			}
+			if !c.trustedAutodiscoverURL(result.redirectURL) {
+				*attempts = append(*attempts, fmt.Sprintf("• %s: перенаправление на недоверенный хост %s отклонено", endpoint, result.redirectURL))
+				return nil
+			}
+			endpoint = result.redirectURL
+			continue
+		}
+
+		return result
+	}
+
+	*attempts = append(*attempts, fmt.Sprintf("• %s: слишком много перенаправлений", endpoint))
+	return nil
+}
+
+// postAutodiscover posts the request and returns either the body or an HTTPS redirect location.
+// Credentials are only attached when withCredentials is set.
+func (c *ExchangeClient) postAutodiscover(endpoint, email string, soap, withCredentials bool) ([]byte, string, error) {
+	var payload string
+	if soap {
+		payload = fmt.Sprintf(soapAutodiscoverTemplate, xmlEscape(endpoint), xmlEscape(email))
+	} else {
+		data, err := xml.Marshal(poxAutodiscoverRequest{
+			Request: poxAutodiscoverParams{
+				EMailAddress:             email,
+				AcceptableResponseSchema: "http://schemas.microsoft.com/exchange/autodiscover/outlook/responseschema/2006a",
+			},
+		})
+		if err != nil {
+			return nil, "", err
+		}
+		payload = `<?xml version="1.0" encoding="utf-8"?>` + string(data)
+	}
+
+	req, err := http.NewRequest("POST", endpoint, strings.NewReader(payload))
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Content-Type", "text/xml; charset=utf-8")
+	if soap {
+		req.Header.Set("SOAPAction", `"http://schemas.microsoft.com/exchange/2010/Autodiscover/Autodiscover/GetUserSettings"`)
+	}
+	if withCredentials {
+		req.SetBasicAuth(c.authUsername(), c.credentials.Password)
+	}
+
+	resp, err := c.noRedirectClient().Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		location := resp.Header.Get("Location")
+		if !c.secureAutodiscoverURL(location) {
+			return nil, "", fmt.Errorf("небезопасный redirect %q", location)
+		}
+		return nil, location, nil
+	case http.StatusOK:
+		body, err := io.ReadAll(resp.Body)
+		return body, "", err
+	case http.StatusUnauthorized:
+		if !withCredentials {
+			return nil, "", errors.New("хост не в списке доверенных, учетные данные не отправлены")
+		}
+		return nil, "", fmt.Errorf("HTTP %d", resp.StatusCode)
+	default:
+		return nil, "", fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+}
+
+// autodiscoverHTTPRedirect asks http://autodiscover.<domain> for a redirect to the HTTPS endpoint
+func (c *ExchangeClient) autodiscoverHTTPRedirect(domain string) string {
+	resp, err := c.noRedirectClient().Get("http://autodiscover." + domain + "/autodiscover/autodiscover.xml")
+	if err != nil {
+		return ""
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusFound && resp.StatusCode != http.StatusMovedPermanently {
+		return ""
+	}
+
+	location := resp.Header.Get("Location")
+	if !c.trustedAutodiscoverURL(location) {
+		return ""
+	}
+	return location
+}
+
+// trustedAutodiscoverURL reports whether the URL is on a trusted host and uses HTTPS
+func (c *ExchangeClient) trustedAutodiscoverURL(rawURL string) bool {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Hostname() == "" || !c.secureAutodiscoverURL(rawURL) {
+		return false
+	}
+	return c.trustedAutodiscoverHost(parsed.Hostname())
+}
+
+// secureAutodiscoverURL reports whether the URL uses HTTPS. Plain HTTP is accepted only
+// when the administrator has configured an http:// server URL.
+func (c *ExchangeClient) secureAutodiscoverURL(rawURL string) bool {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+
+	switch strings.ToLower(parsed.Scheme) {
+	case "https":
+		return true
+	case "http":
+		serverURL, err := url.Parse(c.serverURL)
+		return err == nil && strings.EqualFold(serverURL.Scheme, "http")
+	}
+	return false
+}
+
+// trustedAutodiscoverHost reports whether credentials may be sent to the host: the host of
+// the configured server, or a host in one of the domains the administrator has approved
+func (c *ExchangeClient) trustedAutodiscoverHost(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if serverURL, err := url.Parse(c.serverURL); err == nil && strings.EqualFold(serverURL.Hostname(), host) {
+		return true
+	}
+
+	for _, domain := range c.autodiscoverDomains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseDomainList parses a comma- or space-separated list of domains into lowercase names
+func parseDomainList(value string) []string {
+	var domains []string
+	for _, domain := range strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ';' || r == ' ' || r == '\n'
+	}) {
+		domain = strings.ToLower(strings.Trim(strings.TrimSpace(domain), "."))
+		if domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	return domains
+}
+
+// noRedirectClient shares the transport but lets Autodiscover handle redirects itself,
+// so POST bodies and credentials are never sent to non-HTTPS locations
+func (c *ExchangeClient) noRedirectClient() *http.Client {
+	return &http.Client{
+		Transport: c.httpClient.Transport,
+		Timeout:   c.httpClient.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func parsePOXAutodiscoverResponse(body []byte) (*autodiscoverResult, error) {
+	var resp poxAutodiscoverResponse
+	if err := xml.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal Autodiscover response: %w", err)
+	}
+
+	if resp.Response.Error != nil {
+		return nil, fmt.Errorf("Autodiscover error %s: %s", resp.Response.Error.ErrorCode, resp.Response.Error.Message)
+	}
+
+	account := resp.Response.Account
+	switch account.Action {
+	case "redirectAddr":
+		return &autodiscoverResult{redirectAddr: account.RedirectAddr}, nil
+	case "redirectUrl":
+		return &autodiscoverResult{redirectURL: account.RedirectUrl}, nil
+	}
+
+	// EXCH is the internal endpoint, EXPR the external (Outlook Anywhere) one
+	for _, protocolType := range []string{"EXCH", "EXPR", "WEB"} {
+		for _, protocol := range account.Protocol {
+			if protocol.Type == protocolType && protocol.EwsUrl != "" {
+				return &autodiscoverResult{ewsURL: protocol.EwsUrl}, nil
+			}
+		}
+	}
+
+	return nil, errors.New("в ответе Autodiscover нет EwsUrl")
+}
+
+func parseSOAPAutodiscoverResponse(body []byte) (*autodiscoverResult, error) {
+	var resp soapAutodiscoverResponse
+	if err := xml.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal Autodiscover response: %w", err)
+	}
+
+	if resp.Body.Fault != nil {
+		return nil, fmt.Errorf("SOAP fault: %s - %s", resp.Body.Fault.Code, resp.Body.Fault.String)
+	}
+
+	message := resp.Body.GetUserSettingsResponseMessage
+	if message == nil {
+		return nil, errors.New("empty Autodiscover response")
+	}
+	if message.Response.ErrorCode != "" && message.Response.ErrorCode != "NoError" {
+		return nil, fmt.Errorf("Autodiscover error %s: %s", message.Response.ErrorCode, message.Response.ErrorMessage)
+	}
+	if len(message.Response.UserResponses.UserResponse) == 0 {
+		return nil, errors.New("empty Autodiscover user response")
+	}
+
+	userResponse := message.Response.UserResponses.UserResponse[0]
+	switch userResponse.ErrorCode {
+	case "", "NoError":
+	case "RedirectAddress":
+		return &autodiscoverResult{redirectAddr: userResponse.RedirectTarget}, nil
+	case "RedirectUrl":
+		return &autodiscoverResult{redirectURL: userResponse.RedirectTarget}, nil
+	default:
+		return nil, fmt.Errorf("Autodiscover error %s: %s", userResponse.ErrorCode, userResponse.ErrorMessage)
+	}
+
+	settings := make(map[string]string)
+	for _, setting := range userResponse.UserSettings.UserSetting {
+		settings[setting.Name] = setting.Value
+	}
+	for _, name := range []string{"InternalEwsUrl", "ExternalEwsUrl"} {
+		if settings[name] != "" {
+			return &autodiscoverResult{ewsURL: settings[name]}, nil
+		}
+	}
+
+	return nil, errors.New("в ответе Autodiscover нет EwsUrl")
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
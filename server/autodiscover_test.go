@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTrustedAutodiscoverHost(t *testing.T) {
+	client := NewExchangeClient("https://mail.contoso.com/EWS/Exchange.asmx", &ExchangeCredentials{}, ExchangeClientOptions{
+		AutodiscoverDomains: parseDomainList("fabrikam.com, .Northwind.org"),
+	})
+
+	tests := []struct {
+		host    string
+		trusted bool
+	}{
+		{"mail.contoso.com", true},
+		{"MAIL.CONTOSO.COM.", true},
+		{"contoso.com", false},
+		{"autodiscover.contoso.com", false},
+		{"fabrikam.com", true},
+		{"autodiscover.fabrikam.com", true},
+		{"fabrikam.com.attacker.org", false},
+		{"evilfabrikam.com", false},
+		{"autodiscover.northwind.org", true},
+	}
+
+	for _, tt := range tests {
+		if got := client.trustedAutodiscoverHost(tt.host); got != tt.trusted {
+			t.Errorf("trustedAutodiscoverHost(%q) = %v, want %v", tt.host, got, tt.trusted)
+		}
+	}
+}
+
+func TestAutodiscoverSendsCredentialsOnlyToTrustedHosts(t *testing.T) {
+	var authorizations []string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "https://autodiscover.attacker.org/autodiscover/autodiscover.xml", http.StatusFound)
+			return
+		}
+		if r.Header.Get("Authorization") == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`<Autodiscover><Response><Account><Protocol><Type>EXCH</Type><EwsUrl>https://127.0.0.1/EWS/Exchange.asmx</EwsUrl></Protocol></Account></Response></Autodiscover>`))
+	}))
+	defer server.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+	newClient := func(domains string) *ExchangeClient {
+		return NewExchangeClient("https://mail.contoso.com", &ExchangeCredentials{Username: "jdoe@contoso.com", Password: "Secret1!"}, ExchangeClientOptions{
+			TLSConfig:           &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+			AutodiscoverDomains: parseDomainList(domains),
+		})
+	}
+
+	var attempts []string
+	if result := newClient("").autodiscoverURL(server.URL+"/autodiscover/autodiscover.xml", "jdoe@contoso.com", false, &attempts); result != nil {
+		t.Fatalf("untrusted host answered without credentials: %+v", result)
+	}
+	if len(authorizations) != 1 || authorizations[0] != "" {
+		t.Fatalf("credentials sent to an untrusted host: %q", authorizations)
+	}
+	if len(attempts) != 1 || !strings.Contains(attempts[0], "учетные данные не отправлены") {
+		t.Errorf("unexpected attempts: %q", attempts)
+	}
+
+	authorizations, attempts = nil, nil
+	result := newClient("127.0.0.1").autodiscoverURL(server.URL+"/autodiscover/autodiscover.xml", "jdoe@contoso.com", false, &attempts)
+	if result == nil || result.ewsURL != "https://127.0.0.1/EWS/Exchange.asmx" {
+		t.Fatalf("trusted host: unexpected result %+v, attempts %q", result, attempts)
+	}
+	if len(authorizations) != 1 || !strings.HasPrefix(authorizations[0], "Basic ") {
+		t.Errorf("credentials not sent to a trusted host: %q", authorizations)
+	}
+
+	attempts = nil
+	if result := newClient("127.0.0.1").autodiscoverURL(server.URL+"/redirect", "jdoe@contoso.com", false, &attempts); result != nil {
+		t.Fatalf("redirect to an untrusted host followed: %+v", result)
+	}
+	if len(attempts) != 1 || !strings.Contains(attempts[0], "отклонено") {
+		t.Errorf("unexpected attempts: %q", attempts)
+	}
+}
+
+func TestTrustedAutodiscoverURLRequiresHTTPS(t *testing.T) {
+	secure := NewExchangeClient("https://mail.contoso.com", &ExchangeCredentials{}, ExchangeClientOptions{})
+	plain := NewExchangeClient("http://mail.contoso.com", &ExchangeCredentials{}, ExchangeClientOptions{})
+
+	tests := []struct {
+		name    string
+		client  *ExchangeClient
+		url     string
+		trusted bool
+	}{
+		{"https", secure, "https://mail.contoso.com/EWS/Exchange.asmx", true},
+		{"http on a trusted host", secure, "http://mail.contoso.com/EWS/Exchange.asmx", false},
+		{"other scheme", secure, "ftp://mail.contoso.com/EWS/Exchange.asmx", false},
+		{"http server allows http", plain, "http://mail.contoso.com/EWS/Exchange.asmx", true},
+		{"http server still checks the host", plain, "http://mail.attacker.org/EWS/Exchange.asmx", false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.client.trustedAutodiscoverURL(tt.url); got != tt.trusted {
+			t.Errorf("%s: trustedAutodiscoverURL(%q) = %v, want %v", tt.name, tt.url, got, tt.trusted)
+		}
+	}
+}
+
+func TestAutodiscoverDoesNotFollowHTTPRedirects(t *testing.T) {
+	var requests int
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.Redirect(w, r, "http://127.0.0.1/autodiscover/autodiscover.xml", http.StatusFound)
+	}))
+	defer server.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+	client := NewExchangeClient("https://mail.contoso.com", &ExchangeCredentials{Username: "jdoe@contoso.com", Password: "Secret1!"}, ExchangeClientOptions{
+		TLSConfig:           &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+		AutodiscoverDomains: parseDomainList("127.0.0.1"),
+	})
+
+	var attempts []string
+	if result := client.autodiscoverURL(server.URL+"/autodiscover/autodiscover.xml", "jdoe@contoso.com", false, &attempts); result != nil {
+		t.Fatalf("HTTP redirect followed: %+v", result)
+	}
+	if requests != 1 || len(attempts) != 1 || !strings.Contains(attempts[0], "небезопасный redirect") {
+		t.Errorf("unexpected requests %d, attempts %q", requests, attempts)
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxAvailabilityMailboxes limits the mailboxes of one availability lookup
+const maxAvailabilityMailboxes = 20
+
+// freeBusyMergedInterval is required by GetUserAvailability even though the FreeBusy view does not use it
+const freeBusyMergedInterval = 30
+
+// The free/busy timeline covers the working day in slots of freeBusySlot
+const (
+	freeBusyTimelineStartHour = 8
+	freeBusyTimelineEndHour   = 20
+	freeBusySlot              = 15 * time.Minute
+)
+
+// busyTypeRanks orders the busy statuses; merged blocks keep the strongest one
+var busyTypeRanks = map[string]int{
+	"Tentative": 1,
+	"Busy":      2,
+	"OOF":       3,
+}
+
+// busyTypeMarks are the timeline characters of the busy statuses
+var busyTypeMarks = map[string]string{
+	"":          "·",
+	"Tentative": "░",
+	"Busy":      "█",
+	"OOF":       "▓",
+}
+
+// BusyBlock is a period in which a mailbox is not free
+type BusyBlock struct {
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Status string    `json:"status"` // Tentative, Busy, OOF
+}
+
+// MailboxAvailability is the availability of one mailbox
+type MailboxAvailability struct {
+	User  string      `json:"user"` // The requested @username or email address
+	Email string      `json:"email"`
+	Busy  []BusyBlock `json:"busy"`
+	Error string      `json:"error,omitempty"`
+
+	// Err is set when Exchange could not return the mailbox availability, e.g. for unknown addresses
+	Err error `json:"-"`
+}
+
+// utcTimeZone is the SerializableTimeZone of UTC without daylight saving time
+func utcTimeZone() *SerializableTimeZone {
+	transition := &SerializableTimeZoneTime{Time: "00:00:00", DayOfWeek: "Sunday"}
+	return &SerializableTimeZone{StandardTime: transition, DaylightTime: transition}
+}
+
+// GetUserAvailability returns the merged busy blocks of the mailboxes in the range.
+// It works for any mailbox the user may see free/busy information of, in the order of emails.
+func (c *ExchangeClient) GetUserAvailability(emails []string, start, end time.Time) ([]MailboxAvailability, error) {
+	mailboxes := &MailboxDataArray{}
+	for _, email := range emails {
+		mailboxes.MailboxData = append(mailboxes.MailboxData, MailboxData{
+			Email:        &EmailAddress{Address: email},
+			AttendeeType: "Required",
+		})
+	}
+
+	soapResp, err := c.sendSOAPRequest("GetUserAvailability", SOAPBody{
+		GetUserAvailabilityRequest: &GetUserAvailabilityRequest{
+			TimeZone:         utcTimeZone(),
+			MailboxDataArray: mailboxes,
+			FreeBusyViewOptions: &FreeBusyViewOptions{
+				TimeWindow: &TimeWindow{
+					StartTime: start.UTC().Format("2006-01-02T15:04:05"),
+					EndTime:   end.UTC().Format("2006-01-02T15:04:05"),
+				},
+				MergedFreeBusyIntervalInMinutes: freeBusyMergedInterval,
+				RequestedView:                   "FreeBusy",
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if soapResp.Body.GetUserAvailabilityResponse == nil ||
+		soapResp.Body.GetUserAvailabilityResponse.FreeBusyResponseArray == nil {
+		return nil, fmt.Errorf("empty GetUserAvailability response")
+	}
+
+	responses := soapResp.Body.GetUserAvailabilityResponse.FreeBusyResponseArray.FreeBusyResponse
+	if len(responses) != len(emails) {
+		return nil, fmt.Errorf("GetUserAvailability returned %d mailboxes instead of %d", len(responses), len(emails))
+	}
+
+	availability := make([]MailboxAvailability, 0, len(emails))
+	for i, response := range responses {
+		mailbox := MailboxAvailability{Email: emails[i], Busy: []BusyBlock{}}
+
+		if response.ResponseMessage != nil {
+			if err := response.ResponseMessage.Err(); err != nil {
+				c.throttled(err)
+				mailbox.Err = err
+				availability = append(availability, mailbox)
+				continue
+			}
+		}
+
+		if response.FreeBusyView != nil && response.FreeBusyView.CalendarEventArray != nil {
+			var blocks []BusyBlock
+			for _, event := range response.FreeBusyView.CalendarEventArray.CalendarEvent {
+				if _, busy := busyTypeRanks[event.BusyType]; !busy {
+					continue // Free and NoData
+				}
+
+				// Times are returned in the request time zone, i.e. UTC
+				blockStart, err := parseEWSDateTime(event.StartTime, time.UTC)
+				if err != nil {
+					continue
+				}
+				blockEnd, err := parseEWSDateTime(event.EndTime, time.UTC)
+				if err != nil {
+					continue
+				}
+
+				blocks = append(blocks, BusyBlock{Start: blockStart, End: blockEnd, Status: event.BusyType})
+			}
+			mailbox.Busy = mergeBusyBlocks(blocks)
+		}
+
+		availability = append(availability, mailbox)
+	}
+
+	return availability, nil
+}
+
+// mergeBusyBlocks merges overlapping and adjacent blocks, keeping the strongest status
+func mergeBusyBlocks(blocks []BusyBlock) []BusyBlock {
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].Start.Before(blocks[j].Start)
+	})
+
+	merged := make([]BusyBlock, 0, len(blocks))
+	for _, block := range blocks {
+		last := len(merged) - 1
+		if last < 0 || block.Start.After(merged[last].End) {
+			merged = append(merged, block)
+			continue
+		}
+
+		if block.End.After(merged[last].End) {
+			merged[last].End = block.End
+		}
+		if busyTypeRanks[block.Status] > busyTypeRanks[merged[last].Status] {
+			merged[last].Status = block.Status
+		}
+	}
+
+	return merged
+}
+
+// getAvailability looks up the availability of colleagues with the user's credentials.
+// users are @usernames or email addresses; the ones that cannot be mapped to a mailbox are returned separately.
+func (p *Plugin) getAvailability(userID string, credentials *ExchangeCredentials, users []string, start, end time.Time) ([]MailboxAvailability, []string, error) {
+	var emails, requested, unknown []string
+	for _, user := range users {
+		email, ok := p.resolveMailbox(user)
+		if !ok {
+			unknown = append(unknown, user)
+			continue
+		}
+		emails = append(emails, email)
+		requested = append(requested, user)
+	}
+
+	if len(emails) == 0 {
+		return []MailboxAvailability{}, unknown, nil
+	}
+	if len(emails) > maxAvailabilityMailboxes {
+		return nil, unknown, fmt.Errorf("too many mailboxes: %d, at most %d are supported", len(emails), maxAvailabilityMailboxes)
+	}
+
+	client, err := p.newUserExchangeClient(userID, credentials)
+	if err != nil {
+		return nil, unknown, err
+	}
+
+	availability, err := client.GetUserAvailability(emails, start, end)
+	p.recordEndpointResult(userID, client, err)
+	if err != nil {
+		return nil, unknown, err
+	}
+
+	for i := range availability {
+		availability[i].User = requested[i]
+		if availability[i].Err != nil {
+			availability[i].Error = describeExchangeError(availability[i].Err)
+		}
+	}
+
+	return availability, unknown, nil
+}
+
+// parseDayArgument parses a command date: today, tomorrow, ДД.ММ.ГГГГ, ДД.ММ or ГГГГ-ММ-ДД
+func parseDayArgument(value string, now time.Time, loc *time.Location) (time.Time, bool) {
+	now = now.In(loc)
+
+	switch strings.ToLower(value) {
+	case "today", "сегодня":
+		return now, true
+	case "tomorrow", "завтра":
+		return now.AddDate(0, 0, 1), true
+	}
+
+	for _, layout := range []string{dialogDateLayout, "2006-01-02"} {
+		if day, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return day, true
+		}
+	}
+
+	if day, err := time.ParseInLocation("02.01", value, loc); err == nil {
+		return time.Date(now.Year(), day.Month(), day.Day(), 0, 0, 0, 0, loc), true
+	}
+
+	return time.Time{}, false
+}
+
+// formatFreeBusyTimeline renders the working day of the mailboxes as a monospaced timeline
+func formatFreeBusyTimeline(availability []MailboxAvailability, day time.Time, loc *time.Location) string {
+	day = day.In(loc)
+	timelineStart := time.Date(day.Year(), day.Month(), day.Day(), freeBusyTimelineStartHour, 0, 0, 0, loc)
+	slotsPerHour := int(time.Hour / freeBusySlot)
+
+	width := 0
+	for _, mailbox := range availability {
+		if n := len([]rune(mailbox.User)); n > width {
+			width = n
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString("```\n")
+	header := strings.Repeat(" ", width+1)
+	for hour := freeBusyTimelineStartHour; hour < freeBusyTimelineEndHour; hour++ {
+		header += fmt.Sprintf("%-*s", slotsPerHour, fmt.Sprintf("%02d", hour))
+	}
+	sb.WriteString(strings.TrimRight(header, " ") + "\n")
+
+	for _, mailbox := range availability {
+		sb.WriteString(mailbox.User)
+		sb.WriteString(strings.Repeat(" ", width+1-len([]rune(mailbox.User))))
+
+		if mailbox.Err != nil {
+			sb.WriteString("нет данных\n")
+			continue
+		}
+
+		for slot := 0; slot < (freeBusyTimelineEndHour-freeBusyTimelineStartHour)*slotsPerHour; slot++ {
+			slotStart := timelineStart.Add(time.Duration(slot) * freeBusySlot)
+			slotEnd := slotStart.Add(freeBusySlot)
+
+			status := ""
+			for _, block := range mailbox.Busy {
+				if block.Start.Before(slotEnd) && block.End.After(slotStart) &&
+					busyTypeRanks[block.Status] > busyTypeRanks[status] {
+					status = block.Status
+				}
+			}
+			sb.WriteString(busyTypeMarks[status])
+		}
+		sb.WriteString("\n")
+	}
+	sb.WriteString("```\n")
+	sb.WriteString("· свободен   ░ под вопросом   █ занят   ▓ нет на месте")
+
+	return sb.String()
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMergeBusyBlocks(t *testing.T) {
+	day := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+	block := func(from, to int, status string) BusyBlock {
+		return BusyBlock{Start: day.Add(time.Duration(from) * time.Hour), End: day.Add(time.Duration(to) * time.Hour), Status: status}
+	}
+
+	tests := []struct {
+		name   string
+		blocks []BusyBlock
+		want   []BusyBlock
+	}{
+		{"empty", nil, []BusyBlock{}},
+		{"separate blocks are sorted", []BusyBlock{block(14, 15, "Busy"), block(9, 10, "Tentative")}, []BusyBlock{block(9, 10, "Tentative"), block(14, 15, "Busy")}},
+		{"adjacent blocks merge", []BusyBlock{block(9, 10, "Busy"), block(10, 11, "Busy")}, []BusyBlock{block(9, 11, "Busy")}},
+		{"overlap keeps the strongest status", []BusyBlock{block(9, 11, "Tentative"), block(10, 12, "OOF"), block(11, 12, "Busy")}, []BusyBlock{block(9, 12, "OOF")}},
+		{"contained block keeps the end", []BusyBlock{block(9, 17, "Busy"), block(10, 11, "Tentative")}, []BusyBlock{block(9, 17, "Busy")}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mergeBusyBlocks(tt.blocks)
+			if len(got) != len(tt.want) {
+				t.Fatalf("mergeBusyBlocks = %+v, want %+v", got, tt.want)
+			}
+			for i := range got {
+				if !got[i].Start.Equal(tt.want[i].Start) || !got[i].End.Equal(tt.want[i].End) || got[i].Status != tt.want[i].Status {
+					t.Errorf("block %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
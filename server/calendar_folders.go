@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxCalendarFolders limits the secondary calendar folders one user may choose
+const maxCalendarFolders = 10
+
+// calendarFolderClass is the folder class of calendar folders
+const calendarFolderClass = "IPF.Appointment"
+
+// CalendarFolder is a calendar folder of the user's mailbox other than the default calendar,
+// e.g. a project calendar kept as a subfolder
+type CalendarFolder struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// FindCalendarFolders returns the calendar folders of the user's mailbox except the default calendar, ordered by name
+func (c *ExchangeClient) FindCalendarFolders() ([]CalendarFolder, error) {
+	defaultID, err := c.defaultCalendarFolderID()
+	if err != nil {
+		return nil, err
+	}
+
+	soapResp, err := c.sendSOAPRequest("FindFolder", SOAPBody{
+		FindFolder: &FindFolder{
+			Traversal:   "Deep",
+			FolderShape: &FolderShape{BaseShape: "Default"},
+			Restriction: &Restriction{
+				IsEqualTo: &IsEqualTo{
+					FieldURI: &FieldURI{FieldURI: "folder:FolderClass"},
+					FieldURIOrConstant: &FieldURIOrConstant{
+						Constant: &Constant{Value: calendarFolderClass},
+					},
+				},
+			},
+			ParentFolderIds: &ParentFolderIds{
+				DistinguishedFolderId: &DistinguishedFolderId{Id: "msgfolderroot"},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if soapResp.Body.FindFolderResponse == nil ||
+		soapResp.Body.FindFolderResponse.ResponseMessages == nil ||
+		soapResp.Body.FindFolderResponse.ResponseMessages.FindFolderResponseMessage == nil {
+		return nil, fmt.Errorf("empty FindFolder response")
+	}
+
+	responseMessage := soapResp.Body.FindFolderResponse.ResponseMessages.FindFolderResponseMessage
+	if err := responseMessage.Err(); err != nil {
+		c.throttled(err)
+		return nil, err
+	}
+
+	folders := []CalendarFolder{}
+	if responseMessage.RootFolder != nil && responseMessage.RootFolder.Folders != nil {
+		for _, folder := range responseMessage.RootFolder.Folders.CalendarFolder {
+			if folder.FolderId.Id == defaultID {
+				continue
+			}
+			folders = append(folders, CalendarFolder{ID: folder.FolderId.Id, Name: folder.DisplayName})
+		}
+	}
+
+	sort.SliceStable(folders, func(i, j int) bool {
+		return strings.ToLower(folders[i].Name) < strings.ToLower(folders[j].Name)
+	})
+
+	return folders, nil
+}
+
+// defaultCalendarFolderID returns the folder ID of the distinguished calendar folder
+func (c *ExchangeClient) defaultCalendarFolderID() (string, error) {
+	soapResp, err := c.sendSOAPRequest("GetFolder", SOAPBody{
+		GetFolder: &GetFolder{
+			FolderShape: &FolderShape{BaseShape: "IdOnly"},
+			FolderIds: &FolderIds{
+				DistinguishedFolderId: []DistinguishedFolderId{{Id: "calendar"}},
+			},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if soapResp.Body.GetFolderResponse == nil ||
+		soapResp.Body.GetFolderResponse.ResponseMessages == nil ||
+		len(soapResp.Body.GetFolderResponse.ResponseMessages.GetFolderResponseMessage) != 1 {
+		return "", fmt.Errorf("unexpected GetFolder response")
+	}
+
+	message := soapResp.Body.GetFolderResponse.ResponseMessages.GetFolderResponseMessage[0]
+	if err := message.Err(); err != nil {
+		c.throttled(err)
+		return "", err
+	}
+	if message.Folders == nil || len(message.Folders.CalendarFolder) == 0 {
+		return "", fmt.Errorf("empty GetFolder response")
+	}
+
+	return message.Folders.CalendarFolder[0].FolderId.Id, nil
+}
+
+// getCalendarFolders returns the secondary calendar folders the user has chosen
+func (p *Plugin) getCalendarFolders(userID string) ([]CalendarFolder, error) {
+	data, appErr := p.API.KVGet(fmt.Sprintf("exchange_calendar_folders_%s", userID))
+	if appErr != nil {
+		return nil, fmt.Errorf("failed to get calendar folders: %w", appErr)
+	}
+
+	if data == nil {
+		return []CalendarFolder{}, nil
+	}
+
+	var folders []CalendarFolder
+	if err := json.Unmarshal(data, &folders); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal calendar folders: %w", err)
+	}
+
+	return folders, nil
+}
+
+// storeCalendarFolders stores the secondary calendar folders the user has chosen
+func (p *Plugin) storeCalendarFolders(userID string, folders []CalendarFolder) error {
+	data, err := json.Marshal(folders)
+	if err != nil {
+		return fmt.Errorf("failed to marshal calendar folders: %w", err)
+	}
+
+	if appErr := p.API.KVSet(fmt.Sprintf("exchange_calendar_folders_%s", userID), data); appErr != nil {
+		return fmt.Errorf("failed to store calendar folders: %w", appErr)
+	}
+
+	return nil
+}
+
+// findCalendarFolders discovers the secondary calendar folders of the user's mailbox
+func (p *Plugin) findCalendarFolders(userID string, credentials *ExchangeCredentials) ([]CalendarFolder, error) {
+	client, err := p.newUserExchangeClient(userID, credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	folders, err := client.FindCalendarFolders()
+	p.recordEndpointResult(userID, client, err)
+
+	return folders, err
+}
+
+// addSecondaryCalendarEvents adds the events of the user's chosen calendar folders to the
+// events of the default calendar. A failing folder is logged and skipped, so it does not
+// stop status sync, reminders or summaries.
+func (p *Plugin) addSecondaryCalendarEvents(userID string, client *ExchangeClient, events []CalendarEvent, start, end time.Time) []CalendarEvent {
+	folders, err := p.getCalendarFolders(userID)
+	if err != nil {
+		p.API.LogError("Ошибка получения выбранных календарей", "user_id", userID, "error", err.Error())
+		return events
+	}
+	if len(folders) == 0 {
+		return events
+	}
+
+	for _, folder := range folders {
+		folderEvents, err := client.GetFolderCalendarEvents(folder.ID, start, end)
+		if err != nil {
+			p.logExchangeError(userID, "Ошибка получения событий календаря «"+folder.Name+"»", err)
+			continue
+		}
+		events = append(events, folderEvents...)
+	}
+
+	return mergeCalendarEvents(events)
+}
+
+// mergeCalendarEvents removes events present in several folders, e.g. a meeting copied to a
+// project calendar, and orders the rest by start time
+func mergeCalendarEvents(events []CalendarEvent) []CalendarEvent {
+	seen := make(map[string]bool, len(events))
+	merged := make([]CalendarEvent, 0, len(events))
+	for _, event := range events {
+		key := event.ID
+		if event.UID != "" {
+			key = event.UID + "_" + event.Start.UTC().Format(time.RFC3339)
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		merged = append(merged, event)
+	}
+
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].Start.Before(merged[j].Start)
+	})
+
+	return merged
+}
+
+// matchCalendarFolder returns the folder with the 1-based number or the name, or -1
+func matchCalendarFolder(folders []CalendarFolder, value string) int {
+	if number, err := strconv.Atoi(value); err == nil {
+		if number >= 1 && number <= len(folders) {
+			return number - 1
+		}
+		return -1
+	}
+
+	for i, folder := range folders {
+		if strings.EqualFold(folder.Name, value) {
+			return i
+		}
+	}
+	return -1
+}
+
+// formatCalendarFolders lists the discovered folders, marking the chosen ones
+func formatCalendarFolders(folders, chosen []CalendarFolder) string {
+	if len(folders) == 0 {
+		return "📁 Кроме основного календаря других календарей в почтовом ящике не найдено.\n"
+	}
+
+	text := "📁 **Календари почтового ящика:**\n\n"
+	for i, folder := range folders {
+		mark := "⬜"
+		for _, selected := range chosen {
+			if selected.ID == folder.ID {
+				mark = "✅"
+				break
+			}
+		}
+		text += fmt.Sprintf("%d. %s %s\n", i+1, mark, folder.Name)
+	}
+	return text
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMergeCalendarEvents(t *testing.T) {
+	day := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+	event := func(id, uid string, hour int) CalendarEvent {
+		start := day.Add(time.Duration(hour) * time.Hour)
+		return CalendarEvent{ID: id, UID: uid, Start: start, End: start.Add(time.Hour)}
+	}
+
+	tests := []struct {
+		name   string
+		events []CalendarEvent
+		want   []string // IDs in the merged order
+	}{
+		{"empty", nil, nil},
+		{"ordered by start", []CalendarEvent{event("b", "", 14), event("a", "", 9)}, []string{"a", "b"}},
+		{"meeting copied to another folder", []CalendarEvent{event("own", "uid-1", 10), event("project", "uid-1", 10)}, []string{"own"}},
+		{"occurrences of one series", []CalendarEvent{event("mon", "uid-1", 10), event("tue", "uid-1", 34)}, []string{"mon", "tue"}},
+		{"same item without UID", []CalendarEvent{event("a", "", 10), event("a", "", 10)}, []string{"a"}},
+		{"different items without UID", []CalendarEvent{event("a", "", 10), event("b", "", 10)}, []string{"a", "b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ids []string
+			for _, event := range mergeCalendarEvents(tt.events) {
+				ids = append(ids, event.ID)
+			}
+			if strings.Join(ids, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("merged = %v, want %v", ids, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchCalendarFolder(t *testing.T) {
+	folders := []CalendarFolder{{Name: "Проект"}, {Name: "Отпуска"}}
+
+	tests := []struct {
+		value string
+		want  int
+	}{
+		{"1", 0},
+		{"2", 1},
+		{"0", -1},
+		{"3", -1},
+		{"отпуска", 1},
+		{"Праздники", -1},
+	}
+
+	for _, tt := range tests {
+		if got := matchCalendarFolder(folders, tt.value); got != tt.want {
+			t.Errorf("matchCalendarFolder(%q) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// maxSyncChangesReturned is the page size of SyncFolderItems requests
+const maxSyncChangesReturned = 512
+
+// The calendar cache covers a window larger than the synced -24h..+7d range,
+// so it is rebuilt only about once a week
+const (
+	calendarCacheLookBehind = 24 * time.Hour
+	calendarCacheLookAhead  = 14 * 24 * time.Hour
+	calendarCacheMinAhead   = 7 * 24 * time.Hour
+)
+
+// CalendarChanges are the calendar folder changes since a sync state
+type CalendarChanges struct {
+	SyncState string
+	// Created and Updated are the changed items as stored in the folder: single items
+	// and recurring masters. Occurrences and exceptions are reported as master updates.
+	Created []CalendarEvent
+	Updated []CalendarEvent
+	Deleted []string
+}
+
+// Empty reports whether nothing has changed
+func (c *CalendarChanges) Empty() bool {
+	return len(c.Created) == 0 && len(c.Updated) == 0 && len(c.Deleted) == 0
+}
+
+// SyncCalendarItems returns the calendar folder changes since syncState.
+// An empty syncState starts a new synchronization; the items then only carry their IDs.
+func (c *ExchangeClient) SyncCalendarItems(syncState string) (*CalendarChanges, error) {
+	shape := &ItemShape{BaseShape: "IdOnly"}
+	if syncState != "" {
+		shape = &ItemShape{
+			BaseShape:            "Default",
+			AdditionalProperties: calendarEventProperties(),
+		}
+	}
+
+	changes := &CalendarChanges{SyncState: syncState}
+	for {
+		soapResp, err := c.sendSOAPRequest("SyncFolderItems", SOAPBody{
+			SyncFolderItems: &SyncFolderItems{
+				ItemShape: shape,
+				SyncFolderId: &SyncFolderId{
+					DistinguishedFolderId: &DistinguishedFolderId{
+						Id: "calendar",
+					},
+				},
+				SyncState:          changes.SyncState,
+				MaxChangesReturned: maxSyncChangesReturned,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if soapResp.Body.SyncFolderItemsResponse == nil ||
+			soapResp.Body.SyncFolderItemsResponse.ResponseMessages == nil ||
+			len(soapResp.Body.SyncFolderItemsResponse.ResponseMessages.SyncFolderItemsResponseMessage) == 0 {
+			return nil, fmt.Errorf("empty SyncFolderItems response")
+		}
+
+		responseMessage := soapResp.Body.SyncFolderItemsResponse.ResponseMessages.SyncFolderItemsResponseMessage[0]
+		if err := responseMessage.Err(); err != nil {
+			c.throttled(err)
+			return nil, err
+		}
+
+		changes.SyncState = responseMessage.SyncState
+		if responseMessage.Changes != nil && syncState != "" {
+			c.collectCalendarChanges(changes, responseMessage.Changes)
+		}
+
+		if responseMessage.IncludesLastItemInRange != "false" {
+			return changes, nil
+		}
+	}
+}
+
+// collectCalendarChanges appends the changes of one SyncFolderItems page
+func (c *ExchangeClient) collectCalendarChanges(changes *CalendarChanges, syncChanges *SyncChanges) {
+	for _, change := range syncChanges.Change {
+		if change.XMLName.Local == "Delete" {
+			if change.ItemId != nil {
+				changes.Deleted = append(changes.Deleted, change.ItemId.Id)
+			}
+			continue
+		}
+
+		if change.CalendarItem == nil {
+			continue // Read flag changes and non-calendar items
+		}
+
+		event, err := c.convertToCalendarEvent(*change.CalendarItem)
+		if err != nil {
+			continue // Skip problematic events
+		}
+
+		switch change.XMLName.Local {
+		case "Create":
+			changes.Created = append(changes.Created, event)
+		case "Update":
+			changes.Updated = append(changes.Updated, event)
+		}
+	}
+}
+
+// calendarCache is the per-user copy of the calendar window patched by incremental sync
+type calendarCache struct {
+	SyncState   string          `json:"sync_state"`
+	WindowStart time.Time       `json:"window_start"`
+	WindowEnd   time.Time       `json:"window_end"`
+	Events      []CalendarEvent `json:"events"`
+}
+
+// covers reports whether the cached window contains the range
+func (cache *calendarCache) covers(start, end time.Time) bool {
+	return !start.Before(cache.WindowStart) && !end.After(cache.WindowEnd)
+}
+
+// eventsInRange returns the cached events overlapping the range, ordered by start
+func (cache *calendarCache) eventsInRange(start, end time.Time) []CalendarEvent {
+	events := make([]CalendarEvent, 0, len(cache.Events))
+	for _, event := range cache.Events {
+		if event.Start.Before(end) && event.End.After(start) {
+			events = append(events, event)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Start.Before(events[j].Start)
+	})
+	return events
+}
+
+// apply patches the cached events with the changes. It returns false if a recurring
+// series has changed, since its occurrences can only be expanded by a CalendarView.
+func (cache *calendarCache) apply(changes *CalendarChanges) bool {
+	removed := make(map[string]bool, len(changes.Deleted))
+	for _, id := range changes.Deleted {
+		removed[id] = true
+	}
+
+	var upserted []CalendarEvent
+	for _, event := range append(changes.Created, changes.Updated...) {
+		if event.CalendarItemType != "" && event.CalendarItemType != CalendarItemTypeSingle {
+			return false
+		}
+		removed[event.ID] = true
+		if event.Start.Before(cache.WindowEnd) && event.End.After(cache.WindowStart) {
+			upserted = append(upserted, event)
+		}
+	}
+
+	events := make([]CalendarEvent, 0, len(cache.Events)+len(upserted))
+	for _, event := range cache.Events {
+		// Deleting a series master deletes its occurrences
+		if removed[event.ID] || (event.SeriesMasterID != "" && removed[event.SeriesMasterID]) {
+			continue
+		}
+		events = append(events, event)
+	}
+	cache.Events = append(events, upserted...)
+
+	return true
+}
+
+// getCalendarEventsIncremental returns events from the user's calendar cache after patching it
+// with the changes since the last sync. Ranges outside the cached window are fetched directly.
+func (p *Plugin) getCalendarEventsIncremental(userID string, client *ExchangeClient, start, end time.Time) ([]CalendarEvent, error) {
+	cache, err := p.syncCalendarCache(userID, client)
+	if err != nil {
+		return nil, err
+	}
+
+	if !cache.covers(start, end) {
+		return client.GetCalendarEventsInRange(start, end)
+	}
+
+	return cache.eventsInRange(start, end), nil
+}
+
+// syncCalendarCache brings the user's calendar cache up to date and stores it
+func (p *Plugin) syncCalendarCache(userID string, client *ExchangeClient) (*calendarCache, error) {
+	cache := p.getCalendarCache(userID)
+	now := time.Now()
+
+	refetch := cache == nil || cache.WindowEnd.Before(now.Add(calendarCacheMinAhead))
+	changed := false
+
+	if cache != nil && cache.SyncState != "" {
+		changes, err := client.SyncCalendarItems(cache.SyncState)
+		switch {
+		case errors.Is(err, ErrInvalidSyncState):
+			p.API.LogWarn("Состояние синхронизации календаря устарело, кэш будет перестроен", "user_id", userID)
+			cache = nil
+			refetch = true
+		case err != nil:
+			return nil, err
+		default:
+			// The old sync state stays valid while nothing changes, so it is only stored with changes
+			if !changes.Empty() {
+				changed = true
+				cache.SyncState = changes.SyncState
+				if !cache.apply(changes) {
+					refetch = true
+				}
+			}
+		}
+	}
+
+	if cache == nil || cache.SyncState == "" {
+		// Take the sync state before reading the window, so changes made meanwhile
+		// are reported by the next sync
+		changes, err := client.SyncCalendarItems("")
+		if err != nil {
+			return nil, err
+		}
+		cache = &calendarCache{SyncState: changes.SyncState}
+		refetch = true
+	}
+
+	if refetch {
+		windowStart := now.Add(-calendarCacheLookBehind)
+		windowEnd := now.Add(calendarCacheLookAhead)
+
+		events, err := client.GetCalendarEventsInRange(windowStart, windowEnd)
+		if err != nil {
+			return nil, err
+		}
+
+		cache.WindowStart = windowStart
+		cache.WindowEnd = windowEnd
+		cache.Events = events
+		changed = true
+	}
+
+	if changed {
+		if err := p.storeCalendarCache(userID, cache); err != nil {
+			p.API.LogError("Ошибка сохранения кэша календаря", "user_id", userID, "error", err.Error())
+		}
+	}
+
+	return cache, nil
+}
+
+// getCalendarCache returns the user's calendar cache, or nil if there is none
+func (p *Plugin) getCalendarCache(userID string) *calendarCache {
+	data, appErr := p.API.KVGet(fmt.Sprintf("exchange_calendar_cache_%s", userID))
+	if appErr != nil || data == nil {
+		return nil
+	}
+
+	var cache calendarCache
+	if err := json.Unmarshal(data, &cache); err != nil {
+		p.API.LogWarn("Поврежденный кэш календаря будет перестроен", "user_id", userID, "error", err.Error())
+		return nil
+	}
+
+	return &cache
+}
+
+// storeCalendarCache stores the user's calendar cache
+func (p *Plugin) storeCalendarCache(userID string, cache *calendarCache) error {
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return fmt.Errorf("failed to marshal calendar cache: %w", err)
+	}
+
+	if appErr := p.API.KVSet(fmt.Sprintf("exchange_calendar_cache_%s", userID), data); appErr != nil {
+		return fmt.Errorf("failed to store calendar cache: %w", appErr)
+	}
+
+	return nil
+}
+
+// clearCalendarCache drops the user's calendar cache, e.g. when the mailbox changes
+func (p *Plugin) clearCalendarCache(userID string) {
+	if appErr := p.API.KVDelete(fmt.Sprintf("exchange_calendar_cache_%s", userID)); appErr != nil {
+		p.API.LogError("Ошибка удаления кэша календаря", "user_id", userID, "error", appErr.Error())
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCalendarCacheApply(t *testing.T) {
+	windowStart := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+	event := func(id string, hours int) CalendarEvent {
+		start := windowStart.Add(time.Duration(hours) * time.Hour)
+		return CalendarEvent{ID: id, Start: start, End: start.Add(time.Hour), CalendarItemType: CalendarItemTypeSingle}
+	}
+	occurrence := func(id, masterID string, hours int) CalendarEvent {
+		e := event(id, hours)
+		e.CalendarItemType = CalendarItemTypeOccurrence
+		e.SeriesMasterID = masterID
+		return e
+	}
+
+	tests := []struct {
+		name    string
+		changes CalendarChanges
+		applied bool
+		want    []string // IDs of the cached events afterwards
+	}{
+		{"delete single item", CalendarChanges{Deleted: []string{"single"}}, true, []string{"standup-1", "standup-2"}},
+		{"delete series master", CalendarChanges{Deleted: []string{"standup"}}, true, []string{"single"}},
+		{"create inside window", CalendarChanges{Created: []CalendarEvent{event("new", 48)}}, true, []string{"new", "single", "standup-1", "standup-2"}},
+		{"create outside window", CalendarChanges{Created: []CalendarEvent{event("far", 24*30)}}, true, []string{"single", "standup-1", "standup-2"}},
+		{"move single item out of window", CalendarChanges{Updated: []CalendarEvent{event("single", 24*30)}}, true, []string{"standup-1", "standup-2"}},
+		{"move single item within window", CalendarChanges{Updated: []CalendarEvent{event("single", 72)}}, true, []string{"single", "standup-1", "standup-2"}},
+		{"update series master", CalendarChanges{Updated: []CalendarEvent{{ID: "standup", CalendarItemType: CalendarItemTypeRecurringMaster}}}, false, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := &calendarCache{
+				WindowStart: windowStart,
+				WindowEnd:   windowStart.Add(calendarCacheLookAhead),
+				Events: []CalendarEvent{
+					event("single", 10),
+					occurrence("standup-1", "standup", 9),
+					occurrence("standup-2", "standup", 33),
+				},
+			}
+
+			if applied := cache.apply(&tt.changes); applied != tt.applied {
+				t.Fatalf("apply = %t, want %t", applied, tt.applied)
+			}
+			if !tt.applied {
+				return
+			}
+
+			ids := make([]string, 0, len(cache.Events))
+			for _, e := range cache.Events {
+				ids = append(ids, e.ID)
+			}
+			sort.Strings(ids)
+			if strings.Join(ids, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("cached events = %v, want %v", ids, tt.want)
+			}
+		})
+	}
+}
+
+func TestSyncCalendarCacheRefetchesChangedSeries(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Hour)
+	moved := now.Add(2 * time.Hour)
+
+	tests := []struct {
+		name     string
+		change   string
+		refetch  bool
+		wantIDs  []string
+		wantTime time.Time // Start of the "single" event afterwards
+	}{
+		{"single item moved", fmt.Sprintf(`<t:Update><t:CalendarItem><t:ItemId Id="single"/><t:Start>%s</t:Start><t:End>%s</t:End><t:CalendarItemType>Single</t:CalendarItemType></t:CalendarItem></t:Update>`,
+			moved.Format(time.RFC3339), moved.Add(time.Hour).Format(time.RFC3339)), false, []string{"single", "standup-1"}, moved},
+		{"series changed", `<t:Update><t:CalendarItem><t:ItemId Id="standup"/><t:Start>2024-07-01T09:00:00Z</t:Start><t:End>2024-07-01T09:15:00Z</t:End><t:CalendarItemType>RecurringMaster</t:CalendarItemType></t:CalendarItem></t:Update>`,
+			true, []string{"single", "standup-1", "standup-2"}, now},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The server's calendar after the change
+			view := calendarViewHandler(t, []CalendarEvent{
+				{ID: "single", Start: now, End: now.Add(time.Hour)},
+				{ID: "standup-1", Start: now.Add(24 * time.Hour), End: now.Add(25 * time.Hour)},
+				{ID: "standup-2", Start: now.Add(48 * time.Hour), End: now.Add(49 * time.Hour)},
+			})
+
+			var views int32
+			client, _ := newTestEWSClient(t, func(w http.ResponseWriter, r *http.Request) {
+				if !strings.Contains(r.Header.Get("SOAPAction"), "/SyncFolderItems") {
+					atomic.AddInt32(&views, 1)
+					view(w, r)
+					return
+				}
+				io.WriteString(w, `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>
+<m:SyncFolderItemsResponse xmlns:m="http://schemas.microsoft.com/exchange/services/2006/messages" xmlns:t="http://schemas.microsoft.com/exchange/services/2006/types">
+<m:ResponseMessages><m:SyncFolderItemsResponseMessage ResponseClass="Success"><m:ResponseCode>NoError</m:ResponseCode>
+<m:SyncState>state-2</m:SyncState><m:IncludesLastItemInRange>true</m:IncludesLastItemInRange>
+<m:Changes>`+tt.change+`</m:Changes>
+</m:SyncFolderItemsResponseMessage></m:ResponseMessages></m:SyncFolderItemsResponse></s:Body></s:Envelope>`)
+			})
+
+			p, _ := newTestPlugin()
+			if err := p.storeCalendarCache(testUserID, &calendarCache{
+				SyncState:   "state-1",
+				WindowStart: now.Add(-calendarCacheLookBehind),
+				WindowEnd:   now.Add(calendarCacheLookAhead),
+				Events: []CalendarEvent{
+					{ID: "single", Start: now, End: now.Add(time.Hour), CalendarItemType: CalendarItemTypeSingle},
+					{ID: "standup-1", Start: now.Add(24 * time.Hour), End: now.Add(25 * time.Hour), CalendarItemType: CalendarItemTypeOccurrence, SeriesMasterID: "standup"},
+				},
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			cache, err := p.syncCalendarCache(testUserID, client)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if refetched := atomic.LoadInt32(&views) > 0; refetched != tt.refetch {
+				t.Errorf("window refetched = %t, want %t", refetched, tt.refetch)
+			}
+			stored := p.getCalendarCache(testUserID)
+			if stored == nil || stored.SyncState != "state-2" {
+				t.Errorf("the new sync state is not stored: %+v", stored)
+			}
+
+			ids := make([]string, 0, len(cache.Events))
+			for _, event := range cache.Events {
+				ids = append(ids, event.ID)
+				if event.ID == "single" && !event.Start.Equal(tt.wantTime) {
+					t.Errorf("single starts at %s, want %s", event.Start, tt.wantTime)
+				}
+			}
+			sort.Strings(ids)
+			if strings.Join(ids, ",") != strings.Join(tt.wantIDs, ",") {
+				t.Errorf("cached events = %v, want %v", ids, tt.wantIDs)
+			}
+		})
+	}
+}
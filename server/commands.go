@@ -24,7 +24,7 @@ func createExchangeCommand() *model.Command {
 		IconURL:          "",
 		AutoComplete:     true,
 		AutoCompleteDesc: "Управление интеграцией с Exchange",
-		AutoCompleteHint: "[setup|status|calendar|help]",
+		AutoCompleteHint: "[setup|status|calendar|create|reschedule|cancel|freebusy|findtime|rooms|oof|delegate|folders|mapping|mail|reminders|help]",
 		DisplayName:      "Exchange Integration",
 		Description:      "Команды для управления интеграцией с Microsoft Exchange",
 		URL:              "",
@@ -1,7 +1,13 @@
 package main
 
 import (
+	"crypto/tls"
+	"net/http"
+	"net/url"
 	"reflect"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -18,11 +24,38 @@ import (
 // If you add non-JSON-serializable fields, add the `json:"-"` tag to the field.
 type configuration struct {
 	ExchangeServerURL          string `json:"ExchangeServerURL"`
+	AuthMethod                 string `json:"AuthMethod"`
+	AutodiscoverTrustedDomains string `json:"AutodiscoverTrustedDomains"`
+	TLSCACertificate           string `json:"TLSCACertificate"`
+	TLSPinnedFingerprint       string `json:"TLSPinnedFingerprint"`
+	TLSMinVersion              string `json:"TLSMinVersion"`
+	TLSSkipVerify              bool   `json:"TLSSkipVerify"`
+	EWSMaxConcurrentRequests   string `json:"EWSMaxConcurrentRequests"`
+	EWSRequestsPerSecond       string `json:"EWSRequestsPerSecond"`
+	EWSSubscriptionMode        string `json:"EWSSubscriptionMode"`
 	EnableCalendarSync         bool   `json:"EnableCalendarSync"`
+	EnableIncrementalSync      bool   `json:"EnableIncrementalSync"`
 	DailySummaryTime           string `json:"DailySummaryTime"`
 	EnableMeetingNotifications bool   `json:"EnableMeetingNotifications"`
+	EnableMailNotifications    bool   `json:"EnableMailNotifications"`
+	EnableMailRouting          bool   `json:"EnableMailRouting"`
+	MailRoutingMailbox         string `json:"MailRoutingMailbox"`
+	MailRoutingUser            string `json:"MailRoutingUser"`
+	MailRoutingRules           string `json:"MailRoutingRules"`
 	EnableMeetingReminders     bool   `json:"EnableMeetingReminders"`
 	ReminderMinutesBefore      string `json:"ReminderMinutesBefore"`
+
+	// tlsConfig is computed from the TLS settings in OnConfigurationChange
+	tlsConfig *tls.Config
+
+	// ewsTransport is shared by all Exchange clients so connections are reused
+	ewsTransport http.RoundTripper
+
+	// mailRoutingRules is parsed from MailRoutingRules in OnConfigurationChange
+	mailRoutingRules []MailRoutingRule
+
+	// ewsLimiter is shared by all Exchange clients and kept across configuration changes
+	ewsLimiter *RequestLimiter
 }
 
 // Clone shallow copies the configuration. Your implementation may require a deep copy if
@@ -82,24 +115,110 @@ func (p *Plugin) OnConfigurationChange() error {
 		return errors.Wrap(err, "failed to load plugin configuration")
 	}
 
+	// Reject invalid settings and fill in the defaults before anything is built from them
+	if err := configuration.IsValid(); err != nil {
+		return errors.Wrap(err, "invalid plugin configuration")
+	}
+
+	tlsConfig, err := buildTLSConfig(configuration)
+	if err != nil {
+		return errors.Wrap(err, "invalid TLS configuration")
+	}
+	configuration.tlsConfig = tlsConfig
+	configuration.ewsTransport = newEWSTransport(configuration.AuthMethod, tlsConfig)
+
+	mailRoutingRules, err := parseMailRoutingRules(configuration.MailRoutingRules)
+	if err != nil {
+		return errors.Wrap(err, "invalid mail routing rules")
+	}
+	configuration.mailRoutingRules = mailRoutingRules
+
+	// Keep the existing limiter so a throttling back-off stays in effect
+	maxInFlight, perSecond := parseEWSLimits(configuration)
+	configuration.ewsLimiter = p.getConfiguration().ewsLimiter
+	if configuration.ewsLimiter == nil {
+		configuration.ewsLimiter = NewRequestLimiter(maxInFlight, perSecond)
+	} else {
+		configuration.ewsLimiter.Configure(maxInFlight, perSecond)
+	}
+
+	previous := p.getConfiguration().ewsTransport
 	p.setConfiguration(configuration)
+	if previous != nil {
+		closeIdleConnections(previous)
+	}
 
 	return nil
 }
 
-// IsValid checks if the configuration is valid.
+// IsValid checks if the configuration is valid and fills in the defaults of empty settings.
+// An empty ExchangeServerURL is allowed: the plugin stays active and asks the administrator
+// to configure it.
 func (c *configuration) IsValid() error {
-	if c.ExchangeServerURL == "" {
-		return errors.New("ExchangeServerURL must be set")
+	if c.ExchangeServerURL != "" {
+		u, err := url.Parse(c.ExchangeServerURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return errors.Errorf("ExchangeServerURL must be an http(s) URL, got %q", c.ExchangeServerURL)
+		}
+	}
+
+	if c.AuthMethod == "" {
+		c.AuthMethod = AuthMethodBasic
+	}
+
+	if c.AuthMethod != AuthMethodBasic && c.AuthMethod != AuthMethodNTLM {
+		return errors.Errorf("AuthMethod must be %q or %q", AuthMethodBasic, AuthMethodNTLM)
+	}
+
+	for _, domain := range parseDomainList(c.AutodiscoverTrustedDomains) {
+		if strings.ContainsAny(domain, "/:@ ") {
+			return errors.Errorf("AutodiscoverTrustedDomains must list domain names, got %q", domain)
+		}
 	}
 
 	if c.DailySummaryTime == "" {
 		c.DailySummaryTime = "09:00"
 	}
 
+	if _, err := time.Parse("15:04", c.DailySummaryTime); err != nil {
+		return errors.Errorf("DailySummaryTime must be HH:MM, got %q", c.DailySummaryTime)
+	}
+
+	if c.EWSMaxConcurrentRequests == "" {
+		c.EWSMaxConcurrentRequests = strconv.Itoa(defaultEWSMaxConcurrentRequests)
+	}
+
+	if value, err := strconv.Atoi(c.EWSMaxConcurrentRequests); err != nil || value < 1 {
+		return errors.Errorf("EWSMaxConcurrentRequests must be a positive integer, got %q", c.EWSMaxConcurrentRequests)
+	}
+
+	if c.EWSRequestsPerSecond == "" {
+		c.EWSRequestsPerSecond = strconv.Itoa(defaultEWSRequestsPerSecond)
+	}
+
+	if value, err := strconv.ParseFloat(c.EWSRequestsPerSecond, 64); err != nil || value <= 0 {
+		return errors.Errorf("EWSRequestsPerSecond must be a positive number, got %q", c.EWSRequestsPerSecond)
+	}
+
+	if c.EWSSubscriptionMode == "" {
+		c.EWSSubscriptionMode = SubscriptionModePolling
+	}
+
+	if c.EWSSubscriptionMode != SubscriptionModePolling && c.EWSSubscriptionMode != SubscriptionModePull && c.EWSSubscriptionMode != SubscriptionModeStreaming {
+		return errors.Errorf("EWSSubscriptionMode must be %q, %q or %q", SubscriptionModePolling, SubscriptionModePull, SubscriptionModeStreaming)
+	}
+
+	if c.EnableMailRouting && (strings.TrimSpace(c.MailRoutingMailbox) == "" || strings.TrimSpace(c.MailRoutingUser) == "") {
+		return errors.New("MailRoutingMailbox and MailRoutingUser must be set to enable mail routing")
+	}
+
 	if c.ReminderMinutesBefore == "" {
 		c.ReminderMinutesBefore = "15"
 	}
 
+	if value, err := strconv.Atoi(c.ReminderMinutesBefore); err != nil || value < 1 {
+		return errors.Errorf("ReminderMinutesBefore must be a positive integer, got %q", c.ReminderMinutesBefore)
+	}
+
 	return nil
 }
@@ -0,0 +1,55 @@
+package main
+
+import "testing"
+
+func TestConfigurationIsValid(t *testing.T) {
+	valid := func() *configuration {
+		return &configuration{ExchangeServerURL: "https://mail.contoso.com"}
+	}
+
+	defaults := valid()
+	if err := defaults.IsValid(); err != nil {
+		t.Fatalf("empty settings should get defaults: %v", err)
+	}
+	if defaults.AuthMethod != AuthMethodBasic || defaults.EWSSubscriptionMode != SubscriptionModePolling ||
+		defaults.EWSMaxConcurrentRequests != "10" || defaults.EWSRequestsPerSecond != "5" ||
+		defaults.DailySummaryTime != "09:00" || defaults.ReminderMinutesBefore != "15" {
+		t.Errorf("unexpected defaults: %+v", defaults)
+	}
+
+	if err := (&configuration{}).IsValid(); err != nil {
+		t.Errorf("an unconfigured server URL should be allowed: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(c *configuration)
+		valid  bool
+	}{
+		{"ntlm", func(c *configuration) { c.AuthMethod = AuthMethodNTLM }, true},
+		{"unknown auth method", func(c *configuration) { c.AuthMethod = "kerberos" }, false},
+		{"server url without scheme", func(c *configuration) { c.ExchangeServerURL = "mail.contoso.com" }, false},
+		{"trusted domains", func(c *configuration) { c.AutodiscoverTrustedDomains = "contoso.com, fabrikam.com" }, true},
+		{"trusted domain with scheme", func(c *configuration) { c.AutodiscoverTrustedDomains = "https://contoso.com" }, false},
+		{"fractional rate", func(c *configuration) { c.EWSRequestsPerSecond = "0.5" }, true},
+		{"zero concurrency", func(c *configuration) { c.EWSMaxConcurrentRequests = "0" }, false},
+		{"text concurrency", func(c *configuration) { c.EWSMaxConcurrentRequests = "many" }, false},
+		{"negative rate", func(c *configuration) { c.EWSRequestsPerSecond = "-1" }, false},
+		{"streaming", func(c *configuration) { c.EWSSubscriptionMode = SubscriptionModeStreaming }, true},
+		{"unknown subscription mode", func(c *configuration) { c.EWSSubscriptionMode = "push" }, false},
+		{"summary time", func(c *configuration) { c.DailySummaryTime = "8:30" }, true},
+		{"invalid summary time", func(c *configuration) { c.DailySummaryTime = "25:00" }, false},
+		{"invalid reminder minutes", func(c *configuration) { c.ReminderMinutesBefore = "soon" }, false},
+		{"mail routing without mailbox", func(c *configuration) { c.EnableMailRouting = true; c.MailRoutingUser = "router" }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid()
+			tt.modify(c)
+			if err := c.IsValid(); (err == nil) != tt.valid {
+				t.Errorf("IsValid() = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// maxDelegateMailboxes limits the delegated mailboxes one user may connect
+const maxDelegateMailboxes = 10
+
+// DelegateMailbox is another user's mailbox the user has delegate access to, e.g. an
+// assistant managing a manager's calendar. Reminders and invitation notifications are opt-in.
+type DelegateMailbox struct {
+	Email       string `json:"email"`
+	Name        string `json:"name"`
+	Reminders   bool   `json:"reminders"`
+	Invitations bool   `json:"invitations"`
+}
+
+// Label names whose calendar it is in messages
+func (d DelegateMailbox) Label() string {
+	if d.Name == "" || strings.EqualFold(d.Name, d.Email) {
+		return d.Email
+	}
+	return fmt.Sprintf("%s (%s)", d.Name, d.Email)
+}
+
+// getDelegateMailboxes returns the delegated mailboxes the user has connected
+func (p *Plugin) getDelegateMailboxes(userID string) ([]DelegateMailbox, error) {
+	data, appErr := p.API.KVGet(fmt.Sprintf("exchange_delegates_%s", userID))
+	if appErr != nil {
+		return nil, fmt.Errorf("failed to get delegate mailboxes: %w", appErr)
+	}
+
+	if data == nil {
+		return []DelegateMailbox{}, nil
+	}
+
+	var mailboxes []DelegateMailbox
+	if err := json.Unmarshal(data, &mailboxes); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal delegate mailboxes: %w", err)
+	}
+
+	return mailboxes, nil
+}
+
+// storeDelegateMailboxes stores the delegated mailboxes of the user
+func (p *Plugin) storeDelegateMailboxes(userID string, mailboxes []DelegateMailbox) error {
+	data, err := json.Marshal(mailboxes)
+	if err != nil {
+		return fmt.Errorf("failed to marshal delegate mailboxes: %w", err)
+	}
+
+	if appErr := p.API.KVSet(fmt.Sprintf("exchange_delegates_%s", userID), data); appErr != nil {
+		return fmt.Errorf("failed to store delegate mailboxes: %w", appErr)
+	}
+
+	return nil
+}
+
+// findDelegateMailbox returns the index of the mailbox in the list, or -1
+func findDelegateMailbox(mailboxes []DelegateMailbox, email string) int {
+	for i, mailbox := range mailboxes {
+		if strings.EqualFold(mailbox.Email, email) {
+			return i
+		}
+	}
+	return -1
+}
+
+// delegateMailbox returns the connected mailbox for an @username or email address.
+// A mailbox that is not connected is returned with its name looked up, so it can still be labelled.
+func (p *Plugin) delegateMailbox(userID, value string) (DelegateMailbox, bool, error) {
+	email, ok := p.resolveMailbox(value)
+	if !ok {
+		return DelegateMailbox{}, false, fmt.Errorf("пользователь %s не найден", value)
+	}
+
+	mailboxes, err := p.getDelegateMailboxes(userID)
+	if err != nil {
+		return DelegateMailbox{}, false, err
+	}
+	if i := findDelegateMailbox(mailboxes, email); i >= 0 {
+		return mailboxes[i], true, nil
+	}
+
+	mailbox := DelegateMailbox{Email: email}
+	if user, appErr := p.API.GetUserByEmail(email); appErr == nil {
+		mailbox.Name = user.GetDisplayName(model.ShowFullName)
+	}
+	return mailbox, false, nil
+}
+
+// addDelegateMailbox connects a mailbox after checking that the user can read its calendar
+func (p *Plugin) addDelegateMailbox(userID string, credentials *ExchangeCredentials, mailbox DelegateMailbox) error {
+	mailboxes, err := p.getDelegateMailboxes(userID)
+	if err != nil {
+		return err
+	}
+	if findDelegateMailbox(mailboxes, mailbox.Email) >= 0 {
+		return nil
+	}
+	if len(mailboxes) >= maxDelegateMailboxes {
+		return fmt.Errorf("можно подключить не более %d почтовых ящиков", maxDelegateMailboxes)
+	}
+
+	now := time.Now()
+	if _, err := p.getMailboxCalendarEvents(userID, credentials, mailbox.Email, now, now.Add(time.Hour)); err != nil {
+		return err
+	}
+
+	return p.storeDelegateMailboxes(userID, append(mailboxes, mailbox))
+}
+
+// removeDelegateMailbox disconnects a mailbox and forgets its invitations watermark
+func (p *Plugin) removeDelegateMailbox(userID, email string) (bool, error) {
+	mailboxes, err := p.getDelegateMailboxes(userID)
+	if err != nil {
+		return false, err
+	}
+
+	i := findDelegateMailbox(mailboxes, email)
+	if i < 0 {
+		return false, nil
+	}
+
+	if err := p.storeDelegateMailboxes(userID, append(mailboxes[:i], mailboxes[i+1:]...)); err != nil {
+		return false, err
+	}
+
+	if appErr := p.API.KVDelete(invitationsWatermarkKey(userID, email)); appErr != nil {
+		p.API.LogWarn("Ошибка удаления отметки приглашений делегированного ящика", "user_id", userID, "error", appErr.Error())
+	}
+
+	return true, nil
+}
+
+// setDelegateOption turns reminders or invitation notifications of a connected mailbox on or off
+func (p *Plugin) setDelegateOption(userID, email, option string, enabled bool) (bool, error) {
+	mailboxes, err := p.getDelegateMailboxes(userID)
+	if err != nil {
+		return false, err
+	}
+
+	i := findDelegateMailbox(mailboxes, email)
+	if i < 0 {
+		return false, nil
+	}
+
+	switch option {
+	case "reminders":
+		mailboxes[i].Reminders = enabled
+	case "invitations":
+		mailboxes[i].Invitations = enabled
+	default:
+		return false, fmt.Errorf("unknown delegate option %q", option)
+	}
+
+	return true, p.storeDelegateMailboxes(userID, mailboxes)
+}
+
+// getMailboxCalendarEvents returns the events of a delegated calendar in the range
+func (p *Plugin) getMailboxCalendarEvents(userID string, credentials *ExchangeCredentials, mailbox string, start, end time.Time) ([]CalendarEvent, error) {
+	client, err := p.newUserExchangeClient(userID, credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := client.GetMailboxCalendarEvents(mailbox, start, end)
+	p.recordEndpointResult(userID, client, err)
+
+	return events, err
+}
+
+// checkDelegateMeetingNotifications announces new invitations of the delegated mailboxes
+// the user has opted in for
+func (p *Plugin) checkDelegateMeetingNotifications(userID string) {
+	credentials, err := p.getUserExchangeCredentials(userID)
+	if err != nil {
+		return
+	}
+
+	mailboxes, err := p.getDelegateMailboxes(userID)
+	if err != nil {
+		p.API.LogError("Ошибка получения делегированных ящиков", "user_id", userID, "error", err.Error())
+		return
+	}
+
+	for _, mailbox := range mailboxes {
+		if !mailbox.Invitations {
+			continue
+		}
+
+		if err := p.checkMeetingInvitations(userID, credentials, &mailbox); err != nil {
+			p.logExchangeError(userID, "Ошибка получения приглашений делегированного ящика "+mailbox.Email, err)
+		}
+	}
+}
+
+// formatDelegateMailboxes lists the connected mailboxes for /exchange delegate
+func formatDelegateMailboxes(mailboxes []DelegateMailbox) string {
+	if len(mailboxes) == 0 {
+		return "👥 Делегированные почтовые ящики не подключены.\n"
+	}
+
+	onOff := func(enabled bool) string {
+		if enabled {
+			return "вкл."
+		}
+		return "выкл."
+	}
+
+	text := "👥 **Делегированные почтовые ящики:**\n\n"
+	for _, mailbox := range mailboxes {
+		text += fmt.Sprintf("- %s — напоминания: %s, приглашения: %s\n", mailbox.Label(), onOff(mailbox.Reminders), onOff(mailbox.Invitations))
+	}
+	return text
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDelegateMailboxLabel(t *testing.T) {
+	tests := []struct {
+		mailbox DelegateMailbox
+		want    string
+	}{
+		{DelegateMailbox{Email: "boss@contoso.com"}, "boss@contoso.com"},
+		{DelegateMailbox{Email: "boss@contoso.com", Name: "BOSS@contoso.com"}, "boss@contoso.com"},
+		{DelegateMailbox{Email: "boss@contoso.com", Name: "Иван Петров"}, "Иван Петров (boss@contoso.com)"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.mailbox.Label(); got != tt.want {
+			t.Errorf("Label() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestDelegateMailboxOptions(t *testing.T) {
+	p, api := newTestPlugin()
+	if err := p.storeDelegateMailboxes(testUserID, []DelegateMailbox{{Email: "boss@contoso.com"}, {Email: "room@contoso.com"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		email  string
+		option string
+		found  bool
+		fails  bool
+	}{
+		{"reminders", "BOSS@contoso.com", "reminders", true, false},
+		{"invitations", "boss@contoso.com", "invitations", true, false},
+		{"unknown option", "boss@contoso.com", "mail", false, true},
+		{"not connected", "other@contoso.com", "reminders", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			found, err := p.setDelegateOption(testUserID, tt.email, tt.option, true)
+			if (err != nil) != tt.fails || found != tt.found {
+				t.Errorf("setDelegateOption = %t, %v; want found %t, error %t", found, err, tt.found, tt.fails)
+			}
+		})
+	}
+
+	mailboxes, _ := p.getDelegateMailboxes(testUserID)
+	if !mailboxes[0].Reminders || !mailboxes[0].Invitations || mailboxes[1].Reminders || mailboxes[1].Invitations {
+		t.Errorf("options changed on the wrong mailboxes: %+v", mailboxes)
+	}
+
+	api.kv[invitationsWatermarkKey(testUserID, "room@contoso.com")] = []byte(time.Now().UTC().Format(time.RFC3339))
+	if removed, err := p.removeDelegateMailbox(testUserID, "room@contoso.com"); !removed || err != nil {
+		t.Fatalf("removeDelegateMailbox = %t, %v", removed, err)
+	}
+	if _, ok := api.kv[invitationsWatermarkKey(testUserID, "room@contoso.com")]; ok {
+		t.Error("the invitations watermark of the removed mailbox is kept")
+	}
+	if mailboxes, _ := p.getDelegateMailboxes(testUserID); len(mailboxes) != 1 || mailboxes[0].Email != "boss@contoso.com" {
+		t.Errorf("mailboxes after removal: %+v", mailboxes)
+	}
+}
+
+func TestBuildDelegateReminders(t *testing.T) {
+	now := time.Date(2024, 7, 1, 9, 0, 0, 0, time.UTC)
+	event := CalendarEvent{
+		ID:          "AAMkADk=",
+		Subject:     "Совет директоров",
+		Start:       now.Add(time.Hour),
+		End:         now.Add(2 * time.Hour),
+		IsOrganizer: true,
+		IsMeeting:   true,
+	}
+	delegate := &DelegateMailbox{Email: "Boss@contoso.com", Name: "Иван Петров"}
+
+	rm := &ReminderManager{}
+	reminders := rm.buildReminders(nil, testUserID, []CalendarEvent{event}, nil, nil, 15, now)
+	reminders = rm.buildReminders(reminders, testUserID, []CalendarEvent{event}, delegate, nil, 15, now)
+
+	if len(reminders) != 2 {
+		t.Fatalf("got %d reminders, want 2", len(reminders))
+	}
+	own, delegated := reminders[0], reminders[1]
+	if own.key() == delegated.key() {
+		t.Errorf("own and delegated reminders share the key %q", own.key())
+	}
+	if !own.IsOrganizer || own.Mailbox != "" {
+		t.Errorf("own reminder: %+v", own)
+	}
+	if delegated.IsOrganizer || delegated.Mailbox != "Boss@contoso.com" || delegated.MailboxLabel != "Иван Петров (Boss@contoso.com)" {
+		t.Errorf("delegated reminder: %+v", delegated)
+	}
+
+	// The delegated reminder already sent keeps its state
+	delegated.Sent = true
+	existing := map[string]MeetingReminder{delegated.key(): delegated}
+	reminders = rm.buildReminders(nil, testUserID, []CalendarEvent{event}, delegate, existing, 15, now)
+	if len(reminders) != 1 || !reminders[0].Sent {
+		t.Errorf("rebuilt delegated reminders: %+v", reminders)
+	}
+}
+
+func TestDelegateInvitationCanOnlyBeViewed(t *testing.T) {
+	p, api := newTestPlugin()
+
+	invitation := MeetingInvitation{CalendarEvent: CalendarEvent{
+		ID:      "AAMkADk=",
+		Subject: "Совет директоров",
+		Start:   time.Date(2024, 7, 1, 10, 0, 0, 0, time.UTC),
+		End:     time.Date(2024, 7, 1, 11, 0, 0, 0, time.UTC),
+	}}
+	if err := p.sendMeetingInvitationNotification(testUserID, invitation, &DelegateMailbox{Email: "boss@contoso.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(api.created) != 1 {
+		t.Fatalf("created %d posts, want 1", len(api.created))
+	}
+	actions := api.created[0].Attachments()[0].Actions
+	if len(actions) != 1 || actions[0].Id != "event_details" {
+		t.Errorf("delegated invitation offers %+v, want only the details button", actions)
+	}
+}
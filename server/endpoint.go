@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Sources of a stored EWS endpoint
+const (
+	EndpointSourceAutodiscover = "autodiscover"
+	EndpointSourceProbe        = "probe"
+)
+
+// maxEndpointFailures is the number of consecutive 404s or connection failures
+// after which the stored endpoint is discovered again
+const maxEndpointFailures = 3
+
+// Delays before discovery is tried again after it failed; the delay doubles with each failure
+const (
+	minDiscoveryBackoff = 5 * time.Minute
+	maxDiscoveryBackoff = 6 * time.Hour
+)
+
+// UserEndpoint is the EWS connection negotiated for a user
+type UserEndpoint struct {
+	EWSURL        string `json:"ews_url"`
+	Username      string `json:"username"`
+	ServerVersion string `json:"server_version"`
+	Source        string `json:"source"`
+	Failures      int    `json:"failures"`
+
+	// DiscoveryFailures counts failed rediscoveries; none is tried before NextDiscovery
+	DiscoveryFailures int       `json:"discovery_failures,omitempty"`
+	NextDiscovery     time.Time `json:"next_discovery,omitempty"`
+}
+
+// needsDiscovery reports whether the endpoint is unknown or kept failing
+func (e *UserEndpoint) needsDiscovery() bool {
+	return e == nil || e.EWSURL == "" || e.Failures >= maxEndpointFailures
+}
+
+// discoveryBackoff returns the delay after the given number of failed discoveries
+func discoveryBackoff(failures int) time.Duration {
+	backoff := minDiscoveryBackoff
+	for i := 1; i < failures && backoff < maxDiscoveryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxDiscoveryBackoff {
+		return maxDiscoveryBackoff
+	}
+	return backoff
+}
+
+// getUserEndpoint retrieves the stored EWS endpoint for a user, or nil if none is stored
+func (p *Plugin) getUserEndpoint(userID string) (*UserEndpoint, error) {
+	data, appErr := p.API.KVGet(fmt.Sprintf("exchange_endpoint_%s", userID))
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to get user endpoint")
+	}
+
+	if data == nil {
+		return nil, nil
+	}
+
+	var endpoint UserEndpoint
+	if err := json.Unmarshal(data, &endpoint); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal user endpoint")
+	}
+
+	return &endpoint, nil
+}
+
+// storeUserEndpoint stores the EWS endpoint for a user
+func (p *Plugin) storeUserEndpoint(userID string, endpoint *UserEndpoint) error {
+	data, err := json.Marshal(endpoint)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal user endpoint")
+	}
+
+	if appErr := p.API.KVSet(fmt.Sprintf("exchange_endpoint_%s", userID), data); appErr != nil {
+		return errors.Wrap(appErr, "failed to store user endpoint")
+	}
+
+	return nil
+}
+
+// newUserExchangeClient creates an Exchange client that reuses the user's stored endpoint.
+// The endpoint is discovered (and stored) when there is none yet or it kept failing.
+// A failed discovery is not repeated before its back-off has passed; meanwhile the last
+// known endpoint is used, or an error is returned if there is none.
+func (p *Plugin) newUserExchangeClient(userID string, credentials *ExchangeCredentials) (*ExchangeClient, error) {
+	client := p.newExchangeClient(credentials)
+	client.SetLocation(p.exchangeLocation(userID))
+
+	endpoint, err := p.getUserEndpoint(userID)
+	if err != nil {
+		p.API.LogWarn("Ошибка получения EWS endpoint пользователя", "user_id", userID, "error", err.Error())
+	}
+
+	if endpoint.needsDiscovery() && (endpoint == nil || !time.Now().Before(endpoint.NextDiscovery)) {
+		discovered, err := p.discoverUserEndpoint(userID, client, credentials)
+		if err != nil {
+			endpoint = p.recordDiscoveryFailure(userID, endpoint, err)
+			if endpoint.EWSURL == "" {
+				return nil, errors.Wrap(err, "failed to discover EWS endpoint")
+			}
+			// Keep using the last known endpoint; it may work again
+			client = p.newExchangeClient(credentials)
+			client.SetLocation(p.exchangeLocation(userID))
+		} else {
+			endpoint = discovered
+			if err := p.storeUserEndpoint(userID, endpoint); err != nil {
+				p.API.LogError("Ошибка сохранения EWS endpoint", "user_id", userID, "error", err.Error())
+			}
+		}
+	}
+
+	if endpoint.EWSURL == "" {
+		return nil, fmt.Errorf("EWS endpoint is not discovered yet, next attempt at %s: %w", endpoint.NextDiscovery.Format(time.RFC3339), ErrEndpointUnreachable)
+	}
+
+	client.SetEndpoint(endpoint.EWSURL, endpoint.Username, endpoint.ServerVersion)
+	return client, nil
+}
+
+// recordDiscoveryFailure stores when discovery may be tried again and returns the updated endpoint
+func (p *Plugin) recordDiscoveryFailure(userID string, endpoint *UserEndpoint, discoveryErr error) *UserEndpoint {
+	if endpoint == nil {
+		endpoint = &UserEndpoint{}
+	}
+	endpoint.DiscoveryFailures++
+	endpoint.NextDiscovery = time.Now().Add(discoveryBackoff(endpoint.DiscoveryFailures))
+
+	p.API.LogWarn("Не удалось определить EWS endpoint", "user_id", userID, "failures", endpoint.DiscoveryFailures, "next_attempt", endpoint.NextDiscovery.Format(time.RFC3339), "error", discoveryErr.Error())
+
+	if err := p.storeUserEndpoint(userID, endpoint); err != nil {
+		p.API.LogError("Ошибка сохранения EWS endpoint", "user_id", userID, "error", err.Error())
+	}
+
+	return endpoint
+}
+
+// discoverUserEndpoint finds the user's EWS endpoint via Autodiscover, falling back to
+// path probing, and verifies it with TestConnection
+func (p *Plugin) discoverUserEndpoint(userID string, client *ExchangeClient, credentials *ExchangeCredentials) (*UserEndpoint, error) {
+	source := p.autodiscoverUserEndpoint(userID, client, credentials)
+	discoveredURL := client.EWSURL()
+
+	if err := client.TestConnection(); err != nil {
+		return nil, err
+	}
+
+	// TestConnection only sends GET requests; a SOAP request settles the server version
+	if err := client.NegotiateServerVersion(); err != nil {
+		p.API.LogWarn("Не удалось определить версию Exchange", "user_id", userID, "error", err.Error())
+	}
+
+	if source == "" || client.EWSURL() != discoveredURL {
+		source = EndpointSourceProbe
+	}
+
+	return &UserEndpoint{
+		EWSURL:        client.EWSURL(),
+		Username:      client.Username(),
+		ServerVersion: client.ServerVersion(),
+		Source:        source,
+	}, nil
+}
+
+// recordEndpointResult counts endpoint failures and stores the negotiated server version
+func (p *Plugin) recordEndpointResult(userID string, client *ExchangeClient, callErr error) {
+	endpoint, err := p.getUserEndpoint(userID)
+	if err != nil || endpoint == nil {
+		return
+	}
+
+	switch {
+	case errors.Is(callErr, ErrEndpointUnreachable):
+		endpoint.Failures++
+		p.API.LogWarn("EWS endpoint недоступен", "user_id", userID, "ews_url", endpoint.EWSURL, "failures", endpoint.Failures)
+	case callErr == nil:
+		if endpoint.Failures == 0 && endpoint.DiscoveryFailures == 0 && endpoint.ServerVersion == client.ServerVersion() {
+			return
+		}
+		endpoint.Failures = 0
+		endpoint.DiscoveryFailures = 0
+		endpoint.NextDiscovery = time.Time{}
+		endpoint.ServerVersion = client.ServerVersion()
+	default:
+		return
+	}
+
+	if err := p.storeUserEndpoint(userID, endpoint); err != nil {
+		p.API.LogError("Ошибка сохранения EWS endpoint", "user_id", userID, "error", err.Error())
+	}
+}
+
+// getUserMailboxAddress returns the SMTP address used for Autodiscover: the Exchange
+// username when it is a UPN, otherwise the Mattermost account email
+func (p *Plugin) getUserMailboxAddress(userID string, credentials *ExchangeCredentials) string {
+	if strings.Contains(credentials.Username, "@") {
+		return credentials.Username
+	}
+
+	user, appErr := p.API.GetUser(userID)
+	if appErr != nil {
+		return ""
+	}
+
+	return user.Email
+}
+
+// autodiscoverUserEndpoint runs Autodiscover for the user and points the client at the result.
+// It returns the endpoint source, or an empty string when Autodiscover failed and path
+// probing has to be used instead.
+func (p *Plugin) autodiscoverUserEndpoint(userID string, client *ExchangeClient, credentials *ExchangeCredentials) string {
+	email := p.getUserMailboxAddress(userID, credentials)
+	if email == "" {
+		return ""
+	}
+
+	ewsURL, err := client.Autodiscover(email)
+	if err != nil {
+		p.API.LogWarn("Autodiscover не удался, используется перебор путей EWS", "user_id", userID, "email", email, "error", err.Error())
+		return ""
+	}
+
+	p.API.LogInfo("EWS endpoint найден через Autodiscover", "user_id", userID, "ews_url", ewsURL)
+	client.SetEWSURL(ewsURL)
+	return EndpointSourceAutodiscover
+}
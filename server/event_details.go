@@ -0,0 +1,412 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"golang.org/x/net/html"
+)
+
+// EventAttendee is a meeting attendee with their response
+type EventAttendee struct {
+	Name         string `json:"name"`
+	Email        string `json:"email"`
+	ResponseType string `json:"response_type"` // Unknown, Organizer, Tentative, Accept, Decline, NoResponseReceived
+}
+
+// EventDetails is a calendar event with the fields only GetItem returns
+type EventDetails struct {
+	CalendarEvent
+	Body              string          `json:"body"` // Markdown
+	RequiredAttendees []EventAttendee `json:"required_attendees"`
+	OptionalAttendees []EventAttendee `json:"optional_attendees"`
+	Categories        []string        `json:"categories"`
+	Sensitivity       string          `json:"sensitivity"` // Normal, Personal, Private, Confidential
+	Importance        string          `json:"importance"`  // Low, Normal, High
+	OnlineMeetingURL  string          `json:"online_meeting_url"`
+	OWAURL            string          `json:"owa_url"`
+}
+
+// onlineMeetingURLPattern matches join links of common online meeting services
+var onlineMeetingURLPattern = regexp.MustCompile(`https://(?:teams\.microsoft\.com/l/meetup-join|[\w.-]*zoom\.us/[jw]/|meet\.google\.com/|telemost\.yandex\.ru/j/|[\w.-]+\.webex\.com/|join\.skype\.com/|meet\.lync\.com/|meet\.[\w.-]+/)[^\s"'<>)\]]*`)
+
+// detailsProperties are the properties GetEventDetails requests in addition to the Default shape
+func detailsProperties() *AdditionalProperties {
+	properties := calendarEventProperties()
+	properties.FieldURI = append(properties.FieldURI,
+		FieldURI{FieldURI: "item:Body"},
+		FieldURI{FieldURI: "item:Categories"},
+		FieldURI{FieldURI: "item:Sensitivity"},
+		FieldURI{FieldURI: "item:Importance"},
+		FieldURI{FieldURI: "calendar:RequiredAttendees"},
+		FieldURI{FieldURI: "calendar:OptionalAttendees"},
+	)
+	return properties
+}
+
+// GetEventDetails retrieves the full details of a calendar event
+func (c *ExchangeClient) GetEventDetails(eventID string) (*EventDetails, error) {
+	soapResp, err := c.sendSOAPRequest("GetItem", SOAPBody{
+		GetItem: &GetItem{
+			ItemShape: &ItemShape{
+				BaseShape:            "Default",
+				BodyType:             "HTML",
+				AdditionalProperties: detailsProperties(),
+			},
+			ItemIds: &ItemIds{
+				ItemId: []ItemId{{Id: eventID}},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if soapResp.Body.GetItemResponse == nil ||
+		soapResp.Body.GetItemResponse.ResponseMessages == nil ||
+		len(soapResp.Body.GetItemResponse.ResponseMessages.GetItemResponseMessage) == 0 {
+		return nil, fmt.Errorf("empty GetItem response")
+	}
+
+	responseMessage := soapResp.Body.GetItemResponse.ResponseMessages.GetItemResponseMessage[0]
+	if err := responseMessage.Err(); err != nil {
+		c.throttled(err)
+		return nil, err
+	}
+
+	if responseMessage.Items == nil || len(responseMessage.Items.CalendarItem) == 0 {
+		return nil, &EWSError{Kind: ErrItemNotFound, MessageText: "calendar item not found"}
+	}
+
+	item := responseMessage.Items.CalendarItem[0]
+	event, err := c.convertToCalendarEvent(item)
+	if err != nil {
+		return nil, err
+	}
+
+	details := &EventDetails{
+		CalendarEvent:     event,
+		RequiredAttendees: convertAttendees(item.RequiredAttendees),
+		OptionalAttendees: convertAttendees(item.OptionalAttendees),
+		Sensitivity:       item.Sensitivity,
+		Importance:        item.Importance,
+	}
+
+	if item.Categories != nil {
+		details.Categories = item.Categories.String
+	}
+
+	if item.Body != nil {
+		if item.Body.BodyType == "HTML" {
+			details.Body = htmlToMarkdown(item.Body.Value)
+		} else {
+			details.Body = strings.TrimSpace(item.Body.Value)
+		}
+	}
+
+	// Online meeting links are usually in the location or in the invitation text
+	details.OnlineMeetingURL = onlineMeetingURLPattern.FindString(item.Location)
+	if details.OnlineMeetingURL == "" && item.Body != nil {
+		details.OnlineMeetingURL = html.UnescapeString(onlineMeetingURLPattern.FindString(item.Body.Value))
+	}
+
+	return details, nil
+}
+
+// convertAttendees converts EWS attendees to EventAttendee
+func convertAttendees(attendees *Attendees) []EventAttendee {
+	if attendees == nil {
+		return []EventAttendee{}
+	}
+
+	result := make([]EventAttendee, 0, len(attendees.Attendee))
+	for _, attendee := range attendees.Attendee {
+		if attendee.Mailbox == nil {
+			continue
+		}
+		result = append(result, EventAttendee{
+			Name:         attendee.Mailbox.Name,
+			Email:        attendee.Mailbox.EmailAddress,
+			ResponseType: attendee.ResponseType,
+		})
+	}
+	return result
+}
+
+// getEventDetails retrieves the full details of a user's calendar event
+func (p *Plugin) getEventDetails(userID string, credentials *ExchangeCredentials, eventID string) (*EventDetails, error) {
+	client, err := p.newUserExchangeClient(userID, credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	details, err := client.GetEventDetails(eventID)
+	p.recordEndpointResult(userID, client, err)
+	if err != nil {
+		return nil, err
+	}
+
+	ewsURL := client.EWSURL()
+	if ewsURL == "" {
+		ewsURL = p.getConfiguration().ExchangeServerURL
+	}
+	details.OWAURL = owaItemURL(ewsURL, eventID, "ICalendarItemDetailsViewModelFactory")
+
+	return details, nil
+}
+
+// attendeeResponseLabels are the user-facing attendee response statuses
+var attendeeResponseLabels = map[string]string{
+	"Organizer":          "👤 организатор",
+	"Accept":             "✅ принял",
+	"Tentative":          "❓ под вопросом",
+	"Decline":            "❌ отклонил",
+	"NoResponseReceived": "⏳ нет ответа",
+	"Unknown":            "⏳ нет ответа",
+}
+
+// importanceLabels and sensitivityLabels are shown only for non-default values
+var importanceLabels = map[string]string{
+	"High": "❗ Высокая важность",
+	"Low":  "⬇️ Низкая важность",
+}
+
+var sensitivityLabels = map[string]string{
+	"Personal":     "🔒 Личное",
+	"Private":      "🔒 Частное",
+	"Confidential": "🔒 Конфиденциально",
+}
+
+// formatEventDetails renders event details as a Markdown message in the user's time zone.
+// Text from Exchange cannot mention anyone, and a long body is cut to fit into a post.
+func (p *Plugin) formatEventDetails(userID string, details *EventDetails) string {
+	loc := p.getUserLocation(userID)
+
+	message := fmt.Sprintf("📋 **%s**%s\n\n", escapeMentions(details.Subject), recurrenceMark(details.CalendarEvent))
+	if details.IsAllDay {
+		message += fmt.Sprintf("**Время:** %s (весь день)\n", details.Start.In(loc).Format("02.01.2006"))
+	} else {
+		message += fmt.Sprintf("**Время:** %s - %s\n", details.Start.In(loc).Format("02.01.2006 15:04"), details.End.In(loc).Format("15:04"))
+	}
+	if details.Location != "" {
+		message += fmt.Sprintf("**Место:** %s\n", escapeMentions(details.Location))
+	}
+	if details.OnlineMeetingURL != "" {
+		message += fmt.Sprintf("**Онлайн-встреча:** [Присоединиться](%s)\n", details.OnlineMeetingURL)
+	}
+	if details.Organizer != "" {
+		message += fmt.Sprintf("**Организатор:** %s\n", p.organizerMention(userID, details.CalendarEvent))
+	}
+
+	var labels []string
+	if label, ok := importanceLabels[details.Importance]; ok {
+		labels = append(labels, label)
+	}
+	if label, ok := sensitivityLabels[details.Sensitivity]; ok {
+		labels = append(labels, label)
+	}
+	if len(details.Categories) > 0 {
+		labels = append(labels, "🏷️ "+escapeMentions(strings.Join(details.Categories, ", ")))
+	}
+	if len(labels) > 0 {
+		message += strings.Join(labels, " · ") + "\n"
+	}
+
+	message += p.formatAttendees(userID, "Обязательные участники", details.RequiredAttendees)
+	message += p.formatAttendees(userID, "Необязательные участники", details.OptionalAttendees)
+
+	if details.Body != "" {
+		body := escapeMentions(details.Body)
+
+		more := "\n\n_Текст сокращен._"
+		if details.OWAURL != "" {
+			more = fmt.Sprintf("\n\n_Текст сокращен._ [Открыть в OWA](%s)", details.OWAURL)
+		}
+
+		room := model.PostMessageMaxRunesV2 - utf8.RuneCountInString(message) - utf8.RuneCountInString(more) - 8
+		if utf8.RuneCountInString(body) > room {
+			body = truncateRunes(body, room) + more
+		}
+		message += "\n---\n\n" + body + "\n"
+	}
+
+	// Very long attendee lists alone may not fit
+	return truncateRunes(message, model.PostMessageMaxRunesV2)
+}
+
+// mentionPattern matches an @ that Mattermost would turn into a mention, such as @channel,
+// @all, @here or @username, but not the @ inside an email address or URL
+var mentionPattern = regexp.MustCompile(`(^|[^\p{L}\p{N}_.+\-/:=%])@([\p{L}\p{N}_])`)
+
+// escapeMentions puts a zero-width joiner after the @ of mentions in text from Exchange,
+// so a subject or body cannot notify a channel or a user
+func escapeMentions(text string) string {
+	return mentionPattern.ReplaceAllString(text, "$1@\u200d$2")
+}
+
+// truncateRunes shortens text to at most limit runes, ending it with an ellipsis
+func truncateRunes(text string, limit int) string {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	if limit < 1 {
+		return ""
+	}
+	return string(runes[:limit-1]) + "…"
+}
+
+// formatAttendees renders an attendee list section, mentioning attendees who are in Mattermost
+func (p *Plugin) formatAttendees(userID, title string, attendees []EventAttendee) string {
+	if len(attendees) == 0 {
+		return ""
+	}
+
+	text := fmt.Sprintf("\n**%s:**\n", title)
+	for _, attendee := range attendees {
+		name := attendee.Name
+		if name == "" {
+			name = attendee.Email
+		}
+		name = escapeMentions(name)
+		if mapping := p.mapMailbox(userID, attendee.Email, attendee.Name); mapping.Username != "" {
+			name = mapping.Mention()
+		}
+		label, ok := attendeeResponseLabels[attendee.ResponseType]
+		if !ok {
+			label = attendeeResponseLabels["Unknown"]
+		}
+		text += fmt.Sprintf("- %s — %s\n", name, label)
+	}
+	return text
+}
+
+// htmlToMarkdown converts an HTML message body to Markdown
+func htmlToMarkdown(body string) string {
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return strings.TrimSpace(body)
+	}
+
+	var sb strings.Builder
+	writeMarkdown(&sb, doc)
+
+	// Trim trailing spaces and collapse runs of blank lines
+	lines := strings.Split(sb.String(), "\n")
+	result := make([]string, 0, len(lines))
+	blank := false
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t\u00a0")
+		if strings.TrimSpace(line) == "" {
+			if !blank && len(result) > 0 {
+				result = append(result, "")
+			}
+			blank = true
+			continue
+		}
+		blank = false
+		result = append(result, line)
+	}
+
+	return strings.TrimSpace(strings.Join(result, "\n"))
+}
+
+// whitespacePattern matches runs of HTML whitespace
+var whitespacePattern = regexp.MustCompile(`[\s\x{00a0}]+`)
+
+// markdownHeadings maps heading tags to Markdown prefixes
+var markdownHeadings = map[string]string{
+	"h1": "# ", "h2": "## ", "h3": "### ", "h4": "#### ", "h5": "##### ", "h6": "###### ",
+}
+
+// writeMarkdown writes the Markdown for an HTML node and its children
+func writeMarkdown(sb *strings.Builder, n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		sb.WriteString(whitespacePattern.ReplaceAllString(n.Data, " "))
+		return
+	case html.CommentNode:
+		return
+	case html.ElementNode:
+		switch n.Data {
+		case "head", "script", "style", "title", "img":
+			return
+		case "br":
+			sb.WriteString("\n")
+			return
+		case "hr":
+			sb.WriteString("\n---\n")
+			return
+		case "a":
+			href := attribute(n, "href")
+			text := strings.TrimSpace(childMarkdown(n))
+			switch {
+			case href == "" || strings.HasPrefix(href, "#"):
+				sb.WriteString(text)
+			case text == "" || text == href:
+				sb.WriteString(href)
+			default:
+				fmt.Fprintf(sb, "[%s](%s)", text, href)
+			}
+			return
+		case "b", "strong", "i", "em":
+			marker := "**"
+			if n.Data == "i" || n.Data == "em" {
+				marker = "_"
+			}
+			text := childMarkdown(n)
+			if strings.TrimSpace(text) == "" {
+				sb.WriteString(text)
+				return
+			}
+			fmt.Fprintf(sb, "%s%s%s", marker, strings.TrimSpace(text), marker)
+			return
+		case "li":
+			sb.WriteString("\n- ")
+			sb.WriteString(strings.TrimSpace(childMarkdown(n)))
+			return
+		case "td", "th":
+			sb.WriteString(" ")
+		}
+
+		if prefix, ok := markdownHeadings[n.Data]; ok {
+			sb.WriteString("\n\n" + prefix + strings.TrimSpace(childMarkdown(n)) + "\n\n")
+			return
+		}
+	}
+
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		writeMarkdown(sb, child)
+	}
+
+	if n.Type == html.ElementNode {
+		switch n.Data {
+		case "p", "div", "ul", "ol", "table", "blockquote", "pre":
+			sb.WriteString("\n\n")
+		case "tr":
+			sb.WriteString("\n")
+		}
+	}
+}
+
+// childMarkdown returns the Markdown of a node's children
+func childMarkdown(n *html.Node) string {
+	var sb strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		writeMarkdown(&sb, child)
+	}
+	return sb.String()
+}
+
+// attribute returns the value of an HTML attribute
+func attribute(n *html.Node, name string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == name {
+			return attr.Val
+		}
+	}
+	return ""
+}
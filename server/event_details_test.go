@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestEscapeMentions(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"@channel встреча", "@\u200dchannel встреча"},
+		{"всем @all и @here", "всем @\u200dall и @\u200dhere"},
+		{"(@jdoe, @asmith)", "(@\u200djdoe, @\u200dasmith)"},
+		{"**@channel**", "**@\u200dchannel**"},
+		{"jdoe@contoso.com", "jdoe@contoso.com"},
+		{"https://user@mail.contoso.com/owa", "https://user@mail.contoso.com/owa"},
+		{"mailto:jdoe@contoso.com", "mailto:jdoe@contoso.com"},
+		{"@@here", "@@\u200dhere"},
+		{"без упоминаний", "без упоминаний"},
+	}
+
+	for _, tt := range tests {
+		if got := escapeMentions(tt.text); got != tt.want {
+			t.Errorf("escapeMentions(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestTruncateRunes(t *testing.T) {
+	if got := truncateRunes("короткий", 20); got != "короткий" {
+		t.Errorf("short text changed: %q", got)
+	}
+
+	long := strings.Repeat("я", 100)
+	got := truncateRunes(long, 10)
+	if utf8.RuneCountInString(got) != 10 || !strings.HasSuffix(got, "…") {
+		t.Errorf("truncateRunes = %q, want 10 runes ending with an ellipsis", got)
+	}
+
+	if got := truncateRunes(long, 0); got != "" {
+		t.Errorf("truncateRunes with no room = %q", got)
+	}
+}
+
+func TestHTMLToMarkdownKeepsMentionsForEscaping(t *testing.T) {
+	body := htmlToMarkdown(`<p>Привет, <b>@channel</b>!</p><p>Пишите на <a href="mailto:jdoe@contoso.com">jdoe@contoso.com</a></p>`)
+	escaped := escapeMentions(body)
+
+	if strings.Contains(escaped, "@channel") {
+		t.Errorf("mention not escaped: %q", escaped)
+	}
+	if !strings.Contains(escaped, "jdoe@contoso.com") {
+		t.Errorf("email address changed: %q", escaped)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error kinds returned by ExchangeClient. Match them with errors.Is; use errors.As
+// with *EWSError for the details (HTTP status, ResponseCode, back-off).
+var (
+	ErrUnauthorized      = errors.New("exchange: unauthorized")
+	ErrLoginTimeout      = errors.New("exchange: login timeout")
+	ErrThrottled         = errors.New("exchange: throttled")
+	ErrLimiterBusy       = errors.New("exchange: request limit reached")
+	ErrAccessDenied      = errors.New("exchange: access denied")
+	ErrItemNotFound      = errors.New("exchange: item not found")
+	ErrServerUnavailable = errors.New("exchange: server unavailable")
+	ErrInvalidSyncState  = errors.New("exchange: invalid sync state")
+	ErrSubscriptionLost  = errors.New("exchange: subscription lost")
+	ErrNotOrganizer      = errors.New("exchange: not the organizer")
+	ErrMailboxNotFound   = errors.New("exchange: mailbox not found")
+	ErrOutcomeUnknown    = errors.New("exchange: outcome unknown")
+)
+
+// EWSError describes a failed EWS call
+type EWSError struct {
+	// Kind is one of the Err* values above, or nil for errors without a specific kind
+	Kind               error
+	StatusCode         int
+	ResponseCode       string
+	MessageText        string
+	DescriptiveLinkKey string
+	// BackOff is the delay requested by the server (BackOffMilliseconds or Retry-After)
+	BackOff time.Duration
+}
+
+// Error implements error
+func (e *EWSError) Error() string {
+	var parts []string
+	if e.Kind != nil {
+		parts = append(parts, e.Kind.Error())
+	}
+	if e.StatusCode != 0 && e.StatusCode != http.StatusOK {
+		parts = append(parts, fmt.Sprintf("HTTP %d", e.StatusCode))
+	}
+	if e.ResponseCode != "" {
+		parts = append(parts, e.ResponseCode)
+	}
+	if e.MessageText != "" {
+		parts = append(parts, e.MessageText)
+	}
+	if e.BackOff > 0 {
+		parts = append(parts, fmt.Sprintf("back off %s", e.BackOff))
+	}
+	if len(parts) == 0 {
+		return "EWS error"
+	}
+	return strings.Join(parts, ": ")
+}
+
+// Unwrap lets errors.Is match the error kind
+func (e *EWSError) Unwrap() error {
+	return e.Kind
+}
+
+// ResponseMessage holds the status fields every EWS response message carries
+type ResponseMessage struct {
+	ResponseClass      string      `xml:"ResponseClass,attr"`
+	MessageText        string      `xml:"MessageText"`
+	ResponseCode       string      `xml:"ResponseCode"`
+	DescriptiveLinkKey string      `xml:"DescriptiveLinkKey"`
+	MessageXml         *MessageXml `xml:"MessageXml"`
+}
+
+// MessageXml carries additional error values such as BackOffMilliseconds
+type MessageXml struct {
+	Values []MessageXmlValue `xml:"Value"`
+}
+
+type MessageXmlValue struct {
+	Name  string `xml:"Name,attr"`
+	Value string `xml:",chardata"`
+}
+
+// SOAPFaultDetail is the EWS-specific part of a SOAP fault
+type SOAPFaultDetail struct {
+	ResponseCode string      `xml:"ResponseCode"`
+	Message      string      `xml:"Message"`
+	MessageXml   *MessageXml `xml:"MessageXml"`
+}
+
+// ewsResponseCodeKinds maps EWS ResponseCode values to error kinds
+var ewsResponseCodeKinds = map[string]error{
+	"ErrorServerBusy":                                ErrThrottled,
+	"ErrorAccessDenied":                              ErrAccessDenied,
+	"ErrorImpersonateUserDenied":                     ErrAccessDenied,
+	"ErrorNotDelegate":                               ErrAccessDenied,
+	"ErrorDelegateNoUser":                            ErrAccessDenied,
+	"ErrorItemNotFound":                              ErrItemNotFound,
+	"ErrorFolderNotFound":                            ErrItemNotFound,
+	"ErrorCalendarOccurrenceIsDeletedFromRecurrence": ErrItemNotFound,
+	"ErrorMailboxStoreUnavailable":                   ErrServerUnavailable,
+	"ErrorMailboxMoveInProgress":                     ErrServerUnavailable,
+	"ErrorInternalServerTransientError":              ErrServerUnavailable,
+	"ErrorConnectionFailed":                          ErrServerUnavailable,
+	"ErrorTimeoutExpired":                            ErrServerUnavailable,
+	"ErrorInvalidSyncStateData":                      ErrInvalidSyncState,
+	"ErrorSubscriptionNotFound":                      ErrSubscriptionLost,
+	"ErrorExpiredSubscription":                       ErrSubscriptionLost,
+	"ErrorInvalidSubscription":                       ErrSubscriptionLost,
+	"ErrorInvalidWatermark":                          ErrSubscriptionLost,
+	"ErrorReadEventsFailed":                          ErrSubscriptionLost,
+	"ErrorCalendarIsNotOrganizer":                    ErrNotOrganizer,
+	"ErrorMailRecipientNotFound":                     ErrMailboxNotFound,
+	"ErrorNonExistentMailbox":                        ErrMailboxNotFound,
+}
+
+// backOff returns BackOffMilliseconds from MessageXml
+func (m *MessageXml) backOff() time.Duration {
+	if m == nil {
+		return 0
+	}
+	for _, value := range m.Values {
+		if value.Name != "BackOffMilliseconds" {
+			continue
+		}
+		if ms, err := strconv.Atoi(strings.TrimSpace(value.Value)); err == nil {
+			return time.Duration(ms) * time.Millisecond
+		}
+	}
+	return 0
+}
+
+// Err returns nil for successful response messages and a typed error otherwise
+func (m *ResponseMessage) Err() error {
+	if m.ResponseClass == "Success" {
+		return nil
+	}
+	return &EWSError{
+		Kind:               ewsResponseCodeKinds[m.ResponseCode],
+		StatusCode:         http.StatusOK,
+		ResponseCode:       m.ResponseCode,
+		MessageText:        m.MessageText,
+		DescriptiveLinkKey: m.DescriptiveLinkKey,
+		BackOff:            m.MessageXml.backOff(),
+	}
+}
+
+// httpStatusError converts a non-200 HTTP response from EWS into a typed error.
+// EWS reports most failures (including throttling) as a SOAP fault with HTTP 500.
+func httpStatusError(resp *http.Response, body []byte) error {
+	ewsErr := &EWSError{StatusCode: resp.StatusCode}
+
+	var fault struct {
+		Body struct {
+			Fault *SOAPFault `xml:"Fault"`
+		} `xml:"Body"`
+	}
+	if xml.Unmarshal(body, &fault) == nil && fault.Body.Fault != nil {
+		ewsErr.MessageText = fault.Body.Fault.String
+		if detail := fault.Body.Fault.Detail; detail != nil {
+			ewsErr.ResponseCode = detail.ResponseCode
+			if detail.Message != "" {
+				ewsErr.MessageText = detail.Message
+			}
+			ewsErr.BackOff = detail.MessageXml.backOff()
+		}
+		ewsErr.Kind = ewsResponseCodeKinds[ewsErr.ResponseCode]
+	}
+
+	if ewsErr.Kind == nil {
+		switch resp.StatusCode {
+		case http.StatusUnauthorized:
+			ewsErr.Kind = ErrUnauthorized
+		case 440:
+			ewsErr.Kind = ErrLoginTimeout
+		case http.StatusForbidden:
+			ewsErr.Kind = ErrAccessDenied
+		case http.StatusTooManyRequests:
+			ewsErr.Kind = ErrThrottled
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			ewsErr.Kind = ErrServerUnavailable
+		}
+	}
+
+	if ewsErr.BackOff == 0 {
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			ewsErr.BackOff = time.Duration(seconds) * time.Second
+		}
+	}
+
+	if ewsErr.MessageText == "" {
+		text := strings.TrimSpace(string(body))
+		if len(text) > 200 {
+			text = text[:200] + "..."
+		}
+		ewsErr.MessageText = text
+	}
+
+	return ewsErr
+}
+
+// soapFaultError converts a SOAP fault returned with HTTP 200 into a typed error
+func soapFaultError(fault *SOAPFault) error {
+	ewsErr := &EWSError{
+		StatusCode:  http.StatusOK,
+		MessageText: fmt.Sprintf("SOAP fault: %s - %s", fault.Code, fault.String),
+	}
+	if fault.Detail != nil {
+		ewsErr.ResponseCode = fault.Detail.ResponseCode
+		ewsErr.BackOff = fault.Detail.MessageXml.backOff()
+		ewsErr.Kind = ewsResponseCodeKinds[ewsErr.ResponseCode]
+	}
+	return ewsErr
+}
+
+// describeExchangeError returns a user-facing explanation of an Exchange error
+func describeExchangeError(err error) string {
+	var ewsErr *EWSError
+	errors.As(err, &ewsErr)
+
+	switch {
+	case errors.Is(err, ErrOutcomeUnknown):
+		return "Exchange не ответил, но мог выполнить операцию. Проверьте календарь или почту в Outlook, прежде чем повторять ее."
+	case errors.Is(err, ErrUnauthorized):
+		return "Exchange отклонил учетные данные. Обновите логин и пароль в настройках Exchange Integration."
+	case errors.Is(err, ErrLoginTimeout):
+		return "Сессия Exchange истекла (HTTP 440). Повторите попытку позже."
+	case errors.Is(err, ErrThrottled):
+		if ewsErr != nil && ewsErr.BackOff > 0 {
+			return fmt.Sprintf("Exchange временно ограничил количество запросов. Повторите через %s.", ewsErr.BackOff.Round(time.Second))
+		}
+		return "Exchange временно ограничил количество запросов. Повторите попытку позже."
+	case errors.Is(err, ErrLimiterBusy):
+		return "Плагин сейчас отправляет в Exchange слишком много запросов. Повторите попытку через несколько секунд."
+	case errors.Is(err, ErrAccessDenied):
+		return "Нет доступа к запрошенным данным Exchange."
+	case errors.Is(err, ErrItemNotFound):
+		return "Элемент не найден в Exchange (возможно, он был удален или перемещен)."
+	case errors.Is(err, ErrNotOrganizer):
+		return "Изменить или отменить встречу может только ее организатор."
+	case errors.Is(err, ErrMailboxNotFound):
+		return "Почтовый ящик не найден в Exchange."
+	case errors.Is(err, ErrServerUnavailable), errors.Is(err, ErrEndpointUnreachable):
+		return "Сервер Exchange временно недоступен. Повторите попытку позже."
+	}
+
+	if message, ok := describeCertificateError(err); ok {
+		return message
+	}
+
+	return err.Error()
+}
+
+// exchangeErrorStatus maps an Exchange error to the HTTP status returned by the plugin API
+func exchangeErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrUnauthorized), errors.Is(err, ErrAccessDenied), errors.Is(err, ErrNotOrganizer):
+		return http.StatusForbidden
+	case errors.Is(err, ErrOutcomeUnknown):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, ErrThrottled), errors.Is(err, ErrLimiterBusy):
+		return http.StatusTooManyRequests
+	case errors.Is(err, ErrItemNotFound), errors.Is(err, ErrMailboxNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrLoginTimeout), errors.Is(err, ErrServerUnavailable), errors.Is(err, ErrEndpointUnreachable):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
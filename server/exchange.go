@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -22,354 +26,1391 @@ type SOAPEnvelope struct {
 
 type SOAPHeader struct {
 	RequestServerVersion *RequestServerVersion `xml:"t:RequestServerVersion,omitempty"`
+	TimeZoneContext      *TimeZoneContext      `xml:"t:TimeZoneContext,omitempty"`
 }
 
 type RequestServerVersion struct {
 	Version string `xml:"Version,attr"`
 }
 
+type TimeZoneContext struct {
+	TimeZoneDefinition *TimeZoneDefinition `xml:"t:TimeZoneDefinition"`
+}
+
+type TimeZoneDefinition struct {
+	Id string `xml:"Id,attr"`
+}
+
 type SOAPBody struct {
-	FindItem *FindItem `xml:"m:FindItem,omitempty"`
-	GetItem  *GetItem  `xml:"m:GetItem,omitempty"`
+	FindItem           *FindItem           `xml:"m:FindItem,omitempty"`
+	GetItem            *GetItem            `xml:"m:GetItem,omitempty"`
+	CreateItem         *CreateItem         `xml:"m:CreateItem,omitempty"`
+	UpdateItem         *UpdateItem         `xml:"m:UpdateItem,omitempty"`
+	SyncFolderItems    *SyncFolderItems    `xml:"m:SyncFolderItems,omitempty"`
+	GetFolder          *GetFolder          `xml:"m:GetFolder,omitempty"`
+	FindFolder         *FindFolder         `xml:"m:FindFolder,omitempty"`
+	Subscribe          *Subscribe          `xml:"m:Subscribe,omitempty"`
+	GetEvents          *GetEvents          `xml:"m:GetEvents,omitempty"`
+	GetStreamingEvents *GetStreamingEvents `xml:"m:GetStreamingEvents,omitempty"`
+	Unsubscribe        *Unsubscribe        `xml:"m:Unsubscribe,omitempty"`
+
+	GetUserAvailabilityRequest *GetUserAvailabilityRequest `xml:"m:GetUserAvailabilityRequest,omitempty"`
+	GetRoomLists               *GetRoomLists               `xml:"m:GetRoomLists,omitempty"`
+	GetRooms                   *GetRooms                   `xml:"m:GetRooms,omitempty"`
+	GetUserOofSettingsRequest  *GetUserOofSettingsRequest  `xml:"m:GetUserOofSettingsRequest,omitempty"`
+	SetUserOofSettingsRequest  *SetUserOofSettingsRequest  `xml:"m:SetUserOofSettingsRequest,omitempty"`
+	ResolveNames               *ResolveNames               `xml:"m:ResolveNames,omitempty"`
+	GetAttachment              *GetAttachment              `xml:"m:GetAttachment,omitempty"`
 }
 
 type FindItem struct {
+	Traversal           string               `xml:"Traversal,attr"`
+	ItemShape           *ItemShape           `xml:"m:ItemShape"`
+	IndexedPageItemView *IndexedPageItemView `xml:"m:IndexedPageItemView,omitempty"`
+	CalendarView        *CalendarView        `xml:"m:CalendarView,omitempty"`
+	Restriction         *Restriction         `xml:"m:Restriction,omitempty"`
+	SortOrder           *SortOrder           `xml:"m:SortOrder,omitempty"`
+	ParentFolderIds     *ParentFolderIds     `xml:"m:ParentFolderIds"`
+}
+
+type IndexedPageItemView struct {
+	MaxEntriesReturned string `xml:"MaxEntriesReturned,attr"`
+	Offset             string `xml:"Offset,attr"`
+	BasePoint          string `xml:"BasePoint,attr"`
+}
+
+type Restriction struct {
+	IsEqualTo     *IsEqualTo         `xml:"t:IsEqualTo,omitempty"`
+	IsGreaterThan *IsEqualTo         `xml:"t:IsGreaterThan,omitempty"`
+	And           *SearchExpressions `xml:"t:And,omitempty"`
+}
+
+// SearchExpressions are the operands of an And or Or restriction
+type SearchExpressions struct {
+	IsEqualTo     []IsEqualTo         `xml:"t:IsEqualTo"`
+	IsGreaterThan []IsEqualTo         `xml:"t:IsGreaterThan"` // Same operands as IsEqualTo
+	Contains      []Contains          `xml:"t:Contains"`
+	Or            []SearchExpressions `xml:"t:Or"`
+}
+
+type IsEqualTo struct {
+	FieldURI           *FieldURI           `xml:"t:FieldURI"`
+	FieldURIOrConstant *FieldURIOrConstant `xml:"t:FieldURIOrConstant"`
+}
+
+type Contains struct {
+	ContainmentMode       string            `xml:"ContainmentMode,attr"`       // FullString, Prefixed, Substring, ...
+	ContainmentComparison string            `xml:"ContainmentComparison,attr"` // Exact, IgnoreCase, ...
+	FieldURI              *FieldURI         `xml:"t:FieldURI,omitempty"`
+	ExtendedFieldURI      *ExtendedFieldURI `xml:"t:ExtendedFieldURI,omitempty"`
+	Constant              *Constant         `xml:"t:Constant"`
+}
+
+// ExtendedFieldURI addresses a MAPI property that has no EWS field
+type ExtendedFieldURI struct {
+	PropertyTag  string `xml:"PropertyTag,attr"`
+	PropertyType string `xml:"PropertyType,attr"`
+}
+
+type FieldURIOrConstant struct {
+	Constant *Constant `xml:"t:Constant"`
+}
+
+type Constant struct {
+	Value string `xml:"Value,attr"`
+}
+
+type SortOrder struct {
+	FieldOrder []FieldOrder `xml:"t:FieldOrder"`
+}
+
+type FieldOrder struct {
+	Order    string    `xml:"Order,attr"`
+	FieldURI *FieldURI `xml:"t:FieldURI"`
+}
+
+type ItemShape struct {
+	BaseShape            string                `xml:"t:BaseShape"`
+	BodyType             string                `xml:"t:BodyType,omitempty"`
+	AdditionalProperties *AdditionalProperties `xml:"t:AdditionalProperties,omitempty"`
+}
+
+type AdditionalProperties struct {
+	FieldURI         []FieldURI         `xml:"t:FieldURI"`
+	ExtendedFieldURI []ExtendedFieldURI `xml:"t:ExtendedFieldURI"`
+}
+
+type FieldURI struct {
+	FieldURI string `xml:"FieldURI,attr"`
+}
+
+type CalendarView struct {
+	MaxEntriesReturned string `xml:"MaxEntriesReturned,attr"`
+	StartDate          string `xml:"StartDate,attr"`
+	EndDate            string `xml:"EndDate,attr"`
+}
+
+type ParentFolderIds struct {
+	DistinguishedFolderId *DistinguishedFolderId `xml:"t:DistinguishedFolderId,omitempty"`
+	FolderId              *FolderId              `xml:"t:FolderId,omitempty"`
+}
+
+// DistinguishedFolderId names a well-known folder; Mailbox selects another user's mailbox for delegate access
+type DistinguishedFolderId struct {
+	Id      string          `xml:"Id,attr"`
+	Mailbox *RequestMailbox `xml:"t:Mailbox,omitempty"`
+}
+
+type UpdateItem struct {
+	MessageDisposition                    string       `xml:"MessageDisposition,attr,omitempty"`
+	ConflictResolution                    string       `xml:"ConflictResolution,attr"`
+	SendMeetingInvitationsOrCancellations string       `xml:"SendMeetingInvitationsOrCancellations,attr,omitempty"`
+	ItemChanges                           *ItemChanges `xml:"m:ItemChanges"`
+}
+
+type ItemChanges struct {
+	ItemChange []ItemChange `xml:"t:ItemChange"`
+}
+
+type ItemChange struct {
+	ItemId  *ItemId      `xml:"t:ItemId"`
+	Updates *ItemUpdates `xml:"t:Updates"`
+}
+
+type ItemUpdates struct {
+	SetItemField    []SetItemField    `xml:"t:SetItemField"`
+	DeleteItemField []DeleteItemField `xml:"t:DeleteItemField"`
+}
+
+type SetItemField struct {
+	FieldURI     *FieldURI        `xml:"t:FieldURI"`
+	CalendarItem *NewCalendarItem `xml:"t:CalendarItem"`
+	Message      *MessageUpdate   `xml:"t:Message"`
+}
+
+// MessageUpdate carries the message fields UpdateItem changes
+type MessageUpdate struct {
+	IsRead *bool `xml:"t:IsRead,omitempty"`
+}
+
+type DeleteItemField struct {
+	FieldURI *FieldURI `xml:"t:FieldURI"`
+}
+
+type GetUserAvailabilityRequest struct {
+	TimeZone            *SerializableTimeZone `xml:"t:TimeZone"`
+	MailboxDataArray    *MailboxDataArray     `xml:"m:MailboxDataArray"`
+	FreeBusyViewOptions *FreeBusyViewOptions  `xml:"t:FreeBusyViewOptions"`
+}
+
+// SerializableTimeZone is the time zone of GetUserAvailability requests and returned times
+type SerializableTimeZone struct {
+	Bias         int                       `xml:"t:Bias"`
+	StandardTime *SerializableTimeZoneTime `xml:"t:StandardTime"`
+	DaylightTime *SerializableTimeZoneTime `xml:"t:DaylightTime"`
+}
+
+type SerializableTimeZoneTime struct {
+	Bias      int    `xml:"t:Bias"`
+	Time      string `xml:"t:Time"`
+	DayOrder  int    `xml:"t:DayOrder"`
+	Month     int    `xml:"t:Month"`
+	DayOfWeek string `xml:"t:DayOfWeek"`
+}
+
+type MailboxDataArray struct {
+	MailboxData []MailboxData `xml:"t:MailboxData"`
+}
+
+type MailboxData struct {
+	Email            *EmailAddress `xml:"t:Email"`
+	AttendeeType     string        `xml:"t:AttendeeType"`
+	ExcludeConflicts bool          `xml:"t:ExcludeConflicts"`
+}
+
+type EmailAddress struct {
+	Address string `xml:"t:Address"`
+}
+
+type FreeBusyViewOptions struct {
+	TimeWindow                      *TimeWindow `xml:"t:TimeWindow"`
+	MergedFreeBusyIntervalInMinutes int         `xml:"t:MergedFreeBusyIntervalInMinutes"`
+	RequestedView                   string      `xml:"t:RequestedView"`
+}
+
+type TimeWindow struct {
+	StartTime string `xml:"t:StartTime"`
+	EndTime   string `xml:"t:EndTime"`
+}
+
+type GetRoomLists struct{}
+
+type GetRooms struct {
+	RoomList *RoomListId `xml:"m:RoomList"`
+}
+
+type RoomListId struct {
+	EmailAddress string `xml:"t:EmailAddress"`
+}
+
+type ResolveNames struct {
+	ReturnFullContactData bool   `xml:"ReturnFullContactData,attr"`
+	SearchScope           string `xml:"SearchScope,attr,omitempty"`
+	UnresolvedEntry       string `xml:"m:UnresolvedEntry"`
+}
+
+type GetAttachment struct {
+	AttachmentIds *AttachmentIds `xml:"m:AttachmentIds"`
+}
+
+type AttachmentIds struct {
+	AttachmentId []AttachmentId `xml:"t:AttachmentId"`
+}
+
+type AttachmentId struct {
+	Id string `xml:"Id,attr"`
+}
+
+type SyncFolderItems struct {
+	ItemShape          *ItemShape    `xml:"m:ItemShape"`
+	SyncFolderId       *SyncFolderId `xml:"m:SyncFolderId"`
+	SyncState          string        `xml:"m:SyncState,omitempty"`
+	MaxChangesReturned int           `xml:"m:MaxChangesReturned"`
+}
+
+type SyncFolderId struct {
+	DistinguishedFolderId *DistinguishedFolderId `xml:"t:DistinguishedFolderId"`
+}
+
+type GetFolder struct {
+	FolderShape *FolderShape `xml:"m:FolderShape"`
+	FolderIds   *FolderIds   `xml:"m:FolderIds"`
+}
+
+type FindFolder struct {
 	Traversal       string           `xml:"Traversal,attr"`
-	ItemShape       *ItemShape       `xml:"m:ItemShape"`
-	CalendarView    *CalendarView    `xml:"m:CalendarView"`
+	FolderShape     *FolderShape     `xml:"m:FolderShape"`
+	Restriction     *Restriction     `xml:"m:Restriction,omitempty"`
 	ParentFolderIds *ParentFolderIds `xml:"m:ParentFolderIds"`
 }
 
-type ItemShape struct {
-	BaseShape string `xml:"t:BaseShape"`
+type FolderShape struct {
+	BaseShape string `xml:"t:BaseShape"`
+}
+
+type FolderIds struct {
+	DistinguishedFolderId []DistinguishedFolderId `xml:"t:DistinguishedFolderId"`
+}
+
+type Subscribe struct {
+	PullSubscriptionRequest      *SubscriptionRequest `xml:"m:PullSubscriptionRequest,omitempty"`
+	StreamingSubscriptionRequest *SubscriptionRequest `xml:"m:StreamingSubscriptionRequest,omitempty"`
+}
+
+type SubscriptionRequest struct {
+	FolderIds  *FolderIds  `xml:"t:FolderIds"`
+	EventTypes *EventTypes `xml:"t:EventTypes"`
+	// Timeout is the pull subscription lifetime in minutes without GetEvents calls
+	Timeout int `xml:"t:Timeout,omitempty"`
+}
+
+type EventTypes struct {
+	EventType []string `xml:"t:EventType"`
+}
+
+type GetEvents struct {
+	SubscriptionId string `xml:"m:SubscriptionId"`
+	Watermark      string `xml:"m:Watermark"`
+}
+
+type GetStreamingEvents struct {
+	SubscriptionIds   *SubscriptionIds `xml:"m:SubscriptionIds"`
+	ConnectionTimeout int              `xml:"m:ConnectionTimeout"`
+}
+
+type SubscriptionIds struct {
+	SubscriptionId []string `xml:"t:SubscriptionId"`
+}
+
+type Unsubscribe struct {
+	SubscriptionId string `xml:"m:SubscriptionId"`
+}
+
+type GetItem struct {
+	ItemShape *ItemShape `xml:"m:ItemShape"`
+	ItemIds   *ItemIds   `xml:"m:ItemIds"`
+}
+
+type ItemIds struct {
+	ItemId                []ItemId                `xml:"t:ItemId"`
+	RecurringMasterItemId []RecurringMasterItemId `xml:"t:RecurringMasterItemId"`
+}
+
+type RecurringMasterItemId struct {
+	OccurrenceId string `xml:"OccurrenceId,attr"`
+}
+
+type ItemId struct {
+	Id        string `xml:"Id,attr"`
+	ChangeKey string `xml:"ChangeKey,attr,omitempty"`
+}
+
+type CreateItem struct {
+	MessageDisposition     string             `xml:"MessageDisposition,attr,omitempty"`
+	SendMeetingInvitations string             `xml:"SendMeetingInvitations,attr,omitempty"`
+	SavedItemFolderId      *SavedItemFolderId `xml:"m:SavedItemFolderId,omitempty"`
+	Items                  *CreateItems       `xml:"m:Items"`
+}
+
+type SavedItemFolderId struct {
+	DistinguishedFolderId *DistinguishedFolderId `xml:"t:DistinguishedFolderId"`
+}
+
+type CreateItems struct {
+	AcceptItem            *ResponseObject     `xml:"t:AcceptItem,omitempty"`
+	TentativelyAcceptItem *ResponseObject     `xml:"t:TentativelyAcceptItem,omitempty"`
+	DeclineItem           *ResponseObject     `xml:"t:DeclineItem,omitempty"`
+	CancelCalendarItem    *CancelCalendarItem `xml:"t:CancelCalendarItem,omitempty"`
+	CalendarItem          *NewCalendarItem    `xml:"t:CalendarItem,omitempty"`
+}
+
+type CancelCalendarItem struct {
+	ReferenceItemId *ItemId      `xml:"t:ReferenceItemId"`
+	NewBodyContent  *RequestBody `xml:"t:NewBodyContent,omitempty"`
+}
+
+// NewCalendarItem is a calendar item sent to CreateItem or SetItemField; fields follow the schema order
+type NewCalendarItem struct {
+	Subject           string            `xml:"t:Subject,omitempty"`
+	Body              *RequestBody      `xml:"t:Body,omitempty"`
+	Start             string            `xml:"t:Start,omitempty"`
+	End               string            `xml:"t:End,omitempty"`
+	Location          string            `xml:"t:Location,omitempty"`
+	RequiredAttendees *RequestAttendees `xml:"t:RequiredAttendees,omitempty"`
+	Resources         *RequestAttendees `xml:"t:Resources,omitempty"`
+}
+
+type RequestAttendees struct {
+	Attendee []RequestAttendee `xml:"t:Attendee"`
+}
+
+type RequestAttendee struct {
+	Mailbox *RequestMailbox `xml:"t:Mailbox"`
+}
+
+type RequestMailbox struct {
+	EmailAddress string `xml:"t:EmailAddress"`
+}
+
+// GetUserOofSettingsRequest and SetUserOofSettingsRequest address the mailbox by its SMTP address
+type GetUserOofSettingsRequest struct {
+	Mailbox *OofMailbox `xml:"t:Mailbox"`
+}
+
+type SetUserOofSettingsRequest struct {
+	Mailbox         *OofMailbox      `xml:"t:Mailbox"`
+	UserOofSettings *UserOofSettings `xml:"t:UserOofSettings"`
+}
+
+type OofMailbox struct {
+	Address string `xml:"t:Address"`
+}
+
+// UserOofSettings are the automatic replies sent to SetUserOofSettings; times are in UTC
+type UserOofSettings struct {
+	OofState         string       `xml:"t:OofState"`
+	ExternalAudience string       `xml:"t:ExternalAudience"`
+	Duration         *OofDuration `xml:"t:Duration,omitempty"`
+	InternalReply    *OofReply    `xml:"t:InternalReply,omitempty"`
+	ExternalReply    *OofReply    `xml:"t:ExternalReply,omitempty"`
+}
+
+type OofDuration struct {
+	StartTime string `xml:"t:StartTime"`
+	EndTime   string `xml:"t:EndTime"`
+}
+
+type OofReply struct {
+	Message string `xml:"t:Message"`
+}
+
+type ResponseObject struct {
+	Body            *RequestBody `xml:"t:Body,omitempty"`
+	ReferenceItemId *ItemId      `xml:"t:ReferenceItemId"`
+}
+
+type RequestBody struct {
+	BodyType string `xml:"BodyType,attr"`
+	Value    string `xml:",chardata"`
+}
+
+// Response structures
+type SOAPResponse struct {
+	XMLName xml.Name         `xml:"Envelope"`
+	Body    SOAPResponseBody `xml:"Body"`
+}
+
+type SOAPResponseBody struct {
+	FindItemResponse            *FindItemResponse            `xml:"FindItemResponse"`
+	GetItemResponse             *GetItemResponse             `xml:"GetItemResponse"`
+	CreateItemResponse          *CreateItemResponse          `xml:"CreateItemResponse"`
+	UpdateItemResponse          *UpdateItemResponse          `xml:"UpdateItemResponse"`
+	SyncFolderItemsResponse     *SyncFolderItemsResponse     `xml:"SyncFolderItemsResponse"`
+	GetFolderResponse           *GetFolderResponse           `xml:"GetFolderResponse"`
+	FindFolderResponse          *FindFolderResponse          `xml:"FindFolderResponse"`
+	SubscribeResponse           *SubscribeResponse           `xml:"SubscribeResponse"`
+	GetEventsResponse           *GetEventsResponse           `xml:"GetEventsResponse"`
+	GetStreamingEventsResponse  *GetStreamingEventsResponse  `xml:"GetStreamingEventsResponse"`
+	UnsubscribeResponse         *UnsubscribeResponse         `xml:"UnsubscribeResponse"`
+	GetUserAvailabilityResponse *GetUserAvailabilityResponse `xml:"GetUserAvailabilityResponse"`
+	GetRoomListsResponse        *GetRoomListsResponse        `xml:"GetRoomListsResponse"`
+	GetRoomsResponse            *GetRoomsResponse            `xml:"GetRoomsResponse"`
+	GetUserOofSettingsResponse  *GetUserOofSettingsResponse  `xml:"GetUserOofSettingsResponse"`
+	SetUserOofSettingsResponse  *SetUserOofSettingsResponse  `xml:"SetUserOofSettingsResponse"`
+	ResolveNamesResponse        *ResolveNamesResponse        `xml:"ResolveNamesResponse"`
+	GetAttachmentResponse       *GetAttachmentResponse       `xml:"GetAttachmentResponse"`
+	Fault                       *SOAPFault                   `xml:"Fault"`
+}
+
+type SOAPFault struct {
+	Code   string           `xml:"faultcode"`
+	String string           `xml:"faultstring"`
+	Detail *SOAPFaultDetail `xml:"detail"`
+}
+
+type FindItemResponse struct {
+	ResponseMessages *ResponseMessages `xml:"ResponseMessages"`
+}
+
+type ResponseMessages struct {
+	FindItemResponseMessage *FindItemResponseMessage `xml:"FindItemResponseMessage"`
+}
+
+type FindItemResponseMessage struct {
+	ResponseMessage
+	RootFolder *RootFolder `xml:"RootFolder"`
+}
+
+type RootFolder struct {
+	TotalItemsInView        string `xml:"TotalItemsInView,attr"`
+	IncludesLastItemInRange string `xml:"IncludesLastItemInRange,attr"`
+	Items                   *Items `xml:"Items"`
+}
+
+type Items struct {
+	CalendarItem   []CalendarItem `xml:"CalendarItem"`
+	MeetingRequest []CalendarItem `xml:"MeetingRequest"`
+	Message        []MessageItem  `xml:"Message"`
+}
+
+type CalendarItem struct {
+	ItemId               ItemId      `xml:"ItemId"`
+	Subject              string      `xml:"Subject"`
+	Start                string      `xml:"Start"`
+	End                  string      `xml:"End"`
+	Location             string      `xml:"Location"`
+	Organizer            *Organizer  `xml:"Organizer"`
+	LegacyFreeBusyStatus string      `xml:"LegacyFreeBusyStatus"`
+	IsAllDayEvent        string      `xml:"IsAllDayEvent"`
+	IsMeeting            string      `xml:"IsMeeting"`
+	IsRecurring          string      `xml:"IsRecurring"`
+	CalendarItemType     string      `xml:"CalendarItemType"`
+	RecurrenceId         string      `xml:"RecurrenceId"`
+	UID                  string      `xml:"UID"`
+	MyResponseType       string      `xml:"MyResponseType"`
+	Body                 *ItemBody   `xml:"Body"`
+	Categories           *Categories `xml:"Categories"`
+	Sensitivity          string      `xml:"Sensitivity"`
+	Importance           string      `xml:"Importance"`
+	RequiredAttendees    *Attendees  `xml:"RequiredAttendees"`
+	OptionalAttendees    *Attendees  `xml:"OptionalAttendees"`
+
+	// Meeting request fields
+	AssociatedCalendarItemId *ItemId `xml:"AssociatedCalendarItemId"`
+	MeetingRequestType       string  `xml:"MeetingRequestType"`
+	IsOutOfDate              string  `xml:"IsOutOfDate"`
+	DateTimeReceived         string  `xml:"DateTimeReceived"`
+}
+
+type MessageItem struct {
+	ItemId           ItemId             `xml:"ItemId"`
+	Subject          string             `xml:"Subject"`
+	Body             *ItemBody          `xml:"Body"`
+	Importance       string             `xml:"Importance"`
+	DateTimeReceived string             `xml:"DateTimeReceived"`
+	HasAttachments   string             `xml:"HasAttachments"`
+	IsRead           string             `xml:"IsRead"`
+	From             *Organizer         `xml:"From"` // Same shape as Organizer: a single Mailbox
+	ToRecipients     *Recipients        `xml:"ToRecipients"`
+	CcRecipients     *Recipients        `xml:"CcRecipients"`
+	Attachments      *Attachments       `xml:"Attachments"`
+	ExtendedProperty []ExtendedProperty `xml:"ExtendedProperty"`
+}
+
+type Recipients struct {
+	Mailbox []Mailbox `xml:"Mailbox"`
+}
+
+type Attachments struct {
+	FileAttachment []FileAttachment `xml:"FileAttachment"`
+	ItemAttachment []FileAttachment `xml:"ItemAttachment"` // An attached message; only its name is used
+}
+
+type FileAttachment struct {
+	AttachmentId AttachmentId `xml:"AttachmentId"`
+	Name         string       `xml:"Name"`
+	ContentType  string       `xml:"ContentType"`
+	Size         string       `xml:"Size"`
+	IsInline     string       `xml:"IsInline"`
+	Content      string       `xml:"Content"` // Base64, returned by GetAttachment only
+}
+
+type ExtendedProperty struct {
+	ExtendedFieldURI ExtendedFieldURI `xml:"ExtendedFieldURI"`
+	Value            string           `xml:"Value"`
+}
+
+type ItemBody struct {
+	BodyType string `xml:"BodyType,attr"`
+	Value    string `xml:",chardata"`
+}
+
+type Categories struct {
+	String []string `xml:"String"`
+}
+
+type Attendees struct {
+	Attendee []Attendee `xml:"Attendee"`
+}
+
+type Attendee struct {
+	Mailbox      *Mailbox `xml:"Mailbox"`
+	ResponseType string   `xml:"ResponseType"`
+}
+
+type Organizer struct {
+	Mailbox *Mailbox `xml:"Mailbox"`
+}
+
+type Mailbox struct {
+	Name         string `xml:"Name"`
+	EmailAddress string `xml:"EmailAddress"`
+	RoutingType  string `xml:"RoutingType"` // SMTP, or EX for an internal legacy DN address
+	MailboxType  string `xml:"MailboxType"`
+}
+
+type GetItemResponse struct {
+	ResponseMessages *GetItemResponseMessages `xml:"ResponseMessages"`
+}
+
+type GetItemResponseMessages struct {
+	GetItemResponseMessage []GetItemResponseMessage `xml:"GetItemResponseMessage"`
+}
+
+type GetItemResponseMessage struct {
+	ResponseMessage
+	Items *ResponseItems `xml:"Items"`
+}
+
+type CreateItemResponse struct {
+	ResponseMessages *CreateItemResponseMessages `xml:"ResponseMessages"`
+}
+
+type CreateItemResponseMessages struct {
+	CreateItemResponseMessage []CreateItemResponseMessage `xml:"CreateItemResponseMessage"`
+}
+
+type CreateItemResponseMessage struct {
+	ResponseMessage
+	Items *ResponseItems `xml:"Items"`
+}
+
+type UpdateItemResponse struct {
+	ResponseMessages *UpdateItemResponseMessages `xml:"ResponseMessages"`
+}
+
+type UpdateItemResponseMessages struct {
+	UpdateItemResponseMessage []ResponseMessage `xml:"UpdateItemResponseMessage"`
+}
+
+type GetUserAvailabilityResponse struct {
+	FreeBusyResponseArray *FreeBusyResponseArray `xml:"FreeBusyResponseArray"`
+}
+
+type FreeBusyResponseArray struct {
+	FreeBusyResponse []FreeBusyResponse `xml:"FreeBusyResponse"`
+}
+
+// FreeBusyResponse is the availability of one mailbox, in the order of MailboxDataArray
+type FreeBusyResponse struct {
+	ResponseMessage *ResponseMessage `xml:"ResponseMessage"`
+	FreeBusyView    *FreeBusyView    `xml:"FreeBusyView"`
+}
+
+type FreeBusyView struct {
+	FreeBusyViewType   string              `xml:"FreeBusyViewType"`
+	CalendarEventArray *CalendarEventArray `xml:"CalendarEventArray"`
+}
+
+type CalendarEventArray struct {
+	CalendarEvent []FreeBusyEvent `xml:"CalendarEvent"`
+}
+
+type FreeBusyEvent struct {
+	StartTime string `xml:"StartTime"`
+	EndTime   string `xml:"EndTime"`
+	BusyType  string `xml:"BusyType"` // Free, Tentative, Busy, OOF, NoData
+}
+
+// GetRoomListsResponse and GetRoomsResponse are response messages themselves
+type GetRoomListsResponse struct {
+	ResponseMessage
+	RoomLists *RoomLists `xml:"RoomLists"`
+}
+
+type RoomLists struct {
+	Address []Mailbox `xml:"Address"`
+}
+
+type GetRoomsResponse struct {
+	ResponseMessage
+	Rooms *Rooms `xml:"Rooms"`
+}
+
+type Rooms struct {
+	Room []RoomEntry `xml:"Room"`
+}
+
+type RoomEntry struct {
+	Id *Mailbox `xml:"Id"`
+}
+
+type GetAttachmentResponse struct {
+	ResponseMessages *GetAttachmentResponseMessages `xml:"ResponseMessages"`
+}
+
+type GetAttachmentResponseMessages struct {
+	GetAttachmentResponseMessage []GetAttachmentResponseMessage `xml:"GetAttachmentResponseMessage"`
+}
+
+type GetAttachmentResponseMessage struct {
+	ResponseMessage
+	Attachments *Attachments `xml:"Attachments"`
+}
+
+type ResolveNamesResponse struct {
+	ResponseMessages *ResolveNamesResponseMessages `xml:"ResponseMessages"`
+}
+
+type ResolveNamesResponseMessages struct {
+	ResolveNamesResponseMessage *ResolveNamesResponseMessage `xml:"ResolveNamesResponseMessage"`
+}
+
+type ResolveNamesResponseMessage struct {
+	ResponseMessage
+	ResolutionSet *ResolutionSet `xml:"ResolutionSet"`
+}
+
+type ResolutionSet struct {
+	Resolution []Resolution `xml:"Resolution"`
+}
+
+type Resolution struct {
+	Mailbox *Mailbox `xml:"Mailbox"`
+}
+
+// GetUserOofSettingsResponse and SetUserOofSettingsResponse carry a single ResponseMessage element
+type GetUserOofSettingsResponse struct {
+	ResponseMessage  *ResponseMessage     `xml:"ResponseMessage"`
+	OofSettings      *OofSettingsResponse `xml:"OofSettings"`
+	AllowExternalOof string               `xml:"AllowExternalOof"`
+}
+
+type OofSettingsResponse struct {
+	OofState         string               `xml:"OofState"` // Disabled, Enabled, Scheduled
+	ExternalAudience string               `xml:"ExternalAudience"`
+	Duration         *OofDurationResponse `xml:"Duration"`
+	InternalReply    *OofReplyResponse    `xml:"InternalReply"`
+	ExternalReply    *OofReplyResponse    `xml:"ExternalReply"`
+}
+
+type OofDurationResponse struct {
+	StartTime string `xml:"StartTime"`
+	EndTime   string `xml:"EndTime"`
+}
+
+type OofReplyResponse struct {
+	Message string `xml:"Message"`
+}
+
+type SetUserOofSettingsResponse struct {
+	ResponseMessage *ResponseMessage `xml:"ResponseMessage"`
+}
+
+type SyncFolderItemsResponse struct {
+	ResponseMessages *SyncFolderItemsResponseMessages `xml:"ResponseMessages"`
+}
+
+type SyncFolderItemsResponseMessages struct {
+	SyncFolderItemsResponseMessage []SyncFolderItemsResponseMessage `xml:"SyncFolderItemsResponseMessage"`
+}
+
+type SyncFolderItemsResponseMessage struct {
+	ResponseMessage
+	SyncState               string       `xml:"SyncState"`
+	IncludesLastItemInRange string       `xml:"IncludesLastItemInRange"`
+	Changes                 *SyncChanges `xml:"Changes"`
+}
+
+// SyncChanges keeps Create, Update and Delete elements in the order the server returned them
+type SyncChanges struct {
+	Change []SyncChange `xml:",any"`
+}
+
+type SyncChange struct {
+	XMLName      xml.Name
+	CalendarItem *CalendarItem `xml:"CalendarItem"`
+	ItemId       *ItemId       `xml:"ItemId"`
+}
+
+type GetFolderResponse struct {
+	ResponseMessages *GetFolderResponseMessages `xml:"ResponseMessages"`
+}
+
+type GetFolderResponseMessages struct {
+	GetFolderResponseMessage []GetFolderResponseMessage `xml:"GetFolderResponseMessage"`
+}
+
+type GetFolderResponseMessage struct {
+	ResponseMessage
+	Folders *Folders `xml:"Folders"`
+}
+
+type Folders struct {
+	Folder         []Folder `xml:"Folder"`
+	CalendarFolder []Folder `xml:"CalendarFolder"`
+}
+
+type Folder struct {
+	FolderId    FolderId `xml:"FolderId"`
+	DisplayName string   `xml:"DisplayName"`
+}
+
+type FindFolderResponse struct {
+	ResponseMessages *FindFolderResponseMessages `xml:"ResponseMessages"`
+}
+
+type FindFolderResponseMessages struct {
+	FindFolderResponseMessage *FindFolderResponseMessage `xml:"FindFolderResponseMessage"`
+}
+
+type FindFolderResponseMessage struct {
+	ResponseMessage
+	RootFolder *FindFolderRootFolder `xml:"RootFolder"`
+}
+
+type FindFolderRootFolder struct {
+	Folders *Folders `xml:"Folders"`
+}
+
+type FolderId struct {
+	Id        string `xml:"Id,attr"`
+	ChangeKey string `xml:"ChangeKey,attr,omitempty"`
+}
+
+type SubscribeResponse struct {
+	ResponseMessages *SubscribeResponseMessages `xml:"ResponseMessages"`
+}
+
+type SubscribeResponseMessages struct {
+	SubscribeResponseMessage []SubscribeResponseMessage `xml:"SubscribeResponseMessage"`
+}
+
+type SubscribeResponseMessage struct {
+	ResponseMessage
+	SubscriptionId string `xml:"SubscriptionId"`
+	Watermark      string `xml:"Watermark"`
+}
+
+type GetEventsResponse struct {
+	ResponseMessages *GetEventsResponseMessages `xml:"ResponseMessages"`
+}
+
+type GetEventsResponseMessages struct {
+	GetEventsResponseMessage []GetEventsResponseMessage `xml:"GetEventsResponseMessage"`
+}
+
+type GetEventsResponseMessage struct {
+	ResponseMessage
+	Notification *Notification `xml:"Notification"`
+}
+
+type GetStreamingEventsResponse struct {
+	ResponseMessages *GetStreamingEventsResponseMessages `xml:"ResponseMessages"`
+}
+
+type GetStreamingEventsResponseMessages struct {
+	GetStreamingEventsResponseMessage []GetStreamingEventsResponseMessage `xml:"GetStreamingEventsResponseMessage"`
+}
+
+type GetStreamingEventsResponseMessage struct {
+	ResponseMessage
+	Notifications    *Notifications `xml:"Notifications"`
+	ConnectionStatus string         `xml:"ConnectionStatus"` // OK or Closed
+}
+
+type Notifications struct {
+	Notification []Notification `xml:"Notification"`
+}
+
+type Notification struct {
+	SubscriptionId    string              `xml:"SubscriptionId"`
+	PreviousWatermark string              `xml:"PreviousWatermark"`
+	MoreEvents        string              `xml:"MoreEvents"`
+	Events            []NotificationEvent `xml:",any"`
+}
+
+// NotificationEvent is one of StatusEvent, NewMailEvent, CreatedEvent, ModifiedEvent, DeletedEvent, MovedEvent or CopiedEvent
+type NotificationEvent struct {
+	XMLName           xml.Name
+	Watermark         string    `xml:"Watermark"`
+	ItemId            *ItemId   `xml:"ItemId"`
+	FolderId          *FolderId `xml:"FolderId"`
+	ParentFolderId    *FolderId `xml:"ParentFolderId"`
+	OldParentFolderId *FolderId `xml:"OldParentFolderId"`
+}
+
+type UnsubscribeResponse struct {
+	ResponseMessages *UnsubscribeResponseMessages `xml:"ResponseMessages"`
+}
+
+type UnsubscribeResponseMessages struct {
+	UnsubscribeResponseMessage []ResponseMessage `xml:"UnsubscribeResponseMessage"`
+}
+
+type ResponseItems struct {
+	CalendarItem   []CalendarItem `xml:"CalendarItem"`
+	MeetingRequest []CalendarItem `xml:"MeetingRequest"`
+	Message        []MessageItem  `xml:"Message"`
+}
+
+// Authentication methods supported by ExchangeClient
+const (
+	AuthMethodBasic = "basic"
+	AuthMethodNTLM  = "ntlm"
+)
+
+// ExchangeClientOptions holds the admin-level connection settings
+type ExchangeClientOptions struct {
+	AuthMethod string
+	// TLSConfig is cloned for the client; nil means verified TLS 1.2+
+	TLSConfig *tls.Config
+	// Limiter is shared by all clients; nil means no limits
+	Limiter *RequestLimiter
+	// Transport is shared by all clients so connections are reused; nil creates one for the client
+	Transport http.RoundTripper
+	// AutodiscoverDomains are the domains, besides the server's host, whose Autodiscover
+	// endpoints may receive credentials
+	AutodiscoverDomains []string
+}
+
+// ExchangeClient handles communication with Exchange Web Services
+type ExchangeClient struct {
+	serverURL   string
+	credentials *ExchangeCredentials
+	httpClient  *http.Client
+	limiter     *RequestLimiter
+	// transport is the transport without the limiter, for long-lived streaming connections
+	transport http.RoundTripper
+
+	// autodiscoverDomains are the trusted Autodiscover domains besides the server's host
+	autodiscoverDomains []string
+
+	// ewsURL is the known EWS endpoint (from Autodiscover or a previous probe)
+	ewsURL string
+	// username is the username format the server accepted
+	username string
+	// serverVersion is the RequestServerVersion the server accepted
+	serverVersion string
+	// location is the user's time zone for the TimeZoneContext header and returned times
+	location *time.Location
+}
+
+// Default and fallback values for the RequestServerVersion SOAP header
+const (
+	defaultServerVersion  = "Exchange2010_SP2"
+	fallbackServerVersion = "Exchange2007_SP1"
+)
+
+// ErrEndpointUnreachable is returned when the EWS endpoint answers 404 or cannot be reached
+var ErrEndpointUnreachable = errors.New("EWS endpoint unreachable")
+
+// NewExchangeClient creates a new Exchange client
+func NewExchangeClient(serverURL string, credentials *ExchangeCredentials, options ExchangeClientOptions) *ExchangeClient {
+	transport := options.Transport
+	if transport == nil {
+		transport = newEWSTransport(options.AuthMethod, options.TLSConfig)
+	}
+
+	// Every request, including probes and Autodiscover, goes through the limiter
+	limited := transport
+	if options.Limiter != nil {
+		limited = &limitedTransport{base: transport, limiter: options.Limiter}
+	}
+
+	return &ExchangeClient{
+		serverURL:   serverURL,
+		credentials: credentials,
+		httpClient: &http.Client{
+			Transport: limited,
+			Timeout:   30 * time.Second, // Shorter timeout to avoid 440 errors
+		},
+		limiter:             options.Limiter,
+		transport:           transport,
+		autodiscoverDomains: options.AutodiscoverDomains,
+	}
+}
+
+// newEWSTransport creates the HTTP transport for EWS requests. With NTLM every user
+// gets a keep-alive connection pool, because the handshake authenticates the connection.
+func newEWSTransport(authMethod string, tlsConfig *tls.Config) http.RoundTripper {
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	useNTLM := authMethod == AuthMethodNTLM
+
+	newBase := func() *http.Transport {
+		return &http.Transport{
+			TLSClientConfig:       tlsConfig.Clone(),
+			DisableKeepAlives:     !useNTLM,         // Disable keep-alives to avoid 440 timeouts
+			IdleConnTimeout:       30 * time.Second, // Shorter idle timeout
+			MaxIdleConns:          1,                // Minimal connection pooling
+			MaxIdleConnsPerHost:   1,                // One connection per host
+			ResponseHeaderTimeout: 30 * time.Second, // Response header timeout
+		}
+	}
+
+	if useNTLM {
+		return newNTLMTransport(newBase)
+	}
+	return newBase()
 }
 
-type CalendarView struct {
-	MaxEntriesReturned string `xml:"MaxEntriesReturned,attr"`
-	StartDate          string `xml:"StartDate,attr"`
-	EndDate            string `xml:"EndDate,attr"`
+// closeIdleConnections closes the idle connections of a transport that is no longer used
+func closeIdleConnections(transport http.RoundTripper) {
+	if closer, ok := transport.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
 }
 
-type ParentFolderIds struct {
-	DistinguishedFolderId *DistinguishedFolderId `xml:"t:DistinguishedFolderId"`
+// newExchangeClient creates an Exchange client from the active plugin configuration
+func (p *Plugin) newExchangeClient(credentials *ExchangeCredentials) *ExchangeClient {
+	config := p.getConfiguration()
+	return NewExchangeClient(config.ExchangeServerURL, credentials, ExchangeClientOptions{
+		AuthMethod: config.AuthMethod,
+		TLSConfig:  config.tlsConfig,
+		Limiter:    config.ewsLimiter,
+		Transport:  config.ewsTransport,
+
+		AutodiscoverDomains: parseDomainList(config.AutodiscoverTrustedDomains),
+	})
 }
 
-type DistinguishedFolderId struct {
-	Id string `xml:"Id,attr"`
+// SetLocation sets the user's time zone; event times are returned in it
+func (c *ExchangeClient) SetLocation(loc *time.Location) {
+	c.location = loc
 }
 
-type GetItem struct {
-	ItemShape *ItemShape `xml:"m:ItemShape"`
-	ItemIds   *ItemIds   `xml:"m:ItemIds"`
+// timeLocation returns the user's time zone, or the server's zone if not set
+func (c *ExchangeClient) timeLocation() *time.Location {
+	if c.location == nil {
+		return time.Local
+	}
+	return c.location
 }
 
-type ItemIds struct {
-	ItemId []ItemId `xml:"t:ItemId"`
+// SetEWSURL sets the EWS endpoint so the client does not need to probe for it
+func (c *ExchangeClient) SetEWSURL(ewsURL string) {
+	c.ewsURL = ewsURL
 }
 
-type ItemId struct {
-	Id        string `xml:"Id,attr"`
-	ChangeKey string `xml:"ChangeKey,attr"`
+// SetEndpoint makes the client reuse a previously negotiated endpoint, username format and server version
+func (c *ExchangeClient) SetEndpoint(ewsURL, username, serverVersion string) {
+	c.ewsURL = ewsURL
+	c.username = username
+	c.serverVersion = serverVersion
 }
 
-// Response structures
-type SOAPResponse struct {
-	XMLName xml.Name         `xml:"Envelope"`
-	Body    SOAPResponseBody `xml:"Body"`
+// EWSURL returns the EWS endpoint the client uses, if known
+func (c *ExchangeClient) EWSURL() string {
+	return c.ewsURL
 }
 
-type SOAPResponseBody struct {
-	FindItemResponse *FindItemResponse `xml:"FindItemResponse"`
-	GetItemResponse  *GetItemResponse  `xml:"GetItemResponse"`
-	Fault            *SOAPFault        `xml:"Fault"`
+// Username returns the username format the server accepted, if known
+func (c *ExchangeClient) Username() string {
+	return c.username
 }
 
-type SOAPFault struct {
-	Code   string `xml:"faultcode"`
-	String string `xml:"faultstring"`
+// ServerVersion returns the RequestServerVersion the server accepted, if known
+func (c *ExchangeClient) ServerVersion() string {
+	return c.serverVersion
 }
 
-type FindItemResponse struct {
-	ResponseMessages *ResponseMessages `xml:"ResponseMessages"`
+// requestServerVersion returns the version for the RequestServerVersion header
+func (c *ExchangeClient) requestServerVersion() string {
+	if c.serverVersion != "" {
+		return c.serverVersion
+	}
+	return defaultServerVersion
 }
 
-type ResponseMessages struct {
-	FindItemResponseMessage *FindItemResponseMessage `xml:"FindItemResponseMessage"`
+// authUsername returns the accepted username format, or DOMAIN\user when a domain is set
+func (c *ExchangeClient) authUsername() string {
+	if c.username != "" {
+		return c.username
+	}
+	if c.credentials.Domain != "" {
+		return c.credentials.Domain + "\\" + c.credentials.Username
+	}
+	return c.credentials.Username
 }
 
-type FindItemResponseMessage struct {
-	ResponseClass string      `xml:"ResponseClass,attr"`
-	ResponseCode  string      `xml:"ResponseCode"`
-	RootFolder    *RootFolder `xml:"RootFolder"`
+// retryUsername returns the username for retries: the accepted format if known,
+// otherwise the UPN form for the pbr domain of the 1cbit.ru server
+func (c *ExchangeClient) retryUsername() string {
+	if c.username == "" && c.credentials.Domain == "pbr" {
+		return c.credentials.Username + "@1cbit.ru"
+	}
+	return c.authUsername()
 }
 
-type RootFolder struct {
-	TotalItemsInView string `xml:"TotalItemsInView,attr"`
-	Items            *Items `xml:"Items"`
-}
+// GetCalendarEvents retrieves calendar events for the user
+func (p *Plugin) getCalendarEvents(userID string, credentials *ExchangeCredentials) ([]CalendarEvent, error) {
+	now := time.Now()
+	start := now.Add(-24 * time.Hour)  // Last 24 hours
+	end := now.Add(7 * 24 * time.Hour) // Next 7 days
 
-type Items struct {
-	CalendarItem []CalendarItem `xml:"CalendarItem"`
+	return p.getCalendarEventsInRange(userID, credentials, start, end)
 }
 
-type CalendarItem struct {
-	ItemId               ItemId     `xml:"ItemId"`
-	Subject              string     `xml:"Subject"`
-	Start                string     `xml:"Start"`
-	End                  string     `xml:"End"`
-	Location             string     `xml:"Location"`
-	Organizer            *Organizer `xml:"Organizer"`
-	LegacyFreeBusyStatus string     `xml:"LegacyFreeBusyStatus"`
-	IsAllDayEvent        string     `xml:"IsAllDayEvent"`
-	IsMeeting            string     `xml:"IsMeeting"`
-}
+// GetCalendarEventsInRange retrieves calendar events within a specific time range,
+// including the secondary calendar folders the user has chosen
+func (p *Plugin) getCalendarEventsInRange(userID string, credentials *ExchangeCredentials, start, end time.Time) ([]CalendarEvent, error) {
+	client, err := p.newUserExchangeClient(userID, credentials)
+	if err != nil {
+		return nil, err
+	}
 
-type Organizer struct {
-	Mailbox *Mailbox `xml:"Mailbox"`
-}
+	var events []CalendarEvent
+	if p.getConfiguration().EnableIncrementalSync {
+		events, err = p.getCalendarEventsIncremental(userID, client, start, end)
+	} else {
+		events, err = client.GetCalendarEventsInRange(start, end)
+	}
+	p.recordEndpointResult(userID, client, err)
+	if err != nil {
+		return nil, err
+	}
 
-type Mailbox struct {
-	Name         string `xml:"Name"`
-	EmailAddress string `xml:"EmailAddress"`
+	return p.addSecondaryCalendarEvents(userID, client, events, start, end), nil
 }
 
-type GetItemResponse struct {
-	ResponseMessages *GetItemResponseMessages `xml:"ResponseMessages"`
+// minCalendarViewWindow is the smallest window GetCalendarEventsInRange splits a truncated view into
+const minCalendarViewWindow = 15 * time.Minute
+
+// GetCalendarEventsInRange gets all events in a date range. CalendarView returns at most
+// MaxEntriesReturned items, so a truncated view is split in halves until every window
+// fits. Occurrences spanning a split point come back twice and are deduplicated by ID.
+func (c *ExchangeClient) GetCalendarEventsInRange(start, end time.Time) ([]CalendarEvent, error) {
+	return c.GetMailboxCalendarEvents("", start, end)
 }
 
-type GetItemResponseMessages struct {
-	GetItemResponseMessage *GetItemResponseMessage `xml:"GetItemResponseMessage"`
+// GetMailboxCalendarEvents gets the events of the calendar of a mailbox the user has
+// delegate access to; an empty mailbox is the user's own calendar
+func (c *ExchangeClient) GetMailboxCalendarEvents(mailbox string, start, end time.Time) ([]CalendarEvent, error) {
+	return c.getFolderEvents(&ParentFolderIds{DistinguishedFolderId: distinguishedFolder("calendar", mailbox)}, start, end)
 }
 
-type GetItemResponseMessage struct {
-	ResponseClass string         `xml:"ResponseClass,attr"`
-	ResponseCode  string         `xml:"ResponseCode"`
-	Items         *ResponseItems `xml:"Items"`
+// GetFolderCalendarEvents gets the events of a secondary calendar folder, see FindCalendarFolders
+func (c *ExchangeClient) GetFolderCalendarEvents(folderID string, start, end time.Time) ([]CalendarEvent, error) {
+	return c.getFolderEvents(&ParentFolderIds{FolderId: &FolderId{Id: folderID}}, start, end)
 }
 
-type ResponseItems struct {
-	CalendarItem []CalendarItem `xml:"CalendarItem"`
+// getFolderEvents gets the events of a calendar folder with their series masters
+func (c *ExchangeClient) getFolderEvents(folder *ParentFolderIds, start, end time.Time) ([]CalendarEvent, error) {
+	events, err := c.findCalendarEvents(folder, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	// The series master is informational; the events are usable without it
+	c.resolveSeriesMasters(events)
+
+	return events, nil
 }
 
-// ExchangeClient handles communication with Exchange Web Services
-type ExchangeClient struct {
-	serverURL   string
-	credentials *ExchangeCredentials
-	httpClient  *http.Client
+// distinguishedFolder returns the well-known folder of the mailbox, or of the user's own mailbox if it is empty
+func distinguishedFolder(id, mailbox string) *DistinguishedFolderId {
+	folder := &DistinguishedFolderId{Id: id}
+	if mailbox != "" {
+		folder.Mailbox = &RequestMailbox{EmailAddress: mailbox}
+	}
+	return folder
 }
 
-// NewExchangeClient creates a new Exchange client
-func NewExchangeClient(serverURL string, credentials *ExchangeCredentials) *ExchangeClient {
-	// Create HTTP client with TLS config for Russian servers like 1cbit.ru
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true,             // For self-signed certificates
-			MinVersion:         tls.VersionTLS10, // Support older TLS versions
-			MaxVersion:         tls.VersionTLS13,
-		},
-		DisableKeepAlives:     true,             // Disable keep-alives to avoid 440 timeouts
-		IdleConnTimeout:       30 * time.Second, // Shorter idle timeout
-		MaxIdleConns:          1,                // Minimal connection pooling
-		MaxIdleConnsPerHost:   1,                // One connection per host
-		ResponseHeaderTimeout: 30 * time.Second, // Response header timeout
+// findCalendarEvents returns the events in the range, splitting truncated views
+func (c *ExchangeClient) findCalendarEvents(folder *ParentFolderIds, start, end time.Time) ([]CalendarEvent, error) {
+	events, complete, err := c.findCalendarView(folder, start, end)
+	if err != nil {
+		return nil, err
+	}
+	if complete {
+		return events, nil
 	}
 
-	return &ExchangeClient{
-		serverURL:   serverURL,
-		credentials: credentials,
-		httpClient: &http.Client{
-			Transport: tr,
-			Timeout:   30 * time.Second, // Shorter timeout to avoid 440 errors
-		},
+	if end.Sub(start) <= minCalendarViewWindow {
+		return nil, fmt.Errorf("calendar view %s - %s is truncated even after splitting", start.UTC().Format(time.RFC3339), end.UTC().Format(time.RFC3339))
 	}
-}
 
-// GetCalendarEvents retrieves calendar events for the user
-func (p *Plugin) getCalendarEvents(credentials *ExchangeCredentials) ([]CalendarEvent, error) {
-	config := p.getConfiguration()
-	client := NewExchangeClient(config.ExchangeServerURL, credentials)
+	middle := start.Add(end.Sub(start) / 2)
+	first, err := c.findCalendarEvents(folder, start, middle)
+	if err != nil {
+		return nil, err
+	}
+	second, err := c.findCalendarEvents(folder, middle, end)
+	if err != nil {
+		return nil, err
+	}
 
-	now := time.Now()
-	start := now.Add(-24 * time.Hour)  // Last 24 hours
-	end := now.Add(7 * 24 * time.Hour) // Next 7 days
+	seen := make(map[string]bool, len(first))
+	events = make([]CalendarEvent, 0, len(first)+len(second))
+	for _, event := range append(first, second...) {
+		if seen[event.ID] {
+			continue
+		}
+		seen[event.ID] = true
+		events = append(events, event)
+	}
 
-	return client.GetCalendarEventsInRange(start, end)
+	return events, nil
 }
 
-// GetCalendarEventsInRange retrieves calendar events within a specific time range
-func (p *Plugin) getCalendarEventsInRange(credentials *ExchangeCredentials, start, end time.Time) ([]CalendarEvent, error) {
-	config := p.getConfiguration()
-	client := NewExchangeClient(config.ExchangeServerURL, credentials)
-
-	return client.GetCalendarEventsInRange(start, end)
+// calendarEventProperties requests the recurrence and organizer fields the Default shape does not include
+func calendarEventProperties() *AdditionalProperties {
+	return &AdditionalProperties{
+		FieldURI: []FieldURI{
+			{FieldURI: "calendar:IsRecurring"},
+			{FieldURI: "calendar:CalendarItemType"},
+			{FieldURI: "calendar:RecurrenceId"},
+			{FieldURI: "calendar:UID"},
+			{FieldURI: "calendar:MyResponseType"},
+		},
+	}
 }
 
-// GetNewMeetingInvitations retrieves new meeting invitations
-func (p *Plugin) getNewMeetingInvitations(credentials *ExchangeCredentials) ([]CalendarEvent, error) {
-	// TODO: Implement invitation tracking using Exchange Web Services
-	// For now, return empty slice
-	_ = credentials // credentials will be used when implementing EWS invitation tracking
-	return []CalendarEvent{}, nil
+// resolveSeriesMasters fills SeriesMasterID for occurrences and exceptions
+// with a single GetItem request per call
+func (c *ExchangeClient) resolveSeriesMasters(events []CalendarEvent) {
+	var occurrenceIDs []RecurringMasterItemId
+	var seriesUIDs []string
+	requested := make(map[string]bool)
+	for _, event := range events {
+		if event.CalendarItemType != CalendarItemTypeOccurrence && event.CalendarItemType != CalendarItemTypeException {
+			continue
+		}
+		if event.UID == "" || requested[event.UID] {
+			continue
+		}
+		requested[event.UID] = true
+		occurrenceIDs = append(occurrenceIDs, RecurringMasterItemId{OccurrenceId: event.ID})
+		seriesUIDs = append(seriesUIDs, event.UID)
+	}
+
+	if len(occurrenceIDs) == 0 {
+		return
+	}
+
+	soapResp, err := c.sendSOAPRequest("GetItem", SOAPBody{
+		GetItem: &GetItem{
+			ItemShape: &ItemShape{BaseShape: "IdOnly"},
+			ItemIds:   &ItemIds{RecurringMasterItemId: occurrenceIDs},
+		},
+	})
+	if err != nil || soapResp.Body.GetItemResponse == nil || soapResp.Body.GetItemResponse.ResponseMessages == nil {
+		return
+	}
+
+	// Response messages are returned in the order of the requested IDs
+	masters := make(map[string]string, len(seriesUIDs))
+	for i, message := range soapResp.Body.GetItemResponse.ResponseMessages.GetItemResponseMessage {
+		if i >= len(seriesUIDs) || message.Err() != nil || message.Items == nil || len(message.Items.CalendarItem) == 0 {
+			continue
+		}
+		masters[seriesUIDs[i]] = message.Items.CalendarItem[0].ItemId.Id
+	}
+
+	for i := range events {
+		if masterID, ok := masters[events[i].UID]; ok {
+			events[i].SeriesMasterID = masterID
+		}
+	}
 }
 
-// GetCalendarEventsInRange gets events in a date range with retry logic for 440 errors
-func (c *ExchangeClient) GetCalendarEventsInRange(start, end time.Time) ([]CalendarEvent, error) {
-	// Construct SOAP request - use more compatible format for Russian servers
-	envelope := &SOAPEnvelope{
-		Soap:     "http://schemas.xmlsoap.org/soap/envelope/",
-		Types:    "http://schemas.microsoft.com/exchange/services/2006/types",
-		Messages: "http://schemas.microsoft.com/exchange/services/2006/messages",
-		Header: &SOAPHeader{
-			RequestServerVersion: &RequestServerVersion{
-				Version: "Exchange2010_SP2", // More compatible version for older Russian servers
+// findCalendarView runs one CalendarView request.
+// It reports whether the server returned every item in the range.
+func (c *ExchangeClient) findCalendarView(folder *ParentFolderIds, start, end time.Time) ([]CalendarEvent, bool, error) {
+	soapResp, err := c.sendSOAPRequest("FindItem", SOAPBody{
+		FindItem: &FindItem{
+			Traversal: "Shallow",
+			ItemShape: &ItemShape{
+				BaseShape:            "Default", // Need full info for calendar events
+				AdditionalProperties: calendarEventProperties(),
 			},
-		},
-		Body: SOAPBody{
-			FindItem: &FindItem{
-				Traversal: "Shallow",
-				ItemShape: &ItemShape{
-					BaseShape: "Default", // Need full info for calendar events
-				},
-				CalendarView: &CalendarView{
-					MaxEntriesReturned: "100", // Reduced to avoid timeout
-					StartDate:          start.UTC().Format("2006-01-02T15:04:05Z"),
-					EndDate:            end.UTC().Format("2006-01-02T15:04:05Z"),
-				},
-				ParentFolderIds: &ParentFolderIds{
-					DistinguishedFolderId: &DistinguishedFolderId{
-						Id: "calendar",
-					},
-				},
+			CalendarView: &CalendarView{
+				MaxEntriesReturned: "100", // Reduced to avoid timeout; larger views are split
+				StartDate:          start.UTC().Format("2006-01-02T15:04:05Z"),
+				EndDate:            end.UTC().Format("2006-01-02T15:04:05Z"),
 			},
+			ParentFolderIds: folder,
 		},
+	})
+	if err != nil {
+		return nil, false, err
 	}
 
-	// Marshal to XML
-	xmlData, err := xml.Marshal(envelope)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal SOAP request: %w", err)
+	// Extract calendar events
+	var events []CalendarEvent
+	complete := true
+	if soapResp.Body.FindItemResponse != nil &&
+		soapResp.Body.FindItemResponse.ResponseMessages != nil &&
+		soapResp.Body.FindItemResponse.ResponseMessages.FindItemResponseMessage != nil {
+
+		responseMessage := soapResp.Body.FindItemResponse.ResponseMessages.FindItemResponseMessage
+
+		if err := responseMessage.Err(); err != nil {
+			c.throttled(err)
+			return nil, false, err
+		}
+
+		if responseMessage.RootFolder != nil && responseMessage.RootFolder.Items != nil {
+			items := responseMessage.RootFolder.Items.CalendarItem
+			for _, item := range items {
+				event, err := c.convertToCalendarEvent(item)
+				if err != nil {
+					continue // Skip problematic events
+				}
+				events = append(events, event)
+			}
+
+			// MaxEntriesReturned cut the view short
+			total, err := strconv.Atoi(responseMessage.RootFolder.TotalItemsInView)
+			complete = responseMessage.RootFolder.IncludesLastItemInRange != "false" && (err != nil || total <= len(items))
+		}
 	}
 
-	// Add XML declaration
-	soapRequest := `<?xml version="1.0" encoding="utf-8"?>` + string(xmlData)
+	return events, complete, nil
+}
 
-	// Create HTTP request - try to find working EWS endpoint
+// sendSOAPRequest posts an EWS operation with retry logic for HTTP 440 and 400 errors
+// and returns the parsed response. Failures are returned as typed errors (see EWSError).
+// Operations that change data are retried only when the request never reached the server;
+// if it may have been processed, the error wraps ErrOutcomeUnknown.
+func (c *ExchangeClient) sendSOAPRequest(action string, body SOAPBody) (*SOAPResponse, error) {
+	// Use the known EWS endpoint or try to find a working one
 	ewsURL := c.findWorkingEWSEndpoint()
 	if ewsURL == "" {
 		ewsURL = c.serverURL + "/EWS/Exchange.asmx" // fallback
 	}
-	req, err := http.NewRequest("POST", ewsURL, strings.NewReader(soapRequest))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
-	}
-
-	// Set headers
-	req.Header.Set("Content-Type", "text/xml; charset=utf-8")
-	req.Header.Set("SOAPAction", `"http://schemas.microsoft.com/exchange/services/2006/messages/FindItem"`)
-
-	// Set NTLM auth
-	var username string
-	if c.credentials.Domain != "" {
-		username = c.credentials.Domain + "\\" + c.credentials.Username
-	} else {
-		username = c.credentials.Username
-	}
-	req.SetBasicAuth(username, c.credentials.Password)
 
 	// Send request with retry logic for HTTP 440
 	var resp *http.Response
-	var body []byte
+	var respBody []byte
 	var lastErr error
+	version := c.requestServerVersion()
+	username := c.authUsername()
+	unreachable := false
+	retryable := body.idempotent()
+	attempts := 0
 
 	for attempt := 0; attempt < 3; attempt++ {
+		attempts = attempt + 1
 		if attempt > 0 {
 			// Wait before retry
 			time.Sleep(time.Duration(attempt*2) * time.Second)
+			username = c.retryUsername()
+		}
 
-			// For HTTP 400 retry, try different SOAP format
-			retrySOAP := soapRequest
-			if attempt == 1 {
-				// Try with Exchange2007_SP1 for compatibility with older Russian servers
-				retryEnvelope := &SOAPEnvelope{
-					Soap:     "http://schemas.xmlsoap.org/soap/envelope/",
-					Types:    "http://schemas.microsoft.com/exchange/services/2006/types",
-					Messages: "http://schemas.microsoft.com/exchange/services/2006/messages",
-					Header: &SOAPHeader{
-						RequestServerVersion: &RequestServerVersion{
-							Version: "Exchange2007_SP1", // Even older version for maximum compatibility
-						},
-					},
-					Body: SOAPBody{
-						FindItem: &FindItem{
-							Traversal: "Shallow",
-							ItemShape: &ItemShape{
-								BaseShape: "Default",
-							},
-							CalendarView: &CalendarView{
-								MaxEntriesReturned: "50", // Further reduced
-								StartDate:          start.UTC().Format("2006-01-02T15:04:05Z"),
-								EndDate:            end.UTC().Format("2006-01-02T15:04:05Z"),
-							},
-							ParentFolderIds: &ParentFolderIds{
-								DistinguishedFolderId: &DistinguishedFolderId{
-									Id: "calendar",
-								},
-							},
-						},
-					},
-				}
-
-				retryXmlData, xmlErr := xml.Marshal(retryEnvelope)
-				if xmlErr == nil {
-					retrySOAP = `<?xml version="1.0" encoding="utf-8"?>` + string(retryXmlData)
-				}
-			}
+		soapRequest, err := c.marshalSOAPRequest(version, body)
+		if err != nil {
+			return nil, err
+		}
 
-			// Create new request for retry - use the same ewsURL
-			var retryReq *http.Request
-			retryReq, lastErr = http.NewRequest("POST", ewsURL, strings.NewReader(retrySOAP))
-			if lastErr != nil {
-				continue
-			}
-			retryReq.Header.Set("Content-Type", "text/xml; charset=utf-8")
-			retryReq.Header.Set("SOAPAction", "\"http://schemas.microsoft.com/exchange/services/2006/messages/FindItem\"")
-
-			// Set auth based on domain
-			username := c.credentials.Username
-			if c.credentials.Domain != "" {
-				if c.credentials.Domain == "pbr" {
-					// Try pbr domain format for 1cbit.ru server
-					username = c.credentials.Username + "@1cbit.ru"
-				} else {
-					username = c.credentials.Domain + "\\" + c.credentials.Username
-				}
-			}
-			retryReq.SetBasicAuth(username, c.credentials.Password)
-			req = retryReq
+		req, err := http.NewRequest("POST", ewsURL, strings.NewReader(soapRequest))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create HTTP request: %w", err)
 		}
 
+		// Set headers
+		req.Header.Set("Content-Type", "text/xml; charset=utf-8")
+		req.Header.Set("SOAPAction", fmt.Sprintf(`"http://schemas.microsoft.com/exchange/services/2006/messages/%s"`, action))
+
+		// Set credentials; ntlmTransport turns them into an NTLM handshake when enabled
+		req.SetBasicAuth(username, c.credentials.Password)
+
 		resp, lastErr = c.httpClient.Do(req)
+		if errors.Is(lastErr, ErrLimiterBusy) {
+			// The request was never sent; retrying would only wait longer
+			return nil, lastErr
+		}
 		if lastErr != nil {
+			unreachable = true
+			if !retryable && !requestNotSent(lastErr) {
+				// The server may have created or changed the item before the connection failed
+				lastErr = fmt.Errorf("%w: %w", ErrOutcomeUnknown, lastErr)
+				break
+			}
 			continue
 		}
+		unreachable = false
 
 		// Read response
-		body, lastErr = io.ReadAll(resp.Body)
+		respBody, lastErr = io.ReadAll(resp.Body)
 		resp.Body.Close()
 		if lastErr != nil {
+			if !retryable {
+				lastErr = fmt.Errorf("%w: %w", ErrOutcomeUnknown, lastErr)
+				break
+			}
 			continue
 		}
 
+		// Exchange is overloaded: pause all requests and retry after the back-off
+		if resp.StatusCode != http.StatusOK {
+			if err := httpStatusError(resp, respBody); errors.Is(err, ErrThrottled) {
+				c.throttled(err)
+				lastErr = err
+				if !retryable {
+					break
+				}
+				continue
+			}
+		}
+
 		// Check for HTTP 440 Login Timeout
 		if resp.StatusCode == 440 {
-			lastErr = fmt.Errorf("HTTP 440 Login Timeout (попытка %d)", attempt+1)
+			lastErr = httpStatusError(resp, respBody)
+			if !retryable {
+				break
+			}
 			continue // Retry
 		}
 
-		// Check for HTTP 400 Bad Request - often due to SOAP format issues
-		if resp.StatusCode == 400 && attempt == 0 {
-			// Try with different Exchange version on first 400 error
-			lastErr = fmt.Errorf("HTTP 400 Bad Request (попытка %d) - возможно неправильный формат SOAP", attempt+1)
+		// Check for HTTP 400 Bad Request - often due to SOAP format issues.
+		// Changes are not resent; the server version negotiated at discovery avoids this for them.
+		if resp.StatusCode == 400 && attempt == 0 && retryable {
+			// Try with Exchange2007_SP1 for compatibility with older Russian servers
+			lastErr = httpStatusError(resp, respBody)
+			version = fallbackServerVersion
 			continue // Retry with different settings
 		}
 
@@ -378,48 +1419,116 @@ func (c *ExchangeClient) GetCalendarEventsInRange(start, end time.Time) ([]Calen
 	}
 
 	if lastErr != nil {
-		return nil, fmt.Errorf("failed after %d attempts: %w", 3, lastErr)
+		if unreachable {
+			return nil, fmt.Errorf("failed after %d attempts: %w (%w)", attempts, lastErr, ErrEndpointUnreachable)
+		}
+		return nil, fmt.Errorf("failed after %d attempts: %w", attempts, lastErr)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: HTTP 404 %s", ErrEndpointUnreachable, ewsURL)
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("HTTP error %d: %s", resp.StatusCode, string(body))
+		return nil, httpStatusError(resp, respBody)
 	}
 
 	// Parse SOAP response
 	var soapResp SOAPResponse
-	if err := xml.Unmarshal(body, &soapResp); err != nil {
+	if err := xml.Unmarshal(respBody, &soapResp); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal SOAP response: %w", err)
 	}
 
 	// Check for SOAP fault
 	if soapResp.Body.Fault != nil {
-		return nil, fmt.Errorf("SOAP fault: %s - %s", soapResp.Body.Fault.Code, soapResp.Body.Fault.String)
+		return nil, soapFaultError(soapResp.Body.Fault)
 	}
 
-	// Extract calendar events
-	var events []CalendarEvent
-	if soapResp.Body.FindItemResponse != nil &&
-		soapResp.Body.FindItemResponse.ResponseMessages != nil &&
-		soapResp.Body.FindItemResponse.ResponseMessages.FindItemResponseMessage != nil {
+	// The server accepted this RequestServerVersion; reuse it next time
+	c.serverVersion = version
 
-		responseMessage := soapResp.Body.FindItemResponse.ResponseMessages.FindItemResponseMessage
+	return &soapResp, nil
+}
 
-		if responseMessage.ResponseClass != "Success" {
-			return nil, fmt.Errorf("EWS error: %s", responseMessage.ResponseCode)
-		}
+// waitForLimiter waits until the shared limiter would allow a request without holding a slot
+func (c *ExchangeClient) waitForLimiter(ctx context.Context) error {
+	if c.limiter == nil {
+		return nil
+	}
 
-		if responseMessage.RootFolder != nil && responseMessage.RootFolder.Items != nil {
-			for _, item := range responseMessage.RootFolder.Items.CalendarItem {
-				event, err := c.convertToCalendarEvent(item)
-				if err != nil {
-					continue // Skip problematic events
-				}
-				events = append(events, event)
-			}
+	ctx, cancel := context.WithTimeout(ctx, limiterWaitTimeout)
+	defer cancel()
+
+	release, err := c.limiter.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	release()
+	return nil
+}
+
+// throttled makes all clients back off when Exchange reports throttling
+func (c *ExchangeClient) throttled(err error) {
+	var ewsErr *EWSError
+	if c.limiter == nil || !errors.Is(err, ErrThrottled) || !errors.As(err, &ewsErr) {
+		return
+	}
+	c.limiter.BackOff(ewsErr.BackOff)
+}
+
+// idempotent reports whether the operation can be sent again after a failure without
+// creating, sending or changing anything twice
+func (b SOAPBody) idempotent() bool {
+	return b.CreateItem == nil && b.UpdateItem == nil && b.Subscribe == nil
+}
+
+// requestNotSent reports whether a transport error happened before the request could
+// reach the server, such as a failed DNS lookup or a refused connection
+func requestNotSent(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// availabilityService reports whether the body is a free/busy or out-of-office operation
+func (b SOAPBody) availabilityService() bool {
+	return b.GetUserAvailabilityRequest != nil || b.GetUserOofSettingsRequest != nil || b.SetUserOofSettingsRequest != nil
+}
+
+// marshalSOAPRequest wraps the body in a SOAP envelope with the given server version
+func (c *ExchangeClient) marshalSOAPRequest(version string, body SOAPBody) (string, error) {
+	envelope := &SOAPEnvelope{
+		Soap:     "http://schemas.xmlsoap.org/soap/envelope/",
+		Types:    "http://schemas.microsoft.com/exchange/services/2006/types",
+		Messages: "http://schemas.microsoft.com/exchange/services/2006/messages",
+		Header: &SOAPHeader{
+			RequestServerVersion: &RequestServerVersion{
+				Version: version,
+			},
+		},
+		Body: body,
+	}
+
+	// TimeZoneContext requires Exchange 2010 or later. The availability service operations
+	// take their time zone from the request body or use UTC instead.
+	if id := windowsTimeZoneID(c.timeLocation()); id != "" && version != fallbackServerVersion && !body.availabilityService() {
+		envelope.Header.TimeZoneContext = &TimeZoneContext{
+			TimeZoneDefinition: &TimeZoneDefinition{Id: id},
 		}
 	}
 
-	return events, nil
+	// Marshal to XML
+	xmlData, err := xml.Marshal(envelope)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal SOAP request: %w", err)
+	}
+
+	// Add XML declaration
+	return `<?xml version="1.0" encoding="utf-8"?>` + string(xmlData), nil
 }
 
 // TestConnection tests the connection to Exchange without fetching events
@@ -445,7 +1554,11 @@ func (c *ExchangeClient) TestConnection() error {
 		userFormats = append(userFormats, c.credentials.Username)
 	}
 
-	// Try different EWS endpoints - some servers use different paths
+	// Try the Autodiscover result first, then different EWS paths - some servers use different paths
+	var ewsURLs []string
+	if c.ewsURL != "" {
+		ewsURLs = append(ewsURLs, c.ewsURL)
+	}
 	ewsPaths := []string{
 		"/owa/EWS/Exchange.asmx", // Приоритет для российских серверов типа 1cbit.ru
 		"/EWS/Exchange.asmx",
@@ -460,10 +1573,12 @@ func (c *ExchangeClient) TestConnection() error {
 	var lastError error
 	attemptCount := 0
 
-	// First, try to discover the correct EWS endpoint
 	for _, ewsPath := range ewsPaths {
-		ewsURL := c.serverURL + ewsPath
+		ewsURLs = append(ewsURLs, c.serverURL+ewsPath)
+	}
 
+	// First, try to discover the correct EWS endpoint
+	for _, ewsURL := range ewsURLs {
 		// Test with the first username format only for endpoint discovery
 		username := userFormats[0]
 
@@ -477,6 +1592,11 @@ func (c *ExchangeClient) TestConnection() error {
 		// Send request
 		resp, err := c.httpClient.Do(req)
 		if err != nil {
+			// Certificate problems are reported; other errors mean the path is unavailable
+			if certMsg, ok := describeCertificateError(err); ok {
+				attemptResults = append(attemptResults, fmt.Sprintf("%s: 🔒 %s", ewsURL, certMsg))
+				lastError = err
+			}
 			continue
 		}
 		resp.Body.Close()
@@ -488,7 +1608,7 @@ func (c *ExchangeClient) TestConnection() error {
 				attemptCount++
 				req, err := http.NewRequest("GET", ewsURL, nil)
 				if err != nil {
-					attemptResults = append(attemptResults, fmt.Sprintf("Попытка %d (%s → %s): Ошибка создания запроса - %v", attemptCount, userFormat, ewsPath, err))
+					attemptResults = append(attemptResults, fmt.Sprintf("Попытка %d (%s → %s): Ошибка создания запроса - %v", attemptCount, userFormat, ewsURL, err))
 					lastError = err
 					continue
 				}
@@ -498,26 +1618,35 @@ func (c *ExchangeClient) TestConnection() error {
 				// Send request
 				resp, err := c.httpClient.Do(req)
 				if err != nil {
-					attemptResults = append(attemptResults, fmt.Sprintf("Попытка %d (%s → %s): Ошибка отправки запроса - %v", attemptCount, userFormat, ewsPath, err))
+					if certMsg, ok := describeCertificateError(err); ok {
+						attemptResults = append(attemptResults, fmt.Sprintf("Попытка %d (%s → %s): 🔒 %s", attemptCount, userFormat, ewsURL, certMsg))
+						lastError = err
+						continue
+					}
+					attemptResults = append(attemptResults, fmt.Sprintf("Попытка %d (%s → %s): Ошибка отправки запроса - %v", attemptCount, userFormat, ewsURL, err))
 					lastError = err
 					continue
 				}
-				defer resp.Body.Close()
+				// Close right away: the body holds a limiter slot until then
+				body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+				resp.Body.Close()
 
 				// Check status code
 				if resp.StatusCode == 200 || resp.StatusCode == 405 { // 405 Method Not Allowed is OK for EWS
+					c.ewsURL = ewsURL
+					c.username = userFormat
 					return nil // Success!
 				}
 
 				if resp.StatusCode == 401 {
-					attemptResults = append(attemptResults, fmt.Sprintf("Попытка %d (%s → %s): HTTP 401 - Неверные учетные данные", attemptCount, userFormat, ewsPath))
-					lastError = fmt.Errorf("HTTP 401: Неверные учетные данные")
+					attemptResults = append(attemptResults, fmt.Sprintf("Попытка %d (%s → %s): HTTP 401 - Неверные учетные данные", attemptCount, userFormat, ewsURL))
+					lastError = &EWSError{Kind: ErrUnauthorized, StatusCode: resp.StatusCode, MessageText: "Неверные учетные данные"}
 					continue // Try next format
 				}
 
 				if resp.StatusCode == 440 {
 					// HTTP 440 Login Timeout - retry with fresh connection
-					attemptResults = append(attemptResults, fmt.Sprintf("Попытка %d (%s → %s): HTTP 440 - Login Timeout, повтор...", attemptCount, userFormat, ewsPath))
+					attemptResults = append(attemptResults, fmt.Sprintf("Попытка %d (%s → %s): HTTP 440 - Login Timeout, повтор...", attemptCount, userFormat, ewsURL))
 
 					// Wait a moment and retry with fresh connection
 					time.Sleep(2 * time.Second)
@@ -529,27 +1658,28 @@ func (c *ExchangeClient) TestConnection() error {
 						if retryErr == nil {
 							retryResp.Body.Close()
 							if retryResp.StatusCode == 200 || retryResp.StatusCode == 405 {
+								c.ewsURL = ewsURL
+								c.username = userFormat
 								return nil // Success on retry!
 							}
 						}
 					}
 
-					lastError = fmt.Errorf("HTTP 440: Login Timeout (повтор не помог)")
+					lastError = &EWSError{Kind: ErrLoginTimeout, StatusCode: resp.StatusCode, MessageText: "повтор не помог"}
 					continue
 				}
 
 				if resp.StatusCode >= 400 {
-					body, _ := io.ReadAll(resp.Body)
 					bodyStr := strings.TrimSpace(string(body))
 					if len(bodyStr) > 100 {
 						bodyStr = bodyStr[:100] + "..."
 					}
-					attemptResults = append(attemptResults, fmt.Sprintf("Попытка %d (%s → %s): HTTP %d - %s", attemptCount, userFormat, ewsPath, resp.StatusCode, bodyStr))
-					lastError = fmt.Errorf("HTTP %d", resp.StatusCode)
+					attemptResults = append(attemptResults, fmt.Sprintf("Попытка %d (%s → %s): HTTP %d - %s", attemptCount, userFormat, ewsURL, resp.StatusCode, bodyStr))
+					lastError = httpStatusError(resp, body)
 					continue
 				}
 
-				attemptResults = append(attemptResults, fmt.Sprintf("Попытка %d (%s → %s): HTTP %d - Неожиданный ответ", attemptCount, userFormat, ewsPath, resp.StatusCode))
+				attemptResults = append(attemptResults, fmt.Sprintf("Попытка %d (%s → %s): HTTP %d - Неожиданный ответ", attemptCount, userFormat, ewsURL, resp.StatusCode))
 			}
 			break // We found a working endpoint, no need to try others
 		}
@@ -558,8 +1688,8 @@ func (c *ExchangeClient) TestConnection() error {
 	// If no working endpoint found
 	if attemptCount == 0 {
 		attemptResults = append(attemptResults, "Не найден ни один рабочий EWS endpoint")
-		for _, path := range ewsPaths {
-			attemptResults = append(attemptResults, fmt.Sprintf("  • Проверен путь: %s%s", c.serverURL, path))
+		for _, ewsURL := range ewsURLs {
+			attemptResults = append(attemptResults, fmt.Sprintf("  • Проверен путь: %s", ewsURL))
 		}
 	}
 
@@ -579,14 +1709,26 @@ func (c *ExchangeClient) TestConnection() error {
 	errorMsg += "• Обратитесь к системному администратору за точным URL\n"
 
 	if lastError != nil {
-		errorMsg += fmt.Sprintf("\nПоследняя ошибка: %v", lastError)
+		// Wrap the last error so callers can match its kind
+		return fmt.Errorf("%s\nПоследняя ошибка: %w", errorMsg, lastError)
 	}
 
-	return fmt.Errorf(errorMsg)
+	return errors.New(errorMsg)
+}
+
+// NegotiateServerVersion sends a cheap GetFolder request so the client learns the
+// RequestServerVersion the server accepts
+func (c *ExchangeClient) NegotiateServerVersion() error {
+	_, err := c.defaultCalendarFolderID()
+	return err
 }
 
-// findWorkingEWSEndpoint discovers the correct EWS endpoint for the server
+// findWorkingEWSEndpoint returns the known EWS endpoint or probes common paths as a fallback
 func (c *ExchangeClient) findWorkingEWSEndpoint() string {
+	if c.ewsURL != "" {
+		return c.ewsURL
+	}
+
 	ewsPaths := []string{
 		"/owa/EWS/Exchange.asmx", // Приоритет для российских серверов типа 1cbit.ru
 		"/EWS/Exchange.asmx",
@@ -595,10 +1737,7 @@ func (c *ExchangeClient) findWorkingEWSEndpoint() string {
 		"/exchange/ews/exchange.asmx",
 	}
 
-	username := c.credentials.Username
-	if c.credentials.Domain != "" {
-		username = c.credentials.Domain + "\\" + c.credentials.Username
-	}
+	username := c.authUsername()
 
 	for _, path := range ewsPaths {
 		ewsURL := c.serverURL + path
@@ -625,21 +1764,24 @@ func (c *ExchangeClient) findWorkingEWSEndpoint() string {
 
 // convertToCalendarEvent converts EWS CalendarItem to our CalendarEvent structure
 func (c *ExchangeClient) convertToCalendarEvent(item CalendarItem) (CalendarEvent, error) {
-	startTime, err := time.Parse("2006-01-02T15:04:05Z", item.Start)
+	loc := c.timeLocation()
+
+	startTime, err := parseEWSDateTime(item.Start, loc)
 	if err != nil {
 		return CalendarEvent{}, fmt.Errorf("failed to parse start time: %w", err)
 	}
 
-	endTime, err := time.Parse("2006-01-02T15:04:05Z", item.End)
+	endTime, err := parseEWSDateTime(item.End, loc)
 	if err != nil {
 		return CalendarEvent{}, fmt.Errorf("failed to parse end time: %w", err)
 	}
 
-	var organizer string
+	var organizer, organizerEmail string
 	if item.Organizer != nil && item.Organizer.Mailbox != nil {
 		organizer = item.Organizer.Mailbox.Name
+		organizerEmail = item.Organizer.Mailbox.EmailAddress
 		if organizer == "" {
-			organizer = item.Organizer.Mailbox.EmailAddress
+			organizer = organizerEmail
 		}
 	}
 
@@ -658,15 +1800,34 @@ func (c *ExchangeClient) convertToCalendarEvent(item CalendarItem) (CalendarEven
 		status = "Busy" // Default to busy
 	}
 
-	return CalendarEvent{
-		ID:        item.ItemId.Id,
-		Subject:   item.Subject,
-		Start:     startTime,
-		End:       endTime,
-		Location:  item.Location,
-		Organizer: organizer,
-		IsAllDay:  item.IsAllDayEvent == "true",
-		IsMeeting: item.IsMeeting == "true",
-		Status:    status,
-	}, nil
+	event := CalendarEvent{
+		ID:               item.ItemId.Id,
+		Subject:          item.Subject,
+		Start:            startTime.In(loc),
+		End:              endTime.In(loc),
+		Location:         item.Location,
+		Organizer:        organizer,
+		OrganizerEmail:   organizerEmail,
+		IsAllDay:         item.IsAllDayEvent == "true",
+		IsMeeting:        item.IsMeeting == "true",
+		Status:           status,
+		IsOrganizer:      item.MyResponseType == "Organizer",
+		CalendarItemType: item.CalendarItemType,
+		UID:              item.UID,
+	}
+
+	// Occurrences and exceptions belong to a series even if IsRecurring was not returned
+	event.IsRecurring = item.IsRecurring == "true" ||
+		item.CalendarItemType == CalendarItemTypeOccurrence ||
+		item.CalendarItemType == CalendarItemTypeException
+
+	if item.RecurrenceId != "" {
+		// RecurrenceId is the original start of the occurrence; it does not change when the occurrence is moved
+		recurrenceID, err := parseEWSDateTime(item.RecurrenceId, loc)
+		if err == nil {
+			event.RecurrenceID = recurrenceID.In(loc)
+		}
+	}
+
+	return event, nil
 }
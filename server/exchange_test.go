@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestEWSClient returns a client whose EWS endpoint is the handler
+func newTestEWSClient(t *testing.T, handler http.HandlerFunc) (*ExchangeClient, *int32) {
+	t.Helper()
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		body, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		handler(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	client := NewExchangeClient(server.URL, &ExchangeCredentials{Username: "jdoe@contoso.com", Password: "Secret1!"}, ExchangeClientOptions{})
+	client.SetEWSURL(server.URL + "/EWS/Exchange.asmx")
+	return client, &requests
+}
+
+// closeConnection drops the connection without a response, as if it failed after the request was sent
+func closeConnection(w http.ResponseWriter, r *http.Request) {
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err == nil {
+		conn.Close()
+	}
+}
+
+func TestSendSOAPRequestDoesNotResendChanges(t *testing.T) {
+	createItem := SOAPBody{CreateItem: &CreateItem{SendMeetingInvitations: "SendToAllAndSaveCopy"}}
+
+	client, requests := newTestEWSClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(440)
+	})
+	if _, err := client.sendSOAPRequest("CreateItem", createItem); !errors.Is(err, ErrLoginTimeout) {
+		t.Errorf("expected ErrLoginTimeout, got %v", err)
+	}
+	if n := atomic.LoadInt32(requests); n != 1 {
+		t.Errorf("CreateItem sent %d times after HTTP 440, want 1", n)
+	}
+
+	client, requests = newTestEWSClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	})
+	if _, err := client.sendSOAPRequest("UpdateItem", SOAPBody{UpdateItem: &UpdateItem{}}); err == nil {
+		t.Error("expected an error for HTTP 400")
+	}
+	if n := atomic.LoadInt32(requests); n != 1 {
+		t.Errorf("UpdateItem sent %d times after HTTP 400, want 1", n)
+	}
+
+	client, requests = newTestEWSClient(t, closeConnection)
+	if _, err := client.sendSOAPRequest("CreateItem", createItem); !errors.Is(err, ErrOutcomeUnknown) {
+		t.Errorf("expected ErrOutcomeUnknown after a dropped connection, got %v", err)
+	}
+	if n := atomic.LoadInt32(requests); n != 1 {
+		t.Errorf("CreateItem sent %d times after a dropped connection, want 1", n)
+	}
+}
+
+func TestRequestNotSent(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	_, err = http.Get("http://" + address)
+	if err == nil {
+		t.Fatal("expected a connection error")
+	}
+	if !requestNotSent(err) {
+		t.Errorf("a refused connection should count as not sent: %v", err)
+	}
+
+	if requestNotSent(io.ErrUnexpectedEOF) {
+		t.Error("an error after sending should not count as not sent")
+	}
+}
+
+// calendarViewHandler answers CalendarView requests with the events overlapping the view,
+// truncated to MaxEntriesReturned like Exchange does
+func calendarViewHandler(t *testing.T, events []CalendarEvent) http.HandlerFunc {
+	viewPattern := regexp.MustCompile(`MaxEntriesReturned="(\d+)" StartDate="([^"]+)" EndDate="([^"]+)"`)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		request, _ := io.ReadAll(r.Body)
+		match := viewPattern.FindSubmatch(request)
+		if match == nil {
+			t.Errorf("not a CalendarView request: %s", request)
+			return
+		}
+		limit, _ := strconv.Atoi(string(match[1]))
+		start, _ := time.Parse(time.RFC3339, string(match[2]))
+		end, _ := time.Parse(time.RFC3339, string(match[3]))
+
+		var items strings.Builder
+		total := 0
+		for _, event := range events {
+			if !event.Start.Before(end) || !event.End.After(start) {
+				continue
+			}
+			total++
+			if total <= limit {
+				fmt.Fprintf(&items, `<t:CalendarItem><t:ItemId Id="%s"/><t:Start>%s</t:Start><t:End>%s</t:End></t:CalendarItem>`,
+					event.ID, event.Start.Format(time.RFC3339), event.End.Format(time.RFC3339))
+			}
+		}
+
+		fmt.Fprintf(w, `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>
+<m:FindItemResponse xmlns:m="http://schemas.microsoft.com/exchange/services/2006/messages" xmlns:t="http://schemas.microsoft.com/exchange/services/2006/types">
+<m:ResponseMessages><m:FindItemResponseMessage ResponseClass="Success"><m:ResponseCode>NoError</m:ResponseCode>
+<m:RootFolder TotalItemsInView="%d" IncludesLastItemInRange="%t"><t:Items>%s</t:Items></m:RootFolder>
+</m:FindItemResponseMessage></m:ResponseMessages></m:FindItemResponse></s:Body></s:Envelope>`, total, total <= limit, items.String())
+	}
+}
+
+func TestGetCalendarEventsInRangeSplitsTruncatedViews(t *testing.T) {
+	start := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(7 * 24 * time.Hour)
+
+	// spread returns count half-hour events evenly spread over the window and one spanning it
+	spread := func(count int, window time.Duration) []CalendarEvent {
+		events := []CalendarEvent{{ID: "conference", Start: start, End: end}}
+		for i := 0; i < count; i++ {
+			eventStart := start.Add(window * time.Duration(i) / time.Duration(count))
+			events = append(events, CalendarEvent{ID: fmt.Sprintf("event-%d", i), Start: eventStart, End: eventStart.Add(30 * time.Minute)})
+		}
+		return events
+	}
+
+	tests := []struct {
+		name     string
+		events   []CalendarEvent
+		requests int32 // 0 skips the check
+		fails    bool
+	}{
+		{"fits in one view", spread(50, 7*24*time.Hour), 1, false},
+		{"split into windows", spread(250, 7*24*time.Hour), 0, false},
+		{"too dense to split", spread(150, 10*time.Minute), 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, requests := newTestEWSClient(t, calendarViewHandler(t, tt.events))
+
+			events, err := client.GetCalendarEventsInRange(start, end)
+			if tt.fails {
+				if err == nil {
+					t.Error("expected an error for a view that stays truncated")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.requests != 0 && atomic.LoadInt32(requests) != tt.requests {
+				t.Errorf("sent %d CalendarView requests, want %d", atomic.LoadInt32(requests), tt.requests)
+			}
+			if len(events) != len(tt.events) {
+				t.Errorf("got %d events, want %d", len(events), len(tt.events))
+			}
+			seen := make(map[string]bool)
+			for _, event := range events {
+				if seen[event.ID] {
+					t.Errorf("event %s returned twice", event.ID)
+				}
+				seen[event.ID] = true
+			}
+		})
+	}
+}
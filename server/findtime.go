@@ -0,0 +1,362 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+// Working hours and defaults of /exchange findtime
+const (
+	workdayStartHour       = 9
+	workdayEndHour         = 18
+	findTimeDefaultMinutes = 30
+	findTimeDefaultDays    = 5
+	findTimeMaxDays        = 14
+	findTimeSlotStep       = 30 * time.Minute
+	maxSuggestedSlots      = 5
+)
+
+// bookingListTTL is how long the booking buttons of a findtime or rooms listing can be used
+const bookingListTTL = 24 * time.Hour
+
+// weekdayNames are the short Russian weekday names, indexed by time.Weekday
+var weekdayNames = [...]string{"Вс", "Пн", "Вт", "Ср", "Чт", "Пт", "Сб"}
+
+// MeetingSlot is a candidate meeting time with the attendees who are not free
+type MeetingSlot struct {
+	Start     time.Time
+	End       time.Time
+	Busy      []string // Attendees who are busy or out of office
+	Tentative []string // Attendees with a tentative meeting
+}
+
+// findMeetingSlots returns the best slots of the given duration within working days in loc.
+// Slots where everybody is free come first; the rest are ranked by the number of conflicts.
+// Suggested slots do not overlap each other and are ordered by rank, then by time.
+func findMeetingSlots(availability []MailboxAvailability, from, until time.Time, duration time.Duration, loc *time.Location) []MeetingSlot {
+	var candidates []MeetingSlot
+
+	for day := from.In(loc); day.Before(until); day = day.AddDate(0, 0, 1) {
+		if day.Weekday() == time.Saturday || day.Weekday() == time.Sunday {
+			continue
+		}
+
+		workStart := time.Date(day.Year(), day.Month(), day.Day(), workdayStartHour, 0, 0, 0, loc)
+		workEnd := time.Date(day.Year(), day.Month(), day.Day(), workdayEndHour, 0, 0, 0, loc)
+
+		for start := workStart; !start.Add(duration).After(workEnd); start = start.Add(findTimeSlotStep) {
+			if start.Before(from) || start.Add(duration).After(until) {
+				continue
+			}
+
+			slot := MeetingSlot{Start: start, End: start.Add(duration)}
+			for _, mailbox := range availability {
+				if mailbox.Err != nil {
+					continue // No data for this attendee
+				}
+				switch slotStatus(mailbox.Busy, slot.Start, slot.End) {
+				case "Busy", "OOF":
+					slot.Busy = append(slot.Busy, mailbox.User)
+				case "Tentative":
+					slot.Tentative = append(slot.Tentative, mailbox.User)
+				}
+			}
+			candidates = append(candidates, slot)
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if len(candidates[i].Busy) != len(candidates[j].Busy) {
+			return len(candidates[i].Busy) < len(candidates[j].Busy)
+		}
+		return len(candidates[i].Tentative) < len(candidates[j].Tentative)
+	})
+
+	slots := make([]MeetingSlot, 0, maxSuggestedSlots)
+	for _, candidate := range candidates {
+		overlaps := false
+		for _, slot := range slots {
+			if candidate.Start.Before(slot.End) && candidate.End.After(slot.Start) {
+				overlaps = true
+				break
+			}
+		}
+		if !overlaps {
+			slots = append(slots, candidate)
+		}
+		if len(slots) == maxSuggestedSlots {
+			break
+		}
+	}
+
+	return slots
+}
+
+// slotStatus returns the strongest busy status overlapping the range, or an empty string if free
+func slotStatus(blocks []BusyBlock, start, end time.Time) string {
+	status := ""
+	for _, block := range blocks {
+		if block.Start.Before(end) && block.End.After(start) && busyTypeRanks[block.Status] > busyTypeRanks[status] {
+			status = block.Status
+		}
+	}
+	return status
+}
+
+// parseFindTimeArguments parses "[duration] [within days] [@users...]".
+// The first number is the duration in minutes (30, 45m, 1h, 1ч), the second the number of days (3, 3d, 3д).
+func parseFindTimeArguments(args []string) (int, int, []string, error) {
+	minutes, days := 0, 0
+	var users []string
+
+	for _, arg := range args {
+		lower := strings.ToLower(arg)
+
+		switch {
+		case strings.HasPrefix(arg, "@") || strings.Contains(arg, "@"):
+			users = append(users, arg)
+		case strings.HasSuffix(lower, "d") || strings.HasSuffix(lower, "д"):
+			value, err := strconv.Atoi(strings.TrimRight(lower, "dд"))
+			if err != nil || value <= 0 {
+				return 0, 0, nil, fmt.Errorf("некорректный период: %s", arg)
+			}
+			days = value
+		case minutes == 0:
+			value, ok := parseMinutes(lower)
+			if !ok {
+				return 0, 0, nil, fmt.Errorf("некорректная длительность: %s", arg)
+			}
+			minutes = value
+		default:
+			value, err := strconv.Atoi(lower)
+			if err != nil || value <= 0 || days != 0 {
+				return 0, 0, nil, fmt.Errorf("непонятный аргумент: %s", arg)
+			}
+			days = value
+		}
+	}
+
+	if minutes == 0 {
+		minutes = findTimeDefaultMinutes
+	}
+	if days == 0 {
+		days = findTimeDefaultDays
+	}
+	if days > findTimeMaxDays {
+		return 0, 0, nil, fmt.Errorf("можно искать не более чем на %d дней вперед", findTimeMaxDays)
+	}
+	if minutes > (workdayEndHour-workdayStartHour)*60 {
+		return 0, 0, nil, fmt.Errorf("встреча не помещается в рабочий день")
+	}
+
+	return minutes, days, users, nil
+}
+
+// parseMinutes parses a duration such as 30, 30m, 30мин, 1h or 1ч into minutes
+func parseMinutes(value string) (int, bool) {
+	if minutes, err := strconv.Atoi(value); err == nil {
+		return minutes, minutes > 0
+	}
+
+	replacer := strings.NewReplacer("мин", "m", "ч", "h", "м", "m", "min", "m")
+	duration, err := time.ParseDuration(replacer.Replace(value))
+	if err != nil || duration < time.Minute {
+		return 0, false
+	}
+
+	return int(duration.Minutes()), true
+}
+
+// getChannelAttendees returns the usernames of the active human members of the channel
+func (p *Plugin) getChannelAttendees(channelID string) ([]string, error) {
+	var usernames []string
+	for page := 0; ; page++ {
+		users, appErr := p.API.GetUsersInChannel(channelID, model.ChannelSortByUsername, page, 100)
+		if appErr != nil {
+			return nil, errors.Wrap(appErr, "failed to get channel members")
+		}
+
+		for _, user := range users {
+			if user.IsBot || user.DeleteAt != 0 {
+				continue
+			}
+			usernames = append(usernames, "@"+user.Username)
+		}
+
+		if len(users) < 100 || len(usernames) > maxAvailabilityMailboxes {
+			return usernames, nil
+		}
+	}
+}
+
+// bookingAction returns the button that creates the meeting with its attendees and rooms
+func bookingAction(name string, meeting NewMeeting) *model.PostAction {
+	toContext := func(values []string) []interface{} {
+		list := make([]interface{}, 0, len(values))
+		for _, value := range values {
+			list = append(list, value)
+		}
+		return list
+	}
+
+	return &model.PostAction{
+		Id:   "book_meeting",
+		Name: name,
+		Type: "button",
+		Integration: &model.PostActionIntegration{
+			URL: "/plugins/com.mattermost.exchange-plugin/api/v1/meeting/book",
+			Context: map[string]interface{}{
+				"subject":   meeting.Subject,
+				"location":  meeting.Location,
+				"start":     meeting.Start.UTC().Format(time.RFC3339),
+				"end":       meeting.End.UTC().Format(time.RFC3339),
+				"attendees": toContext(meeting.RequiredAttendees),
+				"resources": toContext(meeting.Resources),
+			},
+		},
+	}
+}
+
+// bookingList is a findtime or rooms listing with booking buttons. The listing is ephemeral,
+// so it is kept to replace it without the button of a booked slot.
+type bookingList struct {
+	Text        string                   `json:"text"`
+	Attachments []*model.SlackAttachment `json:"attachments"`
+}
+
+// bookingListKey returns the KV key of a listing shown to the user
+func bookingListKey(userID, listID string) string {
+	return fmt.Sprintf("exchange_booking_list_%s_%s", userID, listID)
+}
+
+// bookingSlotKey returns the KV key claiming a slot of a listing for a booking
+func bookingSlotKey(userID, listID, slot string) string {
+	return fmt.Sprintf("exchange_booking_slot_%s_%s_%s", userID, listID, slot)
+}
+
+// bookingListResponse stores the listing, numbering its booking buttons, and returns it as an ephemeral response
+func (p *Plugin) bookingListResponse(userID, text string, attachments []*model.SlackAttachment) *model.CommandResponse {
+	listID := model.NewId()
+	for i, attachment := range attachments {
+		for _, action := range attachment.Actions {
+			action.Integration.Context["list_id"] = listID
+			action.Integration.Context["slot"] = strconv.Itoa(i)
+		}
+	}
+
+	if err := p.storeBookingList(userID, listID, bookingList{Text: text, Attachments: attachments}); err != nil {
+		p.API.LogError("Ошибка сохранения списка для бронирования", "user_id", userID, "error", err.Error())
+		return &model.CommandResponse{
+			ResponseType: "ephemeral",
+			Text:         "❌ Не удалось подготовить бронирование. Повторите поиск.",
+		}
+	}
+
+	return &model.CommandResponse{
+		ResponseType: "ephemeral",
+		Text:         text,
+		Attachments:  attachments,
+	}
+}
+
+// storeBookingList saves the listing until its buttons expire
+func (p *Plugin) storeBookingList(userID, listID string, list bookingList) error {
+	data, err := json.Marshal(list)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal booking list")
+	}
+
+	if _, appErr := p.API.KVSetWithOptions(bookingListKey(userID, listID), data, model.PluginKVSetOptions{
+		ExpireInSeconds: int64(bookingListTTL / time.Second),
+	}); appErr != nil {
+		return errors.Wrap(appErr, "failed to store booking list")
+	}
+
+	return nil
+}
+
+// claimBookingSlot reserves a slot of the listing for a single booking. It returns false if the
+// slot has been booked already or is being booked, so a repeated click does not book it again.
+func (p *Plugin) claimBookingSlot(userID, listID, slot string) (bool, error) {
+	claimed, appErr := p.API.KVSetWithOptions(bookingSlotKey(userID, listID, slot), []byte(time.Now().UTC().Format(time.RFC3339)), model.PluginKVSetOptions{
+		Atomic:          true,
+		OldValue:        nil,
+		ExpireInSeconds: int64(bookingListTTL / time.Second),
+	})
+	if appErr != nil {
+		return false, errors.Wrap(appErr, "failed to claim booking slot")
+	}
+
+	return claimed, nil
+}
+
+// releaseBookingSlot lets the slot be booked again after the booking failed
+func (p *Plugin) releaseBookingSlot(userID, listID, slot string) {
+	if appErr := p.API.KVDelete(bookingSlotKey(userID, listID, slot)); appErr != nil {
+		p.API.LogError("Ошибка снятия отметки о бронировании", "user_id", userID, "error", appErr.Error())
+	}
+}
+
+// bookedListPost returns the listing with the booking button of the slot replaced by the note
+func (p *Plugin) bookedListPost(userID, listID, slot, note string) (*model.Post, error) {
+	data, appErr := p.API.KVGet(bookingListKey(userID, listID))
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to get booking list")
+	}
+	if data == nil {
+		return nil, errors.New("booking list expired")
+	}
+
+	var list bookingList
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal booking list")
+	}
+
+	index, err := strconv.Atoi(slot)
+	if err != nil || index < 0 || index >= len(list.Attachments) {
+		return nil, errors.Errorf("invalid booking slot %q", slot)
+	}
+	list.Attachments[index].Actions = nil
+	list.Attachments[index].Text += "\n" + note
+
+	if err := p.storeBookingList(userID, listID, list); err != nil {
+		return nil, err
+	}
+
+	post := &model.Post{Message: list.Text}
+	post.AddProp("attachments", list.Attachments)
+	return post, nil
+}
+
+// formatMeetingSlots lists the suggested slots as attachments with a booking button each
+func formatMeetingSlots(slots []MeetingSlot, subject string, attendees []string, loc *time.Location) []*model.SlackAttachment {
+	attachments := make([]*model.SlackAttachment, 0, len(slots))
+	for _, slot := range slots {
+		text := "✅ Свободны все участники"
+		if len(slot.Busy) > 0 {
+			text = fmt.Sprintf("❌ Заняты: %s", strings.Join(slot.Busy, ", "))
+		}
+		if len(slot.Tentative) > 0 {
+			text += fmt.Sprintf("\n❓ Под вопросом: %s", strings.Join(slot.Tentative, ", "))
+		}
+
+		attachments = append(attachments, &model.SlackAttachment{
+			Title: fmt.Sprintf("%s %s - %s", weekdayNames[slot.Start.In(loc).Weekday()], slot.Start.In(loc).Format("02.01 15:04"), slot.End.In(loc).Format("15:04")),
+			Text:  text,
+			Actions: []*model.PostAction{bookingAction("📅 Забронировать", NewMeeting{
+				Subject:           subject,
+				Start:             slot.Start,
+				End:               slot.End,
+				RequiredAttendees: attendees,
+			})},
+		})
+	}
+	return attachments
+}
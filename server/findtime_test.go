@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+func TestBookSlotBooksOnce(t *testing.T) {
+	start := time.Now().Add(24 * time.Hour).Truncate(time.Hour)
+	slots := []MeetingSlot{
+		{Start: start, End: start.Add(30 * time.Minute)},
+		{Start: start.Add(time.Hour), End: start.Add(90 * time.Minute)},
+	}
+
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		booked   bool // The slot stays claimed and its button is removed
+		dmPrefix string
+	}{
+		{"unknown outcome", closeConnection, true, "⚠️ **Exchange не подтвердил"},
+		{"exchange error", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusInternalServerError) }, false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var creates int32
+			p, api := newTestPluginWithEWS(t, func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&creates, 1)
+				tt.handler(w, r)
+			})
+
+			listing := p.bookingListResponse(testUserID, "🔎 Подходящее время", formatMeetingSlots(slots, "Планерка", []string{"asmith@contoso.com"}, time.UTC))
+			book := func() model.PostActionIntegrationResponse {
+				return postAction(t, p.handleBookSlot, model.PostActionIntegrationRequest{
+					UserId:  testUserID,
+					Context: listing.Attachments[0].Actions[0].Integration.Context,
+				})
+			}
+
+			response := book()
+			if tt.booked {
+				if response.Update == nil {
+					t.Fatal("listing not updated")
+				}
+				attachments := response.Update.Attachments()
+				if len(attachments[0].Actions) != 0 || len(attachments[1].Actions) != 1 {
+					t.Errorf("only the booked slot should lose its button: %+v", attachments)
+				}
+				if len(api.created) != 1 || !strings.HasPrefix(api.created[0].Message, tt.dmPrefix) {
+					t.Errorf("unexpected direct messages: %+v", api.created)
+				}
+			} else if response.Update != nil || !strings.HasPrefix(response.EphemeralText, "❌") {
+				t.Errorf("unexpected response for a failed booking: %+v", response)
+			}
+
+			response = book()
+			want := int32(1)
+			if !tt.booked {
+				want = 2
+			}
+			if n := atomic.LoadInt32(&creates); n != want {
+				t.Errorf("CreateItem sent %d times after two clicks, want %d", n, want)
+			}
+			if tt.booked && !strings.Contains(response.EphemeralText, "уже забронировано") {
+				t.Errorf("second click: %+v", response)
+			}
+		})
+	}
+}
+
+func TestFindMeetingSlots(t *testing.T) {
+	monday := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+	at := func(day, hour, minute int) time.Time {
+		return monday.AddDate(0, 0, day).Add(time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute)
+	}
+	busy := func(user string, status string, from, to time.Time) MailboxAvailability {
+		return MailboxAvailability{User: user, Busy: []BusyBlock{{Start: from, End: to, Status: status}}}
+	}
+
+	tests := []struct {
+		name         string
+		availability []MailboxAvailability
+		from, until  time.Time
+		duration     time.Duration
+		want         []string // Slot starts as "Mon 15:04"
+		firstBusy    int      // Busy attendees of the first slot
+	}{
+		{"free slots only, without overlaps", nil, at(0, 0, 0), at(0, 23, 0), time.Hour,
+			[]string{"Mon 09:00", "Mon 10:00", "Mon 11:00", "Mon 12:00", "Mon 13:00"}, 0},
+		{"free slot before conflicts", []MailboxAvailability{
+			{User: "@asmith", Busy: []BusyBlock{{Start: at(0, 9, 0), End: at(0, 13, 0), Status: "Busy"}, {Start: at(0, 14, 0), End: at(0, 18, 0), Status: "Busy"}}},
+		}, at(0, 0, 0), at(0, 23, 0), time.Hour,
+			[]string{"Mon 13:00", "Mon 09:00", "Mon 10:00", "Mon 11:00", "Mon 12:00"}, 0},
+		{"tentative before busy", []MailboxAvailability{
+			busy("@asmith", "Busy", at(0, 9, 0), at(0, 17, 0)),
+			busy("@bjones", "Tentative", at(0, 17, 0), at(0, 18, 0)),
+		}, at(0, 0, 0), at(0, 23, 0), 30 * time.Minute,
+			[]string{"Mon 17:00", "Mon 17:30", "Mon 09:00", "Mon 09:30", "Mon 10:00"}, 0},
+		{"out of office counts as busy", []MailboxAvailability{
+			busy("@asmith", "OOF", at(0, 9, 0), at(0, 18, 0)),
+		}, at(0, 0, 0), at(0, 23, 0), 3 * time.Hour,
+			[]string{"Mon 09:00", "Mon 12:00", "Mon 15:00"}, 1},
+		{"attendee without data is ignored", []MailboxAvailability{
+			{User: "@asmith", Busy: []BusyBlock{{Start: at(0, 9, 0), End: at(0, 18, 0), Status: "Busy"}}, Err: errors.New("unknown mailbox")},
+		}, at(0, 0, 0), at(0, 23, 0), 4 * time.Hour,
+			[]string{"Mon 09:00", "Mon 13:00"}, 0},
+		{"weekend and past times skipped", nil, at(-1, 16, 0), at(1, 11, 0), time.Hour,
+			[]string{"Mon 09:00", "Mon 10:00", "Mon 11:00", "Mon 12:00", "Mon 13:00"}, 0},
+		{"range ends before the working day does", nil, at(4, 16, 0), at(5, 12, 0), time.Hour,
+			[]string{"Fri 16:00", "Fri 17:00"}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slots := findMeetingSlots(tt.availability, tt.from, tt.until, tt.duration, time.UTC)
+
+			starts := make([]string, 0, len(slots))
+			for _, slot := range slots {
+				starts = append(starts, slot.Start.Format("Mon 15:04"))
+			}
+			if strings.Join(starts, ", ") != strings.Join(tt.want, ", ") {
+				t.Errorf("slots = %v, want %v", starts, tt.want)
+			}
+			if len(slots) > 0 && len(slots[0].Busy) != tt.firstBusy {
+				t.Errorf("first slot has %d busy attendees, want %d", len(slots[0].Busy), tt.firstBusy)
+			}
+		})
+	}
+}
+
+func TestParseFindTimeArguments(t *testing.T) {
+	tests := []struct {
+		args    string
+		minutes int
+		days    int
+		users   []string
+		fails   bool
+	}{
+		{"", findTimeDefaultMinutes, findTimeDefaultDays, nil, false},
+		{"45m 3d @asmith", 45, 3, []string{"@asmith"}, false},
+		{"1ч 10", 60, 10, nil, false},
+		{"90мин 3д asmith@contoso.com @bjones", 90, 3, []string{"asmith@contoso.com", "@bjones"}, false},
+		{"2h", 120, findTimeDefaultDays, nil, false},
+		{"0", 0, 0, nil, true},
+		{"30 3 4", 0, 0, nil, true},
+		{"30 15d", 0, 0, nil, true},
+		{"10h", 0, 0, nil, true},
+		{"xd", 0, 0, nil, true},
+		{"полчаса", 0, 0, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.args, func(t *testing.T) {
+			minutes, days, users, err := parseFindTimeArguments(strings.Fields(tt.args))
+			if tt.fails {
+				if err == nil {
+					t.Errorf("expected an error, got %d min, %d days, %v", minutes, days, users)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if minutes != tt.minutes || days != tt.days || strings.Join(users, " ") != strings.Join(tt.users, " ") {
+				t.Errorf("got %d min, %d days, %v; want %d min, %d days, %v", minutes, days, users, tt.minutes, tt.days, tt.users)
+			}
+		})
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// seenInvitationTTL is how long an announced invitation is remembered
+const seenInvitationTTL = 90 * 24 * time.Hour
+
+// MeetingInvitation is a meeting request from the Inbox linked to its calendar item.
+// ID is the associated calendar item when Exchange has created one, otherwise the meeting request.
+type MeetingInvitation struct {
+	CalendarEvent
+	MeetingRequestID   string    `json:"meeting_request_id"`
+	MeetingRequestType string    `json:"meeting_request_type"` // NewMeetingRequest, FullUpdate, InformationalUpdate, ...
+	IsOutOfDate        bool      `json:"is_out_of_date"`
+	ReceivedAt         time.Time `json:"received_at"`
+}
+
+// IsUpdate reports whether the request updates a meeting the user was already invited to
+func (i MeetingInvitation) IsUpdate() bool {
+	return i.MeetingRequestType == "FullUpdate" || i.MeetingRequestType == "InformationalUpdate"
+}
+
+// meetingRequestProperties are the properties FindMeetingRequests requests in addition to the Default shape
+func meetingRequestProperties() *AdditionalProperties {
+	properties := calendarEventProperties()
+	properties.FieldURI = append(properties.FieldURI,
+		FieldURI{FieldURI: "item:DateTimeReceived"},
+		FieldURI{FieldURI: "calendar:Start"},
+		FieldURI{FieldURI: "calendar:End"},
+		FieldURI{FieldURI: "calendar:Location"},
+		FieldURI{FieldURI: "calendar:Organizer"},
+		FieldURI{FieldURI: "calendar:IsAllDayEvent"},
+		FieldURI{FieldURI: "meeting:AssociatedCalendarItemId"},
+		FieldURI{FieldURI: "meeting:IsOutOfDate"},
+		FieldURI{FieldURI: "meetingRequest:MeetingRequestType"},
+	)
+	return properties
+}
+
+// FindMeetingRequests returns the Inbox meeting requests received after since, oldest first.
+// It reads up to maxMailPagesPerCheck pages and returns the receive time of the last request
+// examined, from which the next check continues. Requests for which seen returns true are
+// skipped before their details are fetched. mailbox selects a delegated mailbox; an empty
+// one is the user's own Inbox.
+func (c *ExchangeClient) FindMeetingRequests(mailbox string, since time.Time, seen func(itemID string) bool) ([]MeetingInvitation, time.Time, error) {
+	// Requests received in the same second as the last one examined are read again; seen skips them
+	restriction := &Restriction{
+		And: &SearchExpressions{
+			IsEqualTo: []IsEqualTo{{
+				FieldURI:           &FieldURI{FieldURI: "item:ItemClass"},
+				FieldURIOrConstant: &FieldURIOrConstant{Constant: &Constant{Value: "IPM.Schedule.Meeting.Request"}},
+			}},
+			IsGreaterThan: []IsEqualTo{{
+				FieldURI:           &FieldURI{FieldURI: "item:DateTimeReceived"},
+				FieldURIOrConstant: &FieldURIOrConstant{Constant: &Constant{Value: since.Add(-time.Second).UTC().Format(time.RFC3339)}},
+			}},
+		},
+	}
+
+	itemIDs, cursor, err := c.findNewMail(mailbox, restriction, since, seen)
+	if err != nil {
+		return nil, since, err
+	}
+
+	if len(itemIDs) == 0 {
+		return []MeetingInvitation{}, cursor, nil
+	}
+
+	invitations, err := c.getMeetingRequests(itemIDs)
+	if err != nil {
+		return nil, since, err
+	}
+
+	return invitations, cursor, nil
+}
+
+// getMeetingRequests fetches meeting requests with a single GetItem request.
+// Requests deleted in the meantime are skipped.
+func (c *ExchangeClient) getMeetingRequests(itemIDs []ItemId) ([]MeetingInvitation, error) {
+	soapResp, err := c.sendSOAPRequest("GetItem", SOAPBody{
+		GetItem: &GetItem{
+			ItemShape: &ItemShape{
+				BaseShape:            "Default",
+				AdditionalProperties: meetingRequestProperties(),
+			},
+			ItemIds: &ItemIds{ItemId: itemIDs},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if soapResp.Body.GetItemResponse == nil || soapResp.Body.GetItemResponse.ResponseMessages == nil {
+		return nil, fmt.Errorf("empty GetItem response")
+	}
+
+	invitations := make([]MeetingInvitation, 0, len(itemIDs))
+	for _, message := range soapResp.Body.GetItemResponse.ResponseMessages.GetItemResponseMessage {
+		if err := message.Err(); err != nil {
+			if errors.Is(err, ErrItemNotFound) {
+				continue
+			}
+			c.throttled(err)
+			return nil, err
+		}
+		if message.Items == nil {
+			continue
+		}
+
+		for _, item := range message.Items.MeetingRequest {
+			invitation, err := c.convertToMeetingInvitation(item)
+			if err != nil {
+				continue // Skip problematic requests
+			}
+			invitations = append(invitations, invitation)
+		}
+	}
+
+	return invitations, nil
+}
+
+// convertToMeetingInvitation converts an EWS meeting request to MeetingInvitation
+func (c *ExchangeClient) convertToMeetingInvitation(item CalendarItem) (MeetingInvitation, error) {
+	event, err := c.convertToCalendarEvent(item)
+	if err != nil {
+		return MeetingInvitation{}, err
+	}
+	event.IsMeeting = true
+
+	invitation := MeetingInvitation{
+		CalendarEvent:      event,
+		MeetingRequestID:   item.ItemId.Id,
+		MeetingRequestType: item.MeetingRequestType,
+		IsOutOfDate:        item.IsOutOfDate == "true",
+	}
+
+	// Responses and details go through the calendar item Exchange created for the request
+	if item.AssociatedCalendarItemId != nil && item.AssociatedCalendarItemId.Id != "" {
+		invitation.ID = item.AssociatedCalendarItemId.Id
+	}
+
+	if received, err := parseEWSDateTime(item.DateTimeReceived, c.timeLocation()); err == nil {
+		invitation.ReceivedAt = received
+	}
+
+	return invitation, nil
+}
+
+// checkMeetingInvitations announces the new meeting invitations of the user's own Inbox or,
+// if delegate is set, of a delegated mailbox. The mailbox watermark moves past the invitations
+// examined but stays at the first one that could not be announced, so it is tried again.
+func (p *Plugin) checkMeetingInvitations(userID string, credentials *ExchangeCredentials, delegate *DelegateMailbox) error {
+	mailbox := ""
+	if delegate != nil {
+		mailbox = delegate.Email
+	}
+
+	since, err := p.getInvitationsWatermark(userID, mailbox)
+	if err != nil {
+		return err
+	}
+
+	client, err := p.newUserExchangeClient(userID, credentials)
+	if err != nil {
+		return err
+	}
+
+	invitations, cursor, err := client.FindMeetingRequests(mailbox, since, func(itemID string) bool {
+		return p.isInvitationSeen(userID, itemID)
+	})
+	p.recordEndpointResult(userID, client, err)
+	if err != nil {
+		return err
+	}
+
+	for _, invitation := range invitations {
+		if !p.announceMeetingInvitation(userID, invitation, delegate) && invitation.ReceivedAt.Before(cursor) {
+			cursor = invitation.ReceivedAt
+		}
+	}
+
+	if cursor.After(since) {
+		if appErr := p.API.KVSet(invitationsWatermarkKey(userID, mailbox), []byte(cursor.UTC().Format(time.RFC3339))); appErr != nil {
+			p.API.LogError("Ошибка сохранения отметки приглашений", "user_id", userID, "error", appErr.Error())
+		}
+	}
+
+	return nil
+}
+
+// getInvitationsWatermark returns the receive time up to which the invitations of the user's
+// mailbox have been examined, starting at the time tracking started. Invitations received
+// earlier are never announced, so enabling notifications does not flood the user with the
+// existing Inbox.
+func (p *Plugin) getInvitationsWatermark(userID, mailbox string) (time.Time, error) {
+	return p.getWatermark(invitationsWatermarkKey(userID, mailbox))
+}
+
+// getWatermark returns the time stored under the key, storing the current time on first use.
+// All nodes agree on the first value because it is set atomically.
+func (p *Plugin) getWatermark(key string) (time.Time, error) {
+	data, appErr := p.API.KVGet(key)
+	if appErr != nil {
+		return time.Time{}, fmt.Errorf("failed to get watermark: %w", appErr)
+	}
+
+	if data == nil {
+		now := []byte(time.Now().UTC().Format(time.RFC3339))
+		set, appErr := p.API.KVSetWithOptions(key, now, model.PluginKVSetOptions{Atomic: true, OldValue: nil})
+		if appErr != nil {
+			return time.Time{}, fmt.Errorf("failed to store watermark: %w", appErr)
+		}
+		if set {
+			data = now
+		} else if data, appErr = p.API.KVGet(key); appErr != nil || data == nil {
+			// Another node has just started tracking
+			return time.Time{}, fmt.Errorf("failed to get watermark")
+		}
+	}
+
+	since, err := time.Parse(time.RFC3339, string(data))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse watermark: %w", err)
+	}
+
+	return since, nil
+}
+
+// invitationsWatermarkKey returns the KV key of the watermark of the user's own or a delegated mailbox
+func invitationsWatermarkKey(userID, mailbox string) string {
+	if mailbox == "" {
+		return fmt.Sprintf("exchange_invitations_since_%s", userID)
+	}
+	return fmt.Sprintf("exchange_invitations_since_%s_%s", userID, keyHash(strings.ToLower(mailbox)))
+}
+
+// seenInvitationKey returns the KV key marking an announced invitation
+func seenInvitationKey(userID, itemID string) string {
+	return fmt.Sprintf("exchange_invitation_%s_%s", userID, keyHash(itemID))
+}
+
+// keyHash shortens values that are too long for KV keys, such as item IDs
+func keyHash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:16])
+}
+
+// isInvitationSeen reports whether the invitation has already been claimed
+func (p *Plugin) isInvitationSeen(userID, itemID string) bool {
+	data, appErr := p.API.KVGet(seenInvitationKey(userID, itemID))
+	return appErr == nil && data != nil
+}
+
+// claimInvitation marks the invitation as announced. It returns false if another
+// check (on this or another node) has claimed it first.
+func (p *Plugin) claimInvitation(userID, itemID string) (bool, error) {
+	claimed, appErr := p.API.KVSetWithOptions(seenInvitationKey(userID, itemID), []byte(time.Now().UTC().Format(time.RFC3339)), model.PluginKVSetOptions{
+		Atomic:          true,
+		OldValue:        nil,
+		ExpireInSeconds: int64(seenInvitationTTL / time.Second),
+	})
+	if appErr != nil {
+		return false, fmt.Errorf("failed to claim invitation: %w", appErr)
+	}
+
+	return claimed, nil
+}
+
+// releaseInvitation forgets a claimed invitation so the next check announces it again
+func (p *Plugin) releaseInvitation(userID, itemID string) {
+	if appErr := p.API.KVDelete(seenInvitationKey(userID, itemID)); appErr != nil {
+		p.API.LogError("Ошибка удаления отметки о приглашении", "user_id", userID, "error", appErr.Error())
+	}
+}
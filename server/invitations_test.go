@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFindMeetingRequestsPagesFromWatermark(t *testing.T) {
+	since := time.Date(2024, 7, 1, 9, 0, 0, 0, time.UTC)
+	received := func(i int) time.Time { return since.Add(time.Duration(i+1) * time.Minute) }
+
+	const total = 30
+	offsetPattern := regexp.MustCompile(`Offset="(\d+)"`)
+	idPattern := regexp.MustCompile(`ItemId Id="([^"]+)"`)
+
+	var offsets []int
+	client, _ := newTestEWSClient(t, func(w http.ResponseWriter, r *http.Request) {
+		request, _ := io.ReadAll(r.Body)
+
+		var items strings.Builder
+		if strings.Contains(r.Header.Get("SOAPAction"), "/FindItem") {
+			for _, want := range []string{
+				"IPM.Schedule.Meeting.Request",
+				`<t:IsGreaterThan><t:FieldURI FieldURI="item:DateTimeReceived"></t:FieldURI><t:FieldURIOrConstant><t:Constant Value="2024-07-01T08:59:59Z">`,
+				`Order="Ascending"`,
+			} {
+				if !strings.Contains(string(request), want) {
+					t.Errorf("FindItem request lacks %s: %s", want, request)
+				}
+			}
+
+			match := offsetPattern.FindSubmatch(request)
+			if match == nil {
+				t.Errorf("FindItem without an offset: %s", request)
+				return
+			}
+			offset, _ := strconv.Atoi(string(match[1]))
+			offsets = append(offsets, offset)
+
+			last := min(offset+mailPageSize, total)
+			for i := offset; i < last; i++ {
+				fmt.Fprintf(&items, `<t:MeetingRequest><t:ItemId Id="req-%d"/><t:DateTimeReceived>%s</t:DateTimeReceived></t:MeetingRequest>`, i, received(i).Format(time.RFC3339))
+			}
+			fmt.Fprintf(w, `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>
+<m:FindItemResponse xmlns:m="http://schemas.microsoft.com/exchange/services/2006/messages" xmlns:t="http://schemas.microsoft.com/exchange/services/2006/types">
+<m:ResponseMessages><m:FindItemResponseMessage ResponseClass="Success"><m:ResponseCode>NoError</m:ResponseCode>
+<m:RootFolder TotalItemsInView="%d" IncludesLastItemInRange="%t"><t:Items>%s</t:Items></m:RootFolder>
+</m:FindItemResponseMessage></m:ResponseMessages></m:FindItemResponse></s:Body></s:Envelope>`, total, last == total, items.String())
+			return
+		}
+
+		for _, match := range idPattern.FindAllSubmatch(request, -1) {
+			fmt.Fprintf(&items, `<m:GetItemResponseMessage ResponseClass="Success"><m:ResponseCode>NoError</m:ResponseCode><m:Items><t:MeetingRequest><t:ItemId Id="%s"/><t:Start>2024-07-02T10:00:00Z</t:Start><t:End>2024-07-02T11:00:00Z</t:End></t:MeetingRequest></m:Items></m:GetItemResponseMessage>`, match[1])
+		}
+		fmt.Fprintf(w, `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>
+<m:GetItemResponse xmlns:m="http://schemas.microsoft.com/exchange/services/2006/messages" xmlns:t="http://schemas.microsoft.com/exchange/services/2006/types">
+<m:ResponseMessages>%s</m:ResponseMessages></m:GetItemResponse></s:Body></s:Envelope>`, items.String())
+	})
+
+	invitations, cursor, err := client.FindMeetingRequests("", since, func(itemID string) bool {
+		return itemID == "req-3"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(offsets) != 2 || offsets[0] != 0 || offsets[1] != mailPageSize {
+		t.Errorf("FindItem offsets = %v, want [0 %d]", offsets, mailPageSize)
+	}
+	if len(invitations) != total-1 {
+		t.Fatalf("got %d invitations, want %d", len(invitations), total-1)
+	}
+	if invitations[0].MeetingRequestID != "req-0" || invitations[len(invitations)-1].MeetingRequestID != fmt.Sprintf("req-%d", total-1) {
+		t.Errorf("invitations are not oldest first: %s … %s", invitations[0].MeetingRequestID, invitations[len(invitations)-1].MeetingRequestID)
+	}
+	if !cursor.Equal(received(total - 1)) {
+		t.Errorf("cursor = %s, want %s", cursor, received(total-1))
+	}
+}
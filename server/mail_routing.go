@@ -0,0 +1,652 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// mailRoutingLookback limits how old a message may be to be routed. It is shorter than
+// routedMailTTL, so a message is never examined again after its marks expire.
+const mailRoutingLookback = 7 * 24 * time.Hour
+
+// routedMailTTL is how long a routed message is remembered
+const routedMailTTL = 30 * 24 * time.Hour
+
+// routedMailClaimTTL is how long a claim of a message for a channel blocks other checks before
+// the post is confirmed. A claim of a node that failed before posting expires after it.
+const routedMailClaimTTL = 15 * time.Minute
+
+// routedMailClaimed is the value of a claim whose post is not confirmed yet
+const routedMailClaimed = "claimed"
+
+// maxRoutedAttachments is the number of files a Mattermost post can hold
+const maxRoutedAttachments = 10
+
+// maxRoutedAttachmentSize caps an attachment downloaded from Exchange; the server's file size limit applies too
+const maxRoutedAttachmentSize = 50 * 1024 * 1024
+
+// MailRoutingRule posts the shared mailbox messages matching all of its conditions into a channel.
+// Conditions left empty are not checked.
+type MailRoutingRule struct {
+	Name         string `json:"name"`
+	Channel      string `json:"channel"`       // team-name/channel-name or a channel ID
+	SenderDomain string `json:"sender_domain"` // example.com, subdomains included
+	Subject      string `json:"subject"`       // Regular expression, e.g. (?i)^\[alert\]
+	To           string `json:"to"`            // An address among the To and Cc recipients
+
+	subject *regexp.Regexp
+}
+
+// Label names the rule in logs
+func (r MailRoutingRule) Label() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return r.Channel
+}
+
+// Matches reports whether the message satisfies all conditions of the rule
+func (r MailRoutingRule) Matches(message RoutedMail) bool {
+	if r.SenderDomain != "" {
+		domain := message.FromEmail[strings.LastIndex(message.FromEmail, "@")+1:]
+		if !isSMTPAddress(message.FromEmail) || (domain != r.SenderDomain && !strings.HasSuffix(domain, "."+r.SenderDomain)) {
+			return false
+		}
+	}
+
+	if r.To != "" && !containsAddress(message.To, r.To) && !containsAddress(message.Cc, r.To) {
+		return false
+	}
+
+	return r.subject == nil || r.subject.MatchString(message.Subject)
+}
+
+// containsAddress reports whether the lowercase address is in the list
+func containsAddress(addresses []string, address string) bool {
+	for _, candidate := range addresses {
+		if candidate == address {
+			return true
+		}
+	}
+	return false
+}
+
+// parseMailRoutingRules parses the JSON list of rules of the MailRoutingRules setting
+func parseMailRoutingRules(value string) ([]MailRoutingRule, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+
+	var rules []MailRoutingRule
+	if err := json.Unmarshal([]byte(value), &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse mail routing rules: %w", err)
+	}
+
+	for i := range rules {
+		rule := &rules[i]
+		rule.Channel = strings.TrimSpace(rule.Channel)
+		if rule.Channel == "" {
+			return nil, fmt.Errorf("mail routing rule %d: channel must be set", i+1)
+		}
+		rule.SenderDomain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(rule.SenderDomain), "@"))
+		rule.To = strings.ToLower(strings.TrimSpace(rule.To))
+		if rule.Subject != "" {
+			subject, err := regexp.Compile(rule.Subject)
+			if err != nil {
+				return nil, fmt.Errorf("mail routing rule %d: invalid subject pattern: %w", i+1, err)
+			}
+			rule.subject = subject
+		}
+	}
+
+	return rules, nil
+}
+
+// RoutedMail is a shared mailbox message with the details needed to post it
+type RoutedMail struct {
+	ID          string
+	Subject     string
+	FromName    string
+	FromEmail   string   // Lowercase SMTP address
+	To          []string // Lowercase addresses
+	Cc          []string
+	ToNames     []string
+	ReceivedAt  time.Time
+	Body        string // Markdown
+	Attachments []MailAttachment
+}
+
+// MailAttachment describes an attachment of a routed message
+type MailAttachment struct {
+	ID       string
+	Name     string
+	Size     int64
+	IsInline bool
+	IsItem   bool // An attached message, which cannot be downloaded as a file
+}
+
+// FindRoutedMail returns the Inbox messages of the mailbox received after since, oldest first.
+// It reads up to maxMailPagesPerCheck pages and returns the receive time of the last message
+// examined, from which the next check continues. Messages for which seen returns true are
+// skipped before their details are fetched.
+func (c *ExchangeClient) FindRoutedMail(mailbox string, since time.Time, seen func(itemID string) bool) ([]RoutedMail, time.Time, error) {
+	// Messages received in the same second as the last one examined are read again; seen skips them
+	restriction := &Restriction{
+		IsGreaterThan: &IsEqualTo{
+			FieldURI:           &FieldURI{FieldURI: "item:DateTimeReceived"},
+			FieldURIOrConstant: &FieldURIOrConstant{Constant: &Constant{Value: since.Add(-time.Second).UTC().Format(time.RFC3339)}},
+		},
+	}
+
+	itemIDs, cursor, err := c.findNewMail(mailbox, restriction, since, seen)
+	if err != nil {
+		return nil, since, err
+	}
+
+	if len(itemIDs) == 0 {
+		return []RoutedMail{}, cursor, nil
+	}
+
+	messages, err := c.getRoutedMail(itemIDs)
+	if err != nil {
+		return nil, since, err
+	}
+
+	return messages, cursor, nil
+}
+
+// getRoutedMail fetches messages with their body, recipients and attachment list with a single
+// GetItem request. Messages deleted in the meantime are skipped.
+func (c *ExchangeClient) getRoutedMail(itemIDs []ItemId) ([]RoutedMail, error) {
+	soapResp, err := c.sendSOAPRequest("GetItem", SOAPBody{
+		GetItem: &GetItem{
+			ItemShape: &ItemShape{
+				BaseShape: "IdOnly",
+				BodyType:  "Best",
+				AdditionalProperties: &AdditionalProperties{
+					FieldURI: []FieldURI{
+						{FieldURI: "item:Subject"},
+						{FieldURI: "item:Body"},
+						{FieldURI: "item:Attachments"},
+						{FieldURI: "item:DateTimeReceived"},
+						{FieldURI: "message:From"},
+						{FieldURI: "message:ToRecipients"},
+						{FieldURI: "message:CcRecipients"},
+					},
+					ExtendedFieldURI: []ExtendedFieldURI{
+						{PropertyTag: senderSMTPAddressTag, PropertyType: "String"},
+					},
+				},
+			},
+			ItemIds: &ItemIds{ItemId: itemIDs},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if soapResp.Body.GetItemResponse == nil || soapResp.Body.GetItemResponse.ResponseMessages == nil {
+		return nil, fmt.Errorf("empty GetItem response")
+	}
+
+	messages := make([]RoutedMail, 0, len(itemIDs))
+	for _, message := range soapResp.Body.GetItemResponse.ResponseMessages.GetItemResponseMessage {
+		if err := message.Err(); err != nil {
+			if errors.Is(err, ErrItemNotFound) {
+				continue
+			}
+			c.throttled(err)
+			return nil, err
+		}
+		if message.Items == nil {
+			continue
+		}
+
+		for _, item := range message.Items.Message {
+			messages = append(messages, convertToRoutedMail(item, c.timeLocation()))
+		}
+	}
+
+	return messages, nil
+}
+
+// convertToRoutedMail converts an EWS message to RoutedMail, turning an HTML body into Markdown
+func convertToRoutedMail(item MessageItem, loc *time.Location) RoutedMail {
+	message := RoutedMail{
+		ID:      item.ItemId.Id,
+		Subject: item.Subject,
+	}
+	if received, err := parseEWSDateTime(item.DateTimeReceived, loc); err == nil {
+		message.ReceivedAt = received
+	}
+
+	if item.From != nil && item.From.Mailbox != nil {
+		message.FromName = item.From.Mailbox.Name
+		message.FromEmail = strings.ToLower(item.From.Mailbox.EmailAddress)
+	}
+	// Internal senders may come with a legacy DN address; the property holds their SMTP address
+	for _, property := range item.ExtendedProperty {
+		if strings.EqualFold(property.ExtendedFieldURI.PropertyTag, senderSMTPAddressTag) && property.Value != "" {
+			message.FromEmail = strings.ToLower(property.Value)
+		}
+	}
+
+	if item.ToRecipients != nil {
+		for _, mailbox := range item.ToRecipients.Mailbox {
+			message.To = append(message.To, strings.ToLower(mailbox.EmailAddress))
+			name := mailbox.Name
+			if name == "" {
+				name = mailbox.EmailAddress
+			}
+			message.ToNames = append(message.ToNames, name)
+		}
+	}
+	if item.CcRecipients != nil {
+		for _, mailbox := range item.CcRecipients.Mailbox {
+			message.Cc = append(message.Cc, strings.ToLower(mailbox.EmailAddress))
+		}
+	}
+
+	if item.Body != nil {
+		if strings.EqualFold(item.Body.BodyType, "HTML") {
+			message.Body = htmlToMarkdown(item.Body.Value)
+		} else {
+			message.Body = strings.TrimSpace(item.Body.Value)
+		}
+	}
+
+	if item.Attachments != nil {
+		for _, attachment := range item.Attachments.FileAttachment {
+			size, _ := strconv.ParseInt(attachment.Size, 10, 64)
+			message.Attachments = append(message.Attachments, MailAttachment{
+				ID:       attachment.AttachmentId.Id,
+				Name:     attachment.Name,
+				Size:     size,
+				IsInline: attachment.IsInline == "true",
+			})
+		}
+		for _, attachment := range item.Attachments.ItemAttachment {
+			message.Attachments = append(message.Attachments, MailAttachment{
+				ID:     attachment.AttachmentId.Id,
+				Name:   attachment.Name,
+				IsItem: true,
+			})
+		}
+	}
+
+	return message
+}
+
+// GetFileAttachment downloads the content of a file attachment
+func (c *ExchangeClient) GetFileAttachment(attachmentID string) ([]byte, error) {
+	soapResp, err := c.sendSOAPRequest("GetAttachment", SOAPBody{
+		GetAttachment: &GetAttachment{
+			AttachmentIds: &AttachmentIds{AttachmentId: []AttachmentId{{Id: attachmentID}}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if soapResp.Body.GetAttachmentResponse == nil ||
+		soapResp.Body.GetAttachmentResponse.ResponseMessages == nil ||
+		len(soapResp.Body.GetAttachmentResponse.ResponseMessages.GetAttachmentResponseMessage) == 0 {
+		return nil, fmt.Errorf("empty GetAttachment response")
+	}
+
+	responseMessage := soapResp.Body.GetAttachmentResponse.ResponseMessages.GetAttachmentResponseMessage[0]
+	if err := responseMessage.Err(); err != nil {
+		c.throttled(err)
+		return nil, err
+	}
+	if responseMessage.Attachments == nil || len(responseMessage.Attachments.FileAttachment) == 0 {
+		return nil, fmt.Errorf("GetAttachment response has no file attachment")
+	}
+
+	content, err := base64.StdEncoding.DecodeString(strings.TrimSpace(responseMessage.Attachments.FileAttachment[0].Content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode attachment: %w", err)
+	}
+
+	return content, nil
+}
+
+// routedMailKey returns the KV key marking a message whose routing is complete
+func routedMailKey(itemID string) string {
+	return fmt.Sprintf("exchange_mail_routed_%s", keyHash(itemID))
+}
+
+// routedMailChannelKey returns the KV key marking a message posted into a channel
+func routedMailChannelKey(itemID, channelID string) string {
+	return fmt.Sprintf("exchange_mail_routed_%s_%s", keyHash(itemID), channelID)
+}
+
+// mailRoutingWatermarkKey returns the KV key of the receive time up to which the mailbox was routed
+func mailRoutingWatermarkKey(mailbox string) string {
+	return fmt.Sprintf("exchange_mail_routing_since_%s", keyHash(strings.ToLower(mailbox)))
+}
+
+// isMailMarked reports whether the key has been set
+func (p *Plugin) isMailMarked(key string) bool {
+	data, appErr := p.API.KVGet(key)
+	return appErr == nil && data != nil
+}
+
+// markRoutedMail sets the key unless it is already set. It returns false if another check
+// (on this or another node) has set it first.
+func (p *Plugin) markRoutedMail(key string) (bool, error) {
+	set, appErr := p.API.KVSetWithOptions(key, []byte(time.Now().UTC().Format(time.RFC3339)), model.PluginKVSetOptions{
+		Atomic:          true,
+		OldValue:        nil,
+		ExpireInSeconds: int64(routedMailTTL / time.Second),
+	})
+	if appErr != nil {
+		return false, fmt.Errorf("failed to mark routed message: %w", appErr)
+	}
+
+	return set, nil
+}
+
+// claimRoutedMail sets the key for routedMailClaimTTL unless it is already set. It returns
+// false if another check (on this or another node) holds the claim or has posted the message.
+func (p *Plugin) claimRoutedMail(key string) (bool, error) {
+	set, appErr := p.API.KVSetWithOptions(key, []byte(routedMailClaimed), model.PluginKVSetOptions{
+		Atomic:          true,
+		OldValue:        nil,
+		ExpireInSeconds: int64(routedMailClaimTTL / time.Second),
+	})
+	if appErr != nil {
+		return false, fmt.Errorf("failed to claim routed message: %w", appErr)
+	}
+
+	return set, nil
+}
+
+// confirmRoutedMail keeps the claim of a posted message for routedMailTTL
+func (p *Plugin) confirmRoutedMail(key string) error {
+	set, appErr := p.API.KVSetWithOptions(key, []byte(time.Now().UTC().Format(time.RFC3339)), model.PluginKVSetOptions{
+		Atomic:          true,
+		OldValue:        []byte(routedMailClaimed),
+		ExpireInSeconds: int64(routedMailTTL / time.Second),
+	})
+	if appErr != nil {
+		return fmt.Errorf("failed to mark routed message: %w", appErr)
+	}
+	if !set {
+		return fmt.Errorf("claim of routed message expired before the post was confirmed")
+	}
+
+	return nil
+}
+
+// isRoutedMailClaimed reports whether the post under the key is claimed but not confirmed
+func (p *Plugin) isRoutedMailClaimed(key string) bool {
+	data, appErr := p.API.KVGet(key)
+	return appErr == nil && string(data) == routedMailClaimed
+}
+
+// checkMailRouting posts the new messages of the shared mailbox into the channels of the
+// matching rules. The mailbox is read with the Exchange account of the MailRoutingUser,
+// who needs access to it.
+func (p *Plugin) checkMailRouting() {
+	config := p.getConfiguration()
+	mailbox := strings.TrimSpace(config.MailRoutingMailbox)
+	if !config.EnableMailRouting || mailbox == "" || len(config.mailRoutingRules) == 0 {
+		return
+	}
+
+	user, appErr := p.API.GetUserByUsername(strings.TrimPrefix(strings.TrimSpace(config.MailRoutingUser), "@"))
+	if appErr != nil {
+		p.API.LogError("Пользователь для маршрутизации почты не найден", "username", config.MailRoutingUser, "error", appErr.Error())
+		return
+	}
+
+	credentials, err := p.getUserExchangeCredentials(user.Id)
+	if err != nil {
+		p.API.LogError("Пользователь для маршрутизации почты не подключил Exchange", "username", user.Username)
+		return
+	}
+
+	client, err := p.newUserExchangeClient(user.Id, credentials)
+	if err != nil {
+		p.logExchangeError(user.Id, "Ошибка подключения к Exchange", err)
+		return
+	}
+
+	since, err := p.getWatermark(mailRoutingWatermarkKey(mailbox))
+	if err != nil {
+		p.API.LogError("Ошибка получения отметки маршрутизации почты", "error", err.Error())
+		return
+	}
+	if lookback := time.Now().Add(-mailRoutingLookback); since.Before(lookback) {
+		since = lookback
+	}
+
+	messages, cursor, err := client.FindRoutedMail(mailbox, since, func(itemID string) bool {
+		return p.isMailMarked(routedMailKey(itemID))
+	})
+	p.recordEndpointResult(user.Id, client, err)
+	if err != nil {
+		p.logExchangeError(user.Id, "Ошибка получения писем общего ящика "+mailbox, err)
+		return
+	}
+
+	// Messages come oldest first so the channels read in the order the mail arrived
+	for _, message := range messages {
+		// The next check starts again from a message that is not routed completely
+		if !p.routeMail(user.Id, client, config.mailRoutingRules, message) && message.ReceivedAt.Before(cursor) {
+			cursor = message.ReceivedAt
+		}
+	}
+
+	if cursor.After(since) {
+		if appErr := p.API.KVSet(mailRoutingWatermarkKey(mailbox), []byte(cursor.UTC().Format(time.RFC3339))); appErr != nil {
+			p.API.LogError("Ошибка сохранения отметки маршрутизации почты", "error", appErr.Error())
+		}
+	}
+}
+
+// routeMail posts the message once into every channel of the matching rules. The message is
+// marked as routed only when all posts succeeded, so a failed channel is retried on the next check.
+// It reports whether the routing is complete.
+func (p *Plugin) routeMail(userID string, client *ExchangeClient, rules []MailRoutingRule, message RoutedMail) bool {
+	var files []routedFile
+	var skipped []string
+	downloaded := false
+
+	complete := true
+	posted := make(map[string]bool)
+	for _, rule := range rules {
+		if !rule.Matches(message) {
+			continue
+		}
+
+		channel, err := p.resolveRoutingChannel(rule.Channel)
+		if err != nil {
+			p.API.LogError("Канал правила маршрутизации почты не найден", "rule", rule.Label(), "error", err.Error())
+			complete = false
+			continue
+		}
+		if posted[channel.Id] {
+			continue
+		}
+		posted[channel.Id] = true
+
+		// Only the check that claims the message for the channel posts it, also across server nodes.
+		// Until the post is confirmed the claim is short, so a node failing in between does not lose it.
+		key := routedMailChannelKey(message.ID, channel.Id)
+		claimed, err := p.claimRoutedMail(key)
+		if err != nil {
+			p.API.LogError("Ошибка сохранения отметки о письме", "rule", rule.Label(), "error", err.Error())
+			complete = false
+			continue
+		}
+		if !claimed {
+			if p.isRoutedMailClaimed(key) {
+				complete = false
+			}
+			continue
+		}
+
+		if !downloaded {
+			files, skipped = p.downloadRoutedAttachments(userID, client, message)
+			downloaded = true
+		}
+
+		if err := p.postRoutedMail(userID, channel.Id, message, files, skipped); err != nil {
+			p.API.LogError("Ошибка публикации письма в канал", "rule", rule.Label(), "channel_id", channel.Id, "error", err.Error())
+			if appErr := p.API.KVDelete(key); appErr != nil {
+				p.API.LogError("Ошибка удаления отметки о письме", "rule", rule.Label(), "error", appErr.Error())
+			}
+			complete = false
+			continue
+		}
+
+		if err := p.confirmRoutedMail(key); err != nil {
+			p.API.LogError("Ошибка сохранения отметки о письме", "rule", rule.Label(), "error", err.Error())
+		}
+	}
+
+	if !complete {
+		return false
+	}
+	if _, err := p.markRoutedMail(routedMailKey(message.ID)); err != nil {
+		p.API.LogError("Ошибка сохранения отметки о письме", "error", err.Error())
+		return false
+	}
+	return true
+}
+
+// resolveRoutingChannel finds the channel of a rule by team-name/channel-name or ID
+func (p *Plugin) resolveRoutingChannel(value string) (*model.Channel, error) {
+	if teamName, channelName, ok := strings.Cut(value, "/"); ok {
+		channel, appErr := p.API.GetChannelByNameForTeamName(strings.TrimPrefix(teamName, "~"), strings.TrimPrefix(channelName, "~"), false)
+		if appErr != nil {
+			return nil, fmt.Errorf("failed to get channel %s: %w", value, appErr)
+		}
+		return channel, nil
+	}
+
+	channel, appErr := p.API.GetChannel(value)
+	if appErr != nil {
+		return nil, fmt.Errorf("failed to get channel %s: %w", value, appErr)
+	}
+	return channel, nil
+}
+
+// routedFile is a downloaded attachment of a routed message
+type routedFile struct {
+	Name    string
+	Content []byte
+}
+
+// downloadRoutedAttachments downloads the file attachments that fit into a post. Inline images
+// belong to the body and are left out; the names of other attachments that could not be
+// attached are returned so the post can list them.
+func (p *Plugin) downloadRoutedAttachments(userID string, client *ExchangeClient, message RoutedMail) ([]routedFile, []string) {
+	maxSize := int64(maxRoutedAttachmentSize)
+	if limit := p.API.GetConfig().FileSettings.MaxFileSize; limit != nil && *limit < maxSize {
+		maxSize = *limit
+	}
+
+	var files []routedFile
+	var skipped []string
+	for _, attachment := range message.Attachments {
+		switch {
+		case attachment.IsInline:
+			continue
+		case attachment.IsItem, attachment.Size > maxSize, len(files) >= maxRoutedAttachments:
+			skipped = append(skipped, attachment.Name)
+			continue
+		}
+
+		content, err := client.GetFileAttachment(attachment.ID)
+		p.recordEndpointResult(userID, client, err)
+		if err != nil {
+			p.logExchangeError(userID, "Ошибка загрузки вложения «"+attachment.Name+"»", err)
+			skipped = append(skipped, attachment.Name)
+			continue
+		}
+
+		files = append(files, routedFile{Name: attachment.Name, Content: content})
+	}
+
+	return files, skipped
+}
+
+// postRoutedMail posts the message into the channel with its attachments as files
+func (p *Plugin) postRoutedMail(userID, channelID string, message RoutedMail, files []routedFile, skipped []string) error {
+	bot, appErr := p.API.GetBot("", true)
+	if appErr != nil {
+		return fmt.Errorf("failed to get bot: %w", appErr)
+	}
+
+	var fileIDs []string
+	for _, file := range files {
+		info, appErr := p.API.UploadFile(file.Content, channelID, file.Name)
+		if appErr != nil {
+			return fmt.Errorf("failed to upload attachment %s: %w", file.Name, appErr)
+		}
+		fileIDs = append(fileIDs, info.Id)
+	}
+
+	post := &model.Post{
+		ChannelId: channelID,
+		UserId:    bot.UserId,
+		Message:   p.formatRoutedMail(userID, message, skipped),
+		FileIds:   fileIDs,
+	}
+
+	if _, appErr := p.API.CreatePost(post); appErr != nil {
+		return fmt.Errorf("failed to create post: %w", appErr)
+	}
+
+	return nil
+}
+
+// formatRoutedMail renders the post of a routed message, shortening the body to the post size limit.
+// Mentions in the text from Exchange are escaped so an email cannot notify the channel.
+func (p *Plugin) formatRoutedMail(userID string, message RoutedMail, skipped []string) string {
+	subject := escapeMentions(message.Subject)
+	if subject == "" {
+		subject = "(без темы)"
+	}
+
+	from := message.FromName
+	switch {
+	case from == "":
+		from = message.FromEmail
+	case message.FromEmail != "" && !strings.EqualFold(from, message.FromEmail):
+		from = fmt.Sprintf("%s <%s>", from, message.FromEmail)
+	}
+	from = escapeMentions(from)
+	if mapping := p.mapMailbox(userID, message.FromEmail, message.FromName); mapping.Username != "" {
+		from = mapping.Mention()
+	}
+
+	header := fmt.Sprintf("📧 **%s**\n", subject)
+	header += fmt.Sprintf("**От:** %s\n", from)
+	if len(message.ToNames) > 0 {
+		header += fmt.Sprintf("**Кому:** %s\n", escapeMentions(strings.Join(message.ToNames, ", ")))
+	}
+	if len(skipped) > 0 {
+		header += fmt.Sprintf("📎 Не удалось приложить: %s\n", escapeMentions(strings.Join(skipped, ", ")))
+	}
+	header = truncateRunes(header, model.PostMessageMaxRunesV2)
+
+	room := model.PostMessageMaxRunesV2 - utf8.RuneCountInString(header) - 1
+	if message.Body == "" || room < 1 {
+		return header
+	}
+
+	return header + "\n" + truncateRunes(escapeMentions(message.Body), room)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFindRoutedMailPagesFromCursor(t *testing.T) {
+	since := time.Date(2024, 7, 1, 9, 0, 0, 0, time.UTC)
+	received := func(i int) time.Time { return since.Add(time.Duration(i+1) * time.Minute) }
+
+	const total = 30
+	offsetPattern := regexp.MustCompile(`Offset="(\d+)"`)
+	idPattern := regexp.MustCompile(`ItemId Id="([^"]+)"`)
+
+	var offsets []int
+	client, _ := newTestEWSClient(t, func(w http.ResponseWriter, r *http.Request) {
+		request, _ := io.ReadAll(r.Body)
+
+		var items strings.Builder
+		if strings.Contains(r.Header.Get("SOAPAction"), "/FindItem") {
+			match := offsetPattern.FindSubmatch(request)
+			if match == nil {
+				t.Errorf("FindItem without an offset: %s", request)
+				return
+			}
+			offset, _ := strconv.Atoi(string(match[1]))
+			offsets = append(offsets, offset)
+
+			last := min(offset+mailPageSize, total)
+			for i := offset; i < last; i++ {
+				fmt.Fprintf(&items, `<t:Message><t:ItemId Id="msg-%d"/><t:DateTimeReceived>%s</t:DateTimeReceived></t:Message>`, i, received(i).Format(time.RFC3339))
+			}
+			fmt.Fprintf(w, `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>
+<m:FindItemResponse xmlns:m="http://schemas.microsoft.com/exchange/services/2006/messages" xmlns:t="http://schemas.microsoft.com/exchange/services/2006/types">
+<m:ResponseMessages><m:FindItemResponseMessage ResponseClass="Success"><m:ResponseCode>NoError</m:ResponseCode>
+<m:RootFolder TotalItemsInView="%d" IncludesLastItemInRange="%t"><t:Items>%s</t:Items></m:RootFolder>
+</m:FindItemResponseMessage></m:ResponseMessages></m:FindItemResponse></s:Body></s:Envelope>`, total, last == total, items.String())
+			return
+		}
+
+		for _, match := range idPattern.FindAllSubmatch(request, -1) {
+			fmt.Fprintf(&items, `<m:GetItemResponseMessage ResponseClass="Success"><m:ResponseCode>NoError</m:ResponseCode><m:Items><t:Message><t:ItemId Id="%s"/></t:Message></m:Items></m:GetItemResponseMessage>`, match[1])
+		}
+		fmt.Fprintf(w, `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>
+<m:GetItemResponse xmlns:m="http://schemas.microsoft.com/exchange/services/2006/messages" xmlns:t="http://schemas.microsoft.com/exchange/services/2006/types">
+<m:ResponseMessages>%s</m:ResponseMessages></m:GetItemResponse></s:Body></s:Envelope>`, items.String())
+	})
+
+	messages, cursor, err := client.FindRoutedMail("duty@contoso.com", since, func(itemID string) bool {
+		return itemID == "msg-3"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(offsets) != 2 || offsets[0] != 0 || offsets[1] != mailPageSize {
+		t.Errorf("FindItem offsets = %v, want [0 %d]", offsets, mailPageSize)
+	}
+	if len(messages) != total-1 {
+		t.Fatalf("got %d messages, want %d", len(messages), total-1)
+	}
+	if messages[0].ID != "msg-0" || messages[len(messages)-1].ID != fmt.Sprintf("msg-%d", total-1) {
+		t.Errorf("messages are not oldest first: %s … %s", messages[0].ID, messages[len(messages)-1].ID)
+	}
+	if !cursor.Equal(received(total - 1)) {
+		t.Errorf("cursor = %s, want %s", cursor, received(total-1))
+	}
+}
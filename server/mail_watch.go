@@ -0,0 +1,708 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// mailPageSize is the number of matching Inbox messages requested per FindItem page
+const mailPageSize = 25
+
+// maxMailPagesPerCheck limits the pages read per check; the rest follows on the next checks
+const maxMailPagesPerCheck = 4
+
+// maxWatchedSenders limits the sender allowlist of one user
+const maxWatchedSenders = 50
+
+// notifiedMailTTL is how long an announced message is remembered
+const notifiedMailTTL = 30 * 24 * time.Hour
+
+// mailPreviewRunes is the length of the message text shown in a notification
+const mailPreviewRunes = 300
+
+// senderSMTPAddressTag is PR_SENDER_SMTP_ADDRESS; unlike message:From it can be used in
+// restrictions and holds the SMTP address for internal senders too
+const senderSMTPAddressTag = "0x5D01"
+
+// MailWatch is the user's opt-in for notifications about new unread mail.
+// A message is announced if it has high importance (when Important is set) or comes from
+// one of Senders, which are email addresses or domains written as @example.com.
+// A domain matches the end of the sender address only, so subdomains do not match.
+type MailWatch struct {
+	Enabled   bool      `json:"enabled"`
+	Important bool      `json:"important"`
+	Senders   []string  `json:"senders"`
+	Since     time.Time `json:"since"` // Messages received earlier are never announced
+}
+
+// MailMessage is an Inbox message announced to the user
+type MailMessage struct {
+	ID             string    `json:"id"`
+	Subject        string    `json:"subject"`
+	FromName       string    `json:"from_name"`
+	FromEmail      string    `json:"from_email"`
+	Importance     string    `json:"importance"` // Low, Normal, High
+	ReceivedAt     time.Time `json:"received_at"`
+	Preview        string    `json:"preview"`
+	HasAttachments bool      `json:"has_attachments"`
+}
+
+// Matches reports whether the message is announced by the watch. Exchange can only match a
+// domain as a substring, so the restriction also returns e.g. x@example.com.attacker.org;
+// this check drops them.
+func (w MailWatch) Matches(message MailMessage) bool {
+	if w.Important && message.Importance == "High" {
+		return true
+	}
+
+	address := strings.ToLower(message.FromEmail)
+	for _, sender := range w.Senders {
+		if strings.HasPrefix(sender, "@") {
+			if strings.HasSuffix(address, sender) {
+				return true
+			}
+		} else if address == sender {
+			return true
+		}
+	}
+	return false
+}
+
+// mailWatchRestriction selects unread messages received after since that match the watch
+func mailWatchRestriction(watch MailWatch, since time.Time) *Restriction {
+	var matches SearchExpressions
+	if watch.Important {
+		matches.IsEqualTo = append(matches.IsEqualTo, IsEqualTo{
+			FieldURI:           &FieldURI{FieldURI: "item:Importance"},
+			FieldURIOrConstant: &FieldURIOrConstant{Constant: &Constant{Value: "High"}},
+		})
+	}
+	for _, sender := range watch.Senders {
+		mode := "FullString"
+		if strings.HasPrefix(sender, "@") {
+			mode = "Substring"
+		}
+		matches.Contains = append(matches.Contains, Contains{
+			ContainmentMode:       mode,
+			ContainmentComparison: "IgnoreCase",
+			ExtendedFieldURI:      &ExtendedFieldURI{PropertyTag: senderSMTPAddressTag, PropertyType: "String"},
+			Constant:              &Constant{Value: sender},
+		})
+	}
+
+	return &Restriction{
+		And: &SearchExpressions{
+			IsEqualTo: []IsEqualTo{{
+				FieldURI:           &FieldURI{FieldURI: "message:IsRead"},
+				FieldURIOrConstant: &FieldURIOrConstant{Constant: &Constant{Value: "false"}},
+			}},
+			IsGreaterThan: []IsEqualTo{{
+				FieldURI:           &FieldURI{FieldURI: "item:DateTimeReceived"},
+				FieldURIOrConstant: &FieldURIOrConstant{Constant: &Constant{Value: since.UTC().Format(time.RFC3339)}},
+			}},
+			Or: []SearchExpressions{matches},
+		},
+	}
+}
+
+// FindWatchedMail returns the unread Inbox messages matching the watch that were received
+// after since, oldest first. It reads up to maxMailPagesPerCheck pages and returns the
+// receive time of the last message examined, from which the next check continues.
+// Messages for which seen returns true are skipped before their details are fetched.
+func (c *ExchangeClient) FindWatchedMail(watch MailWatch, since time.Time, seen func(itemID string) bool) ([]MailMessage, time.Time, error) {
+	// Messages received in the same second as the last one examined are read again; seen skips them
+	itemIDs, cursor, err := c.findNewMail("", mailWatchRestriction(watch, since.Add(-time.Second)), since, seen)
+	if err != nil {
+		return nil, since, err
+	}
+
+	if len(itemIDs) == 0 {
+		return []MailMessage{}, cursor, nil
+	}
+
+	messages, err := c.getMailMessages(itemIDs)
+	if err != nil {
+		return nil, since, err
+	}
+
+	matching := make([]MailMessage, 0, len(messages))
+	for _, message := range messages {
+		if watch.Matches(message) {
+			matching = append(matching, message)
+		}
+	}
+
+	return matching, cursor, nil
+}
+
+// findNewMail pages through the Inbox messages of the mailbox matching the restriction, oldest
+// first, and returns those seen has not marked with the receive time of the last message examined
+func (c *ExchangeClient) findNewMail(mailbox string, restriction *Restriction, since time.Time, seen func(itemID string) bool) ([]ItemId, time.Time, error) {
+	cursor := since
+
+	var itemIDs []ItemId
+	for page := 0; page < maxMailPagesPerCheck; page++ {
+		items, last, err := c.findMailPage(mailbox, restriction, page*mailPageSize)
+		if err != nil {
+			return nil, since, err
+		}
+
+		for _, item := range items {
+			if received, err := parseEWSDateTime(item.DateTimeReceived, c.timeLocation()); err == nil && received.After(cursor) {
+				cursor = received
+			}
+			if !seen(item.ItemId.Id) {
+				itemIDs = append(itemIDs, ItemId{Id: item.ItemId.Id})
+			}
+		}
+
+		if last {
+			break
+		}
+	}
+
+	return itemIDs, cursor, nil
+}
+
+// findMailPage returns a page of the Inbox items of the mailbox matching the restriction,
+// oldest first, and whether it is the last page. Meeting requests are returned with their
+// ID and receive time only.
+func (c *ExchangeClient) findMailPage(mailbox string, restriction *Restriction, offset int) ([]MessageItem, bool, error) {
+	soapResp, err := c.sendSOAPRequest("FindItem", SOAPBody{
+		FindItem: &FindItem{
+			Traversal: "Shallow",
+			ItemShape: &ItemShape{
+				BaseShape: "IdOnly",
+				AdditionalProperties: &AdditionalProperties{
+					FieldURI: []FieldURI{{FieldURI: "item:DateTimeReceived"}},
+				},
+			},
+			IndexedPageItemView: &IndexedPageItemView{
+				MaxEntriesReturned: strconv.Itoa(mailPageSize),
+				Offset:             strconv.Itoa(offset),
+				BasePoint:          "Beginning",
+			},
+			Restriction: restriction,
+			SortOrder: &SortOrder{
+				FieldOrder: []FieldOrder{{
+					Order:    "Ascending",
+					FieldURI: &FieldURI{FieldURI: "item:DateTimeReceived"},
+				}},
+			},
+			ParentFolderIds: &ParentFolderIds{
+				DistinguishedFolderId: distinguishedFolder("inbox", mailbox),
+			},
+		},
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	if soapResp.Body.FindItemResponse == nil ||
+		soapResp.Body.FindItemResponse.ResponseMessages == nil ||
+		soapResp.Body.FindItemResponse.ResponseMessages.FindItemResponseMessage == nil {
+		return nil, false, fmt.Errorf("empty FindItem response")
+	}
+
+	responseMessage := soapResp.Body.FindItemResponse.ResponseMessages.FindItemResponseMessage
+	if err := responseMessage.Err(); err != nil {
+		c.throttled(err)
+		return nil, false, err
+	}
+
+	if responseMessage.RootFolder == nil || responseMessage.RootFolder.Items == nil {
+		return nil, true, nil
+	}
+
+	items := responseMessage.RootFolder.Items.Message
+	for _, request := range responseMessage.RootFolder.Items.MeetingRequest {
+		items = append(items, MessageItem{ItemId: request.ItemId, DateTimeReceived: request.DateTimeReceived})
+	}
+	last := responseMessage.RootFolder.IncludesLastItemInRange != "false" || len(items) < mailPageSize
+	return items, last, nil
+}
+
+// getMailMessages fetches messages with their text body with a single GetItem request.
+// Messages deleted in the meantime are skipped.
+func (c *ExchangeClient) getMailMessages(itemIDs []ItemId) ([]MailMessage, error) {
+	soapResp, err := c.sendSOAPRequest("GetItem", SOAPBody{
+		GetItem: &GetItem{
+			ItemShape: &ItemShape{
+				BaseShape: "IdOnly",
+				BodyType:  "Text",
+				AdditionalProperties: &AdditionalProperties{
+					FieldURI: []FieldURI{
+						{FieldURI: "item:Subject"},
+						{FieldURI: "item:Body"},
+						{FieldURI: "item:Importance"},
+						{FieldURI: "item:DateTimeReceived"},
+						{FieldURI: "item:HasAttachments"},
+						{FieldURI: "message:From"},
+						{FieldURI: "message:IsRead"},
+					},
+					ExtendedFieldURI: []ExtendedFieldURI{
+						{PropertyTag: senderSMTPAddressTag, PropertyType: "String"},
+					},
+				},
+			},
+			ItemIds: &ItemIds{ItemId: itemIDs},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if soapResp.Body.GetItemResponse == nil || soapResp.Body.GetItemResponse.ResponseMessages == nil {
+		return nil, fmt.Errorf("empty GetItem response")
+	}
+
+	messages := make([]MailMessage, 0, len(itemIDs))
+	for _, message := range soapResp.Body.GetItemResponse.ResponseMessages.GetItemResponseMessage {
+		if err := message.Err(); err != nil {
+			if errors.Is(err, ErrItemNotFound) {
+				continue
+			}
+			c.throttled(err)
+			return nil, err
+		}
+		if message.Items == nil {
+			continue
+		}
+
+		for _, item := range message.Items.Message {
+			messages = append(messages, c.convertToMailMessage(item))
+		}
+	}
+
+	return messages, nil
+}
+
+// convertToMailMessage converts an EWS message to MailMessage
+func (c *ExchangeClient) convertToMailMessage(item MessageItem) MailMessage {
+	message := MailMessage{
+		ID:             item.ItemId.Id,
+		Subject:        item.Subject,
+		Importance:     item.Importance,
+		HasAttachments: item.HasAttachments == "true",
+	}
+	if item.From != nil && item.From.Mailbox != nil {
+		message.FromName = item.From.Mailbox.Name
+		message.FromEmail = item.From.Mailbox.EmailAddress
+	}
+	// Internal senders may come with a legacy DN address; the property holds their SMTP address
+	for _, property := range item.ExtendedProperty {
+		if strings.EqualFold(property.ExtendedFieldURI.PropertyTag, senderSMTPAddressTag) && property.Value != "" {
+			message.FromEmail = property.Value
+		}
+	}
+	if received, err := parseEWSDateTime(item.DateTimeReceived, c.timeLocation()); err == nil {
+		message.ReceivedAt = received
+	}
+	if item.Body != nil {
+		message.Preview = mailPreview(item.Body.Value)
+	}
+	return message
+}
+
+// mailPreview shortens a text body to the first mailPreviewRunes characters on a single paragraph
+func mailPreview(body string) string {
+	text := strings.Join(strings.Fields(body), " ")
+	runes := []rune(text)
+	if len(runes) <= mailPreviewRunes {
+		return text
+	}
+	return strings.TrimSpace(string(runes[:mailPreviewRunes])) + "…"
+}
+
+// MarkMailRead marks an Inbox message as read
+func (c *ExchangeClient) MarkMailRead(itemID string) error {
+	isRead := true
+	soapResp, err := c.sendSOAPRequest("UpdateItem", SOAPBody{
+		UpdateItem: &UpdateItem{
+			MessageDisposition: "SaveOnly",
+			ConflictResolution: "AlwaysOverwrite",
+			ItemChanges: &ItemChanges{
+				ItemChange: []ItemChange{{
+					ItemId: &ItemId{Id: itemID},
+					Updates: &ItemUpdates{
+						SetItemField: []SetItemField{{
+							FieldURI: &FieldURI{FieldURI: "message:IsRead"},
+							Message:  &MessageUpdate{IsRead: &isRead},
+						}},
+					},
+				}},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if soapResp.Body.UpdateItemResponse == nil ||
+		soapResp.Body.UpdateItemResponse.ResponseMessages == nil ||
+		len(soapResp.Body.UpdateItemResponse.ResponseMessages.UpdateItemResponseMessage) == 0 {
+		return fmt.Errorf("empty UpdateItem response")
+	}
+
+	responseMessage := soapResp.Body.UpdateItemResponse.ResponseMessages.UpdateItemResponseMessage[0]
+	if err := responseMessage.Err(); err != nil {
+		c.throttled(err)
+		return err
+	}
+
+	return nil
+}
+
+// getMailWatch returns the user's mail notification settings
+func (p *Plugin) getMailWatch(userID string) (MailWatch, error) {
+	data, appErr := p.API.KVGet(fmt.Sprintf("exchange_mail_watch_%s", userID))
+	if appErr != nil {
+		return MailWatch{}, fmt.Errorf("failed to get mail watch: %w", appErr)
+	}
+
+	watch := MailWatch{Important: true, Senders: []string{}}
+	if data == nil {
+		return watch, nil
+	}
+
+	if err := json.Unmarshal(data, &watch); err != nil {
+		return MailWatch{}, fmt.Errorf("failed to unmarshal mail watch: %w", err)
+	}
+
+	return watch, nil
+}
+
+// storeMailWatch stores the user's mail notification settings.
+// Turning notifications on starts them from now, so the existing unread mail is not announced.
+func (p *Plugin) storeMailWatch(userID string, watch MailWatch) error {
+	if watch.Enabled && watch.Since.IsZero() {
+		watch.Since = time.Now().UTC()
+	}
+	if !watch.Enabled {
+		watch.Since = time.Time{}
+	}
+
+	data, err := json.Marshal(watch)
+	if err != nil {
+		return fmt.Errorf("failed to marshal mail watch: %w", err)
+	}
+
+	if appErr := p.API.KVSet(fmt.Sprintf("exchange_mail_watch_%s", userID), data); appErr != nil {
+		return fmt.Errorf("failed to store mail watch: %w", appErr)
+	}
+
+	return nil
+}
+
+// normalizeWatchedSender turns an email address, a domain (@example.com) or an @username
+// into a lowercase allowlist entry
+func (p *Plugin) normalizeWatchedSender(value string) (string, bool) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if strings.HasPrefix(value, "@") {
+		if user, appErr := p.API.GetUserByUsername(strings.TrimPrefix(value, "@")); appErr == nil && !user.IsBot {
+			email, _ := p.mailboxForUser(user)
+			return email, email != ""
+		}
+		return value, strings.Contains(value, ".") && !strings.Contains(value[1:], "@")
+	}
+	return value, isSMTPAddress(value)
+}
+
+// notifiedMailKey returns the KV key marking an announced message
+func notifiedMailKey(userID, itemID string) string {
+	return fmt.Sprintf("exchange_mail_notified_%s_%s", userID, keyHash(itemID))
+}
+
+// mailCursorKey returns the KV key of the receive time up to which the user's Inbox was checked
+func mailCursorKey(userID string) string {
+	return fmt.Sprintf("exchange_mail_cursor_%s", userID)
+}
+
+// getMailCursor returns the receive time the next check continues from, or zero before the first check
+func (p *Plugin) getMailCursor(userID string) time.Time {
+	data, appErr := p.API.KVGet(mailCursorKey(userID))
+	if appErr != nil || data == nil {
+		return time.Time{}
+	}
+
+	cursor, err := time.Parse(time.RFC3339, string(data))
+	if err != nil {
+		return time.Time{}
+	}
+	return cursor
+}
+
+// storeMailCursor saves the receive time the next check continues from
+func (p *Plugin) storeMailCursor(userID string, cursor time.Time) {
+	if appErr := p.API.KVSet(mailCursorKey(userID), []byte(cursor.UTC().Format(time.RFC3339))); appErr != nil {
+		p.API.LogError("Ошибка сохранения позиции проверки почты", "user_id", userID, "error", appErr.Error())
+	}
+}
+
+// isMailNotified reports whether the message has already been claimed
+func (p *Plugin) isMailNotified(userID, itemID string) bool {
+	data, appErr := p.API.KVGet(notifiedMailKey(userID, itemID))
+	return appErr == nil && data != nil
+}
+
+// claimMail marks the message as announced. It returns false if another check has claimed it first.
+func (p *Plugin) claimMail(userID, itemID string) (bool, error) {
+	claimed, appErr := p.API.KVSetWithOptions(notifiedMailKey(userID, itemID), []byte(time.Now().UTC().Format(time.RFC3339)), model.PluginKVSetOptions{
+		Atomic:          true,
+		OldValue:        nil,
+		ExpireInSeconds: int64(notifiedMailTTL / time.Second),
+	})
+	if appErr != nil {
+		return false, fmt.Errorf("failed to claim message: %w", appErr)
+	}
+
+	return claimed, nil
+}
+
+// checkMailNotifications announces new watched mail of all users who opted in
+func (p *Plugin) checkMailNotifications() {
+	config := p.getConfiguration()
+	if !config.EnableMailNotifications {
+		return
+	}
+
+	users, err := p.API.GetUsers(&model.UserGetOptions{
+		Page:    0,
+		PerPage: 1000,
+	})
+	if err != nil {
+		p.API.LogError("Ошибка получения пользователей для уведомлений о почте", "error", err.Error())
+		return
+	}
+
+	subscriptions := config.EWSSubscriptionMode == SubscriptionModePull || config.EWSSubscriptionMode == SubscriptionModeStreaming
+
+	p.forEachUser(users, func(userID string) {
+		// Subscribed users are checked as soon as their inbox changes
+		if !subscriptions || !p.subscriptionManager.IsSubscribed(userID) {
+			p.checkUserMailNotifications(userID)
+		}
+	})
+}
+
+// checkUserMailNotifications announces the user's new watched mail
+func (p *Plugin) checkUserMailNotifications(userID string) {
+	watch, err := p.getMailWatch(userID)
+	if err != nil {
+		p.API.LogError("Ошибка получения настроек уведомлений о почте", "user_id", userID, "error", err.Error())
+		return
+	}
+	if !watch.Enabled || (!watch.Important && len(watch.Senders) == 0) {
+		return
+	}
+
+	credentials, err := p.getUserExchangeCredentials(userID)
+	if err != nil {
+		return
+	}
+
+	client, err := p.newUserExchangeClient(userID, credentials)
+	if err != nil {
+		p.logExchangeError(userID, "Ошибка подключения к Exchange", err)
+		return
+	}
+
+	since := watch.Since
+	if cursor := p.getMailCursor(userID); cursor.After(since) {
+		since = cursor
+	}
+
+	messages, cursor, err := client.FindWatchedMail(watch, since, func(itemID string) bool {
+		return p.isMailNotified(userID, itemID)
+	})
+	p.recordEndpointResult(userID, client, err)
+	if err != nil {
+		p.logExchangeError(userID, "Ошибка получения новых писем", err)
+		return
+	}
+
+	// Messages come oldest first so the DM reads in the order the mail arrived
+	for _, message := range messages {
+		// Only the check that claims a message announces it, also across server nodes
+		claimed, err := p.claimMail(userID, message.ID)
+		if err != nil {
+			p.API.LogError("Ошибка сохранения отметки о письме", "user_id", userID, "error", err.Error())
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		if err := p.sendMailNotification(userID, message); err != nil {
+			p.API.LogError("Ошибка отправки уведомления о письме", "user_id", userID, "error", err.Error())
+			if appErr := p.API.KVDelete(notifiedMailKey(userID, message.ID)); appErr != nil {
+				p.API.LogError("Ошибка удаления отметки о письме", "user_id", userID, "error", appErr.Error())
+			}
+			// The next check starts again from this message
+			if message.ReceivedAt.Before(cursor) {
+				cursor = message.ReceivedAt
+			}
+		}
+	}
+
+	if cursor.After(since) {
+		p.storeMailCursor(userID, cursor)
+	}
+}
+
+// sendMailNotification sends a preview of a new message with Mark read and Open in OWA buttons
+func (p *Plugin) sendMailNotification(userID string, message MailMessage) error {
+	bot, appErr := p.API.GetBot("", true)
+	if appErr != nil {
+		return fmt.Errorf("failed to get bot: %w", appErr)
+	}
+
+	channel, appErr := p.API.GetDirectChannel(userID, bot.UserId)
+	if appErr != nil {
+		return fmt.Errorf("failed to get direct channel: %w", appErr)
+	}
+
+	post := &model.Post{
+		ChannelId: channel.Id,
+		UserId:    bot.UserId,
+		Message:   p.formatMailNotification(userID, message),
+	}
+	post.AddProp("attachments", []*model.SlackAttachment{{Actions: mailActions(message.ID, true)}})
+
+	if _, appErr := p.API.CreatePost(post); appErr != nil {
+		return fmt.Errorf("failed to create post: %w", appErr)
+	}
+
+	return nil
+}
+
+// formatMailNotification renders the notification text of a message in the user's time zone.
+// Mentions in the text from the sender are escaped.
+func (p *Plugin) formatMailNotification(userID string, message MailMessage) string {
+	title := "✉️ **Новое письмо**"
+	if message.Importance == "High" {
+		title = "❗ **Новое важное письмо**"
+	}
+
+	from := escapeMentions(message.FromName)
+	if from == "" {
+		from = message.FromEmail
+	}
+	if mapping := p.mapMailbox(userID, message.FromEmail, message.FromName); mapping.Username != "" {
+		from = mapping.Mention()
+	}
+
+	subject := escapeMentions(message.Subject)
+	if subject == "" {
+		subject = "(без темы)"
+	}
+
+	text := title + "\n\n"
+	text += fmt.Sprintf("**От:** %s\n", from)
+	text += fmt.Sprintf("**Тема:** %s\n", subject)
+	text += fmt.Sprintf("**Получено:** %s\n", message.ReceivedAt.In(p.getUserLocation(userID)).Format("02.01.2006 15:04"))
+	if message.HasAttachments {
+		text += "📎 Есть вложения\n"
+	}
+	if message.Preview != "" {
+		text += "\n> " + escapeMentions(message.Preview) + "\n"
+	}
+	return text
+}
+
+// mailActions returns the buttons of a mail notification; Mark read is left out once the message is read
+func mailActions(itemID string, unread bool) []*model.PostAction {
+	var actions []*model.PostAction
+	if unread {
+		actions = append(actions, &model.PostAction{
+			Id:   "mark_mail_read",
+			Name: "✅ Отметить прочитанным",
+			Type: "button",
+			Integration: &model.PostActionIntegration{
+				URL:     "/plugins/com.mattermost.exchange-plugin/api/v1/mail/read",
+				Context: map[string]interface{}{"item_id": itemID},
+			},
+		})
+	}
+	return append(actions, &model.PostAction{
+		Id:   "open_mail",
+		Name: "🌐 Открыть в OWA",
+		Type: "button",
+		Integration: &model.PostActionIntegration{
+			URL:     "/plugins/com.mattermost.exchange-plugin/api/v1/mail/open",
+			Context: map[string]interface{}{"item_id": itemID},
+		},
+	})
+}
+
+// markMailRead marks the message read in Exchange
+func (p *Plugin) markMailRead(userID string, credentials *ExchangeCredentials, itemID string) error {
+	client, err := p.newUserExchangeClient(userID, credentials)
+	if err != nil {
+		return err
+	}
+
+	err = client.MarkMailRead(itemID)
+	p.recordEndpointResult(userID, client, err)
+
+	return err
+}
+
+// mailOWAURL returns the Outlook Web App link to a message of the user's mailbox
+func (p *Plugin) mailOWAURL(userID string, credentials *ExchangeCredentials, itemID string) (string, error) {
+	client, err := p.newUserExchangeClient(userID, credentials)
+	if err != nil {
+		return "", err
+	}
+
+	ewsURL := client.EWSURL()
+	if ewsURL == "" {
+		ewsURL = p.getConfiguration().ExchangeServerURL
+	}
+
+	return owaItemURL(ewsURL, itemID, "ReadMessageItem"), nil
+}
+
+// markMailNotificationRead notes in the notification post that the message was read and drops the Mark read button.
+// Only the bot's notification of the message in the user's direct channel is changed.
+func (p *Plugin) markMailNotificationRead(userID, postID, itemID string) (*model.Post, error) {
+	post, err := p.getBotDirectPost(userID, postID, "item_id", itemID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mail notification post: %w", err)
+	}
+
+	post.Message += "\n✅ Отмечено как прочитанное"
+	post.AddProp("attachments", []*model.SlackAttachment{{Actions: mailActions(itemID, false)}})
+
+	updated, appErr := p.API.UpdatePost(post)
+	if appErr != nil {
+		return nil, fmt.Errorf("failed to update mail notification post: %w", appErr)
+	}
+
+	return updated, nil
+}
+
+// formatMailWatch describes the user's mail notification settings for /exchange mail
+func formatMailWatch(watch MailWatch) string {
+	if !watch.Enabled {
+		return "✉️ Уведомления о новых письмах выключены.\n"
+	}
+
+	text := "✉️ **Уведомления о новых непрочитанных письмах включены:**\n\n"
+	if watch.Important {
+		text += "- письма с высокой важностью\n"
+	}
+	if len(watch.Senders) > 0 {
+		text += "- письма от: " + strings.Join(watch.Senders, ", ") + "\n"
+	}
+	if !watch.Important && len(watch.Senders) == 0 {
+		text += "⚠️ Не выбрано ни одного условия — уведомления не будут приходить.\n"
+	}
+	return text
+}
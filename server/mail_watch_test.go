@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+func TestMailWatchMatches(t *testing.T) {
+	watch := MailWatch{
+		Important: true,
+		Senders:   []string{"@example.com", "boss@contoso.com"},
+	}
+
+	tests := []struct {
+		name    string
+		message MailMessage
+		want    bool
+	}{
+		{"domain", MailMessage{FromEmail: "x@example.com"}, true},
+		{"domain in upper case", MailMessage{FromEmail: "X@Example.COM"}, true},
+		{"lookalike domain", MailMessage{FromEmail: "x@example.com.attacker.org"}, false},
+		{"domain as local part", MailMessage{FromEmail: "example.com@attacker.org"}, false},
+		{"subdomain", MailMessage{FromEmail: "x@mail.example.com"}, false},
+		{"address", MailMessage{FromEmail: "boss@contoso.com"}, true},
+		{"address with prefix", MailMessage{FromEmail: "notboss@contoso.com"}, false},
+		{"important", MailMessage{FromEmail: "x@fabrikam.com", Importance: "High"}, true},
+		{"other sender", MailMessage{FromEmail: "x@fabrikam.com", Importance: "Normal"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := watch.Matches(tt.message); got != tt.want {
+				t.Errorf("Matches(%q) = %v, want %v", tt.message.FromEmail, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatMailNotificationEscapesMentions(t *testing.T) {
+	p, _ := newTestPlugin()
+
+	text := p.formatMailNotification("jdoeuserid", MailMessage{
+		Subject:   "@channel срочно",
+		FromName:  "@all",
+		FromEmail: "x@fabrikam.com",
+		Preview:   "Привет, @here",
+	})
+
+	for _, mention := range []string{"@channel", "@all", "@here"} {
+		if strings.Contains(text, mention) {
+			t.Errorf("%s not escaped: %q", mention, text)
+		}
+	}
+}
+
+func TestMarkMailNotificationReadOnlyChangesOwnNotification(t *testing.T) {
+	const userID = "jdoeuserid"
+	dm := model.GetDMNameFromIds(userID, testBotUserID)
+
+	tests := []struct {
+		name      string
+		author    string
+		channelID string
+		itemID    string
+		valid     bool
+	}{
+		{"own notification", testBotUserID, dm, "AAMkMail=", true},
+		{"post of another user", "asmithuserid", dm, "AAMkMail=", false},
+		{"bot post in a shared channel", testBotUserID, "townsquareid", "AAMkMail=", false},
+		{"notification of another message", testBotUserID, dm, "AAMkOther=", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, api := newTestPlugin()
+			api.addActionPost("postid", tt.author, tt.channelID, "item_id", tt.itemID)
+
+			post, err := p.markMailNotificationRead(userID, "postid", "AAMkMail=")
+			if !tt.valid {
+				if err == nil || len(api.updated) != 0 {
+					t.Errorf("post changed: err %v, %d updates", err, len(api.updated))
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(post.Message, "Отмечено как прочитанное") {
+				t.Errorf("unexpected post: %q", post.Message)
+			}
+		})
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// mailboxOverridesKey stores the mailboxes an administrator assigned to Mattermost users
+const mailboxOverridesKey = "exchange_mailbox_overrides"
+
+// resolvedMailboxCacheTTL is how long a ResolveNames result is cached
+const resolvedMailboxCacheTTL = 24 * time.Hour
+
+// Sources of a mailbox mapping
+const (
+	mappingSourceOverride     = "override"
+	mappingSourceEmail        = "email"
+	mappingSourceResolveNames = "resolve_names"
+)
+
+// MailboxMapping links an Exchange mailbox to a Mattermost account.
+// UserID is empty when the mailbox belongs to nobody in Mattermost, e.g. an external attendee.
+type MailboxMapping struct {
+	Email    string `json:"email"`
+	Name     string `json:"name,omitempty"`
+	UserID   string `json:"user_id,omitempty"`
+	Username string `json:"username,omitempty"`
+	Source   string `json:"source,omitempty"` // override, email, resolve_names
+}
+
+// Mention returns an @mention of the Mattermost user, or the mailbox name if there is none
+func (m MailboxMapping) Mention() string {
+	switch {
+	case m.Username != "":
+		return "@" + m.Username
+	case m.Name != "":
+		return m.Name
+	default:
+		return m.Email
+	}
+}
+
+// ResolveNames returns the mailboxes of the directory matching an address or a name.
+// No match is not an error; several matches are all returned.
+func (c *ExchangeClient) ResolveNames(entry string) ([]Mailbox, error) {
+	soapResp, err := c.sendSOAPRequest("ResolveNames", SOAPBody{
+		ResolveNames: &ResolveNames{
+			ReturnFullContactData: false,
+			SearchScope:           "ActiveDirectory",
+			UnresolvedEntry:       entry,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if soapResp.Body.ResolveNamesResponse == nil ||
+		soapResp.Body.ResolveNamesResponse.ResponseMessages == nil ||
+		soapResp.Body.ResolveNamesResponse.ResponseMessages.ResolveNamesResponseMessage == nil {
+		return nil, fmt.Errorf("empty ResolveNames response")
+	}
+
+	responseMessage := soapResp.Body.ResolveNamesResponse.ResponseMessages.ResolveNamesResponseMessage
+	switch responseMessage.ResponseCode {
+	case "ErrorNameResolutionNoResults":
+		return []Mailbox{}, nil
+	case "ErrorNameResolutionMultipleResults":
+		// A warning: the candidates are still returned
+	default:
+		if err := responseMessage.Err(); err != nil {
+			c.throttled(err)
+			return nil, err
+		}
+	}
+
+	mailboxes := []Mailbox{}
+	if responseMessage.ResolutionSet != nil {
+		for _, resolution := range responseMessage.ResolutionSet.Resolution {
+			if resolution.Mailbox != nil && resolution.Mailbox.EmailAddress != "" {
+				mailboxes = append(mailboxes, *resolution.Mailbox)
+			}
+		}
+	}
+
+	return mailboxes, nil
+}
+
+// getMailboxOverrides returns the administrator's mapping of lowercase usernames to mailboxes
+func (p *Plugin) getMailboxOverrides() (map[string]string, error) {
+	data, appErr := p.API.KVGet(mailboxOverridesKey)
+	if appErr != nil {
+		return nil, fmt.Errorf("failed to get mailbox overrides: %w", appErr)
+	}
+
+	overrides := make(map[string]string)
+	if data == nil {
+		return overrides, nil
+	}
+
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal mailbox overrides: %w", err)
+	}
+
+	return overrides, nil
+}
+
+// storeMailboxOverrides stores the administrator's mapping of usernames to mailboxes
+func (p *Plugin) storeMailboxOverrides(overrides map[string]string) error {
+	data, err := json.Marshal(overrides)
+	if err != nil {
+		return fmt.Errorf("failed to marshal mailbox overrides: %w", err)
+	}
+
+	if appErr := p.API.KVSet(mailboxOverridesKey, data); appErr != nil {
+		return fmt.Errorf("failed to store mailbox overrides: %w", appErr)
+	}
+
+	return nil
+}
+
+// setMailboxOverride assigns a mailbox to a Mattermost user; an empty email removes the override
+func (p *Plugin) setMailboxOverride(username, email string) error {
+	overrides, err := p.getMailboxOverrides()
+	if err != nil {
+		return err
+	}
+
+	username = strings.ToLower(strings.TrimPrefix(username, "@"))
+	if email == "" {
+		delete(overrides, username)
+	} else {
+		overrides[username] = strings.ToLower(email)
+	}
+
+	return p.storeMailboxOverrides(overrides)
+}
+
+// mailboxForUser returns the mailbox of a Mattermost user: the override if there is one, else the user's email
+func (p *Plugin) mailboxForUser(user *model.User) (string, string) {
+	overrides, err := p.getMailboxOverrides()
+	if err != nil {
+		p.API.LogError("Ошибка получения сопоставлений почтовых ящиков", "error", err.Error())
+	} else if email, ok := overrides[strings.ToLower(user.Username)]; ok {
+		return email, mappingSourceOverride
+	}
+
+	return strings.ToLower(user.Email), mappingSourceEmail
+}
+
+// canSeeEmails reports whether the user may see the email addresses of other users: Mattermost
+// shows them to everybody, or the user is a system administrator
+func (p *Plugin) canSeeEmails(userID string) bool {
+	if show := p.API.GetConfig().PrivacySettings.ShowEmailAddress; show != nil && *show {
+		return true
+	}
+	return p.API.HasPermissionTo(userID, model.PermissionManageSystem)
+}
+
+// mapMailbox finds the Mattermost user of a mailbox address. It checks the overrides and
+// the users' emails first, then asks Exchange with ResolveNames for the primary address,
+// which helps with aliases and legacy DN addresses of internal organizers.
+// userID is the user whose credentials are used for ResolveNames.
+func (p *Plugin) mapMailbox(userID, email, name string) MailboxMapping {
+	mapping := MailboxMapping{Email: strings.ToLower(email), Name: name}
+
+	if isSMTPAddress(email) && p.mapMattermostUser(&mapping) {
+		return mapping
+	}
+
+	resolved, ok := p.resolvePrimaryAddress(userID, email, name)
+	if !ok || strings.EqualFold(resolved, mapping.Email) {
+		return mapping
+	}
+
+	mapping.Email = resolved
+	if p.mapMattermostUser(&mapping) && mapping.Source == mappingSourceEmail {
+		mapping.Source = mappingSourceResolveNames
+	}
+
+	return mapping
+}
+
+// mapMattermostUser fills in the Mattermost user of mapping.Email and reports whether there is one
+func (p *Plugin) mapMattermostUser(mapping *MailboxMapping) bool {
+	overrides, err := p.getMailboxOverrides()
+	if err != nil {
+		p.API.LogError("Ошибка получения сопоставлений почтовых ящиков", "error", err.Error())
+	}
+	for username, email := range overrides {
+		if email != mapping.Email {
+			continue
+		}
+		if user, appErr := p.API.GetUserByUsername(username); appErr == nil && user.DeleteAt == 0 {
+			mapping.setUser(user, mappingSourceOverride)
+			return true
+		}
+	}
+
+	user, appErr := p.API.GetUserByEmail(mapping.Email)
+	if appErr != nil || user.IsBot || user.DeleteAt != 0 {
+		return false
+	}
+
+	// A user whose mailbox was overridden does not own the address of their Mattermost account
+	if email, ok := overrides[strings.ToLower(user.Username)]; ok && email != mapping.Email {
+		return false
+	}
+
+	mapping.setUser(user, mappingSourceEmail)
+	return true
+}
+
+// setUser links the mapping to the Mattermost user
+func (m *MailboxMapping) setUser(user *model.User, source string) {
+	m.UserID, m.Username, m.Source = user.Id, user.Username, source
+	if m.Name == "" {
+		m.Name = user.GetDisplayName(model.ShowFullName)
+	}
+}
+
+// resolvePrimaryAddress returns the primary SMTP address Exchange resolves the address or,
+// for legacy DN addresses, the display name to. Results, including misses, are cached.
+func (p *Plugin) resolvePrimaryAddress(userID, email, name string) (string, bool) {
+	// The smtp: prefix matches proxy addresses exactly instead of ambiguous name resolution
+	entry := "smtp:" + email
+	if !isSMTPAddress(email) {
+		entry = name
+	}
+	if entry == "" {
+		return "", false
+	}
+
+	key := fmt.Sprintf("exchange_resolved_mailbox_%s", keyHash(strings.ToLower(entry)))
+	if data, appErr := p.API.KVGet(key); appErr == nil && data != nil {
+		var resolved string
+		if err := json.Unmarshal(data, &resolved); err == nil {
+			return resolved, resolved != ""
+		}
+	}
+
+	credentials, err := p.getUserExchangeCredentials(userID)
+	if err != nil {
+		return "", false
+	}
+
+	client, err := p.newUserExchangeClient(userID, credentials)
+	if err != nil {
+		return "", false
+	}
+
+	mailboxes, err := client.ResolveNames(entry)
+	p.recordEndpointResult(userID, client, err)
+	if err != nil {
+		p.logExchangeError(userID, "Ошибка поиска почтового ящика в адресной книге", err)
+		return "", false
+	}
+
+	// Several candidates for a display name are ambiguous; remember the miss
+	resolved := ""
+	if len(mailboxes) == 1 && isSMTPAddress(mailboxes[0].EmailAddress) {
+		resolved = strings.ToLower(mailboxes[0].EmailAddress)
+	}
+
+	data, _ := json.Marshal(resolved)
+	if _, appErr := p.API.KVSetWithOptions(key, data, model.PluginKVSetOptions{
+		ExpireInSeconds: int64(resolvedMailboxCacheTTL / time.Second),
+	}); appErr != nil {
+		p.API.LogWarn("Ошибка сохранения результата поиска почтового ящика", "error", appErr.Error())
+	}
+
+	return resolved, resolved != ""
+}
+
+// isSMTPAddress reports whether the address is an SMTP address rather than a legacy DN
+func isSMTPAddress(address string) bool {
+	return strings.Contains(address, "@") && !strings.HasPrefix(address, "/")
+}
+
+// organizerMention returns an @mention of the event's organizer, or their name if they are not in Mattermost
+func (p *Plugin) organizerMention(userID string, event CalendarEvent) string {
+	if event.OrganizerEmail == "" {
+		return escapeMentions(event.Organizer)
+	}
+
+	mapping := p.mapMailbox(userID, event.OrganizerEmail, event.Organizer)
+	if mapping.Username == "" {
+		return escapeMentions(event.Organizer)
+	}
+	return mapping.Mention()
+}
+
+// formatMailboxOverrides lists the administrator's mailbox overrides for /exchange mapping
+func formatMailboxOverrides(overrides map[string]string) string {
+	if len(overrides) == 0 {
+		return "🔗 Сопоставления не заданы: почтовый ящик пользователя определяется по email его учетной записи.\n"
+	}
+
+	usernames := make([]string, 0, len(overrides))
+	for username := range overrides {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+
+	text := "🔗 **Сопоставления пользователей и почтовых ящиков:**\n\n"
+	for _, username := range usernames {
+		text += fmt.Sprintf("- @%s → %s\n", username, overrides[username])
+	}
+	return text
+}
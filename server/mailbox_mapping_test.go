@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+func TestResolveUserMappingHonorsEmailPrivacy(t *testing.T) {
+	tests := []struct {
+		name       string
+		showEmails bool
+		admin      bool
+		value      string
+		wantEmail  string
+		wantErr    error
+	}{
+		{"own mailbox", false, false, "@jdoe", "jdoe@contoso.com", nil},
+		{"other user hidden", false, false, "@asmith", "", nil},
+		{"address lookup hidden", false, false, "asmith@contoso.com", "", errEmailsHidden},
+		{"other user for admin", false, true, "@asmith", "asmith@contoso.com", nil},
+		{"other user when shown", true, false, "@asmith", "asmith@contoso.com", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, api := newTestPlugin()
+			api.config.PrivacySettings.ShowEmailAddress = model.NewBool(tt.showEmails)
+			api.admins[testUserID] = tt.admin
+			api.users[testUserID] = &model.User{Id: testUserID, Username: "jdoe", Email: "jdoe@contoso.com"}
+			api.users["asmithuserid"] = &model.User{Id: "asmithuserid", Username: "asmith", Email: "asmith@contoso.com"}
+
+			mapping, err := p.resolveUserMapping(testUserID, tt.value)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if mapping.Email != tt.wantEmail {
+				t.Errorf("Email = %q, want %q", mapping.Email, tt.wantEmail)
+			}
+		})
+	}
+}
+
+func TestMapMailbox(t *testing.T) {
+	tests := []struct {
+		name         string
+		email        string
+		displayName  string
+		wantUsername string
+		wantSource   string
+		wantMention  string
+	}{
+		{"account email", "JDoe@contoso.com", "John Doe", "jdoe", mappingSourceEmail, "@jdoe"},
+		{"override", "anna@contoso.com", "Anna Smith", "asmith", mappingSourceOverride, "@asmith"},
+		{"overridden account email", "asmith@contoso.com", "Anna Smith", "", "", "Anna Smith"},
+		{"bot", "bot@contoso.com", "", "", "", "bot@contoso.com"},
+		{"deactivated user", "left@contoso.com", "Former Employee", "", "", "Former Employee"},
+		{"legacy DN resolved by Exchange", "/o=Contoso/ou=Exchange/cn=Recipients/cn=jdoe", "John Doe", "jdoe", mappingSourceResolveNames, "@jdoe"},
+		{"external attendee", "partner@fabrikam.com", "Partner", "", "", "Partner"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, api := newTestPlugin()
+			api.users[testUserID] = &model.User{Id: testUserID, Username: "jdoe", Email: "jdoe@contoso.com"}
+			api.users["asmithuserid"] = &model.User{Id: "asmithuserid", Username: "asmith", Email: "asmith@contoso.com"}
+			api.users["botuserid"] = &model.User{Id: "botuserid", Username: "exchange", Email: "bot@contoso.com", IsBot: true}
+			api.users["leftuserid"] = &model.User{Id: "leftuserid", Username: "left", Email: "left@contoso.com", DeleteAt: 1}
+			if err := p.setMailboxOverride("@ASmith", "Anna@contoso.com"); err != nil {
+				t.Fatal(err)
+			}
+			// ResolveNames result cached for the display name of the legacy DN
+			api.kv["exchange_resolved_mailbox_"+keyHash("john doe")] = []byte(`"jdoe@contoso.com"`)
+
+			mapping := p.mapMailbox(testUserID, tt.email, tt.displayName)
+			if mapping.Username != tt.wantUsername || mapping.Source != tt.wantSource {
+				t.Errorf("mapped to %q (%s), want %q (%s)", mapping.Username, mapping.Source, tt.wantUsername, tt.wantSource)
+			}
+			if mention := mapping.Mention(); mention != tt.wantMention {
+				t.Errorf("Mention() = %q, want %q", mention, tt.wantMention)
+			}
+		})
+	}
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+// Date and time formats of the meeting dialogs
+const (
+	dialogDateLayout = "02.01.2006"
+	dialogTimeLayout = "15:04"
+)
+
+// meetingDurations are the durations offered in the meeting dialogs, in minutes
+var meetingDurations = []int{15, 30, 45, 60, 90, 120, 180, 240}
+
+// NewMeeting describes a meeting to create in Exchange
+type NewMeeting struct {
+	Subject           string
+	Body              string
+	Location          string
+	Start             time.Time
+	End               time.Time
+	RequiredAttendees []string // Email addresses
+	Resources         []string // Email addresses of rooms
+}
+
+// CreateMeeting creates a meeting in the calendar and sends invitations to the attendees.
+// The request is never repeated: a resent CreateItem would create a second meeting and send
+// the invitations again. If the result is unknown, the error wraps ErrOutcomeUnknown.
+func (c *ExchangeClient) CreateMeeting(meeting NewMeeting) (*ItemId, error) {
+	item := &NewCalendarItem{
+		Subject:  meeting.Subject,
+		Start:    meeting.Start.UTC().Format("2006-01-02T15:04:05Z"),
+		End:      meeting.End.UTC().Format("2006-01-02T15:04:05Z"),
+		Location: meeting.Location,
+	}
+	if meeting.Body != "" {
+		item.Body = &RequestBody{BodyType: "Text", Value: meeting.Body}
+	}
+
+	invitations := "SendToNone"
+	if len(meeting.RequiredAttendees) > 0 {
+		invitations = "SendToAllAndSaveCopy"
+		item.RequiredAttendees = requestAttendees(meeting.RequiredAttendees)
+	}
+	if len(meeting.Resources) > 0 {
+		// Rooms accept or decline the booking through their invitation
+		invitations = "SendToAllAndSaveCopy"
+		item.Resources = requestAttendees(meeting.Resources)
+	}
+
+	soapResp, err := c.sendSOAPRequest("CreateItem", SOAPBody{
+		CreateItem: &CreateItem{
+			SendMeetingInvitations: invitations,
+			SavedItemFolderId: &SavedItemFolderId{
+				DistinguishedFolderId: &DistinguishedFolderId{Id: "calendar"},
+			},
+			Items: &CreateItems{CalendarItem: item},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if soapResp.Body.CreateItemResponse == nil ||
+		soapResp.Body.CreateItemResponse.ResponseMessages == nil ||
+		len(soapResp.Body.CreateItemResponse.ResponseMessages.CreateItemResponseMessage) == 0 {
+		return nil, fmt.Errorf("empty CreateItem response")
+	}
+
+	responseMessage := soapResp.Body.CreateItemResponse.ResponseMessages.CreateItemResponseMessage[0]
+	if err := responseMessage.Err(); err != nil {
+		c.throttled(err)
+		return nil, err
+	}
+
+	if responseMessage.Items == nil || len(responseMessage.Items.CalendarItem) == 0 {
+		return nil, fmt.Errorf("CreateItem response has no calendar item")
+	}
+
+	return &responseMessage.Items.CalendarItem[0].ItemId, nil
+}
+
+// requestAttendees converts email addresses to CreateItem attendees
+func requestAttendees(emails []string) *RequestAttendees {
+	attendees := &RequestAttendees{}
+	for _, email := range emails {
+		attendees.Attendee = append(attendees.Attendee, RequestAttendee{
+			Mailbox: &RequestMailbox{EmailAddress: email},
+		})
+	}
+	return attendees
+}
+
+// owaItemURL returns the Outlook Web App link to an item on the server of the EWS endpoint.
+// viewModel selects the OWA form, e.g. ICalendarItemDetailsViewModelFactory or ReadMessageItem.
+func owaItemURL(ewsURL, itemID, viewModel string) string {
+	u, err := url.Parse(ewsURL)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+
+	query := url.Values{}
+	query.Set("ItemID", itemID)
+	query.Set("exvsurl", "1")
+	query.Set("viewmodel", viewModel)
+
+	return fmt.Sprintf("%s://%s/owa/?%s", u.Scheme, u.Host, query.Encode())
+}
+
+// createMeeting creates a meeting in the user's calendar and returns its OWA link
+func (p *Plugin) createMeeting(userID string, credentials *ExchangeCredentials, meeting NewMeeting) (*ItemId, string, error) {
+	client, err := p.newUserExchangeClient(userID, credentials)
+	if err != nil {
+		return nil, "", err
+	}
+
+	itemID, err := client.CreateMeeting(meeting)
+	p.recordEndpointResult(userID, client, err)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ewsURL := client.EWSURL()
+	if ewsURL == "" {
+		ewsURL = p.getConfiguration().ExchangeServerURL
+	}
+
+	p.API.LogInfo("Встреча создана", "user_id", userID, "subject", meeting.Subject, "attendees", len(meeting.RequiredAttendees))
+	return itemID, owaItemURL(ewsURL, itemID.Id, "ICalendarItemDetailsViewModelFactory"), nil
+}
+
+// durationOptions returns the duration options of the meeting dialogs
+func durationOptions() []*model.PostActionOptions {
+	options := make([]*model.PostActionOptions, 0, len(meetingDurations))
+	for _, minutes := range meetingDurations {
+		options = append(options, &model.PostActionOptions{Text: durationLabel(minutes), Value: strconv.Itoa(minutes)})
+	}
+	return options
+}
+
+// durationLabel formats a meeting duration given in minutes
+func durationLabel(minutes int) string {
+	switch {
+	case minutes >= 60 && minutes%60 == 0:
+		return fmt.Sprintf("%d ч", minutes/60)
+	case minutes > 60:
+		return fmt.Sprintf("%d ч %d мин", minutes/60, minutes%60)
+	default:
+		return fmt.Sprintf("%d мин", minutes)
+	}
+}
+
+// openCreateMeetingDialog opens the dialog for a new meeting starting at the next full hour
+func (p *Plugin) openCreateMeetingDialog(userID, triggerID, subject string) error {
+	loc := p.getUserLocation(userID)
+	start := time.Now().In(loc).Truncate(time.Minute)
+	start = start.Add(time.Hour - time.Duration(start.Minute())*time.Minute)
+
+	dialog := model.Dialog{
+		CallbackId:  "create_meeting",
+		Title:       "Новая встреча",
+		SubmitLabel: "Создать",
+		Elements: []model.DialogElement{
+			{
+				DisplayName: "Тема",
+				Name:        "subject",
+				Type:        "text",
+				Default:     subject,
+				MaxLength:   255,
+			},
+			{
+				DisplayName: "Дата",
+				Name:        "date",
+				Type:        "text",
+				Default:     start.Format(dialogDateLayout),
+				Placeholder: "ДД.ММ.ГГГГ",
+				HelpText:    fmt.Sprintf("Часовой пояс: %s", loc.String()),
+			},
+			{
+				DisplayName: "Начало",
+				Name:        "time",
+				Type:        "text",
+				Default:     start.Format(dialogTimeLayout),
+				Placeholder: "ЧЧ:ММ",
+			},
+			{
+				DisplayName: "Длительность",
+				Name:        "duration",
+				Type:        "select",
+				Default:     "30",
+				Options:     durationOptions(),
+			},
+			{
+				DisplayName: "Место",
+				Name:        "location",
+				Type:        "text",
+				Optional:    true,
+				MaxLength:   255,
+			},
+			{
+				DisplayName: "Участники",
+				Name:        "attendees",
+				Type:        "text",
+				Optional:    true,
+				Placeholder: "@ivanov, @petrova, partner@example.com",
+				HelpText:    "Пользователи Mattermost (@имя) или адреса электронной почты через запятую. Приглашения будут отправлены из Exchange",
+			},
+			{
+				DisplayName: "Описание",
+				Name:        "body",
+				Type:        "textarea",
+				Optional:    true,
+				MaxLength:   4000,
+			},
+		},
+	}
+
+	// The room search is offered only when the organization has room lists
+	if roomLists := p.roomListOptions(userID); len(roomLists) > 0 {
+		dialog.Elements = append(dialog.Elements, model.DialogElement{
+			DisplayName: "Переговорная",
+			Name:        "room_list",
+			Type:        "select",
+			Optional:    true,
+			Placeholder: "Без переговорной",
+			HelpText:    "Будет забронирована первая свободная переговорная выбранного здания",
+			Options:     roomLists,
+		})
+	}
+
+	appErr := p.API.OpenInteractiveDialog(model.OpenDialogRequest{
+		TriggerId: triggerID,
+		URL:       "/plugins/com.mattermost.exchange-plugin/api/v1/meeting/create",
+		Dialog:    dialog,
+	})
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to open create meeting dialog")
+	}
+
+	return nil
+}
+
+// parseMeetingTime parses the date, time and duration fields of a meeting dialog.
+// Field errors are added to fieldErrors.
+func parseMeetingTime(submission map[string]interface{}, loc *time.Location, fieldErrors map[string]string) (time.Time, time.Time) {
+	date, _ := submission["date"].(string)
+	clock, _ := submission["time"].(string)
+	duration, _ := submission["duration"].(string)
+
+	day, err := time.ParseInLocation(dialogDateLayout, strings.TrimSpace(date), loc)
+	if err != nil {
+		fieldErrors["date"] = "Укажите дату в формате ДД.ММ.ГГГГ"
+	}
+
+	startTime, err := time.Parse(dialogTimeLayout, strings.TrimSpace(clock))
+	if err != nil {
+		fieldErrors["time"] = "Укажите время в формате ЧЧ:ММ"
+	}
+
+	minutes, err := strconv.Atoi(duration)
+	if err != nil || minutes <= 0 {
+		fieldErrors["duration"] = "Выберите длительность"
+	}
+
+	if len(fieldErrors) > 0 {
+		return time.Time{}, time.Time{}
+	}
+
+	start := time.Date(day.Year(), day.Month(), day.Day(), startTime.Hour(), startTime.Minute(), 0, 0, loc)
+	return start, start.Add(time.Duration(minutes) * time.Minute)
+}
+
+// resolveAttendees turns @usernames and email addresses into email addresses.
+// It returns the names it could not resolve.
+func (p *Plugin) resolveAttendees(value string) ([]string, []string) {
+	fields := strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ';' || r == ' ' || r == '\n' || r == '\t'
+	})
+
+	var emails, unknown []string
+	seen := make(map[string]bool)
+	for _, field := range fields {
+		email, ok := p.resolveMailbox(field)
+		if !ok {
+			unknown = append(unknown, field)
+			continue
+		}
+
+		if !seen[email] {
+			seen[email] = true
+			emails = append(emails, email)
+		}
+	}
+
+	return emails, unknown
+}
+
+// resolveMailbox turns an @username or an email address into a lowercase email address.
+// An @username maps to the mailbox an administrator assigned to the user, if any.
+func (p *Plugin) resolveMailbox(value string) (string, bool) {
+	if strings.HasPrefix(value, "@") || !strings.Contains(value, "@") {
+		user, appErr := p.API.GetUserByUsername(strings.TrimPrefix(value, "@"))
+		if appErr != nil || user.IsBot {
+			return "", false
+		}
+		value, _ = p.mailboxForUser(user)
+		if value == "" {
+			return "", false
+		}
+	}
+
+	return strings.ToLower(value), true
+}
+
+// sendMeetingCreatedMessage confirms a new meeting to the user in DM
+func (p *Plugin) sendMeetingCreatedMessage(userID string, meeting NewMeeting, link string) error {
+	return p.sendMeetingMessage(userID, "✅ **Встреча создана**\n\n", meeting, link)
+}
+
+// sendMeetingUnconfirmedMessage tells the user that Exchange did not confirm the meeting,
+// so they check the calendar instead of creating it again
+func (p *Plugin) sendMeetingUnconfirmedMessage(userID string, meeting NewMeeting) error {
+	title := "⚠️ **Exchange не подтвердил создание встречи**\n\n" +
+		"Встреча и приглашения могли быть созданы. Проверьте календарь в Outlook, прежде чем создавать встречу снова.\n\n"
+	return p.sendMeetingMessage(userID, title, meeting, "")
+}
+
+// sendMeetingMessage sends a direct message about a meeting the user created
+func (p *Plugin) sendMeetingMessage(userID, title string, meeting NewMeeting, link string) error {
+	loc := p.getUserLocation(userID)
+
+	message := title
+	message += fmt.Sprintf("**Тема:** %s\n", meeting.Subject)
+	message += fmt.Sprintf("**Время:** %s - %s\n", meeting.Start.In(loc).Format("02.01.2006 15:04"), meeting.End.In(loc).Format("15:04"))
+	if meeting.Location != "" {
+		message += fmt.Sprintf("**Место:** %s\n", meeting.Location)
+	}
+	if len(meeting.RequiredAttendees) > 0 {
+		message += fmt.Sprintf("**Приглашения отправлены:** %s\n", strings.Join(meeting.RequiredAttendees, ", "))
+	}
+	if link != "" {
+		message += fmt.Sprintf("\n[Открыть в Outlook Web App](%s)", link)
+	}
+
+	bot, appErr := p.API.GetBot("", true)
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to get bot")
+	}
+
+	channel, appErr := p.API.GetDirectChannel(userID, bot.UserId)
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to get direct channel")
+	}
+
+	post := &model.Post{
+		ChannelId: channel.Id,
+		UserId:    bot.UserId,
+		Message:   message,
+	}
+
+	if _, appErr := p.API.CreatePost(post); appErr != nil {
+		return errors.Wrap(appErr, "failed to create meeting confirmation post")
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+func TestCreateMeetingDialogReportsUnknownOutcome(t *testing.T) {
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		closed   bool // The dialog closes instead of offering to submit it again
+		dmPrefix string
+	}{
+		{"unknown outcome", closeConnection, true, "⚠️ **Exchange не подтвердил создание встречи**"},
+		{"exchange error", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusInternalServerError) }, false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var creates int32
+			p, api := newTestPluginWithEWS(t, func(w http.ResponseWriter, r *http.Request) {
+				if strings.Contains(r.Header.Get("SOAPAction"), "/CreateItem") {
+					atomic.AddInt32(&creates, 1)
+				}
+				tt.handler(w, r)
+			})
+
+			response := submitDialog(t, p.handleCreateMeetingDialog, model.SubmitDialogRequest{
+				UserId: testUserID,
+				Submission: map[string]interface{}{
+					"subject":   "Планерка",
+					"date":      time.Now().AddDate(0, 0, 1).Format(dialogDateLayout),
+					"time":      "10:00",
+					"duration":  "30",
+					"attendees": "asmith@contoso.com",
+				},
+			})
+
+			if n := atomic.LoadInt32(&creates); n != 1 {
+				t.Errorf("CreateItem sent %d times, want 1", n)
+			}
+			if tt.closed {
+				if response.Error != "" || len(response.Errors) != 0 {
+					t.Errorf("the dialog stays open: %+v", response)
+				}
+				if len(api.created) != 1 || !strings.HasPrefix(api.created[0].Message, tt.dmPrefix) {
+					t.Errorf("unexpected direct messages: %+v", api.created)
+				}
+			} else {
+				if response.Error == "" {
+					t.Errorf("the dialog does not show the error: %+v", response)
+				}
+				if len(api.created) != 0 {
+					t.Errorf("unexpected direct messages: %+v", api.created)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+// Meeting response types
+const (
+	MeetingResponseAccept    = "Accept"
+	MeetingResponseTentative = "Tentative"
+	MeetingResponseDecline   = "Decline"
+)
+
+// meetingResponseLabels are the user-facing names of the meeting responses
+var meetingResponseLabels = map[string]string{
+	MeetingResponseAccept:    "✅ Принято",
+	MeetingResponseTentative: "❓ Под вопросом",
+	MeetingResponseDecline:   "❌ Отклонено",
+}
+
+// meetingResponseState is passed through the response dialog
+type meetingResponseState struct {
+	EventID      string `json:"event_id"`
+	PostID       string `json:"post_id"`
+	ResponseType string `json:"response_type"`
+}
+
+// RespondToMeeting accepts, tentatively accepts or declines a meeting request or calendar item.
+// Without sendResponse the answer is recorded in the calendar but not sent to the organizer.
+func (c *ExchangeClient) RespondToMeeting(itemID, responseType, comment string, sendResponse bool) error {
+	reference, err := c.currentItemID(itemID)
+	if err != nil {
+		return err
+	}
+
+	response := &ResponseObject{ReferenceItemId: reference}
+	if comment != "" {
+		response.Body = &RequestBody{BodyType: "Text", Value: comment}
+	}
+
+	items := &CreateItems{}
+	switch responseType {
+	case MeetingResponseAccept:
+		items.AcceptItem = response
+	case MeetingResponseTentative:
+		items.TentativelyAcceptItem = response
+	case MeetingResponseDecline:
+		items.DeclineItem = response
+	default:
+		return fmt.Errorf("unsupported meeting response %q", responseType)
+	}
+
+	disposition := "SendAndSaveCopy"
+	if !sendResponse {
+		disposition = "SaveOnly"
+	}
+
+	soapResp, err := c.sendSOAPRequest("CreateItem", SOAPBody{
+		CreateItem: &CreateItem{
+			MessageDisposition: disposition,
+			Items:              items,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if soapResp.Body.CreateItemResponse == nil ||
+		soapResp.Body.CreateItemResponse.ResponseMessages == nil ||
+		len(soapResp.Body.CreateItemResponse.ResponseMessages.CreateItemResponseMessage) == 0 {
+		return fmt.Errorf("empty CreateItem response")
+	}
+
+	if err := soapResp.Body.CreateItemResponse.ResponseMessages.CreateItemResponseMessage[0].Err(); err != nil {
+		c.throttled(err)
+		return err
+	}
+
+	return nil
+}
+
+// currentItemID returns the item ID with its current ChangeKey
+func (c *ExchangeClient) currentItemID(itemID string) (*ItemId, error) {
+	soapResp, err := c.sendSOAPRequest("GetItem", SOAPBody{
+		GetItem: &GetItem{
+			ItemShape: &ItemShape{BaseShape: "IdOnly"},
+			ItemIds: &ItemIds{
+				ItemId: []ItemId{{Id: itemID}},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if soapResp.Body.GetItemResponse == nil ||
+		soapResp.Body.GetItemResponse.ResponseMessages == nil ||
+		len(soapResp.Body.GetItemResponse.ResponseMessages.GetItemResponseMessage) == 0 {
+		return nil, fmt.Errorf("empty GetItem response")
+	}
+
+	responseMessage := soapResp.Body.GetItemResponse.ResponseMessages.GetItemResponseMessage[0]
+	if err := responseMessage.Err(); err != nil {
+		c.throttled(err)
+		return nil, err
+	}
+
+	if responseMessage.Items != nil {
+		for _, items := range [][]CalendarItem{responseMessage.Items.CalendarItem, responseMessage.Items.MeetingRequest} {
+			if len(items) > 0 {
+				return &items[0].ItemId, nil
+			}
+		}
+	}
+
+	return nil, &EWSError{Kind: ErrItemNotFound, MessageText: "meeting item not found"}
+}
+
+// sendMeetingResponse sends a meeting response via EWS
+func (p *Plugin) sendMeetingResponse(userID string, credentials *ExchangeCredentials, eventID, responseType, comment string, sendResponse bool) error {
+	client, err := p.newUserExchangeClient(userID, credentials)
+	if err != nil {
+		return err
+	}
+
+	err = client.RespondToMeeting(eventID, responseType, comment, sendResponse)
+	p.recordEndpointResult(userID, client, err)
+	if err != nil {
+		return err
+	}
+
+	p.API.LogInfo("Ответ на приглашение отправлен", "user_id", userID, "event_id", eventID, "response", responseType, "send_response", sendResponse)
+	return nil
+}
+
+// openMeetingResponseDialog asks for an optional comment and whether to notify the organizer
+func (p *Plugin) openMeetingResponseDialog(triggerID, postID, eventID, responseType string) error {
+	state, err := json.Marshal(meetingResponseState{
+		EventID:      eventID,
+		PostID:       postID,
+		ResponseType: responseType,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal dialog state")
+	}
+
+	appErr := p.API.OpenInteractiveDialog(model.OpenDialogRequest{
+		TriggerId: triggerID,
+		URL:       "/plugins/com.mattermost.exchange-plugin/api/v1/meeting/respond",
+		Dialog: model.Dialog{
+			CallbackId:  "meeting_response",
+			Title:       "Ответ на приглашение",
+			SubmitLabel: meetingResponseLabels[responseType],
+			State:       string(state),
+			Elements: []model.DialogElement{
+				{
+					DisplayName: "Комментарий",
+					Name:        "comment",
+					Type:        "textarea",
+					Placeholder: "Необязательно",
+					Optional:    true,
+					MaxLength:   2000,
+				},
+				{
+					DisplayName: "Уведомить организатора",
+					Name:        "send_response",
+					Type:        "radio",
+					Default:     "true",
+					Options: []*model.PostActionOptions{
+						{Text: "Отправить ответ организатору", Value: "true"},
+						{Text: "Не отправлять ответ", Value: "false"},
+					},
+				},
+			},
+		},
+	})
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to open meeting response dialog")
+	}
+
+	return nil
+}
+
+// markMeetingResponded updates the invitation post in place to show the chosen answer.
+// Only the bot's invitation of the event in the user's direct channel is changed.
+func (p *Plugin) markMeetingResponded(userID, postID, eventID, responseType, comment string, sendResponse bool) (*model.Post, error) {
+	post, err := p.getBotDirectPost(userID, postID, "event_id", eventID)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid invitation post")
+	}
+
+	answer := meetingResponseLabels[responseType]
+	if sendResponse {
+		answer += " (ответ отправлен организатору)"
+	} else {
+		answer += " (без отправки ответа)"
+	}
+
+	post.Message += fmt.Sprintf("\n**Ваш ответ:** %s", answer)
+	if comment != "" {
+		post.Message += "\n> " + strings.ReplaceAll(comment, "\n", "\n> ")
+	}
+	post.DelProp("attachments")
+
+	updated, appErr := p.API.UpdatePost(post)
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to update invitation post")
+	}
+
+	return updated, nil
+}
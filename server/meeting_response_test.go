@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+func TestMarkMeetingRespondedOnlyChangesOwnInvitation(t *testing.T) {
+	const userID = "jdoeuserid"
+	dm := model.GetDMNameFromIds(userID, testBotUserID)
+
+	tests := []struct {
+		name      string
+		author    string
+		channelID string
+		eventID   string
+		valid     bool
+	}{
+		{"own invitation", testBotUserID, dm, "AAMkADk=", true},
+		{"post of another user", "asmithuserid", dm, "AAMkADk=", false},
+		{"bot post in another channel", testBotUserID, "townsquareid", "AAMkADk=", false},
+		{"invitation of another event", testBotUserID, dm, "AAMkAOther=", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, api := newTestPlugin()
+			api.addActionPost("postid", tt.author, tt.channelID, "event_id", tt.eventID)
+
+			post, err := p.markMeetingResponded(userID, "postid", "AAMkADk=", MeetingResponseAccept, "", true)
+			if !tt.valid {
+				if err == nil || len(api.updated) != 0 {
+					t.Errorf("post changed: err %v, %d updates", err, len(api.updated))
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(post.Message, "Ваш ответ") || len(post.Attachments()) != 0 {
+				t.Errorf("unexpected post: %q with %d attachments", post.Message, len(post.Attachments()))
+			}
+		})
+	}
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+
+	"golang.org/x/crypto/md4"
+)
+
+// NTLM negotiate flags used by the client (MS-NLMP 2.2.2.5)
+const (
+	ntlmNegotiateUnicode                 uint32 = 0x00000001
+	ntlmNegotiateOEM                     uint32 = 0x00000002
+	ntlmRequestTarget                    uint32 = 0x00000004
+	ntlmNegotiateNTLM                    uint32 = 0x00000200
+	ntlmNegotiateAlwaysSign              uint32 = 0x00008000
+	ntlmNegotiateExtendedSessionSecurity uint32 = 0x00080000
+	ntlmNegotiateTargetInfo              uint32 = 0x00800000
+	ntlmNegotiate128                     uint32 = 0x20000000
+	ntlmNegotiate56                      uint32 = 0x80000000
+
+	ntlmNegotiateFlags = ntlmNegotiateUnicode | ntlmNegotiateOEM | ntlmRequestTarget |
+		ntlmNegotiateNTLM | ntlmNegotiateAlwaysSign | ntlmNegotiateExtendedSessionSecurity |
+		ntlmNegotiateTargetInfo | ntlmNegotiate128 | ntlmNegotiate56
+)
+
+// AV_PAIR identifiers from the challenge TargetInfo (MS-NLMP 2.2.2.1)
+const (
+	ntlmAvEOL       uint16 = 0x0000
+	ntlmAvTimestamp uint16 = 0x0007
+)
+
+var ntlmSignature = []byte("NTLMSSP\x00")
+
+// ntlmPoolIdleTimeout is how long an unused per-user connection pool is kept
+const ntlmPoolIdleTimeout = 30 * time.Minute
+
+// ntlmTransport performs the NTLM negotiate/challenge/authenticate handshake.
+// Requests carry the user's credentials as Basic auth; the transport strips the
+// header and answers the server's NTLM challenge instead. NTLM authenticates the
+// TCP connection, so every user gets their own keep-alive connection pool: a
+// connection authenticated for one user is never reused for another, and the
+// user's next requests are sent on it without a new handshake.
+type ntlmTransport struct {
+	newBase func() *http.Transport
+
+	mu     sync.Mutex
+	users  map[ntlmPoolKey]*ntlmUserTransport
+	pruned time.Time
+}
+
+// ntlmPoolKey identifies the credentials the connections of a pool are authenticated with
+type ntlmPoolKey struct {
+	username     string
+	passwordHash [sha256.Size]byte
+}
+
+// ntlmUserTransport is the connection pool of one user
+type ntlmUserTransport struct {
+	base *http.Transport
+	// lastUsed is guarded by the mutex of ntlmTransport
+	lastUsed time.Time
+
+	mu sync.Mutex
+	// authenticated is set once a handshake succeeded on a pooled connection
+	authenticated bool
+}
+
+// newNTLMTransport creates an NTLM transport whose per-user pools are created by newBase
+func newNTLMTransport(newBase func() *http.Transport) *ntlmTransport {
+	return &ntlmTransport{
+		newBase: newBase,
+		users:   make(map[ntlmPoolKey]*ntlmUserTransport),
+		pruned:  time.Now(),
+	}
+}
+
+// userTransport returns the connection pool of the credentials, creating it on first use.
+// The pools of the user's previous password and the pools unused for ntlmPoolIdleTimeout
+// are dropped.
+func (t *ntlmTransport) userTransport(username, password string) *ntlmUserTransport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(t.pruned) >= ntlmPoolIdleTimeout {
+		for key, user := range t.users {
+			if now.Sub(user.lastUsed) >= ntlmPoolIdleTimeout {
+				t.dropLocked(key)
+			}
+		}
+		t.pruned = now
+	}
+
+	key := ntlmPoolKey{username: username, passwordHash: sha256.Sum256([]byte(password))}
+	user, ok := t.users[key]
+	if !ok {
+		for other := range t.users {
+			if other.username == username {
+				t.dropLocked(other)
+			}
+		}
+		user = &ntlmUserTransport{base: t.newBase()}
+		t.users[key] = user
+	}
+	user.lastUsed = now
+	return user
+}
+
+// dropLocked forgets a pool and closes its idle connections; requests in flight complete
+func (t *ntlmTransport) dropLocked(key ntlmPoolKey) {
+	t.users[key].base.CloseIdleConnections()
+	delete(t.users, key)
+}
+
+// CloseIdleConnections closes the idle connections of all users
+func (t *ntlmTransport) CloseIdleConnections() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, user := range t.users {
+		user.base.CloseIdleConnections()
+	}
+}
+
+func (u *ntlmUserTransport) isAuthenticated() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.authenticated
+}
+
+func (u *ntlmUserTransport) setAuthenticated(authenticated bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.authenticated = authenticated
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *ntlmTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	username, password, ok := req.BasicAuth()
+	if !ok {
+		return t.userTransport("", "").base.RoundTrip(req)
+	}
+	domain, user := splitNTLMUsername(username)
+	pool := t.userTransport(username, password)
+
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request body: %w", err)
+		}
+	}
+
+	// An idle connection authenticated earlier accepts the request as is
+	if pool.isAuthenticated() {
+		resp, err := pool.base.RoundTrip(cloneNTLMRequest(req, body, ""))
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusUnauthorized {
+			return resp, nil
+		}
+		// A new connection was dialed; authenticate it
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	// Negotiate (type 1); the body is only sent with the authenticated request
+	resp, err := pool.base.RoundTrip(cloneNTLMRequest(req, nil, "NTLM "+base64.StdEncoding.EncodeToString(newNTLMNegotiateMessage())))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		if len(body) == 0 {
+			return resp, nil
+		}
+		// The server did not ask for authentication; send the request itself
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return pool.base.RoundTrip(cloneNTLMRequest(req, body, ""))
+	}
+
+	challenge := ntlmChallengeFromResponse(resp)
+	if challenge == nil {
+		// NTLM is not offered; let the caller handle the plain 401
+		return resp, nil
+	}
+
+	// Drain the body so the connection is returned to the pool and reused
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	// Authenticate (type 3)
+	authenticate, err := newNTLMAuthenticateMessage(challenge, user, password, domain)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err = pool.base.RoundTrip(cloneNTLMRequest(req, body, "NTLM "+base64.StdEncoding.EncodeToString(authenticate)))
+	if err != nil {
+		return nil, err
+	}
+	pool.setAuthenticated(resp.StatusCode != http.StatusUnauthorized)
+
+	return resp, nil
+}
+
+// cloneNTLMRequest copies the request with a fresh body and the given Authorization header;
+// an empty authorization removes the header
+func cloneNTLMRequest(req *http.Request, body []byte, authorization string) *http.Request {
+	clone := req.Clone(req.Context())
+	clone.Body = http.NoBody
+	clone.ContentLength = int64(len(body))
+	clone.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	if len(body) > 0 {
+		clone.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	if authorization == "" {
+		clone.Header.Del("Authorization")
+	} else {
+		clone.Header.Set("Authorization", authorization)
+	}
+	return clone
+}
+
+// ntlmChallengeFromResponse extracts the type 2 message from a 401 response
+func ntlmChallengeFromResponse(resp *http.Response) []byte {
+	for _, header := range resp.Header.Values("WWW-Authenticate") {
+		if !strings.HasPrefix(header, "NTLM ") {
+			continue
+		}
+		challenge, err := base64.StdEncoding.DecodeString(strings.TrimSpace(strings.TrimPrefix(header, "NTLM ")))
+		if err == nil {
+			return challenge
+		}
+	}
+	return nil
+}
+
+// splitNTLMUsername splits DOMAIN\user; UPN and plain names are passed as-is
+func splitNTLMUsername(username string) (string, string) {
+	if i := strings.Index(username, "\\"); i >= 0 {
+		return username[:i], username[i+1:]
+	}
+	return "", username
+}
+
+// newNTLMNegotiateMessage builds the type 1 message without domain or workstation
+func newNTLMNegotiateMessage() []byte {
+	msg := make([]byte, 32)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 1)
+	binary.LittleEndian.PutUint32(msg[12:], ntlmNegotiateFlags)
+	// Domain and workstation security buffers stay empty with offset 32
+	binary.LittleEndian.PutUint32(msg[20:], 32)
+	binary.LittleEndian.PutUint32(msg[28:], 32)
+	return msg
+}
+
+// ntlmChallenge holds the parts of the type 2 message used for NTLMv2
+type ntlmChallenge struct {
+	flags           uint32
+	serverChallenge []byte
+	targetInfo      []byte
+}
+
+// parseNTLMChallengeMessage decodes a type 2 message
+func parseNTLMChallengeMessage(data []byte) (*ntlmChallenge, error) {
+	if len(data) < 48 || !bytes.Equal(data[:8], ntlmSignature) {
+		return nil, errors.New("invalid NTLM challenge message")
+	}
+	if binary.LittleEndian.Uint32(data[8:]) != 2 {
+		return nil, errors.New("unexpected NTLM message type")
+	}
+
+	challenge := &ntlmChallenge{
+		flags:           binary.LittleEndian.Uint32(data[20:]),
+		serverChallenge: data[24:32],
+	}
+
+	infoLen := int(binary.LittleEndian.Uint16(data[40:]))
+	infoOffset := int(binary.LittleEndian.Uint32(data[44:]))
+	if infoLen > 0 {
+		if infoOffset+infoLen > len(data) {
+			return nil, errors.New("invalid NTLM target info")
+		}
+		challenge.targetInfo = data[infoOffset : infoOffset+infoLen]
+	}
+
+	return challenge, nil
+}
+
+// timestamp returns MsvAvTimestamp from the target info, if the server sent one
+func (c *ntlmChallenge) timestamp() ([]byte, bool) {
+	info := c.targetInfo
+	for len(info) >= 4 {
+		id := binary.LittleEndian.Uint16(info)
+		length := int(binary.LittleEndian.Uint16(info[2:]))
+		if id == ntlmAvEOL || len(info) < 4+length {
+			break
+		}
+		if id == ntlmAvTimestamp && length == 8 {
+			return info[4:12], true
+		}
+		info = info[4+length:]
+	}
+	return nil, false
+}
+
+// newNTLMAuthenticateMessage answers a type 2 message with NTLMv2 responses
+func newNTLMAuthenticateMessage(challengeData []byte, user, password, domain string) ([]byte, error) {
+	challenge, err := parseNTLMChallengeMessage(challengeData)
+	if err != nil {
+		return nil, err
+	}
+	if challenge.flags&ntlmNegotiateUnicode == 0 {
+		return nil, errors.New("server does not support Unicode NTLM")
+	}
+
+	clientChallenge := make([]byte, 8)
+	if _, err := rand.Read(clientChallenge); err != nil {
+		return nil, fmt.Errorf("failed to generate client challenge: %w", err)
+	}
+
+	timestamp, serverTimestamp := challenge.timestamp()
+	if !serverTimestamp {
+		timestamp = ntlmFiletime(time.Now())
+	}
+
+	responseKey := ntowfv2(user, password, domain)
+
+	// NTLMv2_CLIENT_CHALLENGE (MS-NLMP 2.2.2.7)
+	temp := []byte{0x01, 0x01, 0, 0, 0, 0, 0, 0}
+	temp = append(temp, timestamp...)
+	temp = append(temp, clientChallenge...)
+	temp = append(temp, 0, 0, 0, 0)
+	temp = append(temp, challenge.targetInfo...)
+	temp = append(temp, 0, 0, 0, 0)
+
+	ntProof := hmacMD5(responseKey, challenge.serverChallenge, temp)
+	ntResponse := append(ntProof, temp...)
+
+	// With MsvAvTimestamp present the LMv2 response must be zeroed
+	lmResponse := make([]byte, 24)
+	if !serverTimestamp {
+		lmResponse = append(hmacMD5(responseKey, challenge.serverChallenge, clientChallenge), clientChallenge...)
+	}
+
+	domainBytes := encodeUTF16LE(domain)
+	userBytes := encodeUTF16LE(user)
+
+	flags := ntlmNegotiateFlags & challenge.flags
+	flags &^= ntlmNegotiateOEM
+
+	const headerLen = 64
+	payload := [][]byte{lmResponse, ntResponse, domainBytes, userBytes, {}, {}}
+	msg := make([]byte, headerLen)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 3)
+
+	offset := headerLen
+	for i, field := range payload {
+		pos := 12 + i*8
+		binary.LittleEndian.PutUint16(msg[pos:], uint16(len(field)))
+		binary.LittleEndian.PutUint16(msg[pos+2:], uint16(len(field)))
+		binary.LittleEndian.PutUint32(msg[pos+4:], uint32(offset))
+		offset += len(field)
+	}
+	binary.LittleEndian.PutUint32(msg[60:], flags)
+
+	for _, field := range payload {
+		msg = append(msg, field...)
+	}
+
+	return msg, nil
+}
+
+// ntowfv2 computes the NTLMv2 response key from the password
+func ntowfv2(user, password, domain string) []byte {
+	hash := md4.New()
+	hash.Write(encodeUTF16LE(password))
+	return hmacMD5(hash.Sum(nil), encodeUTF16LE(strings.ToUpper(user)+domain))
+}
+
+func hmacMD5(key []byte, data ...[]byte) []byte {
+	mac := hmac.New(md5.New, key)
+	for _, d := range data {
+		mac.Write(d)
+	}
+	return mac.Sum(nil)
+}
+
+func encodeUTF16LE(s string) []byte {
+	encoded := utf16.Encode([]rune(s))
+	b := make([]byte, 2*len(encoded))
+	for i, r := range encoded {
+		binary.LittleEndian.PutUint16(b[2*i:], r)
+	}
+	return b
+}
+
+// ntlmFiletime converts t to a Windows FILETIME (100ns ticks since 1601-01-01)
+func ntlmFiletime(t time.Time) []byte {
+	ticks := uint64(t.UnixNano()/100) + 116444736000000000
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, ticks)
+	return b
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeNTLMServer answers the NTLM handshake like IIS: a connection stays authenticated
+// for the user who completed the handshake on it
+type fakeNTLMServer struct {
+	t        *testing.T
+	password string
+
+	mu              sync.Mutex
+	serverChallenge []byte
+	authenticated   map[string]string // remote address -> DOMAIN\user
+	handshakes      int
+	bodies          []string
+}
+
+func newFakeNTLMServer(t *testing.T, password string) *fakeNTLMServer {
+	return &fakeNTLMServer{
+		t:               t,
+		password:        password,
+		serverChallenge: []byte{1, 2, 3, 4, 5, 6, 7, 8},
+		authenticated:   make(map[string]string),
+	}
+}
+
+func (s *fakeNTLMServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+	authorization := r.Header.Get("Authorization")
+
+	if strings.HasPrefix(authorization, "Basic ") {
+		s.t.Errorf("Basic credentials sent to an NTLM server")
+	}
+
+	if !strings.HasPrefix(authorization, "NTLM ") {
+		if user, ok := s.authenticated[r.RemoteAddr]; ok {
+			s.bodies = append(s.bodies, string(body))
+			io.WriteString(w, "hello "+user)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", "NTLM")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	message, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(authorization, "NTLM "))
+	if err != nil || len(message) < 12 || !bytes.Equal(message[:8], ntlmSignature) {
+		s.t.Errorf("invalid NTLM message")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	switch binary.LittleEndian.Uint32(message[8:]) {
+	case 1:
+		if len(body) != 0 {
+			s.t.Errorf("negotiate message sent with a body of %d bytes", len(body))
+		}
+		w.Header().Set("WWW-Authenticate", "NTLM "+base64.StdEncoding.EncodeToString(s.challengeMessage()))
+		w.WriteHeader(http.StatusUnauthorized)
+	case 3:
+		user, ok := s.verifyAuthenticateMessage(message)
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		s.handshakes++
+		s.authenticated[r.RemoteAddr] = user
+		s.bodies = append(s.bodies, string(body))
+		io.WriteString(w, "hello "+user)
+	default:
+		s.t.Errorf("unexpected NTLM message type")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// challengeMessage builds a type 2 message with a target info block carrying MsvAvTimestamp
+func (s *fakeNTLMServer) challengeMessage() []byte {
+	var targetInfo []byte
+	targetInfo = appendAVPair(targetInfo, 0x0002, encodeUTF16LE("CONTOSO"))
+	targetInfo = appendAVPair(targetInfo, ntlmAvTimestamp, ntlmFiletime(time.Now()))
+	targetInfo = appendAVPair(targetInfo, ntlmAvEOL, nil)
+
+	msg := make([]byte, 48)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 2)
+	binary.LittleEndian.PutUint32(msg[20:], ntlmNegotiateFlags&^ntlmNegotiateOEM)
+	copy(msg[24:], s.serverChallenge)
+	binary.LittleEndian.PutUint16(msg[40:], uint16(len(targetInfo)))
+	binary.LittleEndian.PutUint16(msg[42:], uint16(len(targetInfo)))
+	binary.LittleEndian.PutUint32(msg[44:], 48)
+	return append(msg, targetInfo...)
+}
+
+// verifyAuthenticateMessage checks the NTLMv2 response of a type 3 message against the password
+func (s *fakeNTLMServer) verifyAuthenticateMessage(message []byte) (string, bool) {
+	field := func(i int) []byte {
+		pos := 12 + i*8
+		length := int(binary.LittleEndian.Uint16(message[pos:]))
+		offset := int(binary.LittleEndian.Uint32(message[pos+4:]))
+		if offset+length > len(message) {
+			s.t.Fatalf("security buffer %d out of range", i)
+		}
+		return message[offset : offset+length]
+	}
+
+	lmResponse, ntResponse := field(0), field(1)
+	domain, user := decodeUTF16LE(field(2)), decodeUTF16LE(field(3))
+
+	if !bytes.Equal(lmResponse, make([]byte, 24)) {
+		s.t.Errorf("LMv2 response must be zero when the server sends MsvAvTimestamp")
+	}
+	if len(ntResponse) <= 16 {
+		s.t.Errorf("NTLMv2 response too short")
+		return "", false
+	}
+
+	temp := ntResponse[16:]
+	expected := hmacMD5(ntowfv2(user, s.password, domain), s.serverChallenge, temp)
+	if !hmac.Equal(ntResponse[:16], expected) {
+		return "", false
+	}
+
+	return domain + `\` + user, true
+}
+
+func appendAVPair(info []byte, id uint16, value []byte) []byte {
+	header := make([]byte, 4)
+	binary.LittleEndian.PutUint16(header, id)
+	binary.LittleEndian.PutUint16(header[2:], uint16(len(value)))
+	return append(append(info, header...), value...)
+}
+
+func decodeUTF16LE(b []byte) string {
+	runes := make([]rune, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		runes = append(runes, rune(binary.LittleEndian.Uint16(b[i:])))
+	}
+	return string(runes)
+}
+
+func TestNTLMTransportHandshake(t *testing.T) {
+	fake := newFakeNTLMServer(t, "Secret1!")
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	client := &http.Client{Transport: newEWSTransport(AuthMethodNTLM, nil)}
+
+	post := func(username, password, body string) *http.Response {
+		req, err := http.NewRequest(http.MethodPost, server.URL, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth(username, password)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	for i, body := range []string{"first", "second", "third"} {
+		resp := post(`CONTOSO\jdoe`, "Secret1!", body)
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("request %d: status %d", i+1, resp.StatusCode)
+		}
+		if string(data) != `hello CONTOSO\jdoe` {
+			t.Fatalf("request %d: unexpected response %q", i+1, data)
+		}
+	}
+
+	fake.mu.Lock()
+	if fake.handshakes != 1 {
+		t.Errorf("expected one handshake for the reused connection, got %d", fake.handshakes)
+	}
+	if strings.Join(fake.bodies, ",") != "first,second,third" {
+		t.Errorf("each body should reach the server once, got %v", fake.bodies)
+	}
+	fake.mu.Unlock()
+
+	// Another user must not reuse the connection authenticated for jdoe
+	resp := post(`CONTOSO\asmith`, "Secret1!", "other")
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(data) != `hello CONTOSO\asmith` {
+		t.Errorf("second user answered as %q", data)
+	}
+
+	// A wrong password fails the handshake
+	resp = post(`CONTOSO\mallory`, "wrong", "x")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("wrong password: status %d, want 401", resp.StatusCode)
+	}
+}
+
+func TestNTLMAuthenticateMessageWithoutServerTimestamp(t *testing.T) {
+	challenge := make([]byte, 48)
+	copy(challenge, ntlmSignature)
+	binary.LittleEndian.PutUint32(challenge[8:], 2)
+	binary.LittleEndian.PutUint32(challenge[20:], ntlmNegotiateFlags)
+	copy(challenge[24:], []byte{8, 7, 6, 5, 4, 3, 2, 1})
+
+	msg, err := newNTLMAuthenticateMessage(challenge, "jdoe", "Secret1!", "CONTOSO")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without MsvAvTimestamp the LMv2 response is HMAC(key, server || client challenge) || client challenge
+	length := int(binary.LittleEndian.Uint16(msg[12:]))
+	offset := int(binary.LittleEndian.Uint32(msg[16:]))
+	lm := msg[offset : offset+length]
+	if len(lm) != 24 {
+		t.Fatalf("LMv2 response length %d, want 24", len(lm))
+	}
+	expected := hmacMD5(ntowfv2("jdoe", "Secret1!", "CONTOSO"), challenge[24:32], lm[16:])
+	if !bytes.Equal(lm[:16], expected) {
+		t.Errorf("invalid LMv2 response")
+	}
+}
+
+func TestParseNTLMChallengeMessageRejectsInvalid(t *testing.T) {
+	if _, err := parseNTLMChallengeMessage([]byte("short")); err == nil {
+		t.Error("expected an error for a short message")
+	}
+
+	msg := make([]byte, 48)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 2)
+	binary.LittleEndian.PutUint16(msg[40:], 100)
+	binary.LittleEndian.PutUint32(msg[44:], 48)
+	if _, err := parseNTLMChallengeMessage(msg); err == nil {
+		t.Error("expected an error for target info out of range")
+	}
+}
+
+func TestNTLMTransportDropsStalePools(t *testing.T) {
+	created := 0
+	transport := newNTLMTransport(func() *http.Transport {
+		created++
+		return &http.Transport{}
+	})
+
+	pool := transport.userTransport(`CONTOSO\jdoe`, "old")
+	if transport.userTransport(`CONTOSO\jdoe`, "old") != pool {
+		t.Error("the pool of the same credentials is not reused")
+	}
+
+	if transport.userTransport(`CONTOSO\jdoe`, "new") == pool {
+		t.Error("the pool authenticated with the old password is reused")
+	}
+	if len(transport.users) != 1 {
+		t.Errorf("%d pools after the password change, want 1", len(transport.users))
+	}
+
+	other := transport.userTransport(`CONTOSO\asmith`, "secret")
+	other.lastUsed = time.Now().Add(-ntlmPoolIdleTimeout)
+	transport.pruned = time.Now().Add(-ntlmPoolIdleTimeout)
+	transport.userTransport(`CONTOSO\jdoe`, "new")
+	if len(transport.users) != 1 {
+		t.Errorf("%d pools after the idle timeout, want 1", len(transport.users))
+	}
+
+	if created != 3 {
+		t.Errorf("created %d pools, want 3", created)
+	}
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// Out-of-office states of Exchange automatic replies
+const (
+	oofStateDisabled  = "Disabled"
+	oofStateEnabled   = "Enabled"
+	oofStateScheduled = "Scheduled"
+)
+
+// oofStatusEmoji is the custom status emoji while automatic replies are sent
+const oofStatusEmoji = "palm_tree"
+
+// oofCacheTTL is how long the calendar sync reuses the automatic replies it read; scheduled
+// replies still start and end on time because ActiveAt is evaluated on every sync
+const oofCacheTTL = 30 * time.Minute
+
+// OofSettings are the automatic replies (out of office) of a mailbox
+type OofSettings struct {
+	State            string    `json:"state"`             // Disabled, Enabled, Scheduled
+	ExternalAudience string    `json:"external_audience"` // None, Known, All
+	Start            time.Time `json:"start"`             // Used only when Scheduled
+	End              time.Time `json:"end"`
+	InternalReply    string    `json:"internal_reply"`
+	ExternalReply    string    `json:"external_reply"`
+}
+
+// ActiveAt reports whether automatic replies are sent at t
+func (s *OofSettings) ActiveAt(t time.Time) bool {
+	switch s.State {
+	case oofStateEnabled:
+		return true
+	case oofStateScheduled:
+		return !t.Before(s.Start) && t.Before(s.End)
+	default:
+		return false
+	}
+}
+
+// OofChange is a change of the automatic replies requested by /exchange oof or the API
+type OofChange struct {
+	State   string    `json:"state"` // Enabled, Disabled, Scheduled
+	Start   time.Time `json:"start"` // Required when Scheduled
+	End     time.Time `json:"end"`
+	Message string    `json:"message"` // Replaces the internal and external replies unless empty
+}
+
+// GetOofSettings returns the automatic replies of the mailbox
+func (c *ExchangeClient) GetOofSettings(email string) (*OofSettings, error) {
+	soapResp, err := c.sendSOAPRequest("GetUserOofSettings", SOAPBody{
+		GetUserOofSettingsRequest: &GetUserOofSettingsRequest{
+			Mailbox: &OofMailbox{Address: email},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	response := soapResp.Body.GetUserOofSettingsResponse
+	if response == nil {
+		return nil, fmt.Errorf("empty GetUserOofSettings response")
+	}
+	if response.ResponseMessage != nil {
+		if err := response.ResponseMessage.Err(); err != nil {
+			c.throttled(err)
+			return nil, err
+		}
+	}
+	if response.OofSettings == nil {
+		return nil, fmt.Errorf("GetUserOofSettings response has no settings")
+	}
+
+	oof := response.OofSettings
+	settings := &OofSettings{
+		State:            oof.OofState,
+		ExternalAudience: oof.ExternalAudience,
+	}
+	if oof.Duration != nil {
+		// Durations are always in UTC
+		if settings.Start, err = parseEWSDateTime(oof.Duration.StartTime, time.UTC); err != nil {
+			return nil, fmt.Errorf("invalid OOF start: %w", err)
+		}
+		if settings.End, err = parseEWSDateTime(oof.Duration.EndTime, time.UTC); err != nil {
+			return nil, fmt.Errorf("invalid OOF end: %w", err)
+		}
+	}
+	if oof.InternalReply != nil {
+		settings.InternalReply = oof.InternalReply.Message
+	}
+	if oof.ExternalReply != nil {
+		settings.ExternalReply = oof.ExternalReply.Message
+	}
+
+	return settings, nil
+}
+
+// SetOofSettings replaces the automatic replies of the mailbox
+func (c *ExchangeClient) SetOofSettings(email string, settings *OofSettings) error {
+	oof := &UserOofSettings{
+		OofState:         settings.State,
+		ExternalAudience: settings.ExternalAudience,
+		InternalReply:    &OofReply{Message: settings.InternalReply},
+		ExternalReply:    &OofReply{Message: settings.ExternalReply},
+	}
+	if oof.ExternalAudience == "" {
+		oof.ExternalAudience = "All"
+	}
+	if !settings.Start.IsZero() && !settings.End.IsZero() {
+		oof.Duration = &OofDuration{
+			StartTime: settings.Start.UTC().Format(time.RFC3339),
+			EndTime:   settings.End.UTC().Format(time.RFC3339),
+		}
+	}
+
+	soapResp, err := c.sendSOAPRequest("SetUserOofSettings", SOAPBody{
+		SetUserOofSettingsRequest: &SetUserOofSettingsRequest{
+			Mailbox:         &OofMailbox{Address: email},
+			UserOofSettings: oof,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	response := soapResp.Body.SetUserOofSettingsResponse
+	if response == nil {
+		return fmt.Errorf("empty SetUserOofSettings response")
+	}
+	if response.ResponseMessage != nil {
+		if err := response.ResponseMessage.Err(); err != nil {
+			c.throttled(err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getOofSettings reads the user's automatic replies from Exchange and refreshes the cache
+func (p *Plugin) getOofSettings(userID string, credentials *ExchangeCredentials) (*OofSettings, error) {
+	email := p.getUserMailboxAddress(userID, credentials)
+	if email == "" {
+		return nil, fmt.Errorf("mailbox address of user %s is unknown", userID)
+	}
+
+	client, err := p.newUserExchangeClient(userID, credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	settings, err := client.GetOofSettings(email)
+	p.recordEndpointResult(userID, client, err)
+	if err != nil {
+		return nil, err
+	}
+
+	p.cacheOofSettings(userID, settings)
+	return settings, nil
+}
+
+// oofCacheKey returns the KV key of the user's cached automatic replies
+func oofCacheKey(userID string) string {
+	return fmt.Sprintf("exchange_oof_cache_%s", userID)
+}
+
+// getCachedOofSettings returns the user's automatic replies, read from Exchange at most once per oofCacheTTL
+func (p *Plugin) getCachedOofSettings(userID string, credentials *ExchangeCredentials) (*OofSettings, error) {
+	if data, appErr := p.API.KVGet(oofCacheKey(userID)); appErr == nil && data != nil {
+		var settings OofSettings
+		if err := json.Unmarshal(data, &settings); err == nil {
+			return &settings, nil
+		}
+	}
+
+	return p.getOofSettings(userID, credentials)
+}
+
+// cacheOofSettings stores the user's automatic replies for getCachedOofSettings
+func (p *Plugin) cacheOofSettings(userID string, settings *OofSettings) {
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return
+	}
+
+	if _, appErr := p.API.KVSetWithOptions(oofCacheKey(userID), data, model.PluginKVSetOptions{
+		ExpireInSeconds: int64(oofCacheTTL / time.Second),
+	}); appErr != nil {
+		p.API.LogWarn("Ошибка сохранения настроек автоответа", "user_id", userID, "error", appErr.Error())
+	}
+}
+
+// changeOofSettings applies the change to the user's automatic replies, updates the
+// Mattermost status accordingly and returns the new settings
+func (p *Plugin) changeOofSettings(userID string, credentials *ExchangeCredentials, change OofChange) (*OofSettings, error) {
+	email := p.getUserMailboxAddress(userID, credentials)
+	if email == "" {
+		return nil, fmt.Errorf("mailbox address of user %s is unknown", userID)
+	}
+
+	client, err := p.newUserExchangeClient(userID, credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read the current settings first to keep the replies and the audience that are not changed
+	settings, err := client.GetOofSettings(email)
+	if err != nil {
+		p.recordEndpointResult(userID, client, err)
+		return nil, err
+	}
+
+	settings.State = change.State
+	if change.State == oofStateScheduled {
+		settings.Start, settings.End = change.Start, change.End
+	}
+	if change.Message != "" {
+		settings.InternalReply = change.Message
+		settings.ExternalReply = change.Message
+	}
+
+	err = client.SetOofSettings(email, settings)
+	p.recordEndpointResult(userID, client, err)
+	if err != nil {
+		return nil, err
+	}
+
+	p.cacheOofSettings(userID, settings)
+	p.updateUserStatusFromOof(userID, settings)
+
+	return settings, nil
+}
+
+// updateUserStatusFromOof sets the away status and an out-of-office custom status while
+// automatic replies are sent. It returns false otherwise, so the calendar decides the status;
+// the custom status set by the plugin is then removed. A custom status the user has set
+// meanwhile is neither overwritten nor removed.
+func (p *Plugin) updateUserStatusFromOof(userID string, settings *OofSettings) bool {
+	if settings == nil {
+		return false
+	}
+
+	// The marker holds the text of the custom status the plugin set
+	key := fmt.Sprintf("exchange_oof_status_%s", userID)
+	marker, appErr := p.API.KVGet(key)
+	if appErr != nil {
+		p.API.LogError("Ошибка получения статуса отсутствия", "user_id", userID, "error", appErr.Error())
+		return settings.ActiveAt(time.Now())
+	}
+
+	var current *model.CustomStatus
+	if user, appErr := p.API.GetUser(userID); appErr == nil {
+		current = user.GetCustomStatus()
+	}
+	setByPlugin := marker != nil && current != nil && current.Emoji == oofStatusEmoji && current.Text == string(marker)
+
+	if !settings.ActiveAt(time.Now()) {
+		if marker == nil {
+			return false
+		}
+
+		if setByPlugin {
+			if appErr := p.API.RemoveUserCustomStatus(userID); appErr != nil {
+				p.API.LogError("Ошибка сброса статуса отсутствия", "user_id", userID, "error", appErr.Error())
+				return false
+			}
+		}
+		if appErr := p.API.KVDelete(key); appErr != nil {
+			p.API.LogError("Ошибка сброса статуса отсутствия", "user_id", userID, "error", appErr.Error())
+		}
+		return false
+	}
+
+	if _, appErr := p.API.UpdateUserStatus(userID, "away"); appErr != nil {
+		p.API.LogError("Ошибка обновления статуса пользователя", "user_id", userID, "error", appErr.Error())
+	}
+
+	// The user replaced the out-of-office status after the plugin set it
+	if marker != nil && !setByPlugin {
+		return true
+	}
+
+	customStatus := &model.CustomStatus{
+		Emoji: oofStatusEmoji,
+		Text:  oofStatusText(settings, p.getUserLocation(userID)),
+	}
+	if settings.State == oofStateScheduled {
+		customStatus.Duration = "date_and_time"
+		customStatus.ExpiresAt = settings.End
+	}
+
+	if setByPlugin && current.Text == customStatus.Text {
+		return true
+	}
+
+	if appErr := p.API.UpdateUserCustomStatus(userID, customStatus); appErr != nil {
+		p.API.LogError("Ошибка обновления статуса отсутствия", "user_id", userID, "error", appErr.Error())
+		return true
+	}
+	if appErr := p.API.KVSet(key, []byte(customStatus.Text)); appErr != nil {
+		p.API.LogError("Ошибка сохранения статуса отсутствия", "user_id", userID, "error", appErr.Error())
+	}
+
+	return true
+}
+
+// oofStatusText is the custom status text: the end of the absence and the first line of the reply
+func oofStatusText(settings *OofSettings, loc *time.Location) string {
+	text := "Не в офисе"
+	if settings.State == oofStateScheduled {
+		text += " до " + settings.End.In(loc).Format("02.01 15:04")
+	}
+
+	for _, line := range strings.Split(htmlToMarkdown(settings.InternalReply), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			text += ": " + line
+			break
+		}
+	}
+
+	if runes := []rune(text); len(runes) > model.CustomStatusTextMaxRunes {
+		text = string(runes[:model.CustomStatusTextMaxRunes-1]) + "…"
+	}
+	return text
+}
+
+// parseOofArguments parses the arguments of /exchange oof; a nil change means showing the settings.
+// Formats: on ["message"], off, schedule <from> [ЧЧ:ММ] <to> [ЧЧ:ММ] ["message"].
+// A date without time starts at 00:00 and, as the end, includes the whole day.
+func parseOofArguments(fields []string, now time.Time, loc *time.Location) (*OofChange, error) {
+	if len(fields) == 0 || strings.EqualFold(fields[0], "status") {
+		return nil, nil
+	}
+
+	switch strings.ToLower(fields[0]) {
+	case "on":
+		return &OofChange{State: oofStateEnabled, Message: unquoteMessage(strings.Join(fields[1:], " "))}, nil
+	case "off":
+		return &OofChange{State: oofStateDisabled}, nil
+	case "schedule":
+	default:
+		return nil, fmt.Errorf("неизвестная подкоманда: %s", fields[0])
+	}
+
+	now = now.In(loc)
+	var bounds []time.Time
+	rest := fields[1:]
+	for len(rest) > 0 && len(bounds) < 2 {
+		day, ok := parseDayArgument(rest[0], now, loc)
+		if !ok {
+			break
+		}
+		rest = rest[1:]
+
+		bound := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, loc)
+		if len(rest) > 0 {
+			if clock, err := time.Parse(dialogTimeLayout, rest[0]); err == nil {
+				bound = time.Date(day.Year(), day.Month(), day.Day(), clock.Hour(), clock.Minute(), 0, 0, loc)
+				rest = rest[1:]
+				bounds = append(bounds, bound)
+				continue
+			}
+		}
+		if len(bounds) == 1 {
+			bound = bound.AddDate(0, 0, 1) // The end date is included
+		}
+		bounds = append(bounds, bound)
+	}
+
+	if len(bounds) < 2 {
+		return nil, fmt.Errorf("укажите начало и конец отсутствия, например `schedule 20.10 24.10`")
+	}
+	if !bounds[1].After(bounds[0]) {
+		return nil, fmt.Errorf("конец отсутствия должен быть позже начала")
+	}
+	if !bounds[1].After(now) {
+		return nil, fmt.Errorf("конец отсутствия уже прошел")
+	}
+
+	return &OofChange{
+		State:   oofStateScheduled,
+		Start:   bounds[0],
+		End:     bounds[1],
+		Message: unquoteMessage(strings.Join(rest, " ")),
+	}, nil
+}
+
+// unquoteMessage strips the quotes around a command message
+func unquoteMessage(message string) string {
+	message = strings.TrimSpace(message)
+	for _, quotes := range [][2]string{{`"`, `"`}, {"«", "»"}, {"“", "”"}, {"'", "'"}} {
+		if len(message) >= len(quotes[0])+len(quotes[1]) && strings.HasPrefix(message, quotes[0]) && strings.HasSuffix(message, quotes[1]) {
+			return strings.TrimSpace(message[len(quotes[0]) : len(message)-len(quotes[1])])
+		}
+	}
+	return message
+}
+
+// formatOofSettings describes the automatic replies for /exchange oof
+func formatOofSettings(settings *OofSettings, now time.Time, loc *time.Location) string {
+	var text string
+	switch {
+	case settings.State == oofStateEnabled:
+		text = "🌴 **Автоответ включен**\n"
+	case settings.State == oofStateScheduled && settings.ActiveAt(now):
+		text = fmt.Sprintf("🌴 **Автоответ включен до %s**\n", settings.End.In(loc).Format("02.01.2006 15:04"))
+	case settings.State == oofStateScheduled && now.Before(settings.Start):
+		text = fmt.Sprintf("🗓 **Автоответ запланирован:** %s - %s\n", settings.Start.In(loc).Format("02.01.2006 15:04"), settings.End.In(loc).Format("02.01.2006 15:04"))
+	default:
+		text = "**Автоответ выключен**\n"
+	}
+
+	if reply := strings.TrimSpace(htmlToMarkdown(settings.InternalReply)); reply != "" {
+		text += "\n**Сообщение:**\n> " + strings.ReplaceAll(reply, "\n", "\n> ") + "\n"
+	}
+	if settings.ExternalReply != settings.InternalReply {
+		if reply := strings.TrimSpace(htmlToMarkdown(settings.ExternalReply)); reply != "" {
+			text += "\n**Сообщение для внешних отправителей:**\n> " + strings.ReplaceAll(reply, "\n", "\n> ") + "\n"
+		}
+	}
+
+	return text
+}
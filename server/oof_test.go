@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseOofArguments(t *testing.T) {
+	loc := time.FixedZone("MSK", 3*60*60)
+	now := time.Date(2024, 10, 15, 12, 0, 0, 0, loc)
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 10, day, hour, minute, 0, 0, loc)
+	}
+
+	tests := []struct {
+		args  string
+		want  *OofChange
+		fails bool
+	}{
+		{"", nil, false},
+		{"status", nil, false},
+		{`on "В отпуске, пишите коллегам"`, &OofChange{State: oofStateEnabled, Message: "В отпуске, пишите коллегам"}, false},
+		{"ON", &OofChange{State: oofStateEnabled}, false},
+		{"off", &OofChange{State: oofStateDisabled}, false},
+		{"schedule 20.10 24.10", &OofChange{State: oofStateScheduled, Start: at(20, 0, 0), End: at(25, 0, 0)}, false},
+		{"schedule 20.10 09:00 24.10 18:00 «Я в отпуске»", &OofChange{State: oofStateScheduled, Start: at(20, 9, 0), End: at(24, 18, 0), Message: "Я в отпуске"}, false},
+		{"schedule today tomorrow", &OofChange{State: oofStateScheduled, Start: at(15, 0, 0), End: at(17, 0, 0)}, false},
+		{"schedule 2024-10-20 21.10.2024 12:00", &OofChange{State: oofStateScheduled, Start: at(20, 0, 0), End: at(21, 12, 0)}, false},
+		{"schedule 20.10", nil, true},
+		{"schedule 24.10 20.10", nil, true},
+		{"schedule 20.10 10:00 20.10 09:00", nil, true},
+		{"schedule 01.10 02.10", nil, true},
+		{"pause", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.args, func(t *testing.T) {
+			change, err := parseOofArguments(strings.Fields(tt.args), now, loc)
+			if tt.fails {
+				if err == nil {
+					t.Errorf("expected an error, got %+v", change)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			switch {
+			case tt.want == nil && change != nil:
+				t.Errorf("got %+v, want no change", change)
+			case tt.want == nil:
+			case change == nil:
+				t.Errorf("got no change, want %+v", tt.want)
+			case change.State != tt.want.State || !change.Start.Equal(tt.want.Start) || !change.End.Equal(tt.want.End) || change.Message != tt.want.Message:
+				t.Errorf("got %+v, want %+v", change, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+// maxOrganizedMeetingsListed is the number of meetings /exchange reschedule and /exchange cancel offer
+const maxOrganizedMeetingsListed = 10
+
+// EventUpdate describes changes to a meeting the user organizes
+type EventUpdate struct {
+	Start time.Time
+	End   time.Time
+	// Location is left unchanged when nil and removed when empty
+	Location *string
+}
+
+// organizerActionState is passed through the reschedule and cancel dialogs
+type organizerActionState struct {
+	EventID  string `json:"event_id"`
+	Subject  string `json:"subject"`
+	Location string `json:"location"`
+}
+
+// UpdateEvent moves a meeting and sends the update to all attendees.
+// For an occurrence only that occurrence is changed. Like all changes, the UpdateItem
+// request is not repeated; if the result is unknown, the error wraps ErrOutcomeUnknown.
+func (c *ExchangeClient) UpdateEvent(itemID string, update EventUpdate) error {
+	reference, err := c.currentItemID(itemID)
+	if err != nil {
+		return err
+	}
+
+	updates := &ItemUpdates{
+		SetItemField: []SetItemField{
+			{
+				FieldURI:     &FieldURI{FieldURI: "calendar:Start"},
+				CalendarItem: &NewCalendarItem{Start: update.Start.UTC().Format("2006-01-02T15:04:05Z")},
+			},
+			{
+				FieldURI:     &FieldURI{FieldURI: "calendar:End"},
+				CalendarItem: &NewCalendarItem{End: update.End.UTC().Format("2006-01-02T15:04:05Z")},
+			},
+		},
+	}
+	if update.Location != nil {
+		if *update.Location != "" {
+			updates.SetItemField = append(updates.SetItemField, SetItemField{
+				FieldURI:     &FieldURI{FieldURI: "calendar:Location"},
+				CalendarItem: &NewCalendarItem{Location: *update.Location},
+			})
+		} else {
+			updates.DeleteItemField = append(updates.DeleteItemField, DeleteItemField{
+				FieldURI: &FieldURI{FieldURI: "calendar:Location"},
+			})
+		}
+	}
+
+	soapResp, err := c.sendSOAPRequest("UpdateItem", SOAPBody{
+		UpdateItem: &UpdateItem{
+			ConflictResolution:                    "AutoResolve",
+			SendMeetingInvitationsOrCancellations: "SendToAllAndSaveCopy",
+			ItemChanges: &ItemChanges{
+				ItemChange: []ItemChange{{ItemId: reference, Updates: updates}},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if soapResp.Body.UpdateItemResponse == nil ||
+		soapResp.Body.UpdateItemResponse.ResponseMessages == nil ||
+		len(soapResp.Body.UpdateItemResponse.ResponseMessages.UpdateItemResponseMessage) == 0 {
+		return fmt.Errorf("empty UpdateItem response")
+	}
+
+	responseMessage := soapResp.Body.UpdateItemResponse.ResponseMessages.UpdateItemResponseMessage[0]
+	if err := responseMessage.Err(); err != nil {
+		c.throttled(err)
+		return err
+	}
+
+	return nil
+}
+
+// CancelEvent cancels a meeting and sends the cancellation with the message to all attendees.
+// The CreateItem request is not repeated, so attendees never get the cancellation twice.
+func (c *ExchangeClient) CancelEvent(itemID, message string) error {
+	reference, err := c.currentItemID(itemID)
+	if err != nil {
+		return err
+	}
+
+	cancellation := &CancelCalendarItem{ReferenceItemId: reference}
+	if message != "" {
+		cancellation.NewBodyContent = &RequestBody{BodyType: "Text", Value: message}
+	}
+
+	soapResp, err := c.sendSOAPRequest("CreateItem", SOAPBody{
+		CreateItem: &CreateItem{
+			MessageDisposition: "SendAndSaveCopy",
+			Items:              &CreateItems{CancelCalendarItem: cancellation},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if soapResp.Body.CreateItemResponse == nil ||
+		soapResp.Body.CreateItemResponse.ResponseMessages == nil ||
+		len(soapResp.Body.CreateItemResponse.ResponseMessages.CreateItemResponseMessage) == 0 {
+		return fmt.Errorf("empty CreateItem response")
+	}
+
+	responseMessage := soapResp.Body.CreateItemResponse.ResponseMessages.CreateItemResponseMessage[0]
+	if err := responseMessage.Err(); err != nil {
+		c.throttled(err)
+		return err
+	}
+
+	return nil
+}
+
+// rescheduleMeeting moves a meeting the user organizes
+func (p *Plugin) rescheduleMeeting(userID string, credentials *ExchangeCredentials, eventID string, update EventUpdate) error {
+	client, err := p.newUserExchangeClient(userID, credentials)
+	if err != nil {
+		return err
+	}
+
+	err = client.UpdateEvent(eventID, update)
+	p.recordEndpointResult(userID, client, err)
+	if err != nil {
+		return err
+	}
+
+	p.API.LogInfo("Встреча перенесена", "user_id", userID, "event_id", eventID, "start", update.Start.Format(time.RFC3339))
+	return nil
+}
+
+// cancelMeeting cancels a meeting the user organizes
+func (p *Plugin) cancelMeeting(userID string, credentials *ExchangeCredentials, eventID, message string) error {
+	client, err := p.newUserExchangeClient(userID, credentials)
+	if err != nil {
+		return err
+	}
+
+	err = client.CancelEvent(eventID, message)
+	p.recordEndpointResult(userID, client, err)
+	if err != nil {
+		return err
+	}
+
+	p.API.LogInfo("Встреча отменена", "user_id", userID, "event_id", eventID)
+	return nil
+}
+
+// getOrganizedMeetings returns the upcoming meetings of the next 7 days the user organizes
+func (p *Plugin) getOrganizedMeetings(userID string, credentials *ExchangeCredentials) ([]CalendarEvent, error) {
+	now := time.Now()
+	events, err := p.getCalendarEventsInRange(userID, credentials, now, now.Add(7*24*time.Hour))
+	if err != nil {
+		return nil, err
+	}
+
+	meetings := make([]CalendarEvent, 0, len(events))
+	for _, event := range events {
+		if event.IsOrganizer && event.IsMeeting && event.Start.After(now) {
+			meetings = append(meetings, event)
+		}
+		if len(meetings) == maxOrganizedMeetingsListed {
+			break
+		}
+	}
+
+	return meetings, nil
+}
+
+// Organizer actions offered as buttons
+const (
+	organizerActionReschedule = "reschedule"
+	organizerActionCancel     = "cancel"
+)
+
+// organizerAction returns the reschedule or cancel button for a meeting
+func organizerAction(eventID, action string) *model.PostAction {
+	button := &model.PostAction{
+		Id:   "reschedule_meeting",
+		Name: "🕑 Перенести",
+		Type: "button",
+		Integration: &model.PostActionIntegration{
+			URL: "/plugins/com.mattermost.exchange-plugin/api/v1/meeting/" + action,
+			Context: map[string]interface{}{
+				"event_id": eventID,
+			},
+		},
+	}
+	if action == organizerActionCancel {
+		button.Id = "cancel_meeting"
+		button.Name = "🚫 Отменить"
+		button.Style = "danger"
+	}
+	return button
+}
+
+// organizedMeetingsAttachments lists the meetings with a reschedule or cancel button each
+func (p *Plugin) organizedMeetingsAttachments(userID string, meetings []CalendarEvent, action string) []*model.SlackAttachment {
+	loc := p.getUserLocation(userID)
+
+	attachments := make([]*model.SlackAttachment, 0, len(meetings))
+	for _, meeting := range meetings {
+		text := fmt.Sprintf("🕐 %s - %s", meeting.Start.In(loc).Format("02.01 15:04"), meeting.End.In(loc).Format("15:04"))
+		if meeting.Location != "" {
+			text += fmt.Sprintf(" (📍 %s)", meeting.Location)
+		}
+
+		attachments = append(attachments, &model.SlackAttachment{
+			Title:   meeting.Subject + recurrenceMark(meeting),
+			Text:    text,
+			Actions: []*model.PostAction{organizerAction(meeting.ID, action)},
+		})
+	}
+
+	return attachments
+}
+
+// openRescheduleDialog opens the reschedule dialog prefilled with the current time and place of the meeting
+func (p *Plugin) openRescheduleDialog(userID, triggerID string, event *EventDetails) error {
+	state, err := json.Marshal(organizerActionState{
+		EventID:  event.ID,
+		Subject:  event.Subject,
+		Location: event.Location,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal dialog state")
+	}
+
+	loc := p.getUserLocation(userID)
+	start := event.Start.In(loc)
+	minutes := int(event.End.Sub(event.Start).Minutes())
+
+	durations := durationOptions()
+	known := false
+	for _, option := range durations {
+		known = known || option.Value == strconv.Itoa(minutes)
+	}
+	if !known && minutes > 0 {
+		durations = append(durations, &model.PostActionOptions{Text: durationLabel(minutes), Value: strconv.Itoa(minutes)})
+	}
+
+	helpText := ""
+	if event.IsRecurring {
+		helpText = "Изменится только это вхождение повторяющейся встречи"
+	}
+
+	appErr := p.API.OpenInteractiveDialog(model.OpenDialogRequest{
+		TriggerId: triggerID,
+		URL:       "/plugins/com.mattermost.exchange-plugin/api/v1/meeting/reschedule/submit",
+		Dialog: model.Dialog{
+			CallbackId:       "reschedule_meeting",
+			Title:            "Перенести встречу",
+			IntroductionText: fmt.Sprintf("**%s**\n\nУчастники получат обновленное приглашение.", event.Subject),
+			SubmitLabel:      "Перенести",
+			State:            string(state),
+			Elements: []model.DialogElement{
+				{
+					DisplayName: "Дата",
+					Name:        "date",
+					Type:        "text",
+					Default:     start.Format(dialogDateLayout),
+					Placeholder: "ДД.ММ.ГГГГ",
+					HelpText:    fmt.Sprintf("Часовой пояс: %s", loc.String()),
+				},
+				{
+					DisplayName: "Начало",
+					Name:        "time",
+					Type:        "text",
+					Default:     start.Format(dialogTimeLayout),
+					Placeholder: "ЧЧ:ММ",
+					HelpText:    helpText,
+				},
+				{
+					DisplayName: "Длительность",
+					Name:        "duration",
+					Type:        "select",
+					Default:     strconv.Itoa(minutes),
+					Options:     durations,
+				},
+				{
+					DisplayName: "Место",
+					Name:        "location",
+					Type:        "text",
+					Default:     event.Location,
+					Optional:    true,
+					MaxLength:   255,
+				},
+			},
+		},
+	})
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to open reschedule dialog")
+	}
+
+	return nil
+}
+
+// openCancelDialog asks for the message sent to the attendees with the cancellation
+func (p *Plugin) openCancelDialog(triggerID string, event *EventDetails) error {
+	state, err := json.Marshal(organizerActionState{
+		EventID: event.ID,
+		Subject: event.Subject,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal dialog state")
+	}
+
+	introduction := fmt.Sprintf("**%s**\n\nВсе участники получат уведомление об отмене.", event.Subject)
+	if event.IsRecurring {
+		introduction += " Будет отменено только это вхождение повторяющейся встречи."
+	}
+
+	appErr := p.API.OpenInteractiveDialog(model.OpenDialogRequest{
+		TriggerId: triggerID,
+		URL:       "/plugins/com.mattermost.exchange-plugin/api/v1/meeting/cancel/submit",
+		Dialog: model.Dialog{
+			CallbackId:       "cancel_meeting",
+			Title:            "Отменить встречу",
+			IntroductionText: introduction,
+			SubmitLabel:      "Отменить встречу",
+			State:            string(state),
+			Elements: []model.DialogElement{
+				{
+					DisplayName: "Сообщение участникам",
+					Name:        "message",
+					Type:        "textarea",
+					Placeholder: "Необязательно",
+					Optional:    true,
+					MaxLength:   2000,
+				},
+			},
+		},
+	})
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to open cancel dialog")
+	}
+
+	return nil
+}
+
+// sendOrganizerConfirmation confirms a rescheduled or cancelled meeting to the user in DM
+func (p *Plugin) sendOrganizerConfirmation(userID, message string) error {
+	bot, appErr := p.API.GetBot("", true)
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to get bot")
+	}
+
+	channel, appErr := p.API.GetDirectChannel(userID, bot.UserId)
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to get direct channel")
+	}
+
+	post := &model.Post{
+		ChannelId: channel.Id,
+		UserId:    bot.UserId,
+		Message:   message,
+	}
+
+	if _, appErr := p.API.CreatePost(post); appErr != nil {
+		return errors.Wrap(appErr, "failed to create organizer confirmation post")
+	}
+
+	return nil
+}
+
+// sendOrganizerUnconfirmed tells the organizer that a change may have been applied without
+// confirmation, so they check the calendar instead of repeating it
+func (p *Plugin) sendOrganizerUnconfirmed(userID, text string) {
+	message := "⚠️ " + text + " Изменение могло быть применено, а участники уведомлены. Проверьте календарь в Outlook, прежде чем повторять его."
+	if err := p.sendOrganizerConfirmation(userID, message); err != nil {
+		p.API.LogError("Ошибка отправки сообщения организатору", "user_id", userID, "error", err.Error())
+	}
+}
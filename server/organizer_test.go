@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// getItemIDResponse answers the GetItem that looks up the current ItemId of the meeting
+const getItemIDResponse = `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>
+<m:GetItemResponse xmlns:m="http://schemas.microsoft.com/exchange/services/2006/messages" xmlns:t="http://schemas.microsoft.com/exchange/services/2006/types">
+<m:ResponseMessages><m:GetItemResponseMessage ResponseClass="Success"><m:ResponseCode>NoError</m:ResponseCode>
+<m:Items><t:CalendarItem><t:ItemId Id="AAMkADk=" ChangeKey="DwAAAB"/></t:CalendarItem></m:Items>
+</m:GetItemResponseMessage></m:ResponseMessages></m:GetItemResponse></s:Body></s:Envelope>`
+
+// changeResponse answers an UpdateItem or CreateItem request with success
+func changeResponse(action string) string {
+	return fmt.Sprintf(`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>
+<m:%[1]sResponse xmlns:m="http://schemas.microsoft.com/exchange/services/2006/messages">
+<m:ResponseMessages><m:%[1]sResponseMessage ResponseClass="Success"><m:ResponseCode>NoError</m:ResponseCode></m:%[1]sResponseMessage></m:ResponseMessages>
+</m:%[1]sResponse></s:Body></s:Envelope>`, action)
+}
+
+// recordChange returns a handler that answers the ItemId lookup and stores the body of the change
+func recordChange(action string, body *string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.Header.Get("SOAPAction"), "/GetItem") {
+			io.WriteString(w, getItemIDResponse)
+			return
+		}
+		request, _ := io.ReadAll(r.Body)
+		*body = string(request)
+		io.WriteString(w, changeResponse(action))
+	}
+}
+
+func TestUpdateEventPayload(t *testing.T) {
+	room := "Переговорная 2"
+	none := ""
+
+	tests := []struct {
+		name     string
+		location *string
+		want     []string
+		absent   []string
+	}{
+		{"location unchanged", nil, nil, []string{"calendar:Location"}},
+		{"location set", &room, []string{`<t:SetItemField><t:FieldURI FieldURI="calendar:Location"></t:FieldURI><t:CalendarItem><t:Location>Переговорная 2</t:Location>`}, []string{"DeleteItemField"}},
+		{"location removed", &none, []string{`<t:DeleteItemField><t:FieldURI FieldURI="calendar:Location">`}, []string{"<t:Location>"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body string
+			client, _ := newTestEWSClient(t, recordChange("UpdateItem", &body))
+
+			start := time.Date(2024, 7, 1, 12, 0, 0, 0, time.FixedZone("MSK", 3*60*60))
+			err := client.UpdateEvent("AAMkADk=", EventUpdate{Start: start, End: start.Add(time.Hour), Location: tt.location})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := append([]string{
+				`SendMeetingInvitationsOrCancellations="SendToAllAndSaveCopy"`,
+				`<t:ItemId Id="AAMkADk=" ChangeKey="DwAAAB">`,
+				`<t:Start>2024-07-01T09:00:00Z</t:Start>`,
+				`<t:End>2024-07-01T10:00:00Z</t:End>`,
+			}, tt.want...)
+			for _, part := range want {
+				if !strings.Contains(body, part) {
+					t.Errorf("UpdateItem lacks %s: %s", part, body)
+				}
+			}
+			for _, part := range tt.absent {
+				if strings.Contains(body, part) {
+					t.Errorf("UpdateItem contains %s: %s", part, body)
+				}
+			}
+		})
+	}
+}
+
+func TestCancelEventPayload(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		body    bool
+	}{
+		{"with message", "Встреча отменяется", true},
+		{"without message", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body string
+			client, _ := newTestEWSClient(t, recordChange("CreateItem", &body))
+
+			if err := client.CancelEvent("AAMkADk=", tt.message); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, part := range []string{
+				`MessageDisposition="SendAndSaveCopy"`,
+				`<t:CancelCalendarItem><t:ReferenceItemId Id="AAMkADk=" ChangeKey="DwAAAB">`,
+			} {
+				if !strings.Contains(body, part) {
+					t.Errorf("CreateItem lacks %s: %s", part, body)
+				}
+			}
+			content := `<t:NewBodyContent BodyType="Text">` + tt.message + `</t:NewBodyContent>`
+			if strings.Contains(body, "NewBodyContent") != tt.body || (tt.body && !strings.Contains(body, content)) {
+				t.Errorf("unexpected cancellation message in %s", body)
+			}
+		})
+	}
+}
+
+func TestOrganizerDialogsReportUnknownOutcome(t *testing.T) {
+	state, _ := json.Marshal(organizerActionState{EventID: "AAMkADk=", Subject: "Планерка"})
+
+	tests := []struct {
+		name       string
+		action     string
+		handler    func(p *Plugin) http.HandlerFunc
+		submission map[string]interface{}
+		dmText     string
+	}{
+		{"reschedule", "UpdateItem", func(p *Plugin) http.HandlerFunc { return p.handleRescheduleDialog }, map[string]interface{}{
+			"date":     time.Now().AddDate(0, 0, 1).Format(dialogDateLayout),
+			"time":     "10:00",
+			"duration": "30",
+		}, "⚠️ Exchange не подтвердил перенос встречи «Планерка»."},
+		{"cancel", "CreateItem", func(p *Plugin) http.HandlerFunc { return p.handleCancelDialog }, map[string]interface{}{
+			"message": "Встреча отменяется",
+		}, "⚠️ Exchange не подтвердил отмену встречи «Планерка»."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var changes int32
+			p, api := newTestPluginWithEWS(t, func(w http.ResponseWriter, r *http.Request) {
+				if strings.Contains(r.Header.Get("SOAPAction"), "/GetItem") {
+					io.WriteString(w, getItemIDResponse)
+					return
+				}
+				if strings.Contains(r.Header.Get("SOAPAction"), "/"+tt.action) {
+					atomic.AddInt32(&changes, 1)
+				}
+				closeConnection(w, r)
+			})
+
+			response := submitDialog(t, tt.handler(p), model.SubmitDialogRequest{
+				UserId:     testUserID,
+				State:      string(state),
+				Submission: tt.submission,
+			})
+
+			if n := atomic.LoadInt32(&changes); n != 1 {
+				t.Errorf("%s sent %d times, want 1", tt.action, n)
+			}
+			if response.Error != "" || len(response.Errors) != 0 {
+				t.Errorf("the dialog stays open: %+v", response)
+			}
+			if len(api.created) != 1 || !strings.HasPrefix(api.created[0].Message, tt.dmText) {
+				t.Errorf("unexpected direct messages: %+v", api.created)
+			}
+		})
+	}
+}
@@ -27,6 +27,12 @@ type Plugin struct {
 
 	// reminder manager for meeting notifications
 	reminderManager *ReminderManager
+
+	// subscription manager for EWS notifications
+	subscriptionManager *SubscriptionManager
+
+	// unmappedTimeZones remembers the user zones without a Windows ID that were already logged
+	unmappedTimeZones sync.Map
 }
 
 // ExchangeCredentials represents user's Exchange credentials
@@ -38,15 +44,52 @@ type ExchangeCredentials struct {
 
 // CalendarEvent represents a calendar event from Exchange
 type CalendarEvent struct {
-	ID        string    `json:"id"`
-	Subject   string    `json:"subject"`
-	Start     time.Time `json:"start"`
-	End       time.Time `json:"end"`
-	Location  string    `json:"location"`
-	Organizer string    `json:"organizer"`
-	IsAllDay  bool      `json:"is_all_day"`
-	IsMeeting bool      `json:"is_meeting"`
-	Status    string    `json:"status"` // Free, Busy, Tentative, OutOfOffice
+	ID          string    `json:"id"`
+	Subject     string    `json:"subject"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	Location    string    `json:"location"`
+	Organizer   string    `json:"organizer"`
+	IsAllDay    bool      `json:"is_all_day"`
+	IsMeeting   bool      `json:"is_meeting"`
+	Status      string    `json:"status"` // Free, Busy, Tentative, OutOfOffice
+	IsOrganizer bool      `json:"is_organizer"`
+
+	OrganizerEmail string `json:"organizer_email"` // SMTP, or a legacy DN for EX addresses
+
+	IsRecurring      bool      `json:"is_recurring"`
+	CalendarItemType string    `json:"calendar_item_type"` // Single, Occurrence, Exception, RecurringMaster
+	RecurrenceID     time.Time `json:"recurrence_id"`      // Original start of an occurrence
+	SeriesMasterID   string    `json:"series_master_id"`
+	UID              string    `json:"uid"`
+}
+
+// EWS CalendarItemType values
+const (
+	CalendarItemTypeSingle          = "Single"
+	CalendarItemTypeOccurrence      = "Occurrence"
+	CalendarItemTypeException       = "Exception"
+	CalendarItemTypeRecurringMaster = "RecurringMaster"
+)
+
+// OccurrenceKey identifies a single occurrence of an event. It is stable when an
+// occurrence is moved, because RecurrenceID keeps the original start.
+func (e CalendarEvent) OccurrenceKey() string {
+	if e.UID != "" && !e.RecurrenceID.IsZero() {
+		return fmt.Sprintf("%s_%s", e.UID, e.RecurrenceID.UTC().Format("20060102T150405Z"))
+	}
+	return e.ID
+}
+
+// recurrenceMark returns the marker shown next to recurring events in listings
+func recurrenceMark(event CalendarEvent) string {
+	switch {
+	case event.CalendarItemType == CalendarItemTypeException:
+		return " 🔁 _(изменено)_"
+	case event.IsRecurring:
+		return " 🔁"
+	}
+	return ""
 }
 
 // OnActivate is called when the plugin is activated
@@ -61,6 +104,9 @@ func (p *Plugin) OnActivate() error {
 	// Initialize reminder manager
 	p.reminderManager = NewReminderManager(p)
 
+	// Initialize subscription manager
+	p.subscriptionManager = NewSubscriptionManager(p)
+
 	// Initialize scheduler
 	p.scheduler = NewScheduler(p)
 
@@ -81,6 +127,14 @@ func (p *Plugin) OnDeactivate() error {
 		p.scheduler.Stop()
 	}
 
+	if p.subscriptionManager != nil {
+		p.subscriptionManager.StopAll()
+	}
+
+	if transport := p.getConfiguration().ewsTransport; transport != nil {
+		closeIdleConnections(transport)
+	}
+
 	return nil
 }
 
@@ -90,16 +144,26 @@ func (p *Plugin) startPeriodicTasks() {
 	p.scheduler.AddJob("calendar_sync", 5*time.Minute, p.syncAllUsersCalendars)
 
 	// Start daily summary job
-	p.scheduler.AddJob("daily_summary", 24*time.Hour, p.sendDailySummaries)
+	// Check every minute; each user gets the summary at the configured time in their own zone
+	p.scheduler.AddJob("daily_summary", 1*time.Minute, p.sendDailySummaries)
 
 	// Start meeting notifications check every minute
 	p.scheduler.AddJob("meeting_notifications", 1*time.Minute, p.checkMeetingNotifications)
 
+	// Start mail notifications check every minute
+	p.scheduler.AddJob("mail_notifications", 1*time.Minute, p.checkMailNotifications)
+
+	// Post shared mailbox messages matching the routing rules every minute
+	p.scheduler.AddJob("mail_routing", 1*time.Minute, p.checkMailRouting)
+
 	// Start reminder checks every minute
 	p.scheduler.AddJob("reminder_check", 1*time.Minute, p.reminderManager.CheckAndSendReminders)
 
 	// Update reminders every 30 minutes
 	p.scheduler.AddJob("reminder_update", 30*time.Minute, p.updateAllUsersReminders)
+
+	// Keep EWS subscriptions alive and poll pull subscriptions every 30 seconds
+	p.scheduler.AddJob("ews_subscriptions", 30*time.Second, p.subscriptionManager.Refresh)
 }
 
 // syncAllUsersCalendars syncs calendars for all connected users
@@ -118,9 +182,7 @@ func (p *Plugin) syncAllUsersCalendars() {
 		return
 	}
 
-	for _, user := range users {
-		go p.syncUserCalendar(user.Id)
-	}
+	p.forEachUser(users, p.syncUserCalendar)
 }
 
 // syncUserCalendar syncs calendar for a specific user and updates their status
@@ -131,23 +193,107 @@ func (p *Plugin) syncUserCalendar(userID string) {
 		return
 	}
 
-	events, err := p.getCalendarEvents(credentials)
+	events, err := p.getCalendarEvents(userID, credentials)
 	if err != nil {
-		p.API.LogError("Ошибка получения календарных событий", "user_id", userID, "error", err.Error())
+		p.logExchangeError(userID, "Ошибка получения календарных событий", err)
 		return
 	}
 
+	// The credentials work again; allow a new notification on the next failure
+	p.clearExchangeAuthFailure(userID)
+
+	// Automatic replies take priority over the calendar; without them the status stays calendar-based.
+	// They rarely change, so they are read from Exchange at most once per oofCacheTTL.
+	oof, err := p.getCachedOofSettings(userID, credentials)
+	if err != nil {
+		p.logExchangeError(userID, "Ошибка получения настроек автоответа", err)
+	}
+
 	// Update user status based on current calendar events
-	p.updateUserStatusFromCalendar(userID, events)
+	p.updateUserStatusFromCalendar(userID, events, oof)
 
 	// Update reminders for the user
 	if err := p.reminderManager.UpdateRemindersForUser(userID); err != nil {
-		p.API.LogError("Ошибка обновления напоминаний", "user_id", userID, "error", err.Error())
+		p.logExchangeError(userID, "Ошибка обновления напоминаний", err)
+	}
+}
+
+// logExchangeError logs a background Exchange failure at a level matching its kind.
+// Rejected credentials are also reported to the user once.
+func (p *Plugin) logExchangeError(userID, message string, err error) {
+	var ewsErr *EWSError
+	errors.As(err, &ewsErr)
+
+	switch {
+	case errors.Is(err, ErrUnauthorized):
+		p.API.LogWarn(message+": Exchange отклонил учетные данные", "user_id", userID, "error", err.Error())
+		p.notifyExchangeAuthFailure(userID)
+	case errors.Is(err, ErrLimiterBusy):
+		p.API.LogWarn(message+": достигнут лимит запросов к Exchange", "user_id", userID, "error", err.Error())
+	case errors.Is(err, ErrThrottled):
+		backOff := ""
+		if ewsErr != nil {
+			backOff = ewsErr.BackOff.String()
+		}
+		p.API.LogWarn(message+": Exchange ограничил количество запросов", "user_id", userID, "back_off", backOff, "error", err.Error())
+	case errors.Is(err, ErrLoginTimeout), errors.Is(err, ErrServerUnavailable), errors.Is(err, ErrEndpointUnreachable):
+		p.API.LogWarn(message+": Exchange временно недоступен", "user_id", userID, "error", err.Error())
+	default:
+		p.API.LogError(message, "user_id", userID, "error", err.Error())
+	}
+}
+
+// notifyExchangeAuthFailure tells the user once that Exchange rejected their credentials
+func (p *Plugin) notifyExchangeAuthFailure(userID string) {
+	key := fmt.Sprintf("exchange_auth_failed_%s", userID)
+
+	data, appErr := p.API.KVGet(key)
+	if appErr != nil || data != nil {
+		return
+	}
+
+	if appErr := p.API.KVSet(key, []byte("1")); appErr != nil {
+		p.API.LogError("Ошибка сохранения статуса учетных данных", "user_id", userID, "error", appErr.Error())
+		return
+	}
+
+	bot, botErr := p.API.GetBot("", true)
+	if botErr != nil {
+		p.API.LogError("Ошибка получения бота", "user_id", userID, "error", botErr.Error())
+		return
+	}
+
+	channel, channelErr := p.API.GetDirectChannel(userID, bot.UserId)
+	if channelErr != nil {
+		p.API.LogError("Ошибка создания прямого канала", "user_id", userID, "error", channelErr.Error())
+		return
+	}
+
+	post := &model.Post{
+		ChannelId: channel.Id,
+		UserId:    bot.UserId,
+		Message:   "🔐 **Exchange отклонил ваши учетные данные**\n\nСинхронизация календаря приостановлена. Обновите логин и пароль в настройках Exchange Integration.",
+	}
+
+	if _, postErr := p.API.CreatePost(post); postErr != nil {
+		p.API.LogError("Ошибка отправки уведомления об учетных данных", "user_id", userID, "error", postErr.Error())
+	}
+}
+
+// clearExchangeAuthFailure resets the rejected credentials notification flag
+func (p *Plugin) clearExchangeAuthFailure(userID string) {
+	if appErr := p.API.KVDelete(fmt.Sprintf("exchange_auth_failed_%s", userID)); appErr != nil {
+		p.API.LogError("Ошибка сброса статуса учетных данных", "user_id", userID, "error", appErr.Error())
 	}
 }
 
 // updateUserStatusFromCalendar updates user's Mattermost status based on calendar events
-func (p *Plugin) updateUserStatusFromCalendar(userID string, events []CalendarEvent) {
+// unless the user's automatic replies are on
+func (p *Plugin) updateUserStatusFromCalendar(userID string, events []CalendarEvent, oof *OofSettings) {
+	if p.updateUserStatusFromOof(userID, oof) {
+		return
+	}
+
 	now := time.Now()
 	var currentEvent *CalendarEvent
 
@@ -212,24 +358,18 @@ func (p *Plugin) updateUserStatusFromCalendar(userID string, events []CalendarEv
 	}
 }
 
-// sendDailySummaries sends daily meeting summaries to all users
+// sendDailySummaries sends daily meeting summaries to the users whose local time has
+// reached DailySummaryTime
 func (p *Plugin) sendDailySummaries() {
 	config := p.getConfiguration()
 	summaryTime := config.DailySummaryTime
 
-	// Check if it's the right time for daily summary
-	now := time.Now()
 	targetTime, err := time.Parse("15:04", summaryTime)
 	if err != nil {
 		p.API.LogError("Неверный формат времени для ежедневной сводки", "time", summaryTime)
 		return
 	}
 
-	currentTime := time.Date(0, 1, 1, now.Hour(), now.Minute(), 0, 0, time.UTC)
-	if currentTime.Hour() != targetTime.Hour() || currentTime.Minute() != targetTime.Minute() {
-		return
-	}
-
 	users, getUsersErr := p.API.GetUsers(&model.UserGetOptions{
 		Page:    0,
 		PerPage: 1000,
@@ -239,9 +379,57 @@ func (p *Plugin) sendDailySummaries() {
 		return
 	}
 
+	now := time.Now()
+	var due []*model.User
 	for _, user := range users {
-		go p.sendUserDailySummary(user.Id)
+		day, ok := dailySummaryDue(now, p.userLocation(user), targetTime)
+		if !ok {
+			continue
+		}
+
+		// Once per local day, also across cluster nodes and repeated DST hours
+		claimed, appErr := p.API.KVSetWithOptions(dailySummaryKey(user.Id, day), []byte(now.UTC().Format(time.RFC3339)), model.PluginKVSetOptions{
+			Atomic:          true,
+			OldValue:        nil,
+			ExpireInSeconds: int64(dailySummaryMarkerTTL / time.Second),
+		})
+		if appErr != nil {
+			p.API.LogError("Ошибка отметки ежедневной сводки", "user_id", user.Id, "error", appErr.Error())
+			continue
+		}
+		if claimed {
+			due = append(due, user)
+		}
+	}
+
+	p.forEachUser(due, p.sendUserDailySummary)
+}
+
+// dailySummaryWindow is how late a summary is still sent, e.g. after a missed check
+// or when the send time falls into a skipped DST hour
+const dailySummaryWindow = 30 * time.Minute
+
+// dailySummaryMarkerTTL is how long the per-day marker of a sent summary is kept
+const dailySummaryMarkerTTL = 48 * time.Hour
+
+// dailySummaryDue reports whether the summary is due at now in loc and returns the local day
+func dailySummaryDue(now time.Time, loc *time.Location, targetTime time.Time) (string, bool) {
+	now = now.In(loc)
+	// time.Date picks the first occurrence of a repeated DST hour
+	sendAt := time.Date(now.Year(), now.Month(), now.Day(), targetTime.Hour(), targetTime.Minute(), 0, 0, loc)
+	if sendAt.Hour() != targetTime.Hour() || sendAt.Minute() != targetTime.Minute() {
+		// The send time falls into a skipped DST hour: send when the clocks jump
+		_, sendAt = sendAt.ZoneBounds()
 	}
+	if now.Before(sendAt) || !now.Before(sendAt.Add(dailySummaryWindow)) {
+		return "", false
+	}
+	return now.Format("2006-01-02"), true
+}
+
+// dailySummaryKey returns the KV key marking the summary sent to the user on a local day
+func dailySummaryKey(userID, day string) string {
+	return "daily_summary_" + userID + "_" + day
 }
 
 // sendUserDailySummary sends daily meeting summary to a specific user
@@ -251,14 +439,13 @@ func (p *Plugin) sendUserDailySummary(userID string) {
 		return
 	}
 
-	// Get today's events
-	today := time.Now()
-	startOfDay := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, today.Location())
-	endOfDay := startOfDay.Add(24 * time.Hour)
+	// Get today's events in the user's time zone
+	loc := p.getUserLocation(userID)
+	startOfDay, endOfDay := dayBounds(time.Now(), loc)
 
-	events, err := p.getCalendarEventsInRange(credentials, startOfDay, endOfDay)
+	events, err := p.getCalendarEventsInRange(userID, credentials, startOfDay, endOfDay)
 	if err != nil {
-		p.API.LogError("Ошибка получения событий для ежедневной сводки", "user_id", userID, "error", err.Error())
+		p.logExchangeError(userID, "Ошибка получения событий для ежедневной сводки", err)
 		return
 	}
 
@@ -269,12 +456,12 @@ func (p *Plugin) sendUserDailySummary(userID string) {
 	// Create summary message
 	message := "📅 **Ваши встречи на сегодня:**\n\n"
 	for _, event := range events {
-		startTime := event.Start.Format("15:04")
-		endTime := event.End.Format("15:04")
+		startTime := event.Start.In(loc).Format("15:04")
+		endTime := event.End.In(loc).Format("15:04")
 
-		message += fmt.Sprintf("🕐 **%s - %s**: %s", startTime, endTime, event.Subject)
+		message += fmt.Sprintf("🕐 **%s - %s**: %s%s", startTime, endTime, escapeMentions(event.Subject), recurrenceMark(event))
 		if event.Location != "" {
-			message += fmt.Sprintf(" (📍 %s)", event.Location)
+			message += fmt.Sprintf(" (📍 %s)", escapeMentions(event.Location))
 		}
 		message += "\n"
 	}
@@ -320,9 +507,15 @@ func (p *Plugin) checkMeetingNotifications() {
 		return
 	}
 
-	for _, user := range users {
-		go p.checkUserMeetingNotifications(user.Id)
-	}
+	subscriptions := config.EWSSubscriptionMode == SubscriptionModePull || config.EWSSubscriptionMode == SubscriptionModeStreaming
+
+	p.forEachUser(users, func(userID string) {
+		// Subscribed users are checked as soon as their inbox changes; delegated mailboxes are always polled
+		if !subscriptions || !p.subscriptionManager.IsSubscribed(userID) {
+			p.checkUserMeetingNotifications(userID)
+		}
+		p.checkDelegateMeetingNotifications(userID)
+	})
 }
 
 // checkUserMeetingNotifications checks for new meeting invitations for a specific user
@@ -332,29 +525,53 @@ func (p *Plugin) checkUserMeetingNotifications(userID string) {
 		return
 	}
 
-	// Get new meeting invitations (this would need to be implemented with Exchange Web Services)
-	invitations, err := p.getNewMeetingInvitations(credentials)
+	if err := p.checkMeetingInvitations(userID, credentials, nil); err != nil {
+		p.logExchangeError(userID, "Ошибка получения приглашений на встречи", err)
+	}
+}
+
+// announceMeetingInvitation notifies the user of an invitation of their own mailbox, or of a
+// delegated one if delegate is set. It returns false if the invitation has to be tried again.
+func (p *Plugin) announceMeetingInvitation(userID string, invitation MeetingInvitation, delegate *DelegateMailbox) bool {
+	// Only the check that claims an invitation announces it, so each one is sent once
+	// even when several server nodes run the check at the same time
+	claimed, err := p.claimInvitation(userID, invitation.MeetingRequestID)
 	if err != nil {
-		p.API.LogError("Ошибка получения приглашений на встречи", "user_id", userID, "error", err.Error())
-		return
+		p.API.LogError("Ошибка сохранения отметки о приглашении", "user_id", userID, "error", err.Error())
+		return false
+	}
+	if !claimed || invitation.IsOutOfDate {
+		return true
 	}
 
-	for _, invitation := range invitations {
-		p.sendMeetingInvitationNotification(userID, invitation)
+	if err := p.sendMeetingInvitationNotification(userID, invitation, delegate); err != nil {
+		p.API.LogError("Ошибка отправки уведомления о встрече", "user_id", userID, "error", err.Error())
+		p.releaseInvitation(userID, invitation.MeetingRequestID)
+		return false
 	}
+
+	return true
 }
 
-// sendMeetingInvitationNotification sends a notification about a new meeting invitation
-func (p *Plugin) sendMeetingInvitationNotification(userID string, event CalendarEvent) {
-	startTime := event.Start.Format("02.01.2006 15:04")
+// sendMeetingInvitationNotification sends a notification about a new meeting invitation.
+// Invitations of a delegated mailbox name its owner and can only be viewed, not answered.
+func (p *Plugin) sendMeetingInvitationNotification(userID string, invitation MeetingInvitation, delegate *DelegateMailbox) error {
+	event := invitation.CalendarEvent
+	startTime := event.Start.In(p.getUserLocation(userID)).Format("02.01.2006 15:04")
 
 	message := "📧 **Новое приглашение на встречу**\n\n"
-	message += fmt.Sprintf("**Тема:** %s\n", event.Subject)
+	if invitation.IsUpdate() {
+		message = "🔄 **Приглашение на встречу обновлено**\n\n"
+	}
+	if delegate != nil {
+		message += fmt.Sprintf("👥 **Календарь:** %s\n", escapeMentions(delegate.Label()))
+	}
+	message += fmt.Sprintf("**Тема:** %s%s\n", escapeMentions(event.Subject), recurrenceMark(event))
 	message += fmt.Sprintf("**Время:** %s\n", startTime)
 	if event.Location != "" {
-		message += fmt.Sprintf("**Место:** %s\n", event.Location)
+		message += fmt.Sprintf("**Место:** %s\n", escapeMentions(event.Location))
 	}
-	message += fmt.Sprintf("**Организатор:** %s\n\n", event.Organizer)
+	message += fmt.Sprintf("**Организатор:** %s\n\n", p.organizerMention(userID, event))
 
 	// Add action buttons
 	attachments := []*model.SlackAttachment{
@@ -396,21 +613,37 @@ func (p *Plugin) sendMeetingInvitationNotification(userID string, event Calendar
 						},
 					},
 				},
+				{
+					Id:   "event_details",
+					Name: "📋 Подробности",
+					Type: "button",
+					Integration: &model.PostActionIntegration{
+						URL: "/plugins/com.mattermost.exchange-plugin/api/v1/event/details",
+						Context: map[string]interface{}{
+							"event_id": event.ID,
+							"user_id":  userID,
+						},
+					},
+				},
 			},
 		},
 	}
 
+	// Answering on behalf of the mailbox owner is left to Outlook; only the details button is kept
+	if delegate != nil {
+		actions := attachments[0].Actions
+		attachments[0].Actions = actions[len(actions)-1:]
+	}
+
 	// Send direct message to user
 	bot, botErr := p.API.GetBot("", true)
 	if botErr != nil {
-		p.API.LogError("Ошибка получения бота для уведомления", "user_id", userID, "error", botErr.Error())
-		return
+		return errors.Wrap(botErr, "failed to get bot")
 	}
 
 	channel, channelErr := p.API.GetDirectChannel(userID, bot.UserId)
 	if channelErr != nil {
-		p.API.LogError("Ошибка создания прямого канала для уведомления", "user_id", userID, "error", channelErr.Error())
-		return
+		return errors.Wrap(channelErr, "failed to get direct channel")
 	}
 
 	post := &model.Post{
@@ -422,10 +655,47 @@ func (p *Plugin) sendMeetingInvitationNotification(userID string, event Calendar
 		},
 	}
 
-	_, postErr := p.API.CreatePost(post)
-	if postErr != nil {
-		p.API.LogError("Ошибка отправки уведомления о встрече", "user_id", userID, "error", postErr.Error())
+	if _, postErr := p.API.CreatePost(post); postErr != nil {
+		return errors.Wrap(postErr, "failed to create invitation post")
+	}
+
+	p.API.LogInfo("Уведомление о приглашении отправлено", "user_id", userID, "event_id", event.ID, "subject", event.Subject)
+	return nil
+}
+
+// getBotDirectPost returns a post the bot sent to the user's direct channel with it whose buttons
+// carry value under key. Post actions pass the post ID from the client, so only such posts may be changed.
+func (p *Plugin) getBotDirectPost(userID, postID, key, value string) (*model.Post, error) {
+	post, appErr := p.API.GetPost(postID)
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to get post")
 	}
+
+	bot, appErr := p.API.GetBot("", true)
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to get bot")
+	}
+	if post.UserId != bot.UserId {
+		return nil, errors.New("post was not sent by the bot")
+	}
+
+	channel, appErr := p.API.GetDirectChannel(userID, bot.UserId)
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to get direct channel")
+	}
+	if post.ChannelId != channel.Id {
+		return nil, errors.New("post is not in the user's direct channel with the bot")
+	}
+
+	for _, attachment := range post.Attachments() {
+		for _, action := range attachment.Actions {
+			if action.Integration != nil && action.Integration.Context[key] == value {
+				return post, nil
+			}
+		}
+	}
+
+	return nil, errors.Errorf("post has no action for %s %s", key, value)
 }
 
 // getUserExchangeCredentials retrieves user's Exchange credentials
@@ -460,11 +730,9 @@ func (p *Plugin) updateAllUsersReminders() {
 		return
 	}
 
-	for _, user := range users {
-		go func(userID string) {
-			if err := p.reminderManager.UpdateRemindersForUser(userID); err != nil {
-				p.API.LogError("Ошибка обновления напоминаний пользователя", "user_id", userID, "error", err.Error())
-			}
-		}(user.Id)
-	}
+	p.forEachUser(users, func(userID string) {
+		if err := p.reminderManager.UpdateRemindersForUser(userID); err != nil {
+			p.logExchangeError(userID, "Ошибка обновления напоминаний пользователя", err)
+		}
+	})
 }
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/mattermost/mattermost-server/v6/plugin"
+)
+
+// testBotUserID is the user ID of the plugin bot in tests
+const testBotUserID = "botuserid"
+
+// testAPI implements the plugin API calls the tests use; calling any other panics
+type testAPI struct {
+	plugin.API
+
+	config  *model.Config
+	admins  map[string]bool
+	kv      map[string][]byte
+	users   map[string]*model.User
+	posts   map[string]*model.Post
+	created []*model.Post
+	updated []*model.Post
+}
+
+func newTestPlugin() (*Plugin, *testAPI) {
+	config := &model.Config{}
+	config.SetDefaults()
+
+	api := &testAPI{
+		config: config,
+		admins: make(map[string]bool),
+		kv:     make(map[string][]byte),
+		users:  make(map[string]*model.User),
+		posts:  make(map[string]*model.Post),
+	}
+	p := &Plugin{}
+	p.SetAPI(api)
+	return p, api
+}
+
+// newTestPluginWithEWS returns a plugin whose user testUserID is connected to the EWS handler
+func newTestPluginWithEWS(t *testing.T, handler http.HandlerFunc) (*Plugin, *testAPI) {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	p, api := newTestPlugin()
+	p.setConfiguration(&configuration{ExchangeServerURL: server.URL})
+
+	credentials, _ := json.Marshal(ExchangeCredentials{Username: "jdoe@contoso.com", Password: "Secret1!"})
+	api.kv["exchange_creds_"+testUserID] = credentials
+	if err := p.storeUserEndpoint(testUserID, &UserEndpoint{EWSURL: server.URL + "/EWS/Exchange.asmx", ServerVersion: defaultServerVersion}); err != nil {
+		t.Fatal(err)
+	}
+
+	return p, api
+}
+
+// testUserID is the user ID of the connected user in tests
+const testUserID = "jdoeuserid"
+
+// postAction calls a post action handler as the user and decodes its response
+func postAction(t *testing.T, handler http.HandlerFunc, request model.PostActionIntegrationRequest) model.PostActionIntegrationResponse {
+	t.Helper()
+
+	body, _ := json.Marshal(request)
+	r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	r.Header.Set("Mattermost-User-Id", testUserID)
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	var response model.PostActionIntegrationResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("invalid response %d %q: %v", w.Code, strings.TrimSpace(w.Body.String()), err)
+	}
+	return response
+}
+
+// submitDialog calls an interactive dialog handler as the user and decodes its response
+func submitDialog(t *testing.T, handler http.HandlerFunc, request model.SubmitDialogRequest) model.SubmitDialogResponse {
+	t.Helper()
+
+	body, _ := json.Marshal(request)
+	r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	r.Header.Set("Mattermost-User-Id", testUserID)
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	var response model.SubmitDialogResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("invalid response %d %q: %v", w.Code, strings.TrimSpace(w.Body.String()), err)
+	}
+	return response
+}
+
+func (a *testAPI) GetConfig() *model.Config {
+	return a.config
+}
+
+func (a *testAPI) HasPermissionTo(userID string, permission *model.Permission) bool {
+	return permission == model.PermissionManageSystem && a.admins[userID]
+}
+
+func (a *testAPI) KVGet(key string) ([]byte, *model.AppError) {
+	return a.kv[key], nil
+}
+
+func (a *testAPI) KVSet(key string, value []byte) *model.AppError {
+	a.kv[key] = value
+	return nil
+}
+
+func (a *testAPI) KVSetWithOptions(key string, value []byte, options model.PluginKVSetOptions) (bool, *model.AppError) {
+	if options.Atomic {
+		if current, ok := a.kv[key]; ok != (options.OldValue != nil) || !bytes.Equal(current, options.OldValue) {
+			return false, nil
+		}
+	}
+	if value == nil {
+		delete(a.kv, key)
+	} else {
+		a.kv[key] = value
+	}
+	return true, nil
+}
+
+func (a *testAPI) KVDelete(key string) *model.AppError {
+	delete(a.kv, key)
+	return nil
+}
+
+func (a *testAPI) GetUser(userID string) (*model.User, *model.AppError) {
+	if user, ok := a.users[userID]; ok {
+		return user, nil
+	}
+	return nil, model.NewAppError("GetUser", "app.user.missing_account.const", nil, "", http.StatusNotFound)
+}
+
+func (a *testAPI) GetUserByEmail(email string) (*model.User, *model.AppError) {
+	for _, user := range a.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+	return nil, model.NewAppError("GetUserByEmail", "app.user.missing_account.const", nil, "", http.StatusNotFound)
+}
+
+func (a *testAPI) GetUserByUsername(username string) (*model.User, *model.AppError) {
+	for _, user := range a.users {
+		if user.Username == username {
+			return user, nil
+		}
+	}
+	return nil, model.NewAppError("GetUserByUsername", "app.user.missing_account.const", nil, "", http.StatusNotFound)
+}
+
+func (a *testAPI) GetBot(botUserID string, includeDeleted bool) (*model.Bot, *model.AppError) {
+	return &model.Bot{UserId: testBotUserID}, nil
+}
+
+func (a *testAPI) GetDirectChannel(userID1, userID2 string) (*model.Channel, *model.AppError) {
+	return &model.Channel{Id: model.GetDMNameFromIds(userID1, userID2), Type: model.ChannelTypeDirect}, nil
+}
+
+func (a *testAPI) GetPost(postID string) (*model.Post, *model.AppError) {
+	post, ok := a.posts[postID]
+	if !ok {
+		return nil, model.NewAppError("GetPost", "app.post.get.app_error", nil, "", http.StatusNotFound)
+	}
+	return post.Clone(), nil
+}
+
+func (a *testAPI) CreatePost(post *model.Post) (*model.Post, *model.AppError) {
+	a.created = append(a.created, post)
+	return post, nil
+}
+
+func (a *testAPI) UpdatePost(post *model.Post) (*model.Post, *model.AppError) {
+	a.updated = append(a.updated, post)
+	a.posts[post.Id] = post
+	return post, nil
+}
+
+func (a *testAPI) LogError(msg string, keyValuePairs ...interface{}) {}
+func (a *testAPI) LogWarn(msg string, keyValuePairs ...interface{})  {}
+func (a *testAPI) LogInfo(msg string, keyValuePairs ...interface{})  {}
+
+// addActionPost stores a post with a button whose context holds the key and value
+func (a *testAPI) addActionPost(postID, userID, channelID, key, value string) {
+	post := &model.Post{Id: postID, UserId: userID, ChannelId: channelID, Message: "Приглашение"}
+	post.AddProp("attachments", []*model.SlackAttachment{{
+		Actions: []*model.PostAction{{
+			Id:          "action",
+			Name:        "Кнопка",
+			Integration: &model.PostActionIntegration{Context: map[string]interface{}{key: value}},
+		}},
+	}})
+	a.posts[postID] = post
+}
+
+func TestMeetingInvitationNotificationEscapesMentions(t *testing.T) {
+	p, api := newTestPlugin()
+
+	invitation := MeetingInvitation{CalendarEvent: CalendarEvent{
+		ID:        "event-1",
+		Subject:   "Ретро @channel",
+		Location:  "@all в переговорной",
+		Organizer: "Jane Doe",
+		Start:     time.Date(2024, 7, 1, 10, 0, 0, 0, time.UTC),
+		End:       time.Date(2024, 7, 1, 11, 0, 0, 0, time.UTC),
+	}}
+	if err := p.sendMeetingInvitationNotification(testUserID, invitation, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(api.created) != 1 {
+		t.Fatalf("created %d posts, want 1", len(api.created))
+	}
+	message := api.created[0].Message
+	for _, mention := range []string{"@channel", "@all"} {
+		if strings.Contains(message, mention) {
+			t.Errorf("notification keeps the %s mention: %q", mention, message)
+		}
+	}
+}
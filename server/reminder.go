@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/mattermost/mattermost-server/v6/model"
@@ -11,13 +12,25 @@ import (
 
 // MeetingReminder represents a scheduled reminder
 type MeetingReminder struct {
-	UserID       string    `json:"user_id"`
-	EventID      string    `json:"event_id"`
-	Subject      string    `json:"subject"`
-	StartTime    time.Time `json:"start_time"`
-	Location     string    `json:"location"`
-	ReminderTime time.Time `json:"reminder_time"`
-	Sent         bool      `json:"sent"`
+	UserID        string    `json:"user_id"`
+	EventID       string    `json:"event_id"`
+	OccurrenceKey string    `json:"occurrence_key"` // See CalendarEvent.OccurrenceKey
+	Subject       string    `json:"subject"`
+	StartTime     time.Time `json:"start_time"`
+	Location      string    `json:"location"`
+	IsOrganizer   bool      `json:"is_organizer"`      // The user organizes the meeting
+	Mailbox       string    `json:"mailbox,omitempty"` // Delegated mailbox the meeting is in; empty for the user's own
+	MailboxLabel  string    `json:"mailbox_label,omitempty"`
+	ReminderTime  time.Time `json:"reminder_time"`
+	Sent          bool      `json:"sent"`
+}
+
+// key returns the occurrence key, falling back to the event ID for reminders stored before it existed
+func (r MeetingReminder) key() string {
+	if r.OccurrenceKey != "" {
+		return r.OccurrenceKey
+	}
+	return r.EventID
 }
 
 // ReminderManager manages meeting reminders
@@ -72,26 +85,52 @@ func (rm *ReminderManager) checkUserReminders(userID string, now time.Time) {
 
 		// Check if it's time to send the reminder (with 1-minute tolerance)
 		if now.After(reminder.ReminderTime) && now.Before(reminder.ReminderTime.Add(2*time.Minute)) {
-			if err := rm.sendReminder(reminder); err != nil {
-				rm.plugin.API.LogError("Ошибка отправки напоминания", "user_id", userID, "event_id", reminder.EventID, "error", err.Error())
+			// Mark the reminder as sent first, so only one check (on any node) sends it
+			claimed, err := rm.setReminderSent(reminder, true)
+			if err != nil {
+				rm.plugin.API.LogError("Ошибка обновления статуса напоминания", "user_id", userID, "event_id", reminder.EventID, "error", err.Error())
+				continue
+			}
+			if !claimed {
 				continue
 			}
 
-			// Mark reminder as sent
-			reminder.Sent = true
-			addErr := rm.addUserReminder(reminder)
-			if addErr != nil {
-				rm.plugin.API.LogError("Ошибка обновления статуса напоминания", "user_id", userID, "event_id", reminder.EventID, "error", addErr.Error())
+			if err := rm.sendReminder(reminder); err != nil {
+				rm.plugin.API.LogError("Ошибка отправки напоминания", "user_id", userID, "event_id", reminder.EventID, "error", err.Error())
+				// Let the next check try again
+				if _, err := rm.setReminderSent(reminder, false); err != nil {
+					rm.plugin.API.LogError("Ошибка обновления статуса напоминания", "user_id", userID, "event_id", reminder.EventID, "error", err.Error())
+				}
+				continue
 			}
 		}
 
 		// Clean up old reminders (older than 1 hour after meeting start)
 		if now.After(reminder.StartTime.Add(time.Hour)) {
-			rm.deleteReminder(userID, reminder.EventID)
+			if err := rm.deleteReminder(userID, reminder.key()); err != nil {
+				rm.plugin.API.LogError("Ошибка удаления напоминания", "user_id", userID, "event_id", reminder.EventID, "error", err.Error())
+			}
 		}
 	}
 }
 
+// setReminderSent changes the sent flag of a reminder that is still scheduled for the same
+// time. It returns false if the flag already had that value or the reminder has changed.
+func (rm *ReminderManager) setReminderSent(reminder MeetingReminder, sent bool) (bool, error) {
+	changed := false
+	err := rm.updateUserReminders(reminder.UserID, func(reminders []MeetingReminder) []MeetingReminder {
+		changed = false
+		for i, existing := range reminders {
+			if existing.key() == reminder.key() && existing.ReminderTime.Equal(reminder.ReminderTime) && existing.Sent != sent {
+				reminders[i].Sent = sent
+				changed = true
+			}
+		}
+		return reminders
+	})
+	return changed, err
+}
+
 // sendReminder sends a reminder notification to the user
 func (rm *ReminderManager) sendReminder(reminder MeetingReminder) error {
 	timeUntilMeeting := time.Until(reminder.StartTime)
@@ -113,47 +152,74 @@ func (rm *ReminderManager) sendReminder(reminder MeetingReminder) error {
 	}
 
 	message := "⏰ **Напоминание о встрече**\n\n"
-	message += fmt.Sprintf("**Встреча:** %s\n", reminder.Subject)
-	message += fmt.Sprintf("**Начало:** %s (%s)\n", reminder.StartTime.Format("15:04"), timeText)
+	if reminder.Mailbox != "" {
+		message += fmt.Sprintf("👥 **Календарь:** %s\n", escapeMentions(reminder.MailboxLabel))
+	}
+	message += fmt.Sprintf("**Встреча:** %s\n", escapeMentions(reminder.Subject))
+	loc := rm.plugin.getUserLocation(reminder.UserID)
+	message += fmt.Sprintf("**Начало:** %s (%s)\n", reminder.StartTime.In(loc).Format("15:04"), timeText)
 
 	if reminder.Location != "" {
-		message += fmt.Sprintf("**Место:** %s\n", reminder.Location)
+		message += fmt.Sprintf("**Место:** %s\n", escapeMentions(reminder.Location))
 	}
 
 	message += fmt.Sprintf("\n💡 *Это напоминание отправлено за %s минут до встречи*", reminderMins)
 
 	// Create attachment with quick actions
-	attachments := []*model.SlackAttachment{
+	actions := []*model.PostAction{
+		{
+			Id:   "snooze_reminder",
+			Name: "⏱️ Напомнить через 5 мин",
+			Type: "button",
+			Integration: &model.PostActionIntegration{
+				URL: "/plugins/com.mattermost.exchange-plugin/api/v1/reminder/snooze",
+				Context: map[string]interface{}{
+					"event_id":       reminder.EventID,
+					"occurrence_key": reminder.key(),
+					"user_id":        reminder.UserID,
+					"snooze_mins":    5,
+				},
+			},
+		},
 		{
-			Actions: []*model.PostAction{
-				{
-					Id:   "snooze_reminder",
-					Name: "⏱️ Напомнить через 5 мин",
-					Type: "button",
-					Integration: &model.PostActionIntegration{
-						URL: "/plugins/com.mattermost.exchange-plugin/api/v1/reminder/snooze",
-						Context: map[string]interface{}{
-							"event_id":    reminder.EventID,
-							"user_id":     reminder.UserID,
-							"snooze_mins": 5,
-						},
-					},
+			Id:   "event_details",
+			Name: "📋 Подробности",
+			Type: "button",
+			Integration: &model.PostActionIntegration{
+				URL: "/plugins/com.mattermost.exchange-plugin/api/v1/event/details",
+				Context: map[string]interface{}{
+					"event_id": reminder.EventID,
+					"user_id":  reminder.UserID,
 				},
-				{
-					Id:   "view_calendar",
-					Name: "📅 Открыть календарь",
-					Type: "button",
-					Integration: &model.PostActionIntegration{
-						URL: "/plugins/com.mattermost.exchange-plugin/api/v1/calendar/open",
-						Context: map[string]interface{}{
-							"user_id": reminder.UserID,
-						},
-					},
+			},
+		},
+		{
+			Id:   "view_calendar",
+			Name: "📅 Открыть календарь",
+			Type: "button",
+			Integration: &model.PostActionIntegration{
+				URL: "/plugins/com.mattermost.exchange-plugin/api/v1/calendar/open",
+				Context: map[string]interface{}{
+					"user_id": reminder.UserID,
 				},
 			},
 		},
 	}
 
+	// Meetings the user organizes can be moved or cancelled right from the reminder
+	if reminder.IsOrganizer {
+		actions = append(actions,
+			organizerAction(reminder.EventID, organizerActionReschedule),
+			organizerAction(reminder.EventID, organizerActionCancel),
+		)
+	}
+
+	attachments := []*model.SlackAttachment{
+		{
+			Actions: actions,
+		},
+	}
+
 	// Send direct message to user
 	bot, err := rm.plugin.API.GetBot("", true)
 	if err != nil {
@@ -204,55 +270,79 @@ func (rm *ReminderManager) getUserReminders(userID string) ([]MeetingReminder, e
 	return reminders, nil
 }
 
-// storeUserReminders stores all reminders for a user
-func (rm *ReminderManager) storeUserReminders(userID string, reminders []MeetingReminder) error {
+// maxReminderUpdateAttempts limits the retries when another check changes the reminders concurrently
+const maxReminderUpdateAttempts = 5
+
+// updateUserReminders applies update to the user's stored reminders and saves the result only
+// if nothing changed them in the meantime; on a conflict update runs again on the new list.
+// This keeps the sent and snooze state set by concurrent checks.
+func (rm *ReminderManager) updateUserReminders(userID string, update func([]MeetingReminder) []MeetingReminder) error {
 	key := fmt.Sprintf("user_reminders_%s", userID)
 
-	data, err := json.Marshal(reminders)
-	if err != nil {
-		return fmt.Errorf("failed to marshal reminders: %w", err)
-	}
+	for attempt := 0; attempt < maxReminderUpdateAttempts; attempt++ {
+		oldData, appErr := rm.plugin.API.KVGet(key)
+		if appErr != nil {
+			return fmt.Errorf("failed to get user reminders: %w", appErr)
+		}
 
-	return rm.plugin.API.KVSet(key, data)
-}
+		reminders := []MeetingReminder{}
+		if oldData != nil {
+			if err := json.Unmarshal(oldData, &reminders); err != nil {
+				return fmt.Errorf("failed to unmarshal reminders: %w", err)
+			}
+		}
 
-// deleteReminder deletes a specific reminder
-func (rm *ReminderManager) deleteReminder(userID, eventID string) error {
-	reminders, err := rm.getUserReminders(userID)
-	if err != nil {
-		return err
-	}
+		data, err := json.Marshal(update(reminders))
+		if err != nil {
+			return fmt.Errorf("failed to marshal reminders: %w", err)
+		}
 
-	// Filter out the reminder to delete
-	filteredReminders := make([]MeetingReminder, 0, len(reminders))
-	for _, reminder := range reminders {
-		if reminder.EventID != eventID {
-			filteredReminders = append(filteredReminders, reminder)
+		saved, appErr := rm.plugin.API.KVSetWithOptions(key, data, model.PluginKVSetOptions{
+			Atomic:   true,
+			OldValue: oldData,
+		})
+		if appErr != nil {
+			return fmt.Errorf("failed to store reminders: %w", appErr)
+		}
+		if saved {
+			return nil
 		}
 	}
 
-	return rm.storeUserReminders(userID, filteredReminders)
+	return fmt.Errorf("reminders of user %s changed concurrently %d times", userID, maxReminderUpdateAttempts)
 }
 
-// addUserReminder adds a reminder to user's reminder list
-func (rm *ReminderManager) addUserReminder(reminder MeetingReminder) error {
-	reminders, err := rm.getUserReminders(reminder.UserID)
-	if err != nil {
-		return err
-	}
-
-	// Check if reminder already exists (prevent duplicates)
-	for i, existing := range reminders {
-		if existing.EventID == reminder.EventID {
-			// Update existing reminder
-			reminders[i] = reminder
-			return rm.storeUserReminders(reminder.UserID, reminders)
+// deleteReminder deletes the reminder for an occurrence
+func (rm *ReminderManager) deleteReminder(userID, key string) error {
+	return rm.updateUserReminders(userID, func(reminders []MeetingReminder) []MeetingReminder {
+		// Filter out the reminder to delete
+		filteredReminders := make([]MeetingReminder, 0, len(reminders))
+		for _, reminder := range reminders {
+			if reminder.key() != key {
+				filteredReminders = append(filteredReminders, reminder)
+			}
 		}
-	}
+		return filteredReminders
+	})
+}
 
-	// Add new reminder
-	reminders = append(reminders, reminder)
-	return rm.storeUserReminders(reminder.UserID, reminders)
+// snoozeReminder moves the reminder for an occurrence to snoozeTime and returns it
+func (rm *ReminderManager) snoozeReminder(userID, key string, snoozeTime time.Time) (*MeetingReminder, error) {
+	var snoozed *MeetingReminder
+	err := rm.updateUserReminders(userID, func(reminders []MeetingReminder) []MeetingReminder {
+		snoozed = nil
+		for i := range reminders {
+			if reminders[i].key() == key {
+				reminders[i].ReminderTime = snoozeTime
+				reminders[i].Sent = false
+				reminder := reminders[i]
+				snoozed = &reminder
+				break
+			}
+		}
+		return reminders
+	})
+	return snoozed, err
 }
 
 // UpdateRemindersForUser updates reminders for a specific user based on their calendar
@@ -278,45 +368,96 @@ func (rm *ReminderManager) UpdateRemindersForUser(userID string) error {
 	now := time.Now()
 	endTime := now.Add(7 * 24 * time.Hour)
 
-	events, err := rm.plugin.getCalendarEventsInRange(credentials, now, endTime)
+	events, err := rm.plugin.getCalendarEventsInRange(userID, credentials, now, endTime)
 	if err != nil {
 		return fmt.Errorf("failed to get calendar events: %w", err)
 	}
 
-	// Clear existing reminders and schedule new ones
-	if err := rm.storeUserReminders(userID, []MeetingReminder{}); err != nil {
-		return fmt.Errorf("failed to clear existing reminders: %w", err)
+	// Delegated mailboxes the user opted in for get reminders too; their failures do not affect the user's own
+	delegates, err := rm.plugin.getDelegateMailboxes(userID)
+	if err != nil {
+		rm.plugin.API.LogError("Ошибка получения делегированных ящиков", "user_id", userID, "error", err.Error())
 	}
+	delegateEvents := make(map[int][]CalendarEvent, len(delegates))
+	for i, delegate := range delegates {
+		if !delegate.Reminders {
+			continue
+		}
 
-	// Schedule new reminders
-	for _, event := range events {
-		// Only schedule reminders for future meetings
-		if event.Start.Before(now) {
+		mailboxEvents, err := rm.plugin.getMailboxCalendarEvents(userID, credentials, delegate.Email, now, endTime)
+		if err != nil {
+			rm.plugin.logExchangeError(userID, "Ошибка получения календаря делегированного ящика "+delegate.Email, err)
 			continue
 		}
+		delegateEvents[i] = mailboxEvents
+	}
+
+	// Rebuild from the stored list as it is at save time, so a reminder sent or snoozed
+	// meanwhile keeps its state
+	err = rm.updateUserReminders(userID, func(existing []MeetingReminder) []MeetingReminder {
+		existingByKey := make(map[string]MeetingReminder, len(existing))
+		for _, reminder := range existing {
+			existingByKey[reminder.key()] = reminder
+		}
+
+		reminders := rm.buildReminders(make([]MeetingReminder, 0, len(events)), userID, events, nil, existingByKey, reminderMins, now)
+		for i := range delegates {
+			if mailboxEvents, ok := delegateEvents[i]; ok {
+				reminders = rm.buildReminders(reminders, userID, mailboxEvents, &delegates[i], existingByKey, reminderMins, now)
+			}
+		}
+		return reminders
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store reminders: %w", err)
+	}
 
-		// Calculate reminder time based on configuration
-		reminderTime := event.Start.Add(-time.Duration(reminderMins) * time.Minute)
+	return nil
+}
 
-		// Don't schedule reminders for meetings starting within the reminder window
-		if reminderTime.Before(now) {
+// buildReminders appends the reminders of the events of the user's own calendar, or of a delegated one
+func (rm *ReminderManager) buildReminders(reminders []MeetingReminder, userID string, events []CalendarEvent, delegate *DelegateMailbox, existingByKey map[string]MeetingReminder, reminderMins int, now time.Time) []MeetingReminder {
+	// Rebuild reminders per occurrence
+	for _, event := range events {
+		// Only schedule reminders for future meetings
+		if event.Start.Before(now) {
 			continue
 		}
 
 		reminder := MeetingReminder{
-			UserID:       userID,
-			EventID:      event.ID,
-			Subject:      event.Subject,
-			StartTime:    event.Start,
-			Location:     event.Location,
-			ReminderTime: reminderTime,
+			UserID:        userID,
+			EventID:       event.ID,
+			OccurrenceKey: event.OccurrenceKey(),
+			Subject:       event.Subject,
+			StartTime:     event.Start,
+			Location:      event.Location,
+			IsOrganizer:   event.IsOrganizer && event.IsMeeting,
+			// Calculate reminder time based on configuration
+			ReminderTime: event.Start.Add(-time.Duration(reminderMins) * time.Minute),
 			Sent:         false,
 		}
 
-		if err := rm.addUserReminder(reminder); err != nil {
-			rm.plugin.API.LogError("Ошибка добавления напоминания", "user_id", userID, "event_id", event.ID, "error", err.Error())
+		// The user may attend the same meeting as the mailbox owner, so delegated keys are
+		// prefixed; organizer actions stay with the owner
+		if delegate != nil {
+			reminder.OccurrenceKey = strings.ToLower(delegate.Email) + "_" + reminder.OccurrenceKey
+			reminder.IsOrganizer = false
+			reminder.Mailbox = delegate.Email
+			reminder.MailboxLabel = delegate.Label()
 		}
+
+		// Unchanged occurrences keep their sent and snooze state. A moved occurrence gets
+		// a new reminder; other occurrences of the series are not affected.
+		if previous, ok := existingByKey[reminder.key()]; ok && previous.StartTime.Equal(event.Start) {
+			reminder.ReminderTime = previous.ReminderTime
+			reminder.Sent = previous.Sent
+		} else if reminder.ReminderTime.Before(now) {
+			// Don't schedule reminders for meetings starting within the reminder window
+			continue
+		}
+
+		reminders = append(reminders, reminder)
 	}
 
-	return nil
+	return reminders
 }
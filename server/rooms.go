@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// maxRoomsChecked limits the rooms whose availability one search requests
+const maxRoomsChecked = 100
+
+// roomListsCacheTTL is how long the organization's room lists are cached
+const roomListsCacheTTL = time.Hour
+
+// Room is a room mailbox or a room list
+type Room struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// GetRoomLists returns the room lists (usually buildings) of the organization
+func (c *ExchangeClient) GetRoomLists() ([]Room, error) {
+	soapResp, err := c.sendSOAPRequest("GetRoomLists", SOAPBody{
+		GetRoomLists: &GetRoomLists{},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	response := soapResp.Body.GetRoomListsResponse
+	if response == nil {
+		return nil, fmt.Errorf("empty GetRoomLists response")
+	}
+	if err := response.Err(); err != nil {
+		c.throttled(err)
+		return nil, err
+	}
+
+	lists := []Room{}
+	if response.RoomLists != nil {
+		for _, address := range response.RoomLists.Address {
+			lists = append(lists, Room{Name: address.Name, Email: address.EmailAddress})
+		}
+	}
+
+	return lists, nil
+}
+
+// GetRooms returns the rooms of a room list
+func (c *ExchangeClient) GetRooms(roomListEmail string) ([]Room, error) {
+	soapResp, err := c.sendSOAPRequest("GetRooms", SOAPBody{
+		GetRooms: &GetRooms{
+			RoomList: &RoomListId{EmailAddress: roomListEmail},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	response := soapResp.Body.GetRoomsResponse
+	if response == nil {
+		return nil, fmt.Errorf("empty GetRooms response")
+	}
+	if err := response.Err(); err != nil {
+		c.throttled(err)
+		return nil, err
+	}
+
+	rooms := []Room{}
+	if response.Rooms != nil {
+		for _, room := range response.Rooms.Room {
+			if room.Id != nil && room.Id.EmailAddress != "" {
+				rooms = append(rooms, Room{Name: room.Id.Name, Email: room.Id.EmailAddress})
+			}
+		}
+	}
+
+	return rooms, nil
+}
+
+// FindFreeRooms returns the rooms of the room lists that are free for the whole range, ordered by name
+func (c *ExchangeClient) FindFreeRooms(roomLists []Room, start, end time.Time) ([]Room, error) {
+	var rooms []Room
+	seen := make(map[string]bool)
+	for _, list := range roomLists {
+		listRooms, err := c.GetRooms(list.Email)
+		if err != nil {
+			return nil, err
+		}
+		for _, room := range listRooms {
+			email := strings.ToLower(room.Email)
+			if !seen[email] && len(rooms) < maxRoomsChecked {
+				seen[email] = true
+				rooms = append(rooms, room)
+			}
+		}
+	}
+
+	free := []Room{}
+	for offset := 0; offset < len(rooms); offset += maxAvailabilityMailboxes {
+		batch := rooms[offset:min(offset+maxAvailabilityMailboxes, len(rooms))]
+
+		emails := make([]string, 0, len(batch))
+		for _, room := range batch {
+			emails = append(emails, room.Email)
+		}
+
+		availability, err := c.GetUserAvailability(emails, start, end)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, mailbox := range availability {
+			if mailbox.Err == nil && slotStatus(mailbox.Busy, start, end) == "" {
+				free = append(free, batch[i])
+			}
+		}
+	}
+
+	sort.Slice(free, func(i, j int) bool {
+		return free[i].Name < free[j].Name
+	})
+
+	return free, nil
+}
+
+// getRoomLists returns the room lists, cached for all users
+func (p *Plugin) getRoomLists(userID string, credentials *ExchangeCredentials) ([]Room, error) {
+	if data, appErr := p.API.KVGet("exchange_room_lists"); appErr == nil && data != nil {
+		var lists []Room
+		if err := json.Unmarshal(data, &lists); err == nil {
+			return lists, nil
+		}
+	}
+
+	client, err := p.newUserExchangeClient(userID, credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	lists, err := client.GetRoomLists()
+	p.recordEndpointResult(userID, client, err)
+	if err != nil {
+		return nil, err
+	}
+
+	if data, err := json.Marshal(lists); err == nil {
+		if _, appErr := p.API.KVSetWithOptions("exchange_room_lists", data, model.PluginKVSetOptions{
+			ExpireInSeconds: int64(roomListsCacheTTL / time.Second),
+		}); appErr != nil {
+			p.API.LogWarn("Ошибка сохранения списков переговорных", "error", appErr.Error())
+		}
+	}
+
+	return lists, nil
+}
+
+// matchRoomLists returns the room lists whose name or address contains building; all lists if it is empty
+func matchRoomLists(lists []Room, building string) []Room {
+	building = strings.ToLower(strings.TrimSpace(building))
+	if building == "" {
+		return lists
+	}
+
+	var matched []Room
+	for _, list := range lists {
+		if strings.Contains(strings.ToLower(list.Name), building) || strings.EqualFold(list.Email, building) {
+			matched = append(matched, list)
+		}
+	}
+	return matched
+}
+
+// findFreeRooms returns the rooms of the matching room lists that are free in the range
+func (p *Plugin) findFreeRooms(userID string, credentials *ExchangeCredentials, roomLists []Room, start, end time.Time) ([]Room, error) {
+	client, err := p.newUserExchangeClient(userID, credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	rooms, err := client.FindFreeRooms(roomLists, start, end)
+	p.recordEndpointResult(userID, client, err)
+
+	return rooms, err
+}
+
+// roomsAreFree reports whether all rooms are free for the whole range
+func (p *Plugin) roomsAreFree(userID string, credentials *ExchangeCredentials, emails []string, start, end time.Time) (bool, error) {
+	client, err := p.newUserExchangeClient(userID, credentials)
+	if err != nil {
+		return false, err
+	}
+
+	availability, err := client.GetUserAvailability(emails, start, end)
+	p.recordEndpointResult(userID, client, err)
+	if err != nil {
+		return false, err
+	}
+
+	for _, mailbox := range availability {
+		if mailbox.Err == nil && slotStatus(mailbox.Busy, start, end) != "" {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// roomListOptions returns the room lists as dialog options, or nil if they cannot be loaded
+func (p *Plugin) roomListOptions(userID string) []*model.PostActionOptions {
+	credentials, err := p.getUserExchangeCredentials(userID)
+	if err != nil {
+		return nil
+	}
+
+	lists, err := p.getRoomLists(userID, credentials)
+	if err != nil {
+		p.logExchangeError(userID, "Ошибка получения списков переговорных", err)
+		return nil
+	}
+
+	options := make([]*model.PostActionOptions, 0, len(lists))
+	for _, list := range lists {
+		options = append(options, &model.PostActionOptions{Text: list.Name, Value: list.Email})
+	}
+	return options
+}
+
+// parseRoomsArguments parses "[building] [time] [duration]" of /exchange rooms.
+// Time is ЧЧ:ММ, optionally preceded by a date; the default is the next half hour for 30 minutes.
+func parseRoomsArguments(args []string, now time.Time, loc *time.Location) (string, time.Time, int, error) {
+	now = now.In(loc)
+	day := now
+	var clock *time.Time
+	minutes := 0
+	var building []string
+
+	for _, arg := range args {
+		if parsed, err := time.Parse(dialogTimeLayout, arg); err == nil {
+			clock = &parsed
+			continue
+		}
+		if parsed, ok := parseDayArgument(arg, now, loc); ok {
+			day = parsed
+			continue
+		}
+		// A bare number is a duration only after the time, so "Корпус 2" stays a building name
+		_, bareErr := strconv.Atoi(arg)
+		if value, ok := parseMinutes(strings.ToLower(arg)); ok && minutes == 0 && (clock != nil || bareErr != nil) {
+			minutes = value
+			continue
+		}
+		building = append(building, arg)
+	}
+
+	if minutes == 0 {
+		minutes = findTimeDefaultMinutes
+	}
+
+	start := now.Truncate(findTimeSlotStep).Add(findTimeSlotStep)
+	if clock != nil {
+		start = time.Date(day.Year(), day.Month(), day.Day(), clock.Hour(), clock.Minute(), 0, 0, loc)
+	} else if day.YearDay() != now.YearDay() || day.Year() != now.Year() {
+		start = time.Date(day.Year(), day.Month(), day.Day(), workdayStartHour, 0, 0, 0, loc)
+	}
+
+	if start.Before(now.Add(-time.Minute)) {
+		return "", time.Time{}, 0, fmt.Errorf("время начала уже прошло")
+	}
+
+	return strings.Join(building, " "), start, minutes, nil
+}
+
+// formatFreeRooms lists the free rooms with a booking button each
+func formatFreeRooms(rooms []Room, start, end time.Time) []*model.SlackAttachment {
+	attachments := make([]*model.SlackAttachment, 0, len(rooms))
+	for _, room := range rooms {
+		attachments = append(attachments, &model.SlackAttachment{
+			Title: "🏢 " + room.Name,
+			Text:  room.Email,
+			Actions: []*model.PostAction{bookingAction("📅 Забронировать", NewMeeting{
+				Subject:   "Встреча",
+				Location:  room.Name,
+				Start:     start,
+				End:       end,
+				Resources: []string{room.Email},
+			})},
+		})
+	}
+	return attachments
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+func TestBookRoomDoesNotBookWhenAvailabilityFails(t *testing.T) {
+	var checks, creates int32
+	p, _ := newTestPluginWithEWS(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.Header.Get("SOAPAction"), "/CreateItem") {
+			atomic.AddInt32(&creates, 1)
+		} else {
+			atomic.AddInt32(&checks, 1)
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	start := time.Now().Add(24 * time.Hour).Truncate(time.Hour)
+	rooms := []Room{{Name: "Переговорная 1", Email: "room1@contoso.com"}}
+	listing := p.bookingListResponse(testUserID, "🏢 Свободные переговорные", formatFreeRooms(rooms, start, start.Add(time.Hour)))
+
+	for click := 1; click <= 2; click++ {
+		response := postAction(t, p.handleBookSlot, model.PostActionIntegrationRequest{
+			UserId:  testUserID,
+			Context: listing.Attachments[0].Actions[0].Integration.Context,
+		})
+		if !strings.Contains(response.EphemeralText, "Не удалось проверить") || response.Update != nil {
+			t.Errorf("click %d: unexpected response %+v", click, response)
+		}
+	}
+
+	if n := atomic.LoadInt32(&creates); n != 0 {
+		t.Errorf("meeting booked %d times without a room check", n)
+	}
+	if atomic.LoadInt32(&checks) == 0 {
+		t.Error("room availability was not checked")
+	}
+}
@@ -0,0 +1,638 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// EWS subscription modes
+const (
+	SubscriptionModePolling   = "polling"
+	SubscriptionModePull      = "pull"
+	SubscriptionModeStreaming = "streaming"
+)
+
+const (
+	// pullSubscriptionTimeout is the pull subscription lifetime in minutes without GetEvents calls
+	pullSubscriptionTimeout = 10
+	// streamingConnectionTimeout is how long one GetStreamingEvents connection stays open, in minutes
+	streamingConnectionTimeout = 10
+	// subscriptionLeaseTTL is how long a node serves a user's subscription without renewing it
+	subscriptionLeaseTTL = 90 * time.Second
+	// subscriptionRetryDelay is how long a user stays on polling after the subscription failed
+	subscriptionRetryDelay = 5 * time.Minute
+	// minStreamingConnectionTime is how long a streaming connection has to stay open to count as working
+	minStreamingConnectionTime = time.Minute
+	// streamingReconnectDelay is the pause after a streaming connection closed early; it doubles with each further one
+	streamingReconnectDelay = 5 * time.Second
+	// maxEarlyStreamingCloses is how many early closes in a row return the user to polling
+	maxEarlyStreamingCloses = 5
+)
+
+// errStreamingClosed is reported when the server keeps closing the streaming connection right away
+var errStreamingClosed = errors.New("exchange: streaming connection closed right after opening")
+
+// subscriptionEventTypes are the events that trigger a calendar or inbox check
+var subscriptionEventTypes = []string{"NewMailEvent", "CreatedEvent", "ModifiedEvent", "DeletedEvent", "MovedEvent", "CopiedEvent"}
+
+// Subscription is an EWS notification subscription for the calendar and inbox folders
+type Subscription struct {
+	ID               string
+	Watermark        string
+	Streaming        bool
+	CalendarFolderID string
+	InboxFolderID    string
+}
+
+// FolderChanges tells which subscribed folders have changed
+type FolderChanges struct {
+	Calendar bool
+	Inbox    bool
+}
+
+// add records the folders an event refers to
+func (f *FolderChanges) add(sub *Subscription, event NotificationEvent) {
+	if event.XMLName.Local == "NewMailEvent" {
+		f.Inbox = true
+	}
+	for _, folder := range []*FolderId{event.ParentFolderId, event.OldParentFolderId, event.FolderId} {
+		if folder == nil {
+			continue
+		}
+		switch folder.Id {
+		case sub.CalendarFolderID:
+			f.Calendar = true
+		case sub.InboxFolderID:
+			f.Inbox = true
+		}
+	}
+}
+
+// subscriptionFolders returns the folders the plugin subscribes to
+func subscriptionFolders() *FolderIds {
+	return &FolderIds{
+		DistinguishedFolderId: []DistinguishedFolderId{{Id: "calendar"}, {Id: "inbox"}},
+	}
+}
+
+// Subscribe creates a pull or streaming subscription for the calendar and inbox folders
+func (c *ExchangeClient) Subscribe(streaming bool) (*Subscription, error) {
+	sub := &Subscription{Streaming: streaming}
+	if err := c.resolveSubscriptionFolders(sub); err != nil {
+		return nil, err
+	}
+
+	request := &SubscriptionRequest{
+		FolderIds:  subscriptionFolders(),
+		EventTypes: &EventTypes{EventType: subscriptionEventTypes},
+	}
+	subscribe := &Subscribe{}
+	if streaming {
+		subscribe.StreamingSubscriptionRequest = request
+	} else {
+		request.Timeout = pullSubscriptionTimeout
+		subscribe.PullSubscriptionRequest = request
+	}
+
+	soapResp, err := c.sendSOAPRequest("Subscribe", SOAPBody{Subscribe: subscribe})
+	if err != nil {
+		return nil, err
+	}
+
+	if soapResp.Body.SubscribeResponse == nil ||
+		soapResp.Body.SubscribeResponse.ResponseMessages == nil ||
+		len(soapResp.Body.SubscribeResponse.ResponseMessages.SubscribeResponseMessage) == 0 {
+		return nil, fmt.Errorf("empty Subscribe response")
+	}
+
+	responseMessage := soapResp.Body.SubscribeResponse.ResponseMessages.SubscribeResponseMessage[0]
+	if err := responseMessage.Err(); err != nil {
+		c.throttled(err)
+		return nil, err
+	}
+
+	sub.ID = responseMessage.SubscriptionId
+	sub.Watermark = responseMessage.Watermark
+	return sub, nil
+}
+
+// resolveSubscriptionFolders looks up the IDs of the calendar and inbox folders,
+// since notifications refer to folders by ID only
+func (c *ExchangeClient) resolveSubscriptionFolders(sub *Subscription) error {
+	soapResp, err := c.sendSOAPRequest("GetFolder", SOAPBody{
+		GetFolder: &GetFolder{
+			FolderShape: &FolderShape{BaseShape: "IdOnly"},
+			FolderIds:   subscriptionFolders(),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if soapResp.Body.GetFolderResponse == nil ||
+		soapResp.Body.GetFolderResponse.ResponseMessages == nil ||
+		len(soapResp.Body.GetFolderResponse.ResponseMessages.GetFolderResponseMessage) != 2 {
+		return fmt.Errorf("unexpected GetFolder response")
+	}
+
+	// Response messages are returned in the order of the requested folders
+	var folderIDs []string
+	for _, message := range soapResp.Body.GetFolderResponse.ResponseMessages.GetFolderResponseMessage {
+		if err := message.Err(); err != nil {
+			c.throttled(err)
+			return err
+		}
+		switch {
+		case message.Folders == nil:
+			return fmt.Errorf("empty GetFolder response")
+		case len(message.Folders.CalendarFolder) > 0:
+			folderIDs = append(folderIDs, message.Folders.CalendarFolder[0].FolderId.Id)
+		case len(message.Folders.Folder) > 0:
+			folderIDs = append(folderIDs, message.Folders.Folder[0].FolderId.Id)
+		default:
+			return fmt.Errorf("empty GetFolder response")
+		}
+	}
+
+	sub.CalendarFolderID = folderIDs[0]
+	sub.InboxFolderID = folderIDs[1]
+	return nil
+}
+
+// GetEvents returns the folder changes of a pull subscription since its watermark
+// and advances the watermark. Each call also renews the subscription.
+func (c *ExchangeClient) GetEvents(sub *Subscription) (FolderChanges, error) {
+	var changes FolderChanges
+	for {
+		soapResp, err := c.sendSOAPRequest("GetEvents", SOAPBody{
+			GetEvents: &GetEvents{
+				SubscriptionId: sub.ID,
+				Watermark:      sub.Watermark,
+			},
+		})
+		if err != nil {
+			return changes, err
+		}
+
+		if soapResp.Body.GetEventsResponse == nil ||
+			soapResp.Body.GetEventsResponse.ResponseMessages == nil ||
+			len(soapResp.Body.GetEventsResponse.ResponseMessages.GetEventsResponseMessage) == 0 {
+			return changes, fmt.Errorf("empty GetEvents response")
+		}
+
+		responseMessage := soapResp.Body.GetEventsResponse.ResponseMessages.GetEventsResponseMessage[0]
+		if err := responseMessage.Err(); err != nil {
+			c.throttled(err)
+			return changes, err
+		}
+
+		notification := responseMessage.Notification
+		if notification == nil {
+			return changes, nil
+		}
+
+		for _, event := range notification.Events {
+			changes.add(sub, event)
+			if event.Watermark != "" {
+				sub.Watermark = event.Watermark
+			}
+		}
+
+		if notification.MoreEvents != "true" {
+			return changes, nil
+		}
+	}
+}
+
+// StreamEvents keeps a GetStreamingEvents connection open until the server closes it
+// after streamingConnectionTimeout or ctx is canceled, calling handle for each notification
+func (c *ExchangeClient) StreamEvents(ctx context.Context, sub *Subscription, handle func(FolderChanges)) error {
+	ewsURL := c.findWorkingEWSEndpoint()
+	if ewsURL == "" {
+		ewsURL = c.serverURL + "/EWS/Exchange.asmx" // fallback
+	}
+
+	soapRequest, err := c.marshalSOAPRequest(c.requestServerVersion(), SOAPBody{
+		GetStreamingEvents: &GetStreamingEvents{
+			SubscriptionIds:   &SubscriptionIds{SubscriptionId: []string{sub.ID}},
+			ConnectionTimeout: streamingConnectionTimeout,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", ewsURL, strings.NewReader(soapRequest))
+	if err != nil {
+		return fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/xml; charset=utf-8")
+	req.Header.Set("SOAPAction", `"http://schemas.microsoft.com/exchange/services/2006/messages/GetStreamingEvents"`)
+	req.SetBasicAuth(c.authUsername(), c.credentials.Password)
+
+	// The connection is long-lived, so it only waits for the limiter instead of holding a slot
+	if err := c.waitForLimiter(ctx); err != nil {
+		return err
+	}
+
+	// The regular client timeout would cut the stream short
+	streamClient := &http.Client{Transport: c.transport}
+	resp, err := streamClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrEndpointUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		err := httpStatusError(resp, body)
+		c.throttled(err)
+		return err
+	}
+
+	// The response is a sequence of SOAP envelopes sent as events arrive
+	decoder := xml.NewDecoder(resp.Body)
+	for {
+		var envelope SOAPResponse
+		if err := decoder.Decode(&envelope); err != nil {
+			if errors.Is(err, io.EOF) || ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to read streaming events: %w", err)
+		}
+
+		if envelope.Body.Fault != nil {
+			return soapFaultError(envelope.Body.Fault)
+		}
+
+		if envelope.Body.GetStreamingEventsResponse == nil || envelope.Body.GetStreamingEventsResponse.ResponseMessages == nil {
+			continue
+		}
+
+		for _, message := range envelope.Body.GetStreamingEventsResponse.ResponseMessages.GetStreamingEventsResponseMessage {
+			if err := message.Err(); err != nil {
+				c.throttled(err)
+				return err
+			}
+
+			if message.Notifications != nil {
+				var changes FolderChanges
+				for _, notification := range message.Notifications.Notification {
+					for _, event := range notification.Events {
+						changes.add(sub, event)
+					}
+				}
+				if changes.Calendar || changes.Inbox {
+					handle(changes)
+				}
+			}
+
+			if message.ConnectionStatus == "Closed" {
+				return nil
+			}
+		}
+	}
+}
+
+// Unsubscribe ends a subscription
+func (c *ExchangeClient) Unsubscribe(sub *Subscription) error {
+	soapResp, err := c.sendSOAPRequest("Unsubscribe", SOAPBody{
+		Unsubscribe: &Unsubscribe{SubscriptionId: sub.ID},
+	})
+	if err != nil {
+		return err
+	}
+
+	if soapResp.Body.UnsubscribeResponse == nil ||
+		soapResp.Body.UnsubscribeResponse.ResponseMessages == nil ||
+		len(soapResp.Body.UnsubscribeResponse.ResponseMessages.UnsubscribeResponseMessage) == 0 {
+		return fmt.Errorf("empty Unsubscribe response")
+	}
+
+	return soapResp.Body.UnsubscribeResponse.ResponseMessages.UnsubscribeResponseMessage[0].Err()
+}
+
+// userSubscription is the subscription state of a user served by this node
+type userSubscription struct {
+	subscription *Subscription
+	// cancel stops the streaming connection
+	cancel context.CancelFunc
+	// failedUntil keeps the user on polling after a failure
+	failedUntil time.Time
+	// leased is set while this node holds the user's lease
+	leased bool
+}
+
+// SubscriptionManager keeps EWS subscriptions for connected users and reacts to their
+// notifications. Each user is served by one node, which holds a lease in the KV store;
+// users without a working subscription are handled by the regular polling jobs.
+type SubscriptionManager struct {
+	plugin *Plugin
+	nodeID string
+
+	mutex sync.Mutex
+	users map[string]*userSubscription
+}
+
+// NewSubscriptionManager creates a new subscription manager
+func NewSubscriptionManager(plugin *Plugin) *SubscriptionManager {
+	return &SubscriptionManager{
+		plugin: plugin,
+		nodeID: model.NewId(),
+		users:  make(map[string]*userSubscription),
+	}
+}
+
+// Refresh creates, polls and renews the subscriptions of all connected users
+func (sm *SubscriptionManager) Refresh() {
+	config := sm.plugin.getConfiguration()
+	if config.EWSSubscriptionMode != SubscriptionModePull && config.EWSSubscriptionMode != SubscriptionModeStreaming {
+		sm.StopAll()
+		return
+	}
+
+	users, err := sm.plugin.API.GetUsers(&model.UserGetOptions{
+		Page:    0,
+		PerPage: 1000,
+	})
+	if err != nil {
+		sm.plugin.API.LogError("Ошибка получения пользователей для подписок Exchange", "error", err.Error())
+		return
+	}
+
+	sm.plugin.forEachUser(users, sm.refreshUser)
+}
+
+// refreshUser keeps the subscription of one user alive
+func (sm *SubscriptionManager) refreshUser(userID string) {
+	credentials, err := sm.plugin.getUserExchangeCredentials(userID)
+	if err != nil {
+		sm.stop(userID)
+		return
+	}
+
+	state := sm.state(userID)
+	if time.Now().Before(state.failedUntil) {
+		return
+	}
+
+	// Another node serves this user
+	if !sm.renewLease(userID) {
+		sm.stop(userID)
+		return
+	}
+	sm.mutex.Lock()
+	state.leased = true
+	sm.mutex.Unlock()
+
+	client, err := sm.plugin.newUserExchangeClient(userID, credentials)
+	if err != nil {
+		sm.fail(userID, err)
+		return
+	}
+
+	sm.mutex.Lock()
+	sub := state.subscription
+	streaming := state.cancel != nil
+	sm.mutex.Unlock()
+
+	if sub == nil {
+		sub, err = sm.subscribe(client)
+		sm.plugin.recordEndpointResult(userID, client, err)
+		if err != nil {
+			sm.fail(userID, err)
+			return
+		}
+
+		sm.mutex.Lock()
+		state.subscription = sub
+		sm.mutex.Unlock()
+
+		// Catch up on changes made while the user had no subscription
+		sm.dispatch(userID, FolderChanges{Calendar: true, Inbox: true})
+	}
+
+	if sub.Streaming {
+		if !streaming {
+			ctx, cancel := context.WithCancel(context.Background())
+			sm.mutex.Lock()
+			state.cancel = cancel
+			sm.mutex.Unlock()
+			go sm.stream(ctx, userID, client, sub)
+		}
+		return
+	}
+
+	changes, err := client.GetEvents(sub)
+	if err != nil {
+		sm.fail(userID, err)
+		return
+	}
+	sm.dispatch(userID, changes)
+}
+
+// subscribe creates a subscription in the configured mode. Streaming falls back to a pull
+// subscription on servers that do not support it (before Exchange 2010 SP1).
+func (sm *SubscriptionManager) subscribe(client *ExchangeClient) (*Subscription, error) {
+	streaming := sm.plugin.getConfiguration().EWSSubscriptionMode == SubscriptionModeStreaming &&
+		client.ServerVersion() != fallbackServerVersion
+
+	if streaming {
+		sub, err := client.Subscribe(true)
+		if err == nil || !streamingUnsupported(err) {
+			return sub, err
+		}
+	}
+
+	return client.Subscribe(false)
+}
+
+// streamingUnsupported reports whether a streaming Subscribe failed because the server lacks support
+func streamingUnsupported(err error) bool {
+	var ewsErr *EWSError
+	if !errors.As(err, &ewsErr) {
+		return false
+	}
+	return ewsErr.Kind == nil &&
+		(ewsErr.StatusCode == http.StatusBadRequest || ewsErr.StatusCode == http.StatusOK || ewsErr.StatusCode == http.StatusInternalServerError)
+}
+
+// stream keeps reconnecting the streaming connection of a user until it fails or is stopped.
+// Connections the server closes early are reopened after a growing delay, and after
+// maxEarlyStreamingCloses of them in a row the user returns to polling.
+func (sm *SubscriptionManager) stream(ctx context.Context, userID string, client *ExchangeClient, sub *Subscription) {
+	earlyCloses := 0
+	for ctx.Err() == nil {
+		opened := time.Now()
+		err := client.StreamEvents(ctx, sub, func(changes FolderChanges) {
+			sm.dispatch(userID, changes)
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			sm.fail(userID, err)
+			return
+		}
+
+		if time.Since(opened) >= minStreamingConnectionTime {
+			earlyCloses = 0
+			continue
+		}
+
+		earlyCloses++
+		if earlyCloses >= maxEarlyStreamingCloses {
+			sm.fail(userID, errStreamingClosed)
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(streamingBackoff(earlyCloses)):
+		}
+	}
+}
+
+// streamingBackoff returns the delay before reopening a streaming connection after the given
+// number of early closes in a row
+func streamingBackoff(earlyCloses int) time.Duration {
+	return streamingReconnectDelay << (earlyCloses - 1)
+}
+
+// dispatch runs the checks for the changed folders
+func (sm *SubscriptionManager) dispatch(userID string, changes FolderChanges) {
+	config := sm.plugin.getConfiguration()
+
+	if changes.Calendar {
+		if config.EnableCalendarSync {
+			sm.plugin.syncUserCalendar(userID)
+		} else if err := sm.plugin.reminderManager.UpdateRemindersForUser(userID); err != nil {
+			sm.plugin.logExchangeError(userID, "Ошибка обновления напоминаний", err)
+		}
+	}
+
+	if changes.Inbox && config.EnableMeetingNotifications {
+		sm.plugin.checkUserMeetingNotifications(userID)
+	}
+
+	if changes.Inbox && config.EnableMailNotifications {
+		sm.plugin.checkUserMailNotifications(userID)
+	}
+}
+
+// fail drops the user's subscription and returns the user to polling for a while
+func (sm *SubscriptionManager) fail(userID string, err error) {
+	if errors.Is(err, ErrSubscriptionLost) {
+		sm.plugin.API.LogInfo("Подписка Exchange истекла и будет создана заново", "user_id", userID)
+	} else {
+		sm.plugin.logExchangeError(userID, "Ошибка подписки на уведомления Exchange, используется периодический опрос", err)
+	}
+
+	sm.stop(userID)
+
+	// A lost subscription is recreated on the next refresh
+	if !errors.Is(err, ErrSubscriptionLost) {
+		sm.mutex.Lock()
+		sm.stateLocked(userID).failedUntil = time.Now().Add(subscriptionRetryDelay)
+		sm.mutex.Unlock()
+	}
+}
+
+// IsSubscribed reports whether any node currently serves the user with a subscription
+func (sm *SubscriptionManager) IsSubscribed(userID string) bool {
+	data, appErr := sm.plugin.API.KVGet(fmt.Sprintf("exchange_subscription_%s", userID))
+	return appErr == nil && data != nil
+}
+
+// renewLease claims or extends this node's lease on serving the user
+func (sm *SubscriptionManager) renewLease(userID string) bool {
+	key := fmt.Sprintf("exchange_subscription_%s", userID)
+	nodeID := []byte(sm.nodeID)
+
+	for _, oldValue := range [][]byte{nil, nodeID} {
+		ok, appErr := sm.plugin.API.KVSetWithOptions(key, nodeID, model.PluginKVSetOptions{
+			Atomic:          true,
+			OldValue:        oldValue,
+			ExpireInSeconds: int64(subscriptionLeaseTTL / time.Second),
+		})
+		if appErr != nil {
+			sm.plugin.API.LogError("Ошибка продления подписки Exchange", "user_id", userID, "error", appErr.Error())
+			return false
+		}
+		if ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// releaseLease gives up this node's lease so the user is polled again
+func (sm *SubscriptionManager) releaseLease(userID string) {
+	key := fmt.Sprintf("exchange_subscription_%s", userID)
+	if _, appErr := sm.plugin.API.KVCompareAndDelete(key, []byte(sm.nodeID)); appErr != nil {
+		sm.plugin.API.LogError("Ошибка освобождения подписки Exchange", "user_id", userID, "error", appErr.Error())
+	}
+}
+
+// state returns the user's subscription state, creating it if needed
+func (sm *SubscriptionManager) state(userID string) *userSubscription {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	return sm.stateLocked(userID)
+}
+
+func (sm *SubscriptionManager) stateLocked(userID string) *userSubscription {
+	state, ok := sm.users[userID]
+	if !ok {
+		state = &userSubscription{}
+		sm.users[userID] = state
+	}
+	return state
+}
+
+// stop closes the user's subscription on this node. Pull subscriptions expire on
+// the server after pullSubscriptionTimeout, streaming ones when the connection closes.
+func (sm *SubscriptionManager) stop(userID string) {
+	sm.mutex.Lock()
+	state, ok := sm.users[userID]
+	var leased bool
+	if ok {
+		leased = state.leased
+		if state.cancel != nil {
+			state.cancel()
+		}
+		state.subscription = nil
+		state.cancel = nil
+		state.leased = false
+	}
+	sm.mutex.Unlock()
+
+	if leased {
+		sm.releaseLease(userID)
+	}
+}
+
+// StopAll closes all subscriptions served by this node
+func (sm *SubscriptionManager) StopAll() {
+	sm.mutex.Lock()
+	userIDs := make([]string, 0, len(sm.users))
+	for userID := range sm.users {
+		userIDs = append(userIDs, userID)
+	}
+	sm.mutex.Unlock()
+
+	for _, userID := range userIDs {
+		sm.stop(userID)
+	}
+}
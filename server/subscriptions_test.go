@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStreamingBackoff(t *testing.T) {
+	tests := []struct {
+		earlyCloses int
+		want        time.Duration
+	}{
+		{1, 5 * time.Second},
+		{2, 10 * time.Second},
+		{3, 20 * time.Second},
+		{maxEarlyStreamingCloses - 1, 40 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := streamingBackoff(tt.earlyCloses); got != tt.want {
+			t.Errorf("streamingBackoff(%d) = %s, want %s", tt.earlyCloses, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// Defaults for the EWS request limits
+const (
+	defaultEWSMaxConcurrentRequests = 10
+	defaultEWSRequestsPerSecond     = 5
+)
+
+// limiterWaitTimeout is how long a request waits for the limiter before it fails with
+// ErrLimiterBusy, so interactive commands do not hang behind a large background sync
+const limiterWaitTimeout = 20 * time.Second
+
+// defaultThrottleBackOff is used when Exchange reports throttling without BackOffMilliseconds
+const defaultThrottleBackOff = 30 * time.Second
+
+// RequestLimiter caps the EWS requests the plugin sends to the Exchange server:
+// the number of requests in flight, the request rate and a shared back-off after throttling
+type RequestLimiter struct {
+	mutex       sync.Mutex
+	released    *sync.Cond
+	maxInFlight int
+	inFlight    int
+	interval    time.Duration
+	next        time.Time
+	pausedUntil time.Time
+}
+
+// NewRequestLimiter creates a limiter; zero values disable the corresponding limit
+func NewRequestLimiter(maxInFlight int, perSecond float64) *RequestLimiter {
+	l := &RequestLimiter{}
+	l.released = sync.NewCond(&l.mutex)
+	l.Configure(maxInFlight, perSecond)
+	return l
+}
+
+// Configure changes the limits; requests already in flight are not affected
+func (l *RequestLimiter) Configure(maxInFlight int, perSecond float64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.maxInFlight = maxInFlight
+	l.interval = 0
+	if perSecond > 0 {
+		l.interval = time.Duration(float64(time.Second) / perSecond)
+	}
+	l.released.Broadcast()
+}
+
+// Acquire waits until a request may be sent and returns the function that releases its slot.
+// It gives up with ErrLimiterBusy once ctx is done, or right away when a throttling
+// pause lasts beyond the deadline of ctx.
+func (l *RequestLimiter) Acquire(ctx context.Context) (func(), error) {
+	// Wake the waiters so a canceled one can give up
+	stop := context.AfterFunc(ctx, func() {
+		l.mutex.Lock()
+		l.released.Broadcast()
+		l.mutex.Unlock()
+	})
+	defer stop()
+
+	l.mutex.Lock()
+	for l.maxInFlight > 0 && l.inFlight >= l.maxInFlight {
+		if ctx.Err() != nil {
+			// Pass a release signal this waiter may have taken on to the next one
+			l.released.Signal()
+			l.mutex.Unlock()
+			return nil, limiterBusyError(ctx)
+		}
+		l.released.Wait()
+	}
+	l.inFlight++
+
+	// Reserve the next start time allowed by the rate limit
+	start := time.Now()
+	if l.next.After(start) {
+		start = l.next
+	}
+	l.next = start.Add(l.interval)
+	l.mutex.Unlock()
+
+	if !sleepContext(ctx, time.Until(start)) {
+		l.release()
+		return nil, limiterBusyError(ctx)
+	}
+
+	// A throttled response may have paused everyone while this request was waiting
+	for {
+		l.mutex.Lock()
+		pausedUntil := l.pausedUntil
+		l.mutex.Unlock()
+
+		wait := time.Until(pausedUntil)
+		if wait <= 0 {
+			break
+		}
+		if deadline, ok := ctx.Deadline(); ok && pausedUntil.After(deadline) {
+			l.release()
+			return nil, &EWSError{Kind: ErrLimiterBusy, MessageText: "Exchange ограничил количество запросов", BackOff: wait}
+		}
+		if !sleepContext(ctx, wait) {
+			l.release()
+			return nil, limiterBusyError(ctx)
+		}
+	}
+
+	return l.release, nil
+}
+
+// sleepContext waits for d and reports false if ctx was done first
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// limiterBusyError describes a request that did not get a slot in time
+func limiterBusyError(ctx context.Context) error {
+	return &EWSError{Kind: ErrLimiterBusy, MessageText: ctx.Err().Error()}
+}
+
+// limitedTransport sends every request through the shared RequestLimiter. The slot is held
+// until the response body is closed.
+type limitedTransport struct {
+	base    http.RoundTripper
+	limiter *RequestLimiter
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), limiterWaitTimeout)
+	release, err := t.limiter.Acquire(ctx)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// releasingBody releases the limiter slot of a response when it is closed
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+// Close implements io.Closer
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// release frees a request slot
+func (l *RequestLimiter) release() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.inFlight--
+	l.released.Signal()
+}
+
+// BackOff pauses all requests for the given duration
+func (l *RequestLimiter) BackOff(d time.Duration) {
+	if d <= 0 {
+		d = defaultThrottleBackOff
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if until := time.Now().Add(d); until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+}
+
+// parseEWSLimits reads the EWS request limits from the configuration
+func parseEWSLimits(c *configuration) (int, float64) {
+	maxInFlight := defaultEWSMaxConcurrentRequests
+	if value, err := strconv.Atoi(c.EWSMaxConcurrentRequests); err == nil && value > 0 {
+		maxInFlight = value
+	}
+
+	perSecond := float64(defaultEWSRequestsPerSecond)
+	if value, err := strconv.ParseFloat(c.EWSRequestsPerSecond, 64); err == nil && value > 0 {
+		perSecond = value
+	}
+
+	return maxInFlight, perSecond
+}
+
+// forEachUser runs task for every user with at most EWSMaxConcurrentRequests users at a time
+// and waits until all of them are done
+func (p *Plugin) forEachUser(users []*model.User, task func(userID string)) {
+	workers, _ := parseEWSLimits(p.getConfiguration())
+
+	userIDs := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for userID := range userIDs {
+				task(userID)
+			}
+		}()
+	}
+
+	for _, user := range users {
+		userIDs <- user.Id
+	}
+	close(userIDs)
+
+	wg.Wait()
+}
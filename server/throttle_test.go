@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequestLimiterAcquireHonorsContext(t *testing.T) {
+	limiter := NewRequestLimiter(1, 0)
+
+	release, err := limiter.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := limiter.Acquire(ctx); !errors.Is(err, ErrLimiterBusy) {
+		t.Fatalf("expected ErrLimiterBusy while the only slot is taken, got %v", err)
+	}
+
+	// The canceled waiter must not keep the slot from the next one
+	release()
+	second, err := limiter.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	second()
+
+	// A pause longer than the deadline fails without waiting for it
+	limiter.BackOff(time.Minute)
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	if _, err := limiter.Acquire(ctx); !errors.Is(err, ErrLimiterBusy) {
+		t.Fatalf("expected ErrLimiterBusy during a back-off, got %v", err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("Acquire waited for a pause beyond its deadline")
+	}
+}
+
+func TestExchangeClientRequestsHoldLimiterSlot(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}))
+	defer server.Close()
+
+	limiter := NewRequestLimiter(1, 0)
+	client := NewExchangeClient(server.URL, &ExchangeCredentials{Username: "jdoe", Password: "Secret1!"}, ExchangeClientOptions{Limiter: limiter})
+
+	// Plain GET probes go through the limiter too and hold the slot until the body is closed
+	resp, err := client.httpClient.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := limiter.Acquire(ctx); !errors.Is(err, ErrLimiterBusy) {
+		t.Fatalf("expected the open response to hold the slot, got %v", err)
+	}
+
+	resp.Body.Close()
+	release, err := limiter.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("slot not released after closing the body: %v", err)
+	}
+	release()
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// ewsDateTimeLayouts are the EWS date-time forms that carry a zone (Z or an offset)
+var ewsDateTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02Z07:00",
+}
+
+// ewsLocalDateTimeLayouts are the EWS date-time forms without a zone
+var ewsLocalDateTimeLayouts = []string{
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// windowsTimeZones maps IANA time zones to the Windows IDs used in the EWS TimeZoneContext header
+var windowsTimeZones = map[string]string{
+	"UTC":                 "UTC",
+	"Etc/UTC":             "UTC",
+	"Europe/Kaliningrad":  "Kaliningrad Standard Time",
+	"Europe/Moscow":       "Russian Standard Time",
+	"Europe/Simferopol":   "Russian Standard Time",
+	"Europe/Kirov":        "Russian Standard Time",
+	"Europe/Volgograd":    "Volgograd Standard Time",
+	"Europe/Samara":       "Russia Time Zone 3",
+	"Europe/Saratov":      "Saratov Standard Time",
+	"Europe/Astrakhan":    "Astrakhan Standard Time",
+	"Europe/Ulyanovsk":    "Astrakhan Standard Time",
+	"Asia/Yekaterinburg":  "Ekaterinburg Standard Time",
+	"Asia/Omsk":           "Omsk Standard Time",
+	"Asia/Novosibirsk":    "N. Central Asia Standard Time",
+	"Asia/Barnaul":        "Altai Standard Time",
+	"Asia/Tomsk":          "Tomsk Standard Time",
+	"Asia/Krasnoyarsk":    "North Asia Standard Time",
+	"Asia/Novokuznetsk":   "North Asia Standard Time",
+	"Asia/Irkutsk":        "North Asia East Standard Time",
+	"Asia/Chita":          "Transbaikal Standard Time",
+	"Asia/Yakutsk":        "Yakutsk Standard Time",
+	"Asia/Vladivostok":    "Vladivostok Standard Time",
+	"Asia/Sakhalin":       "Sakhalin Standard Time",
+	"Asia/Magadan":        "Magadan Standard Time",
+	"Asia/Kamchatka":      "Russia Time Zone 11",
+	"Europe/Minsk":        "Belarus Standard Time",
+	"Europe/Kiev":         "FLE Standard Time",
+	"Europe/Kyiv":         "FLE Standard Time",
+	"Europe/Riga":         "FLE Standard Time",
+	"Europe/Vilnius":      "FLE Standard Time",
+	"Europe/Tallinn":      "FLE Standard Time",
+	"Europe/Helsinki":     "FLE Standard Time",
+	"Europe/Istanbul":     "Turkey Standard Time",
+	"Europe/London":       "GMT Standard Time",
+	"Europe/Berlin":       "W. Europe Standard Time",
+	"Europe/Amsterdam":    "W. Europe Standard Time",
+	"Europe/Rome":         "W. Europe Standard Time",
+	"Europe/Vienna":       "W. Europe Standard Time",
+	"Europe/Paris":        "Romance Standard Time",
+	"Europe/Madrid":       "Romance Standard Time",
+	"Europe/Prague":       "Central Europe Standard Time",
+	"Europe/Warsaw":       "Central European Standard Time",
+	"Asia/Tbilisi":        "Georgian Standard Time",
+	"Asia/Yerevan":        "Caucasus Standard Time",
+	"Asia/Baku":           "Azerbaijan Standard Time",
+	"Asia/Almaty":         "Central Asia Standard Time",
+	"Asia/Tashkent":       "West Asia Standard Time",
+	"Asia/Bishkek":        "Central Asia Standard Time",
+	"Asia/Dubai":          "Arabian Standard Time",
+	"America/New_York":    "Eastern Standard Time",
+	"America/Chicago":     "Central Standard Time",
+	"America/Denver":      "Mountain Standard Time",
+	"America/Phoenix":     "US Mountain Standard Time",
+	"America/Los_Angeles": "Pacific Standard Time",
+}
+
+// parseEWSDateTime parses the ISO-8601 date-time forms EWS returns.
+// Values without a zone are interpreted in loc.
+func parseEWSDateTime(value string, loc *time.Location) (time.Time, error) {
+	value = strings.TrimSpace(value)
+
+	for _, layout := range ewsDateTimeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+
+	for _, layout := range ewsLocalDateTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("unsupported date-time format %q", value)
+}
+
+// windowsTimeZoneID returns the Windows time zone ID for loc, or an empty string if unknown
+func windowsTimeZoneID(loc *time.Location) string {
+	return windowsTimeZones[loc.String()]
+}
+
+// getUserLocation returns the user's Mattermost timezone, falling back to the server's zone
+func (p *Plugin) getUserLocation(userID string) *time.Location {
+	user, appErr := p.API.GetUser(userID)
+	if appErr != nil {
+		return time.Local
+	}
+
+	return p.userLocation(user)
+}
+
+// userLocation returns the Mattermost timezone of a loaded user, falling back to the server's zone
+func (p *Plugin) userLocation(user *model.User) *time.Location {
+	name := user.GetPreferredTimezone()
+	if name == "" {
+		return time.Local
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		p.API.LogWarn("Неизвестный часовой пояс пользователя", "user_id", user.Id, "timezone", name)
+		return time.Local
+	}
+
+	return loc
+}
+
+// exchangeLocation returns the zone the user's Exchange requests are made in. A zone without
+// a Windows ID falls back to the server's zone, or UTC, so the TimeZoneContext header is
+// always sent; event times are absolute and are still shown in the user's own zone.
+func (p *Plugin) exchangeLocation(userID string) *time.Location {
+	loc := p.getUserLocation(userID)
+	if windowsTimeZoneID(loc) != "" {
+		return loc
+	}
+
+	fallback := time.Local
+	if windowsTimeZoneID(fallback) == "" {
+		fallback = time.UTC
+	}
+
+	// Warn once per zone rather than on every request
+	if _, warned := p.unmappedTimeZones.LoadOrStore(loc.String(), true); !warned {
+		p.API.LogWarn("Нет соответствия часового пояса Windows, для запросов к Exchange используется запасной пояс",
+			"timezone", loc.String(), "fallback", fallback.String())
+	}
+
+	return fallback
+}
+
+// dayBounds returns the start of the day containing t in loc and the start of the next day.
+// The day is 23 or 25 hours long on DST transitions.
+func dayBounds(t time.Time, loc *time.Location) (time.Time, time.Time) {
+	t = t.In(loc)
+	startOfDay := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	return startOfDay, startOfDay.AddDate(0, 0, 1)
+}
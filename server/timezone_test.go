@@ -0,0 +1,170 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestParseEWSDateTimeAcrossDST(t *testing.T) {
+	newYork := mustLoadLocation(t, "America/New_York")
+	berlin := mustLoadLocation(t, "Europe/Berlin")
+
+	tests := []struct {
+		name  string
+		value string
+		loc   *time.Location
+		want  string // UTC
+	}{
+		{"utc", "2024-03-10T07:30:00Z", newYork, "2024-03-10T07:30:00Z"},
+		{"offset", "2024-11-03T01:30:00-05:00", newYork, "2024-11-03T06:30:00Z"},
+		{"local before spring forward", "2024-03-10T01:30:00", newYork, "2024-03-10T06:30:00Z"},
+		{"local after spring forward", "2024-03-10T03:30:00", newYork, "2024-03-10T07:30:00Z"},
+		{"local in repeated hour", "2024-11-03T01:30:00", newYork, "2024-11-03T05:30:00Z"},
+		{"local after fall back", "2024-11-03T03:00:00", newYork, "2024-11-03T08:00:00Z"},
+		{"local before spring forward berlin", "2024-03-31T01:59:00", berlin, "2024-03-31T00:59:00Z"},
+		{"local after fall back berlin", "2024-10-27T03:00:00", berlin, "2024-10-27T02:00:00Z"},
+		{"date with offset", "2024-10-27+02:00", berlin, "2024-10-26T22:00:00Z"},
+		{"local date", "2024-10-27", berlin, "2024-10-26T22:00:00Z"},
+		{"minutes", "2024-03-31T12:00", berlin, "2024-03-31T10:00:00Z"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseEWSDateTime(tt.value, tt.loc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s := got.UTC().Format(time.RFC3339); s != tt.want {
+				t.Errorf("parseEWSDateTime(%q) = %s, want %s", tt.value, s, tt.want)
+			}
+		})
+	}
+
+	if _, err := parseEWSDateTime("not a date", newYork); err == nil {
+		t.Error("expected an error for an unsupported format")
+	}
+}
+
+func TestDayBoundsAcrossDST(t *testing.T) {
+	newYork := mustLoadLocation(t, "America/New_York")
+	moscow := mustLoadLocation(t, "Europe/Moscow")
+
+	tests := []struct {
+		name   string
+		t      time.Time
+		loc    *time.Location
+		start  string // UTC
+		length time.Duration
+	}{
+		{"spring forward", time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC), newYork, "2024-03-10T05:00:00Z", 23 * time.Hour},
+		{"fall back", time.Date(2024, 11, 3, 12, 0, 0, 0, time.UTC), newYork, "2024-11-03T04:00:00Z", 25 * time.Hour},
+		{"regular day", time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC), newYork, "2024-07-01T04:00:00Z", 24 * time.Hour},
+		{"utc instant on previous local day", time.Date(2024, 11, 3, 3, 0, 0, 0, time.UTC), newYork, "2024-11-02T04:00:00Z", 24 * time.Hour},
+		{"no DST", time.Date(2024, 3, 31, 22, 30, 0, 0, time.UTC), moscow, "2024-03-31T21:00:00Z", 24 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := dayBounds(tt.t, tt.loc)
+			if s := start.UTC().Format(time.RFC3339); s != tt.start {
+				t.Errorf("start = %s, want %s", s, tt.start)
+			}
+			if d := end.Sub(start); d != tt.length {
+				t.Errorf("day length = %s, want %s", d, tt.length)
+			}
+		})
+	}
+}
+
+func TestTimeZoneContextHeader(t *testing.T) {
+	body := SOAPBody{}
+
+	tests := []struct {
+		name    string
+		loc     *time.Location
+		version string
+		want    string // empty means no TimeZoneContext
+	}{
+		{"new york", mustLoadLocation(t, "America/New_York"), defaultServerVersion, "Eastern Standard Time"},
+		{"berlin", mustLoadLocation(t, "Europe/Berlin"), defaultServerVersion, "W. Europe Standard Time"},
+		{"moscow", mustLoadLocation(t, "Europe/Moscow"), defaultServerVersion, "Russian Standard Time"},
+		{"utc", time.UTC, defaultServerVersion, "UTC"},
+		{"exchange 2007", mustLoadLocation(t, "Europe/Moscow"), fallbackServerVersion, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewExchangeClient("https://mail.contoso.com", &ExchangeCredentials{}, ExchangeClientOptions{})
+			client.SetLocation(tt.loc)
+
+			request, err := client.marshalSOAPRequest(tt.version, body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			hasContext := strings.Contains(request, "TimeZoneContext")
+			if tt.want == "" {
+				if hasContext {
+					t.Errorf("unexpected TimeZoneContext: %s", request)
+				}
+				return
+			}
+			if !strings.Contains(request, `Id="`+tt.want+`"`) {
+				t.Errorf("TimeZoneContext %q missing: %s", tt.want, request)
+			}
+		})
+	}
+}
+
+func TestDailySummaryDueAcrossDST(t *testing.T) {
+	newYork := mustLoadLocation(t, "America/New_York")
+	at := func(value string) time.Time {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+	target := func(value string) time.Time {
+		parsed, _ := time.Parse("15:04", value)
+		return parsed
+	}
+
+	tests := []struct {
+		name   string
+		now    time.Time
+		target time.Time
+		day    string // empty means not due
+	}{
+		{"at send time", at("2024-07-01T13:00:00Z"), target("09:00"), "2024-07-01"},
+		{"before send time", at("2024-07-01T12:59:00Z"), target("09:00"), ""},
+		{"within window", at("2024-07-01T13:20:00Z"), target("09:00"), "2024-07-01"},
+		{"after window", at("2024-07-01T13:30:00Z"), target("09:00"), ""},
+		{"after spring forward", at("2024-03-10T13:00:00Z"), target("09:00"), "2024-03-10"},
+		{"after fall back", at("2024-11-03T14:00:00Z"), target("09:00"), "2024-11-03"},
+		{"skipped hour moves forward", at("2024-03-10T07:10:00Z"), target("02:30"), "2024-03-10"},
+		{"first of repeated hour", at("2024-11-03T05:30:00Z"), target("01:30"), "2024-11-03"},
+		{"second of repeated hour", at("2024-11-03T06:30:00Z"), target("01:30"), ""},
+		{"server midnight is not user day", at("2024-07-02T00:00:00Z"), target("20:00"), "2024-07-01"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			day, ok := dailySummaryDue(tt.now, newYork, tt.target)
+			if ok != (tt.day != "") || day != tt.day {
+				t.Errorf("dailySummaryDue = %q, %v; want %q", day, ok, tt.day)
+			}
+		})
+	}
+}
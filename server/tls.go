@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// tlsVersions maps the TLSMinVersion setting to crypto/tls constants
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// defaultTLSMinVersion is used when TLSMinVersion is not set
+const defaultTLSMinVersion = "1.2"
+
+// errCertificatePinMismatch is returned when the server certificate does not match the pinned fingerprint
+var errCertificatePinMismatch = errors.New("server certificate does not match the pinned fingerprint")
+
+// buildTLSConfig creates the TLS settings for Exchange connections from the plugin configuration
+func buildTLSConfig(c *configuration) (*tls.Config, error) {
+	minVersion := c.TLSMinVersion
+	if minVersion == "" {
+		minVersion = defaultTLSMinVersion
+	}
+	version, ok := tlsVersions[minVersion]
+	if !ok {
+		return nil, fmt.Errorf("unsupported TLSMinVersion %q", c.TLSMinVersion)
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         version,
+		MaxVersion:         tls.VersionTLS13,
+		InsecureSkipVerify: c.TLSSkipVerify,
+	}
+
+	if strings.TrimSpace(c.TLSCACertificate) != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM([]byte(c.TLSCACertificate)) {
+			return nil, errors.New("TLSCACertificate does not contain a valid PEM certificate")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if strings.TrimSpace(c.TLSPinnedFingerprint) != "" {
+		fingerprint, err := parseCertificateFingerprint(c.TLSPinnedFingerprint)
+		if err != nil {
+			return nil, err
+		}
+
+		// Runs after the regular chain verification (unless it is skipped)
+		tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 {
+				return errCertificatePinMismatch
+			}
+			sum := sha256.Sum256(state.PeerCertificates[0].Raw)
+			if !bytes.Equal(sum[:], fingerprint) {
+				return errCertificatePinMismatch
+			}
+			return nil
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// parseCertificateFingerprint parses a SHA-256 fingerprint in hex, with or without colons
+func parseCertificateFingerprint(value string) ([]byte, error) {
+	cleaned := strings.NewReplacer(":", "", " ", "").Replace(strings.TrimSpace(value))
+	fingerprint, err := hex.DecodeString(cleaned)
+	if err != nil || len(fingerprint) != sha256.Size {
+		return nil, errors.New("TLSPinnedFingerprint must be a SHA-256 fingerprint in hex")
+	}
+	return fingerprint, nil
+}
+
+// describeCertificateError explains TLS certificate failures in user-facing terms
+func describeCertificateError(err error) (string, bool) {
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	var verification *tls.CertificateVerificationError
+
+	switch {
+	case errors.Is(err, errCertificatePinMismatch):
+		return "Сертификат сервера не совпадает с закрепленным отпечатком (TLSPinnedFingerprint)", true
+	case errors.As(err, &unknownAuthority):
+		return "Сертификат сервера выдан неизвестным центром сертификации. Добавьте корневой сертификат в настройку \"CA сертификаты\"", true
+	case errors.As(err, &hostname):
+		return fmt.Sprintf("Сертификат сервера не подходит для имени %s", hostname.Host), true
+	case errors.As(err, &invalid):
+		if invalid.Reason == x509.Expired {
+			return "Срок действия сертификата сервера истек или еще не наступил", true
+		}
+		return fmt.Sprintf("Недействительный сертификат сервера: %v", invalid), true
+	case errors.As(err, &verification):
+		return fmt.Sprintf("Ошибка проверки сертификата сервера: %v", verification.Err), true
+	case strings.Contains(err.Error(), "protocol version not supported"):
+		return "Сервер не поддерживает минимальную версию TLS из настроек (TLSMinVersion)", true
+	}
+
+	return "", false
+}